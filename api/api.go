@@ -0,0 +1,189 @@
+// Package api exposes a board over a local HTTP/JSON API so other tools can
+// push strokes in and pull renderings and AI conversions out. Every request
+// needs the server's token, and the server only listens on and answers to
+// localhost.
+//
+//	GET    /api/board             current lines and version
+//	POST   /api/board             start a new board (optionally with {"lines": [...]})
+//	POST   /api/board/open        load a board file with all its pages: {"path": "..."}
+//	POST   /api/board/save        save every page of the board to a file: {"path": "..."}
+//	POST   /api/strokes           add one line or an array of lines
+//	DELETE /api/strokes/{id}      delete a line
+//	GET    /api/render.{png,svg,pdf}?scale=1&margin=20
+//	POST   /api/convert?trusted=1 run the AI conversion on the rendered board
+//	GET    /api/events            server-sent events for every change
+//
+// The API serves the page the board shows. When the board shows another
+// page, event streams get a "page" event and clients should read the board
+// again.
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"goWhiteBoard/util"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Server serves the API for a document, which can be replaced while it runs
+type Server struct {
+	token string
+	mux   *http.ServeMux
+
+	// Convert runs the AI conversion; it defaults to util.ConvertImage
+	Convert func(image []byte) (util.Extraction, error)
+	// Layers returns the layers of the document served, bottom first, and
+	// Grid its background. Renderings and conversions leave hidden layers
+	// out and fade translucent ones; without Layers every line is drawn.
+	Layers func() []model.Layer
+	Grid   func() model.Grid
+	// Edit changes the document served like an edit made on the board, as
+	// one undoable edit: it removes every line if clear is set, or else the
+	// lines remove, and adds add to the current layer, returning their IDs.
+	// It fails if the current layer is locked or hidden or a line to
+	// remove cannot be selected. Without Edit the document is changed
+	// directly.
+	Edit func(clear bool, remove []model.ID, add []model.Line) ([]model.ID, error)
+	// Open loads a board file with all its pages into the board, and Save
+	// writes every page of the board to a file. Without them the API
+	// cannot open or save files.
+	Open func(path string) error
+	Save func(path string) error
+
+	mutex    sync.Mutex
+	doc      *model.Document
+	switched chan struct{} // doc を入れ替えると閉じる
+	http     *http.Server
+}
+
+// NewToken returns a random token for New
+func NewToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// New creates an API server for doc. Clients must present token either as
+// "Authorization: Bearer <token>" or as the "token" query parameter (for
+// EventSource, which cannot set headers).
+func New(doc *model.Document, token string) *Server {
+	s := &Server{doc: doc, switched: make(chan struct{}), token: token, mux: http.NewServeMux(), Convert: util.ConvertImage}
+	s.mux.HandleFunc("GET /api/board", s.getBoard)
+	s.mux.HandleFunc("POST /api/board", s.newBoard)
+	s.mux.HandleFunc("POST /api/board/open", s.openBoard)
+	s.mux.HandleFunc("POST /api/board/save", s.saveBoard)
+	s.mux.HandleFunc("POST /api/strokes", s.addStrokes)
+	s.mux.HandleFunc("DELETE /api/strokes/{id}", s.deleteStroke)
+	for _, format := range render.Formats {
+		s.mux.HandleFunc("GET /api/render."+format, s.render(format))
+	}
+	s.mux.HandleFunc("POST /api/convert", s.convert)
+	s.mux.HandleFunc("GET /api/events", s.events)
+	return s
+}
+
+// SetDocument makes the server serve doc from now on, e.g. after the board
+// showed another page. Event streams follow with a "page" event.
+func (s *Server) SetDocument(doc *model.Document) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if doc == s.doc {
+		return
+	}
+	s.doc = doc
+	close(s.switched)
+	s.switched = make(chan struct{})
+}
+
+// document returns the document served and a channel that is closed when
+// it is replaced
+func (s *Server) document() (*model.Document, <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc, s.switched
+}
+
+// Token returns the token clients have to present
+func (s *Server) Token() string {
+	return s.token
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// DNS リバインディング対策として localhost 以外の Host は拒否する
+	if !isLocalHost(r.Host) {
+		writeError(w, http.StatusForbidden, errors.New("only localhost may use the API"))
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Start listens on addr, which must be a loopback address such as
+// "127.0.0.1:7777", and serves in the background. It returns the actual
+// address (useful with port 0).
+func (s *Server) Start(addr string) (string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", errors.New("the API may only listen on a loopback address")
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	srv := &http.Server{Handler: s}
+
+	s.mutex.Lock()
+	s.http = srv
+	s.mutex.Unlock()
+
+	go srv.Serve(l)
+	return l.Addr().String(), nil
+}
+
+// Close stops a server started with Start
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	srv := s.http
+	s.http = nil
+	s.mutex.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Close()
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	got := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		got = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return s.token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) == 1
+}
+
+// isLocalHost reports whether a Host header names the local machine
+func isLocalHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
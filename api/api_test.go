@@ -0,0 +1,334 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"goWhiteBoard/model"
+	"goWhiteBoard/util"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testToken = "secret"
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	s := New(model.NewDocument(), testToken)
+	h := httptest.NewServer(s)
+	t.Cleanup(h.Close)
+	return s, h
+}
+
+// call sends an authorized request and returns the response body
+func call(t *testing.T, h *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, h.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+const stroke = `{"points": [{"x": 0, "y": 0}, {"x": 30, "y": 40}], "color": "#ff0000", "width": 3}`
+
+func TestAuthorization(t *testing.T) {
+	_, h := newTestServer(t)
+
+	resp, err := http.Get(h.URL + "/api/board")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(h.URL + "/api/board?token=" + testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("token in query: %d", resp.StatusCode)
+	}
+
+	// localhost 以外の名前で届いたリクエストは拒否する
+	req, _ := http.NewRequest("GET", h.URL+"/api/board?token="+testToken, nil)
+	req.Host = "evil.example.com"
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("foreign host: %d", resp.StatusCode)
+	}
+}
+
+func TestStartRefusesPublicAddress(t *testing.T) {
+	s := New(model.NewDocument(), testToken)
+	if _, err := s.Start("0.0.0.0:0"); err == nil {
+		s.Close()
+		t.Error("listening on every interface was allowed")
+	}
+	addr, err := s.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !strings.HasPrefix(addr, "127.0.0.1:") {
+		t.Errorf("addr = %s", addr)
+	}
+}
+
+func TestStrokesAndBoard(t *testing.T) {
+	s, h := newTestServer(t)
+
+	code, body := call(t, h, "POST", "/api/strokes", "["+stroke+","+stroke+"]")
+	if code != http.StatusCreated {
+		t.Fatalf("add: %d %s", code, body)
+	}
+	var added struct{ IDs []model.ID }
+	json.Unmarshal([]byte(body), &added)
+	if len(added.IDs) != 2 || s.doc.Len() != 2 {
+		t.Fatalf("added %v, document has %d lines", added.IDs, s.doc.Len())
+	}
+
+	if code, body := call(t, h, "DELETE", "/api/strokes/"+added.IDs[0].String(), ""); code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", code, body)
+	}
+	if code, _ := call(t, h, "DELETE", "/api/strokes/"+added.IDs[0].String(), ""); code != http.StatusNotFound {
+		t.Errorf("second delete: %d", code)
+	}
+
+	code, body = call(t, h, "GET", "/api/board", "")
+	var board boardResponse
+	if err := json.Unmarshal([]byte(body), &board); err != nil || code != http.StatusOK {
+		t.Fatalf("board: %d %s", code, body)
+	}
+	if len(board.Lines) != 1 || board.Lines[0].ID != added.IDs[1] || board.Lines[0].Width != 3 {
+		t.Errorf("board = %+v", board)
+	}
+
+	if code, body := call(t, h, "POST", "/api/strokes", `{"points": []}`); code != http.StatusBadRequest {
+		t.Errorf("empty stroke: %d %s", code, body)
+	}
+}
+
+func TestEditHook(t *testing.T) {
+	s, h := newTestServer(t)
+	type request struct {
+		clear  bool
+		remove []model.ID
+		add    int
+	}
+	var got []request
+	refuse := false
+	s.Edit = func(clear bool, remove []model.ID, add []model.Line) ([]model.ID, error) {
+		got = append(got, request{clear, remove, len(add)})
+		if refuse {
+			return nil, errors.New("the current layer is locked or hidden")
+		}
+		return s.doc.Update(remove, add), nil
+	}
+
+	// 書き込みはすべてボードの編集として行う
+	call(t, h, "POST", "/api/strokes", stroke)
+	id := s.doc.Snapshot().Lines[0].ID
+	call(t, h, "DELETE", "/api/strokes/"+id.String(), "")
+	call(t, h, "POST", "/api/board", `{"lines": [`+stroke+`]}`)
+	want := []request{{add: 1}, {remove: []model.ID{id}}, {clear: true, add: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("edits = %+v, want %+v", got, want)
+	}
+
+	// 断られた編集は 409 で返す
+	refuse = true
+	if code, body := call(t, h, "POST", "/api/strokes", stroke); code != http.StatusConflict || !strings.Contains(body, "locked") {
+		t.Errorf("refused add: %d %s", code, body)
+	}
+}
+
+func TestOpenAndSave(t *testing.T) {
+	s, h := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "saved"+model.BoardExt)
+	save := `{"path": "` + filepath.ToSlash(path) + `"}`
+
+	// 開く・保存はボードに任せる。任せる先がなければできない
+	if code, _ := call(t, h, "POST", "/api/board/save", save); code != http.StatusNotImplemented {
+		t.Errorf("save without Save: %d", code)
+	}
+	if code, _ := call(t, h, "POST", "/api/board/open", save); code != http.StatusNotImplemented {
+		t.Errorf("open without Open: %d", code)
+	}
+
+	var saved, opened []string
+	s.Save = func(path string) error {
+		saved = append(saved, path)
+		return nil
+	}
+	s.Open = func(path string) error {
+		opened = append(opened, path)
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		s.SetDocument(model.NewDocument())
+		return nil
+	}
+	if code, body := call(t, h, "POST", "/api/board/save", save); code != http.StatusNoContent || !reflect.DeepEqual(saved, []string{path}) {
+		t.Fatalf("save: %d %s, saved %q", code, body, saved)
+	}
+	if code, _ := call(t, h, "POST", "/api/board/open", save); code != http.StatusUnprocessableEntity {
+		t.Errorf("opening a missing file: %d", code)
+	}
+	if err := model.SavePages(path, []model.BoardPage{{Layers: model.DefaultLayers()}}); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := call(t, h, "POST", "/api/board/open", save); code != http.StatusOK || len(opened) != 2 {
+		t.Fatalf("open: %d %s", code, body)
+	}
+}
+
+func TestRender(t *testing.T) {
+	_, h := newTestServer(t)
+	call(t, h, "POST", "/api/strokes", stroke)
+
+	for path, prefix := range map[string]string{
+		"/api/render.png":         "\x89PNG",
+		"/api/render.svg?scale=2": "<svg",
+		"/api/render.pdf":         "%PDF",
+	} {
+		code, body := call(t, h, "GET", path, "")
+		if code != http.StatusOK || !strings.HasPrefix(body, prefix) {
+			t.Errorf("%s: %d %.20q", path, code, body)
+		}
+	}
+	if code, _ := call(t, h, "GET", "/api/render.png?scale=-1", ""); code != http.StatusBadRequest {
+		t.Errorf("negative scale: %d", code)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	s, h := newTestServer(t)
+	s.Convert = func(image []byte) (util.Extraction, error) {
+		return util.ExtractArtifact("<html><body onload=\"x()\">done</body></html>"), nil
+	}
+
+	code, body := call(t, h, "POST", "/api/convert", "")
+	var result conversion
+	if err := json.Unmarshal([]byte(body), &result); err != nil || code != http.StatusOK {
+		t.Fatalf("convert: %d %s", code, body)
+	}
+	if result.Kind != util.ArtifactHTML || !strings.Contains(result.Content, "done") || strings.Contains(result.Content, "onload") {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, h := newTestServer(t)
+
+	resp, err := http.Get(h.URL + "/api/events?token=" + testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	events := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		var name string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				events <- name + " " + strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	next := func() string {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return ""
+		}
+	}
+	if e := next(); !strings.HasPrefix(e, "hello ") {
+		t.Fatalf("first event = %q", e)
+	}
+
+	s.doc.Clear()
+	if e := next(); !strings.HasPrefix(e, `change {"version":1,"kind":"cleared"`) {
+		t.Errorf("event = %q", e)
+	}
+	call(t, h, "POST", "/api/strokes", stroke)
+	if e := next(); !strings.Contains(e, `"kind":"added"`) || !strings.Contains(e, `"kind":"insert"`) {
+		t.Errorf("event = %q", e)
+	}
+
+	// 別のページを表示したら、そのページの変更を送る
+	old := s.doc
+	page := model.NewDocument()
+	page.Clear()
+	s.SetDocument(page)
+	if e := next(); e != `page {"version":1}` {
+		t.Errorf("event = %q", e)
+	}
+	old.Clear()
+	call(t, h, "POST", "/api/strokes", stroke)
+	if e := next(); !strings.HasPrefix(e, `change {"version":2,"kind":"added"`) {
+		t.Errorf("event = %q", e)
+	}
+	if page.Len() != 1 || old.Len() != 0 {
+		t.Errorf("stroke added to the page no longer shown: %d, %d lines", page.Len(), old.Len())
+	}
+}
+
+func TestRenderHidesLayers(t *testing.T) {
+	s, h := newTestServer(t)
+	s.Layers = func() []model.Layer {
+		return []model.Layer{{Name: "Layer 1", Opacity: 1}, {ID: "notes", Name: "Notes", Hidden: true, Opacity: 1}}
+	}
+	var sent []byte
+	s.Convert = func(image []byte) (util.Extraction, error) {
+		sent = image
+		return util.ExtractArtifact("<p>done</p>"), nil
+	}
+	call(t, h, "POST", "/api/strokes", `{"points": [{"x": 0, "y": 0}, {"x": 30, "y": 40}], "color": "#ff0000", "width": 3, "layer": "notes"}`)
+
+	// 隠したレイヤーの線は書き出しにも変換にも出さない
+	_, body := call(t, h, "GET", "/api/render.png", "")
+	call(t, h, "POST", "/api/convert", "")
+	for name, data := range map[string][]byte{"render": []byte(body), "convert": sent} {
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if r, g, b, _ := img.At(35, 40).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+			t.Errorf("%s shows the hidden line", name)
+		}
+	}
+}
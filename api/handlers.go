@@ -0,0 +1,372 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"goWhiteBoard/util"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxBodySize limits request bodies (stroke lists can be long, but not huge)
+const maxBodySize = 16 << 20
+
+// maxPixels bounds the size of rendered images
+const maxPixels = 64 << 20
+
+// keepAlive is how often an idle event stream gets a comment line
+const keepAlive = 30 * time.Second
+
+// boardResponse is returned by the board endpoints
+type boardResponse struct {
+	Version uint64       `json:"version"`
+	Lines   []model.Line `json:"lines"`
+}
+
+// pathRequest is the body of open and save
+type pathRequest struct {
+	Path string `json:"path"`
+}
+
+// event is the data of a server-sent "change" event
+type event struct {
+	Version uint64     `json:"version"`
+	Kind    string     `json:"kind"`
+	Origin  string     `json:"origin,omitempty"`
+	Ops     []model.Op `json:"ops"`
+}
+
+// conversion is returned by /api/convert
+type conversion struct {
+	Kind         string `json:"kind"`
+	Source       string `json:"source"`
+	Content      string `json:"content"` // sanitized HTML
+	Raw          string `json:"raw"`
+	NeedsScripts bool   `json:"needsScripts"`
+}
+
+var changeKinds = map[model.ChangeKind]string{
+	model.LineAdded: "added",
+	model.Cleared:   "cleared",
+	model.Replaced:  "replaced",
+}
+
+func (s *Server) getBoard(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.board())
+}
+
+func (s *Server) newBoard(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Lines []model.Line `json:"lines"`
+	}
+	if err := readJSON(r, &req, true); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := s.edit(true, nil, req.Lines); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.board())
+}
+
+func (s *Server) openBoard(w http.ResponseWriter, r *http.Request) {
+	var req pathRequest
+	if err := readJSON(r, &req, false); err != nil || req.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.Join(errors.New(`expected {"path": "..."}`), err))
+		return
+	}
+	if s.Open == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("opening board files is not supported"))
+		return
+	}
+	// ボードはすべてのページを読み込んで最初のページを表示し、API もそのページに移る
+	if err := s.Open(req.Path); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.board())
+}
+
+func (s *Server) saveBoard(w http.ResponseWriter, r *http.Request) {
+	var req pathRequest
+	if err := readJSON(r, &req, false); err != nil || req.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.Join(errors.New(`expected {"path": "..."}`), err))
+		return
+	}
+	if s.Save == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("saving board files is not supported"))
+		return
+	}
+	if err := s.Save(req.Path); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addStrokes accepts a single line object or an array of them and returns
+// the IDs of the new lines
+func (s *Server) addStrokes(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var lines []model.Line
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(body, &lines)
+	} else {
+		var l model.Line
+		err = json.Unmarshal(body, &l)
+		lines = []model.Line{l}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	for _, l := range lines {
+		if len(l.Points) == 0 {
+			writeError(w, http.StatusBadRequest, errors.New("a stroke needs at least one point"))
+			return
+		}
+	}
+	ids, err := s.edit(false, nil, lines)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string][]model.ID{"ids": ids})
+}
+
+func (s *Server) deleteStroke(w http.ResponseWriter, r *http.Request) {
+	var id model.ID
+	if err := id.UnmarshalText([]byte(r.PathValue("id"))); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	doc, _ := s.document()
+	for _, l := range doc.Snapshot().Lines {
+		if l.ID == id {
+			if _, err := s.edit(false, []model.ID{id}, nil); err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("no stroke %s", id))
+}
+
+// edit makes an edit through Edit, or directly on the document without it
+func (s *Server) edit(clear bool, remove []model.ID, add []model.Line) ([]model.ID, error) {
+	if s.Edit != nil {
+		return s.Edit(clear, remove, add)
+	}
+	doc, _ := s.document()
+	if clear {
+		remove = nil
+		for _, l := range doc.Snapshot().Lines {
+			remove = append(remove, l.ID)
+		}
+	}
+	return doc.Update(remove, add), nil
+}
+
+func (s *Server) render(format string) http.HandlerFunc {
+	contentTypes := map[string]string{
+		render.FormatPNG: "image/png",
+		render.FormatSVG: "image/svg+xml",
+		render.FormatPDF: "application/pdf",
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _ := s.document()
+		lines := doc.Snapshot().Lines
+		view, err := viewFor(r, lines)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := render.WriteGroups(&buf, format, s.groups(lines, view), view); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypes[format])
+		w.Write(buf.Bytes())
+	}
+}
+
+func (s *Server) convert(w http.ResponseWriter, r *http.Request) {
+	doc, _ := s.document()
+	lines := doc.Snapshot().Lines
+	view, err := viewFor(r, lines)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var image bytes.Buffer
+	if err := render.WriteGroups(&image, render.FormatPNG, s.groups(lines, view), view); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := s.Convert(image.Bytes())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	policy := util.StrictPolicy
+	if trusted, _ := strconv.ParseBool(r.URL.Query().Get("trusted")); trusted {
+		policy = util.TrustedPolicy
+	}
+	writeJSON(w, http.StatusOK, conversion{
+		Kind:         result.Kind,
+		Source:       result.Source,
+		Content:      util.SanitizeHTML(result.Content, policy),
+		Raw:          result.Raw,
+		NeedsScripts: result.NeedsScripts,
+	})
+}
+
+// events streams every document change as a server-sent event until the
+// client goes away. When another document is served, a "page" event with
+// its version follows and the stream goes on with its changes.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	first := "hello"
+	for {
+		doc, switched := s.document()
+		if !s.streamDocument(w, flusher, r, doc, switched, first) {
+			return
+		}
+		first = "page"
+	}
+}
+
+// streamDocument streams the changes of doc, starting with a first event
+// that carries its version. It returns true when switched is closed and
+// false when the stream has to end.
+func (s *Server) streamDocument(w http.ResponseWriter, flusher http.Flusher, r *http.Request, doc *model.Document, switched <-chan struct{}, first string) bool {
+	// 通知はドキュメントを変更したゴルーチンで呼ばれるので、溢れたら切断する
+	changes := make(chan model.Change, 64)
+	overflow := make(chan struct{})
+	var once sync.Once
+	unsubscribe := doc.Subscribe(func(c model.Change) {
+		select {
+		case changes <- c:
+		default:
+			once.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+
+	fmt.Fprintf(w, "event: %s\ndata: {\"version\":%d}\n\n", first, doc.Snapshot().Version)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-overflow:
+			return false
+		case <-switched:
+			return true
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case c := <-changes:
+			data, err := json.Marshal(event{Version: c.Version, Kind: changeKinds[c.Kind], Origin: c.Origin, Ops: c.Ops})
+			if err != nil {
+				return false
+			}
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Server) board() boardResponse {
+	doc, _ := s.document()
+	snap := doc.Snapshot()
+	lines := snap.Lines
+	if lines == nil {
+		lines = []model.Line{}
+	}
+	return boardResponse{Version: snap.Version, Lines: lines}
+}
+
+// groups returns lines split into the visible layers, with the grid
+// beneath them when it is exported
+func (s *Server) groups(lines []model.Line, view render.View) []render.Group {
+	var layers []model.Layer
+	if s.Layers != nil {
+		layers = s.Layers()
+	}
+	var grid model.Grid
+	if s.Grid != nil {
+		grid = s.Grid()
+	}
+	return render.LayersWithGrid(lines, layers, grid, view)
+}
+
+// viewFor reads the scale and margin query parameters
+func viewFor(r *http.Request, lines []model.Line) (render.View, error) {
+	scale, margin := 1.0, 20
+	var err error
+	if v := r.URL.Query().Get("scale"); v != "" {
+		if scale, err = strconv.ParseFloat(v, 32); err != nil || scale <= 0 || scale > 10 {
+			return render.View{}, fmt.Errorf("invalid scale %q", v)
+		}
+	}
+	if v := r.URL.Query().Get("margin"); v != "" {
+		if margin, err = strconv.Atoi(v); err != nil || margin < 0 || margin > 1000 {
+			return render.View{}, fmt.Errorf("invalid margin %q", v)
+		}
+	}
+	view := render.ContentView(lines, margin, float32(scale))
+	if view.Width*view.Height > maxPixels {
+		return render.View{}, fmt.Errorf("the rendering would be %dx%d pixels; lower the scale", view.Width, view.Height)
+	}
+	return view, nil
+}
+
+// readJSON decodes the request body into v. An empty body is accepted when
+// optional is set.
+func readJSON(r *http.Request, v interface{}, optional bool) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		return err
+	}
+	if optional && len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"goWhiteBoard/connect"
+	"goWhiteBoard/model"
+	"goWhiteBoard/snap"
+	"slices"
+	"sort"
+)
+
+// zOrder is where the selection goes in the stacking order
+type zOrder int
+
+const (
+	// toFront puts the selection above every other line
+	toFront zOrder = iota
+	// forward puts each selected line above the line just above it
+	forward
+	// backward puts each selected line below the line just below it
+	backward
+	// toBack puts the selection below every other line
+	toBack
+)
+
+// alignment is the edge or centre line the selection is aligned on
+type alignment int
+
+const (
+	alignLeft alignment = iota
+	alignCenter
+	alignRight
+	alignTop
+	alignMiddle
+	alignBottom
+)
+
+// GroupSelection puts the selected lines into one new group as one undoable
+// edit. Groups among them are merged into it.
+func (w *whiteboard) GroupSelection() {
+	w.mutex.Lock()
+	lines := slices.Clone(w.doc.Snapshot().Lines) // スナップショットは文書と配列を共有する
+	selected := idSet(w.selection)
+	if len(w.selectedLinesLocked(lines)) < 2 {
+		w.mutex.Unlock()
+		return
+	}
+	group := model.NewName()
+	changed := map[model.ID]bool{}
+	for i, l := range lines {
+		if selected[l.ID] {
+			lines[i].Group = group
+			changed[l.ID] = true
+		}
+	}
+	w.mutex.Unlock()
+	w.rearrange(lines, changed)
+}
+
+// UngroupSelection takes the selected lines out of their groups as one
+// undoable edit
+func (w *whiteboard) UngroupSelection() {
+	w.changeSelection(func(l *model.Line) bool {
+		if l.Group == "" {
+			return false
+		}
+		l.Group = ""
+		return true
+	})
+}
+
+// LockSelection locks or unlocks the selected lines as one undoable edit.
+// Locked lines stay selectable but cannot be moved, resized, deleted or
+// aligned.
+func (w *whiteboard) LockSelection(locked bool) {
+	w.changeSelection(func(l *model.Line) bool {
+		if l.Locked == locked {
+			return false
+		}
+		l.Locked = locked
+		return true
+	})
+}
+
+// changeSelection applies change to copies of the selected lines and puts
+// the ones it changed in place of the originals as one undoable edit
+func (w *whiteboard) changeSelection(change func(l *model.Line) bool) {
+	w.mutex.Lock()
+	lines := slices.Clone(w.doc.Snapshot().Lines) // スナップショットは文書と配列を共有する
+	selected := idSet(w.selection)
+	changed := map[model.ID]bool{}
+	for i, l := range lines {
+		if selected[l.ID] && change(&lines[i]) {
+			changed[l.ID] = true
+		}
+	}
+	w.mutex.Unlock()
+	w.rearrange(lines, changed)
+}
+
+// Arrange moves the selection in the stacking order as one undoable edit
+func (w *whiteboard) Arrange(order zOrder) {
+	w.mutex.Lock()
+	lines := slices.Clone(w.doc.Snapshot().Lines) // スナップショットは文書と配列を共有する
+	selected := idSet(w.selection)
+	w.mutex.Unlock()
+	if len(selected) == 0 {
+		return
+	}
+	switch order {
+	case toFront, toBack:
+		var front, back []model.Line
+		for _, l := range lines {
+			if selected[l.ID] == (order == toFront) {
+				front = append(front, l)
+			} else {
+				back = append(back, l)
+			}
+		}
+		lines = append(back, front...)
+	case forward:
+		// 上から順に、すぐ上の選ばれていない線と入れ替える
+		for i := len(lines) - 2; i >= 0; i-- {
+			if selected[lines[i].ID] && !selected[lines[i+1].ID] {
+				lines[i], lines[i+1] = lines[i+1], lines[i]
+			}
+		}
+	case backward:
+		for i := 1; i < len(lines); i++ {
+			if selected[lines[i].ID] && !selected[lines[i-1].ID] {
+				lines[i], lines[i-1] = lines[i-1], lines[i]
+			}
+		}
+	}
+	w.rearrange(lines, nil)
+}
+
+// Align lines the selected groups and lines up on the edge or centre line
+// a of the selection's bounds as one undoable edit. Locked ones stay where
+// they are.
+func (w *whiteboard) Align(a alignment) {
+	w.moveItems(func(items []arrangeItem, all snap.Rect) {
+		for i, it := range items {
+			b := it.bounds
+			switch a {
+			case alignLeft:
+				items[i].offset.X = all.Min.X - b.Min.X
+			case alignCenter:
+				items[i].offset.X = (all.Min.X+all.Max.X)/2 - (b.Min.X+b.Max.X)/2
+			case alignRight:
+				items[i].offset.X = all.Max.X - b.Max.X
+			case alignTop:
+				items[i].offset.Y = all.Min.Y - b.Min.Y
+			case alignMiddle:
+				items[i].offset.Y = (all.Min.Y+all.Max.Y)/2 - (b.Min.Y+b.Max.Y)/2
+			case alignBottom:
+				items[i].offset.Y = all.Max.Y - b.Max.Y
+			}
+		}
+	})
+}
+
+// Distribute spaces the selected groups and lines evenly between the first
+// and the last of them, horizontally or vertically, as one undoable edit.
+// Locked ones stay where they are.
+func (w *whiteboard) Distribute(vertical bool) {
+	w.moveItems(func(items []arrangeItem, _ snap.Rect) {
+		if len(items) < 3 {
+			return
+		}
+		// 軸を入れ替えて横と縦を同じように扱う
+		lo := func(r snap.Rect) float32 { return r.Min.X }
+		hi := func(r snap.Rect) float32 { return r.Max.X }
+		if vertical {
+			lo = func(r snap.Rect) float32 { return r.Min.Y }
+			hi = func(r snap.Rect) float32 { return r.Max.Y }
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return lo(items[i].bounds)+hi(items[i].bounds) < lo(items[j].bounds)+hi(items[j].bounds)
+		})
+		first, last := items[0].bounds, items[len(items)-1].bounds
+		space := hi(last) - lo(first)
+		for _, it := range items {
+			space -= hi(it.bounds) - lo(it.bounds)
+		}
+		gap := space / float32(len(items)-1)
+		at := hi(first) + gap
+		for i := 1; i < len(items)-1; i++ {
+			d := at - lo(items[i].bounds)
+			if vertical {
+				items[i].offset.Y = d
+			} else {
+				items[i].offset.X = d
+			}
+			at += hi(items[i].bounds) - lo(items[i].bounds) + gap
+		}
+	})
+}
+
+// arrangeItem is a group, or a line outside groups, that is aligned and
+// distributed as one
+type arrangeItem struct {
+	ids    []model.ID
+	bounds snap.Rect
+	offset model.Point
+}
+
+// moveItems collects the selected groups and lines that are not locked as
+// items, lets place set their offsets and moves them as one undoable edit.
+// Connectors attached to them are rerouted in the same edit.
+func (w *whiteboard) moveItems(place func(items []arrangeItem, all snap.Rect)) {
+	w.mutex.Lock()
+	lines := slices.Clone(w.doc.Snapshot().Lines) // スナップショットは文書と配列を共有する
+	selected := w.selectedLinesLocked(lines)
+	w.mutex.Unlock()
+
+	var items []arrangeItem
+	index := map[string]int{}
+	locked := map[int]bool{}
+	members := map[int][]model.Line{}
+	for _, l := range selected {
+		i, ok := index[l.Group]
+		if !ok || l.Group == "" {
+			i = len(items)
+			items = append(items, arrangeItem{})
+			if l.Group != "" {
+				index[l.Group] = i
+			}
+		}
+		items[i].ids = append(items[i].ids, l.ID)
+		members[i] = append(members[i], l)
+		locked[i] = locked[i] || l.Locked
+	}
+	var movable []arrangeItem
+	for i, it := range items {
+		if locked[i] {
+			continue
+		}
+		it.bounds, _ = snap.Bounds(members[i])
+		movable = append(movable, it)
+	}
+	all, ok := snap.Bounds(selected)
+	if !ok || len(movable) < 2 {
+		return
+	}
+	place(movable, all)
+
+	offsets := map[model.ID]model.Point{}
+	for _, it := range movable {
+		if it.offset == (model.Point{}) {
+			continue
+		}
+		for _, id := range it.ids {
+			offsets[id] = it.offset
+		}
+	}
+	changed := map[model.ID]bool{}
+	for i, l := range lines {
+		if d, ok := offsets[l.ID]; ok {
+			lines[i] = translateLine(l, d)
+			changed[l.ID] = true
+		}
+	}
+	w.rearrange(lines, changed)
+}
+
+// rearrange makes lines, every line of the page shown in their new order,
+// the content of the page as one undoable edit. Lines whose IDs are in
+// changed were edited and are replaced by copies in their place; of the
+// others only those whose place changed are moved, so lines nobody touched
+// keep their IDs for other participants. Undo puts everything back.
+// Connectors attached to changed lines are rerouted in place, and the
+// selection follows the copies.
+func (w *whiteboard) rearrange(lines []model.Line, changed map[model.ID]bool) {
+	if changed == nil {
+		changed = map[model.ID]bool{}
+	}
+	w.mutex.Lock()
+	old := w.doc.Snapshot().Lines
+	shapes := map[string]snap.Rect{}
+	moved := map[string]bool{}
+	for _, l := range lines {
+		if l.Node != "" {
+			shapes[l.Node], _ = snap.Bounds([]model.Line{l})
+			moved[l.Node] = moved[l.Node] || changed[l.ID]
+		}
+	}
+	for i, l := range lines {
+		if l.Connector != nil && (changed[l.ID] || moved[l.Connector.From.Node] || moved[l.Connector.To.Node]) {
+			lines[i] = connect.Reroute(l, shapes)
+			changed[l.ID] = true
+		}
+	}
+
+	k := 0
+	for k < len(lines) && k < len(old) && lines[k].ID == old[k].ID && !changed[lines[k].ID] {
+		k++
+	}
+	if k == len(lines) && len(lines) == len(old) {
+		w.mutex.Unlock()
+		return
+	}
+	var remove []model.ID
+	for _, l := range lines {
+		if changed[l.ID] {
+			remove = append(remove, l.ID)
+		}
+	}
+	history := w.history
+	w.mutex.Unlock()
+
+	// 通知で再描画するのでロックの外で並べ替える
+	ids := history.Arrange(remove, lines)
+	w.mutex.Lock()
+	renamed := make(map[model.ID]model.ID, len(remove))
+	for i, id := range remove {
+		renamed[id] = ids[i]
+	}
+	selection := make([]model.ID, len(w.selection))
+	for i, id := range w.selection {
+		if n, ok := renamed[id]; ok {
+			id = n
+		}
+		selection[i] = id
+	}
+	w.selection = selection
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// withGroups returns ids together with the other lines of their groups
+func withGroups(lines []model.Line, ids []model.ID) []model.ID {
+	selected := idSet(ids)
+	groups := map[string]bool{}
+	for _, l := range lines {
+		if selected[l.ID] && l.Group != "" {
+			groups[l.Group] = true
+		}
+	}
+	if len(groups) == 0 {
+		return ids
+	}
+	var out []model.ID
+	for _, l := range lines {
+		if selected[l.ID] || groups[l.Group] {
+			out = append(out, l.ID)
+		}
+	}
+	return out
+}
+
+// lockedLines reports whether any of lines is locked
+func lockedLines(lines []model.Line) bool {
+	for _, l := range lines {
+		if l.Locked {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"goWhiteBoard/recovery"
+	"goWhiteBoard/render"
+	"image"
+	"log"
+	"slices"
+	"time"
+)
+
+const (
+	autosaveDelay    = 2 * time.Second        // 変更が止まってから保存するまでの時間
+	autosaveInterval = 30 * time.Second       // 変更が続いていても少なくともこの間隔で保存する
+	autosaveTick     = 500 * time.Millisecond // 変更を確かめる間隔
+)
+
+// Size of the thumbnail saved with an autosaved board
+const (
+	thumbnailWidth  = 160
+	thumbnailHeight = 120
+)
+
+// autosaveKey returns a value that changes whenever anything saved in a
+// board file changes
+func (w *whiteboard) autosaveKey() []uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	key := []uint64{w.edits}
+	for _, p := range w.pages {
+		key = append(key, p.doc.Snapshot().Version)
+	}
+	return key
+}
+
+// Autosave saves every page to session, with a thumbnail of the page shown
+func (w *whiteboard) Autosave(session *recovery.Session) error {
+	w.mutex.Lock()
+	pages, _ := w.boardPagesLocked()
+	state := w.pageStateLocked(w.pageIndex)
+	w.mutex.Unlock()
+	return session.Save(pages, state.thumbnail(thumbnailWidth, thumbnailHeight))
+}
+
+// thumbnail renders the page, or on a page without a size its content,
+// scaled down to fit into width × height
+func (s exportState) thumbnail(width, height int) *image.RGBA {
+	view := render.ContentView(s.lines(), 20, 1)
+	if s.pageSize.Fixed() {
+		view = s.exportView()
+	}
+	scale := min(float32(width)/float32(max(view.Width, 1)), float32(height)/float32(max(view.Height, 1)), 1)
+	view.Scale *= scale
+	view.Width = max(int(float32(view.Width)*scale), 1)
+	view.Height = max(int(float32(view.Height)*scale), 1)
+	return render.GroupsImage(s.exportGroups(view), view)
+}
+
+// autosaver saves a board to a recovery session shortly after it changes,
+// and at least every autosaveInterval while it keeps changing. Between
+// saves it keeps the session's heartbeat going.
+type autosaver struct {
+	board   *whiteboard
+	session *recovery.Session
+	stop    chan struct{}
+	done    chan struct{}
+
+	// 変更の検出（run のゴルーチンだけが使う）
+	key     []uint64  // 最後に見た autosaveKey
+	changed time.Time // 最後に変更を見た時刻
+	dirty   time.Time // 保存していない最初の変更を見た時刻（ゼロなら保存済み）
+	touched time.Time // 最後に心拍を記録した時刻
+}
+
+// startAutosave starts saving board to session in the background
+func startAutosave(board *whiteboard, session *recovery.Session) *autosaver {
+	a := &autosaver{
+		board:   board,
+		session: session,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		key:     board.autosaveKey(),
+		touched: time.Now(),
+	}
+	go a.run()
+	return a
+}
+
+// run checks the board for changes until Close is called
+func (a *autosaver) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(autosaveTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C:
+			a.tick(now)
+		}
+	}
+}
+
+// tick saves the board if it is due at now, or else touches the session
+// when the heartbeat is due
+func (a *autosaver) tick(now time.Time) {
+	if a.due(a.board.autosaveKey(), now) {
+		if err := a.board.Autosave(a.session); err != nil {
+			// 保存できなければ少し待ってやり直す
+			log.Printf("autosave: %v", err)
+			a.changed, a.dirty = now, now
+			return
+		}
+		a.dirty, a.touched = time.Time{}, now
+		return
+	}
+	if now.Sub(a.touched) >= recovery.Heartbeat {
+		if err := a.session.Touch(); err != nil {
+			log.Printf("autosave: %v", err)
+		}
+		a.touched = now
+	}
+}
+
+// due records key, the state of the board at now, and reports whether the
+// board should be saved: the changes have settled for autosaveDelay, or
+// have kept coming for autosaveInterval
+func (a *autosaver) due(key []uint64, now time.Time) bool {
+	if !slices.Equal(key, a.key) {
+		a.key, a.changed = key, now
+		if a.dirty.IsZero() {
+			a.dirty = now
+		}
+	}
+	if a.dirty.IsZero() {
+		return false
+	}
+	return now.Sub(a.changed) >= autosaveDelay || now.Sub(a.dirty) >= autosaveInterval
+}
+
+// SaveNow saves the board at once, e.g. right after restoring it
+func (a *autosaver) SaveNow() error {
+	return a.board.Autosave(a.session)
+}
+
+// Close stops saving on a clean exit, waiting for a save in progress, and
+// removes the session
+func (a *autosaver) Close() error {
+	close(a.stop)
+	<-a.done
+	return a.session.Close()
+}
@@ -0,0 +1,117 @@
+// Package cli implements the headless subcommands (render, export, convert,
+// send, serve). None of them open a window, so they run in scripts and on
+// machines without a display.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// command is one subcommand. run returns an error for failures worth a
+// non-zero exit status.
+type command struct {
+	usage string // arguments, e.g. "[flags] BOARD"
+	help  string
+	run   func(e *env, args []string) error
+}
+
+// env carries the output streams so commands can be tested
+type env struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands = map[string]command{}
+
+func register(name string, c command) {
+	commands[name] = c
+}
+
+// IsCommand reports whether name is a subcommand
+func IsCommand(name string) bool {
+	if name == "help" || name == "-h" || name == "--help" {
+		return true
+	}
+	_, ok := commands[name]
+	return ok
+}
+
+// Run executes the subcommand named by args[0] and returns the exit status
+func Run(args []string, stdout, stderr io.Writer) int {
+	e := &env{stdout: stdout, stderr: stderr}
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	c, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	if err := c.run(e, args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: goWhiteBoard <command> [flags] [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintln(w, "\nRun without a command to open the whiteboard.")
+}
+
+// newFlags creates the flag set of a subcommand with its usage line
+func newFlags(e *env, name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	flags.Usage = func() {
+		c := commands[name]
+		fmt.Fprintf(e.stderr, "usage: goWhiteBoard %s %s\n\n%s\n\nflags:\n", name, c.usage, c.help)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses flags and checks the number of positional arguments.
+// Flags may follow the positional arguments.
+func parseArgs(flags *flag.FlagSet, args []string, n int) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) != n {
+		flags.Usage()
+		return nil, fmt.Errorf("expected %d argument(s), got %d (%s)", n, len(positional), strings.Join(positional, " "))
+	}
+	return positional, nil
+}
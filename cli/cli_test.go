@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"goWhiteBoard/model"
+	"goWhiteBoard/util"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestBoard saves a small board in a temporary directory
+func writeTestBoard(t *testing.T) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "sketch"+model.BoardExt)
+	lines := []model.Line{{Points: []model.Point{{X: 0, Y: 0}, {X: 40, Y: 20}}, Color: color.Black, Width: 2}}
+	if err := model.SaveBoard(filename, lines); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func run(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = Run(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestRender(t *testing.T) {
+	board := writeTestBoard(t)
+	for _, format := range []string{"png", "svg", "pdf"} {
+		out := filepath.Join(filepath.Dir(board), "out."+format)
+		if code, _, stderr := run("render", board, "-o", out); code != 0 {
+			t.Fatalf("render %s: exit %d: %s", format, code, stderr)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil || len(data) == 0 {
+			t.Errorf("%s: %v", out, err)
+		}
+	}
+
+	// -o を省略するとボードの隣に書き出す
+	if code, stdout, _ := run("render", "-format", "svg", board); code != 0 || strings.TrimSpace(stdout) != strings.TrimSuffix(board, model.BoardExt)+".svg" {
+		t.Errorf("default output: exit %d, %q", code, stdout)
+	}
+}
+
+func TestExport(t *testing.T) {
+	board := writeTestBoard(t)
+	dir := filepath.Join(filepath.Dir(board), "out")
+	if code, _, stderr := run("export", "-dir", dir, board); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	for _, name := range []string{"sketch.png", "sketch.svg", "sketch.pdf"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestExportPages(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "deck"+model.BoardExt)
+	line := model.Line{Points: []model.Point{{X: 0, Y: 0}, {X: 40, Y: 20}}, Color: color.Black, Width: 2}
+	pages := []model.BoardPage{{Name: "Intro", Lines: []model.Line{line}}, {Name: "Plan", Lines: []model.Line{line}}}
+	if err := model.SavePages(filename, pages); err != nil {
+		t.Fatal(err)
+	}
+
+	// PNG はページごと、PDF はまとめて 1 ファイル
+	dir := filepath.Join(filepath.Dir(filename), "out")
+	if code, _, stderr := run("export", "-dir", dir, "-formats", "png,pdf", filename); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	for _, name := range []string{"deck-1.png", "deck-2.png", "deck.pdf"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if pdf, _ := os.ReadFile(filepath.Join(dir, "deck.pdf")); !strings.Contains(string(pdf), "/Count 2") {
+		t.Error("the PDF does not have two pages")
+	}
+
+	if code, _, _ := run("render", "-page", "3", filename); code == 0 {
+		t.Error("rendering a missing page succeeded")
+	}
+	out := filepath.Join(dir, "plan.svg")
+	if code, _, stderr := run("render", "-page", "2", "-o", out, filename); code != 0 {
+		t.Fatalf("render -page 2: exit %d: %s", code, stderr)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	var sent []byte
+	convertImage = func(image []byte) (util.Extraction, error) {
+		sent = image
+		return util.ExtractArtifact("```html\n<html><body><p>ok</p><script>alert(1)</script></body></html>\n```"), nil
+	}
+	t.Cleanup(func() { convertImage = util.ConvertImage })
+
+	board := writeTestBoard(t)
+	raw := filepath.Join(filepath.Dir(board), "reply.txt")
+	if code, _, stderr := run("convert", "-raw", raw, board); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !bytes.HasPrefix(sent, []byte("\x89PNG")) {
+		t.Error("the board was not sent as a PNG")
+	}
+	html, _ := os.ReadFile(strings.TrimSuffix(board, model.BoardExt) + ".html")
+	if !strings.Contains(string(html), "<p>ok</p>") || strings.Contains(string(html), "alert") {
+		t.Errorf("artifact not sanitized: %s", html)
+	}
+	if reply, _ := os.ReadFile(raw); !strings.Contains(string(reply), "```html") {
+		t.Errorf("raw reply = %q", reply)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	convertImage = func([]byte) (util.Extraction, error) { return util.Extraction{}, errors.New("no API key") }
+	t.Cleanup(func() { convertImage = util.ConvertImage })
+
+	board := writeTestBoard(t)
+	for _, args := range [][]string{
+		{"nosuchcommand"},
+		{"render"},
+		{"render", "missing" + model.BoardExt},
+		{"render", "-o", "out.gif", board},
+		{"convert", board},
+	} {
+		if code, _, _ := run(args...); code == 0 {
+			t.Errorf("%v succeeded", args)
+		}
+	}
+}
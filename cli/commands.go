@@ -0,0 +1,276 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"goWhiteBoard/collab"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"goWhiteBoard/util"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// convertImage is the AI conversion pipeline; tests replace it
+var convertImage = util.ConvertImage
+
+func init() {
+	register("render", command{
+		usage: "[-o FILE] [-format png|svg|pdf] [-page N] [-scale N] [-margin PX] BOARD",
+		help:  "render a page of a board file to a PNG, SVG or PDF file",
+		run:   runRender,
+	})
+	register("export", command{
+		usage: "[-dir DIR] [-formats png,svg,pdf] [-page N] [-scale N] [-margin PX] BOARD",
+		help:  "render the pages of a board file to several formats at once",
+		run:   runExport,
+	})
+	register("convert", command{
+		usage: "[-o FILE] [-raw FILE] [-trusted] [-page N] [-scale N] [-margin PX] BOARD",
+		help:  "render a board and convert it with the AI model into an HTML artifact",
+		run:   runConvert,
+	})
+	register("send", command{
+		usage: "[-o FILE] [-raw FILE] [-trusted] IMAGE",
+		help:  "convert an existing PNG image with the AI model into an HTML artifact",
+		run:   runSend,
+	})
+	register("serve", command{
+		usage: "[-addr HOST:PORT] [-token TOKEN]",
+		help:  "run the collaboration server",
+		run:   runServe,
+	})
+}
+
+func runRender(e *env, args []string) error {
+	flags := newFlags(e, "render")
+	out := flags.String("o", "", "output file (default: BOARD with the format's extension)")
+	format := flags.String("format", "", "output format: png, svg or pdf (default: from -o, else png)")
+	page := flags.Int("page", 1, "page to render, counting from 1")
+	scale := flags.Float64("scale", 1, "output pixels per board unit")
+	margin := flags.Int("margin", 20, "blank border around the content in output pixels")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	p, err := loadPage(positional[0], *page)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *format == "" && *out == "":
+		*format = render.FormatPNG
+	case *format == "":
+		if *format, err = render.FormatOf(*out); err != nil {
+			return err
+		}
+	}
+	if *out == "" {
+		*out = replaceExt(positional[0], "."+*format)
+	}
+
+	if *scale <= 0 {
+		return fmt.Errorf("-scale must be positive")
+	}
+	view := render.ContentView(p.Lines, *margin, float32(*scale))
+	if err := writeFile(*out, func(buf *bytes.Buffer) error {
+		return render.WriteGroups(buf, *format, render.LayersWithGrid(p.Lines, p.Layers, p.Grid, view), view)
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintln(e.stdout, *out)
+	return nil
+}
+
+func runExport(e *env, args []string) error {
+	flags := newFlags(e, "export")
+	dir := flags.String("dir", ".", "output directory")
+	formats := flags.String("formats", strings.Join(render.Formats, ","), "comma separated output formats")
+	page := flags.Int("page", 0, "page to export, counting from 1 (default: every page)")
+	scale := flags.Float64("scale", 1, "output pixels per board unit")
+	margin := flags.Int("margin", 20, "blank border around the content in output pixels")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	pages, err := model.LoadPages(positional[0])
+	if err != nil {
+		return err
+	}
+	if *page != 0 {
+		if *page < 1 || *page > len(pages) {
+			return fmt.Errorf("-page %d: the board has %d pages", *page, len(pages))
+		}
+		pages = pages[*page-1 : *page]
+	}
+	if *scale <= 0 {
+		return fmt.Errorf("-scale must be positive")
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	views := make([]render.Page, len(pages))
+	for i, p := range pages {
+		view := render.ContentView(p.Lines, *margin, float32(*scale))
+		views[i] = render.Page{Groups: render.LayersWithGrid(p.Lines, p.Layers, p.Grid, view), View: view}
+	}
+	base := replaceExt(filepath.Base(positional[0]), "")
+	for _, format := range strings.Split(*formats, ",") {
+		format = strings.TrimSpace(format)
+		// PDF はページをまとめて 1 つのファイルに、ほかはページごとのファイルにする
+		if format == render.FormatPDF || len(views) == 1 {
+			out := filepath.Join(*dir, base+"."+format)
+			if err := writeFile(out, func(buf *bytes.Buffer) error {
+				if format == render.FormatPDF {
+					return render.WritePDFPages(buf, views)
+				}
+				return render.WriteGroups(buf, format, views[0].Groups, views[0].View)
+			}); err != nil {
+				return err
+			}
+			fmt.Fprintln(e.stdout, out)
+			continue
+		}
+		for i, v := range views {
+			out := filepath.Join(*dir, fmt.Sprintf("%s-%d.%s", base, i+1, format))
+			if err := writeFile(out, func(buf *bytes.Buffer) error {
+				return render.WriteGroups(buf, format, v.Groups, v.View)
+			}); err != nil {
+				return err
+			}
+			fmt.Fprintln(e.stdout, out)
+		}
+	}
+	return nil
+}
+
+func runConvert(e *env, args []string) error {
+	flags := newFlags(e, "convert")
+	out := flags.String("o", "", "artifact file (default: BOARD with .html)")
+	raw := flags.String("raw", "", "also write the model's raw reply to this file")
+	trusted := flags.Bool("trusted", false, "keep scripts from trusted CDNs (needed for Mermaid)")
+	page := flags.Int("page", 1, "page to convert, counting from 1")
+	scale := flags.Float64("scale", 1, "output pixels per board unit")
+	margin := flags.Int("margin", 20, "blank border around the content in output pixels")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	p, err := loadPage(positional[0], *page)
+	if err != nil {
+		return err
+	}
+	if *scale <= 0 {
+		return fmt.Errorf("-scale must be positive")
+	}
+	view := render.ContentView(p.Lines, *margin, float32(*scale))
+	var image bytes.Buffer
+	if err := render.WriteGroups(&image, render.FormatPNG, render.LayersWithGrid(p.Lines, p.Layers, p.Grid, view), view); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		*out = replaceExt(positional[0], ".html")
+	}
+	return convert(e, image.Bytes(), *out, *raw, *trusted)
+}
+
+func runSend(e *env, args []string) error {
+	flags := newFlags(e, "send")
+	out := flags.String("o", "", "artifact file (default: IMAGE with .html)")
+	raw := flags.String("raw", "", "also write the model's raw reply to this file")
+	trusted := flags.Bool("trusted", false, "keep scripts from trusted CDNs (needed for Mermaid)")
+	positional, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	image, err := os.ReadFile(positional[0])
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = replaceExt(positional[0], ".html")
+	}
+	return convert(e, image, *out, *raw, *trusted)
+}
+
+// convert runs the AI pipeline on a PNG image and writes the sanitized
+// artifact (and optionally the raw reply)
+func convert(e *env, image []byte, out, raw string, trusted bool) error {
+	// API_KEY などは .env からも読む（なければ環境変数のみ）
+	godotenv.Load()
+
+	result, err := convertImage(image)
+	if err != nil {
+		return err
+	}
+
+	policy := util.StrictPolicy
+	if trusted {
+		policy = util.TrustedPolicy
+	}
+	if err := os.WriteFile(out, []byte(util.SanitizeHTML(result.Content, policy)), 0o644); err != nil {
+		return err
+	}
+	if raw != "" {
+		if err := os.WriteFile(raw, []byte(result.Raw), 0o644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(e.stdout, "%s: %s\n", out, result.Summary())
+	if result.NeedsScripts && !trusted {
+		fmt.Fprintln(e.stderr, "note: the artifact needs scripts to render; rerun with -trusted")
+	}
+	return nil
+}
+
+func runServe(e *env, args []string) error {
+	flags := newFlags(e, "serve")
+	addr := flags.String("addr", "localhost:8080", "address to listen on (use :8080 to accept other machines)")
+	token := flags.String("token", os.Getenv("WHITEBOARD_COLLAB_TOKEN"), "token participants must enter (default: $WHITEBOARD_COLLAB_TOKEN or a random one)")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	if *token == "" {
+		*token = collab.NewToken()
+	}
+	fmt.Fprintf(e.stdout, "token: %s\n", *token)
+	return collab.ListenAndServe(*addr, *token)
+}
+
+// writeFile renders into memory first so a failed render leaves no
+// half-written file behind
+// loadPage reads page n, counting from 1, of a board file
+func loadPage(filename string, n int) (model.BoardPage, error) {
+	pages, err := model.LoadPages(filename)
+	if err != nil {
+		return model.BoardPage{}, err
+	}
+	if n < 1 || n > len(pages) {
+		return model.BoardPage{}, fmt.Errorf("-page %d: the board has %d pages", n, len(pages))
+	}
+	return pages[n-1], nil
+}
+
+func writeFile(filename string, fill func(*bytes.Buffer) error) error {
+	var buf bytes.Buffer
+	if err := fill(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, buf.Bytes(), 0o644)
+}
+
+// replaceExt swaps the extension of filename for ext
+func replaceExt(filename, ext string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"goWhiteBoard/snap"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+)
+
+const (
+	pasteStep       = 20 // 同じ内容を続けて貼り付けたときにずらす量（画面上のピクセル）
+	defaultTextSize = 24 // 貼り付けた文字の大きさ（画面上のピクセル）
+	copyImageMargin = 10 // 選択範囲を画像としてコピーするときの余白
+)
+
+// SelectedLines returns copies of the selected lines in document order
+func (w *whiteboard) SelectedLines() []model.Line {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.selectedLinesLocked(w.doc.Snapshot().Lines)
+}
+
+// PasteLines adds lines as one undoable edit and selects them with the
+// select tool. Lines that would be visible are shifted by shift steps, so
+// repeated pastes do not cover each other; others are centred in the view.
+// The lines go to the current layer.
+func (w *whiteboard) PasteLines(lines []model.Line, shift int) error {
+	b, ok := snap.Bounds(lines)
+	if !ok {
+		return nil
+	}
+	w.mutex.Lock()
+	if w.layerLockedLocked() {
+		w.mutex.Unlock()
+		return errLayerLocked
+	}
+	layer := w.pages[w.pageIndex].layer
+	visible := snap.Rect{Min: w.view.toWorld(fyne.Position{}), Max: w.view.toWorld(fyne.NewPos(w.size.Width, w.size.Height))}
+	var d model.Point
+	if visible.Contains(b) || w.size.IsZero() {
+		step := float32(shift) * pasteStep / w.view.scale
+		d = model.Point{X: step, Y: step}
+	} else {
+		d = model.Point{
+			X: (visible.Min.X+visible.Max.X)/2 - (b.Min.X+b.Max.X)/2,
+			Y: (visible.Min.Y+visible.Max.Y)/2 - (b.Min.Y+b.Max.Y)/2,
+		}
+	}
+	history := w.history
+	w.mutex.Unlock()
+
+	// 貼り付けた図形は元の図形とは別のグループにし、別にコネクターでつなげるようにする
+	lines = model.RenameCopies(lines)
+	add := make([]model.Line, len(lines))
+	for i, l := range lines {
+		add[i] = translateLine(l, d)
+		add[i].Layer = layer
+	}
+	// 通知で再描画するのでロックの外で追加する
+	ids := history.Update(nil, add)
+	w.mutex.Lock()
+	w.tool = toolSelect
+	w.selection = ids
+	w.mutex.Unlock()
+	w.Refresh()
+	return nil
+}
+
+// AddText places text in the middle of the visible area in the pen color,
+// at a size that reads the same at every zoom. Adding it is one undoable
+// edit.
+func (w *whiteboard) AddText(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("there is no text to paste")
+	}
+	w.mutex.Lock()
+	if w.layerLockedLocked() {
+		w.mutex.Unlock()
+		return errLayerLocked
+	}
+	size := defaultTextSize / w.view.scale
+	l := render.TextLine(text, model.Point{}, size, render.Opaque(w.lineColor))
+	// 中心を表示領域の中心に合わせる
+	c := w.view.toWorld(fyne.NewPos(w.size.Width/2, w.size.Height/2))
+	l = translateLine(l, model.Point{X: c.X - l.Points[1].X/2, Y: c.Y - l.Points[1].Y/2})
+	l.Layer = w.pages[w.pageIndex].layer
+	history := w.history
+	selectNew := w.tool == toolSelect
+	w.mutex.Unlock()
+
+	id := history.Add(l)
+	if selectNew {
+		w.mutex.Lock()
+		w.selection = []model.ID{id}
+		w.mutex.Unlock()
+		w.Refresh()
+	}
+	return nil
+}
+
+// SelectionPNG renders the selection cropped to its bounds, or the page
+// shown as Send would without a selection, as a PNG image
+func (w *whiteboard) SelectionPNG() ([]byte, error) {
+	selected := w.SelectedLines()
+	if len(selected) == 0 {
+		return w.PagesPNG([]int{w.CurrentPage()}, nil)
+	}
+	var buf bytes.Buffer
+	if err := render.WritePNG(&buf, selected, render.ContentView(selected, copyImageMargin, 1)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// boardClipboard copies board content through the window's clipboard.
+// Fyne's clipboard only carries text, so lines are copied in the model's
+// clipboard format and images as data: URIs, which other instances of the
+// program and many other programs can paste.
+type boardClipboard struct {
+	window fyne.Window
+	board  *whiteboard
+	last   string // 最後にコピー・貼り付けした内容
+	pastes int    // last を続けて貼り付けた回数
+}
+
+func newBoardClipboard(window fyne.Window, board *whiteboard) *boardClipboard {
+	return &boardClipboard{window: window, board: board}
+}
+
+// Copy puts the selected lines on the clipboard
+func (c *boardClipboard) Copy() bool {
+	lines := c.board.SelectedLines()
+	if len(lines) == 0 {
+		return false
+	}
+	text, err := model.WriteClip(lines)
+	if err != nil {
+		dialog.ShowError(err, c.window)
+		return false
+	}
+	c.window.Clipboard().SetContent(text)
+	c.last, c.pastes = text, 0
+	return true
+}
+
+// Cut copies the selected lines and removes them
+func (c *boardClipboard) Cut() {
+	if c.Copy() {
+		c.board.DeleteSelection()
+	}
+}
+
+// CopyImage puts the selection, or the page shown, on the clipboard as a
+// PNG data: URI
+func (c *boardClipboard) CopyImage() {
+	data, err := c.board.SelectionPNG()
+	if err != nil {
+		dialog.ShowError(err, c.window)
+		return
+	}
+	c.window.Clipboard().SetContent("data:image/png;base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+// Paste adds what is on the clipboard: copied lines, an image, or plain
+// text as a text object
+func (c *boardClipboard) Paste() {
+	text := c.window.Clipboard().Content()
+	if lines, ok := model.ReadClip(text); ok {
+		if text != c.last {
+			c.last, c.pastes = text, 0
+		}
+		c.pastes++
+		if err := c.board.PasteLines(lines, c.pastes); err != nil {
+			dialog.ShowError(err, c.window)
+		}
+		return
+	}
+	img, err := clipboardImage(text)
+	switch {
+	case err == nil:
+		err = c.board.AddImage(img, false)
+	case errors.Is(err, errNoImage):
+		err = c.board.AddText(text)
+	}
+	if err != nil {
+		dialog.ShowError(err, c.window)
+	}
+}
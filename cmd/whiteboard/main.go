@@ -0,0 +1,13 @@
+// Command whiteboard is the headless build of the whiteboard tools. It runs
+// the same subcommands as the desktop app (render, export, convert, send,
+// serve) but does not link the GUI, so it builds and runs without a display.
+package main
+
+import (
+	"goWhiteBoard/cli"
+	"os"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,360 @@
+package collab
+
+import (
+	"goWhiteBoard/model"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// serverOrigin marks document changes that came from the room history
+const serverOrigin = "collab"
+
+// Reconnect backoff and cursor throttling
+const (
+	minBackoff     = 500 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+	cursorInterval = 30 * time.Millisecond
+)
+
+// Client keeps a local document in sync with a room on a Server. Local edits
+// are sent as they happen and remote ones are merged into the document with
+// the peer id as origin. Because the document is a CRDT nothing has to be
+// confirmed or replayed in order: after a reconnect the client merges the
+// room history and uploads whatever the room has not seen yet.
+type Client struct {
+	url   string
+	token string
+	name  string
+	color string
+	doc   *model.Document
+	// 一度に送る操作の大きさの上限（バイト）。テストでは小さくする
+	batchSize int
+
+	// OnPeers is called with the other participants whenever presence
+	// changes. Set it before calling Start.
+	OnPeers func([]Peer)
+	// OnStatus is called when the connection is established or lost. Set it
+	// before calling Start.
+	OnStatus func(connected bool)
+	// OnRejected is called with the reason when the server turns the client
+	// away, e.g. for a wrong token, or when a line is too large to share.
+	// The client stops reconnecting. Set it before calling Start.
+	OnRejected func(reason string)
+
+	mutex       sync.Mutex
+	ws          *websocket.Conn
+	queue       chan message // ws に送るメッセージ（書き込み用のゴルーチンが送る）
+	id          string
+	peers       map[string]Peer
+	lastCursor  time.Time
+	closed      bool
+	done        chan struct{}
+	unsubscribe func()
+}
+
+// NewClient creates a client for the room at url (e.g.
+// "ws://localhost:8080/ws?room=design") that joins with the server's token.
+// name and color ("#rrggbb") are shown to the other participants.
+func NewClient(url, token, name, color string, doc *model.Document) *Client {
+	return &Client{
+		url:       url,
+		token:     token,
+		name:      name,
+		color:     color,
+		doc:       doc,
+		batchSize: maxBatchSize,
+		peers:     map[string]Peer{},
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins syncing in the background. Lines already on the board are
+// uploaded to the room once connected.
+func (c *Client) Start() {
+	c.unsubscribe = c.doc.Subscribe(c.localChange)
+	go c.run()
+}
+
+// Close disconnects and stops reconnecting
+func (c *Client) Close() {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return
+	}
+	c.closed = true
+	if c.ws != nil {
+		c.ws.Close()
+	}
+	close(c.done)
+	c.mutex.Unlock()
+
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+	}
+}
+
+// Connected reports whether the client is currently joined to the room
+func (c *Client) Connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ws != nil
+}
+
+// Peers returns the other participants ordered by id
+func (c *Client) Peers() []Peer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.peersLocked()
+}
+
+// SendCursor shares the local cursor position (world coordinates). Updates
+// are throttled and dropped while disconnected.
+func (c *Client) SendCursor(p model.Point) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ws == nil || time.Since(c.lastCursor) < cursorInterval {
+		return
+	}
+	c.lastCursor = time.Now()
+	c.sendLocked(message{Type: msgCursor, Cursor: &p})
+}
+
+// localChange sends the operations of local edits. While disconnected they
+// are only kept in the document and uploaded on the next connect.
+func (c *Client) localChange(change model.Change) {
+	if change.Origin != "" {
+		// リモートから適用した変更は送り返さない
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ws != nil {
+		c.sendLocked(message{Type: msgOps, Ops: change.Ops})
+	}
+}
+
+// sendLocked queues msg for the current connection without waiting for the
+// network, so a slow connection never holds up drawing. A cursor update is
+// dropped when the queue is full; otherwise the connection is closed and
+// the client resyncs when it reconnects.
+func (c *Client) sendLocked(msg message) {
+	select {
+	case c.queue <- msg:
+	default:
+		if msg.Type != msgCursor {
+			// 書き込み中の送信を止めないと Close が待ち続ける
+			c.ws.SetWriteDeadline(time.Now())
+			c.ws.Close()
+		}
+	}
+}
+
+// writeLoop sends the queued messages on ws until the queue is closed. A
+// failed send closes the connection; the read loop notices and reconnects.
+func (c *Client) writeLoop(ws *websocket.Conn, queue <-chan message) {
+	for msg := range queue {
+		if err := c.send(ws, msg); err != nil {
+			ws.Close()
+			// 残りは接続が終わって queue が閉じられるまで読み捨てる
+			for range queue {
+			}
+			return
+		}
+	}
+}
+
+// send sends msg on ws, splitting its operations into messages the server
+// accepts. An operation too large to send stops the client with a reason,
+// since the boards could not agree any more.
+func (c *Client) send(ws *websocket.Conn, msg message) error {
+	if msg.Type != msgOps {
+		return websocket.JSON.Send(ws, msg)
+	}
+	// 描画を待たせないよう、分けるのは送信用のゴルーチンで行う
+	sends, err := batches(msg.Ops, c.batchSize)
+	if err != nil {
+		c.rejected(err.Error())
+		return err
+	}
+	for _, ops := range sends {
+		msg.Ops = ops
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) run() {
+	backoff := minBackoff
+	for {
+		ws, err := websocket.Dial(c.url, "", "http://localhost/")
+		if err == nil {
+			backoff = minBackoff
+			c.session(ws)
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil && backoff < maxBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// session runs one connection until it fails
+func (c *Client) session(ws *websocket.Conn) {
+	defer ws.Close()
+	ws.MaxPayloadBytes = maxMessageSize
+
+	hello := message{Type: msgHello, Token: c.token, Name: c.name, Color: c.color}
+	if err := websocket.JSON.Send(ws, hello); err != nil {
+		return
+	}
+	var welcome message
+	ws.SetReadDeadline(time.Now().Add(helloTimeout))
+	if err := websocket.JSON.Receive(ws, &welcome); err != nil {
+		return
+	}
+	if welcome.Type == msgRejected {
+		c.rejected(welcome.Error)
+		return
+	}
+	if welcome.Type != msgWelcome {
+		return
+	}
+	ws.SetReadDeadline(time.Time{})
+
+	// 長い履歴は ops に分けて続けて届く
+	history := welcome.Ops
+	for more := welcome.More; more; {
+		var msg message
+		if err := websocket.JSON.Receive(ws, &msg); err != nil || msg.Type != msgOps {
+			return
+		}
+		history = append(history, msg.Ops...)
+		more = msg.More
+	}
+
+	if !c.connect(ws, welcome, history) {
+		return
+	}
+	c.notifyStatus(true)
+	c.notifyPeers()
+
+	for {
+		var msg message
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			break
+		}
+		c.apply(msg)
+	}
+
+	c.mutex.Lock()
+	if c.ws == ws {
+		c.ws = nil
+		close(c.queue)
+		c.queue = nil
+	}
+	c.peers = map[string]Peer{}
+	closed := c.closed
+	c.mutex.Unlock()
+	if !closed {
+		// Close した後は呼び出し側に通知しない
+		c.notifyStatus(false)
+		c.notifyPeers()
+	}
+}
+
+// connect merges the room history and uploads the operations the room is
+// missing, such as edits made while offline
+func (c *Client) connect(ws *websocket.Conn, welcome message, history []model.Op) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return false
+	}
+
+	c.id = welcome.From
+	c.peers = map[string]Peer{}
+	for _, p := range welcome.Peers {
+		c.peers[p.ID] = p
+	}
+
+	c.doc.Apply(serverOrigin, history...)
+
+	known := make(map[model.ID]bool, len(history))
+	for _, op := range history {
+		known[op.ID] = true
+	}
+	var missing []model.Op
+	for _, op := range c.doc.Ops() {
+		if !known[op.ID] {
+			missing = append(missing, op)
+		}
+	}
+
+	c.ws = ws
+	c.queue = make(chan message, sendQueueSize)
+	go c.writeLoop(ws, c.queue)
+	if len(missing) > 0 {
+		c.sendLocked(message{Type: msgOps, Ops: missing})
+	}
+	return true
+}
+
+// apply handles a message relayed from another participant
+func (c *Client) apply(msg message) {
+	switch msg.Type {
+	case msgOps:
+		c.doc.Apply(msg.From, msg.Ops...)
+	case msgCursor:
+		c.mutex.Lock()
+		c.peers[msg.From] = Peer{ID: msg.From, Name: msg.Name, Color: msg.Color, Cursor: msg.Cursor}
+		c.mutex.Unlock()
+		c.notifyPeers()
+	case msgLeave:
+		c.mutex.Lock()
+		delete(c.peers, msg.From)
+		c.mutex.Unlock()
+		c.notifyPeers()
+	case msgRejected:
+		c.rejected(msg.Error)
+	}
+}
+
+// rejected stops the client after the server turned it away
+func (c *Client) rejected(reason string) {
+	c.Close()
+	if c.OnRejected != nil {
+		c.OnRejected(reason)
+	}
+}
+
+func (c *Client) peersLocked() []Peer {
+	peers := make([]Peer, 0, len(c.peers))
+	for _, p := range c.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+func (c *Client) notifyPeers() {
+	if c.OnPeers != nil {
+		c.OnPeers(c.Peers())
+	}
+}
+
+func (c *Client) notifyStatus(connected bool) {
+	if c.OnStatus != nil {
+		c.OnStatus(connected)
+	}
+}
@@ -0,0 +1,435 @@
+package collab
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"goWhiteBoard/model"
+	"image/color"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const testToken = "secret"
+
+func testLine(x float32) model.Line {
+	return model.Line{
+		Points: []model.Point{{X: x, Y: 0}, {X: x, Y: 10}},
+		Color:  color.NRGBA{R: 255, A: 255},
+		Width:  2,
+	}
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// xs returns the x coordinate of every line, which identifies test lines
+func xs(doc *model.Document) string {
+	var b strings.Builder
+	for _, l := range doc.Snapshot().Lines {
+		b.WriteString(string(rune('0' + int(l.Points[0].X))))
+	}
+	return b.String()
+}
+
+type testRoom struct {
+	t      *testing.T
+	server *Server
+	http   *httptest.Server
+}
+
+func newTestRoom(t *testing.T) *testRoom {
+	s := NewServer(testToken)
+	h := httptest.NewServer(s)
+	t.Cleanup(h.Close)
+	return &testRoom{t: t, server: s, http: h}
+}
+
+// join connects a new client; setup may install callbacks before it starts
+func (r *testRoom) join(name string, setup ...func(*Client)) (*Client, *model.Document) {
+	doc := model.NewDocument()
+	c := NewClient("ws"+strings.TrimPrefix(r.http.URL, "http")+"/?room=test", testToken, name, "#ff0000", doc)
+	for _, fn := range setup {
+		fn(c)
+	}
+	c.Start()
+	r.t.Cleanup(c.Close)
+	waitFor(r.t, name+" to connect", c.Connected)
+	return c, doc
+}
+
+func TestSyncBetweenClients(t *testing.T) {
+	room := newTestRoom(t)
+	_, docA := room.join("alice")
+	_, docB := room.join("bob")
+	_, docC := room.join("carol")
+
+	docA.Add(testLine(1))
+	docB.Add(testLine(2))
+	for _, doc := range []*model.Document{docA, docB, docC} {
+		doc := doc
+		waitFor(t, "lines to sync", func() bool { return doc.Len() == 2 })
+	}
+
+	docC.Clear()
+	for _, doc := range []*model.Document{docA, docB, docC} {
+		doc := doc
+		waitFor(t, "clear to sync", func() bool { return doc.Len() == 0 })
+	}
+
+	docB.Add(testLine(3))
+	waitFor(t, "line after clear", func() bool { return xs(docA) == "3" && xs(docC) == "3" })
+}
+
+func TestLateJoinerReceivesState(t *testing.T) {
+	room := newTestRoom(t)
+	_, docA := room.join("alice")
+	docA.Add(testLine(1))
+	docA.Add(testLine(2))
+
+	// サーバーに届いてから参加する
+	waitFor(t, "server to store lines", func() bool {
+		r := room.server.room("test")
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return len(r.ops) == 2
+	})
+
+	_, docB := room.join("bob")
+	waitFor(t, "late joiner state", func() bool { return xs(docB) == "12" })
+}
+
+func TestLocalBoardIsUploadedOnJoin(t *testing.T) {
+	room := newTestRoom(t)
+	_, docA := room.join("alice")
+
+	doc := model.NewDocument()
+	doc.Add(testLine(5))
+	c := NewClient("ws"+strings.TrimPrefix(room.http.URL, "http")+"/?room=test", testToken, "bob", "#00ff00", doc)
+	c.Start()
+	t.Cleanup(c.Close)
+
+	waitFor(t, "existing line to be shared", func() bool { return xs(docA) == "5" && xs(doc) == "5" })
+}
+
+func TestPresence(t *testing.T) {
+	room := newTestRoom(t)
+
+	var mutex sync.Mutex
+	var seen []Peer
+	room.join("alice", func(a *Client) {
+		a.OnPeers = func(peers []Peer) {
+			mutex.Lock()
+			seen = peers
+			mutex.Unlock()
+		}
+	})
+	peers := func() []Peer {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return seen
+	}
+
+	b, _ := room.join("bob")
+	waitFor(t, "bob to appear", func() bool { p := peers(); return len(p) == 1 && p[0].Name == "bob" })
+
+	b.SendCursor(model.Point{X: 12, Y: 34})
+	waitFor(t, "bob's cursor", func() bool {
+		p := peers()
+		return len(p) == 1 && p[0].Cursor != nil && *p[0].Cursor == model.Point{X: 12, Y: 34}
+	})
+
+	b.Close()
+	waitFor(t, "bob to leave", func() bool { return len(peers()) == 0 })
+}
+
+func TestReconnectKeepsEdits(t *testing.T) {
+	room := newTestRoom(t)
+	a, docA := room.join("alice")
+	_, docB := room.join("bob")
+
+	docA.Add(testLine(1))
+	waitFor(t, "first line", func() bool { return xs(docB) == "1" })
+
+	room.server.DisconnectAll()
+	waitFor(t, "disconnect", func() bool { return !a.Connected() })
+
+	// 切断中の編集は再接続後に送られる
+	docA.Add(testLine(2))
+	docB.Add(testLine(3))
+
+	waitFor(t, "reconnect", a.Connected)
+	waitFor(t, "edits made offline to sync", func() bool {
+		return docA.Len() == 3 && docB.Len() == 3
+	})
+	waitFor(t, "the same order on both boards", func() bool { return xs(docA) == xs(docB) })
+
+	// 再送で重複しないこと
+	time.Sleep(100 * time.Millisecond)
+	if docA.Len() != 3 || docB.Len() != 3 {
+		t.Errorf("lines duplicated: %q / %q", xs(docA), xs(docB))
+	}
+}
+
+func TestConcurrentEditsConverge(t *testing.T) {
+	room := newTestRoom(t)
+	_, docA := room.join("alice")
+	_, docB := room.join("bob")
+
+	// 双方が同時に描いても最終的に同じ順序になる
+	var wg sync.WaitGroup
+	for i, doc := range []*model.Document{docA, docB} {
+		wg.Add(1)
+		go func(doc *model.Document, base float32) {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				doc.Add(testLine(base + float32(j)))
+			}
+		}(doc, float32(i*4+1))
+	}
+	wg.Wait()
+
+	waitFor(t, "boards to converge", func() bool {
+		return docA.Len() == 8 && xs(docA) == xs(docB)
+	})
+}
+
+func TestAdmission(t *testing.T) {
+	room := newTestRoom(t)
+	url := "ws" + strings.TrimPrefix(room.http.URL, "http") + "/?room=test"
+
+	// 違うトークンでは参加できず、再接続もしない
+	rejected := make(chan string, 1)
+	c := NewClient(url, "wrong", "mallory", "#000000", model.NewDocument())
+	c.OnRejected = func(reason string) { rejected <- reason }
+	c.Start()
+	t.Cleanup(c.Close)
+	select {
+	case reason := <-rejected:
+		if reason != "wrong token" {
+			t.Errorf("rejected because %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wrong token admitted")
+	}
+
+	// ほかのサイトのページからは接続できない
+	if ws, err := websocket.Dial(url, "", "http://evil.example/"); err == nil {
+		ws.Close()
+		t.Error("foreign origin admitted")
+	}
+	ws, err := websocket.Dial(url, "", "http://127.0.0.1:3000/")
+	if err != nil {
+		t.Fatalf("local origin refused: %v", err)
+	}
+	ws.Close()
+}
+
+func TestRoomLimits(t *testing.T) {
+	room := newTestRoom(t)
+	rejected := make(chan string, 1)
+	_, docA := room.join("alice", func(a *Client) {
+		a.OnRejected = func(reason string) { rejected <- reason }
+	})
+
+	// 上限に達した部屋は新しい操作を受け取らず、送った人を切断する
+	r := room.server.room("test")
+	r.mutex.Lock()
+	r.ops = make([]model.Op, maxRoomOps)
+	r.mutex.Unlock()
+	docA.Add(testLine(1))
+	select {
+	case reason := <-rejected:
+		if reason != "the room is full" {
+			t.Errorf("rejected because %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("full room took more operations")
+	}
+
+	// 誰もいなくなった部屋は消える
+	waitFor(t, "the room to be dropped", func() bool {
+		room.server.mutex.Lock()
+		defer room.server.mutex.Unlock()
+		return len(room.server.rooms) == 0
+	})
+}
+
+func TestRoomCompaction(t *testing.T) {
+	room := newTestRoom(t)
+	_, docA := room.join("alice")
+
+	// 消された線の操作は上限に数えない
+	r := room.server.room("test")
+	r.mutex.Lock()
+	var targets []model.ID
+	for i := 1; i < maxRoomOps; i++ {
+		id := model.ID{Time: uint64(i), Replica: "old"}
+		r.ops = append(r.ops, model.Op{Kind: model.OpInsert, ID: id, Line: &model.Line{}})
+		r.ops = append(r.ops, model.Op{Kind: model.OpRaise, ID: model.ID{Time: uint64(i), Replica: "moves"}, Targets: []model.ID{id}})
+		r.seen[id] = true
+		targets = append(targets, id)
+	}
+	r.ops = append(r.ops, model.Op{Kind: model.OpDelete, ID: model.ID{Time: maxRoomOps, Replica: "old"}, Targets: targets})
+	r.mutex.Unlock()
+
+	docA.Add(testLine(1))
+	waitFor(t, "the room to take the line", func() bool {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return len(r.ops) == 2 && r.ops[0].Kind == model.OpDelete && r.ops[1].Kind == model.OpInsert
+	})
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.seen[targets[0]] {
+		t.Error("the dropped insert is no longer seen")
+	}
+}
+
+func TestBatches(t *testing.T) {
+	doc := model.NewDocument()
+	for i := 0; i < 5; i++ {
+		doc.Add(testLine(float32(i)))
+	}
+	ops := doc.Ops()
+	one, _ := json.Marshal(ops[0])
+
+	// 上限に収まるだけまとめ、収まらない操作は送れない
+	got, err := batches(ops, 2*len(one)+2)
+	if err != nil || len(got) != 3 || len(got[0]) != 2 || len(got[2]) != 1 {
+		t.Errorf("batches = %v, %v", got, err)
+	}
+	if _, err := batches(ops, len(one)); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("an operation over the limit: %v", err)
+	}
+	if got, err := batches(nil, maxBatchSize); err != nil || len(got) != 0 {
+		t.Errorf("no operations: %v, %v", got, err)
+	}
+
+	// 取り込める一番大きい画像の線も 1 つのメッセージで送れる
+	l := testLine(1)
+	l.Image = &model.Image{Format: model.ImagePNG, Data: []byte("\x89PNG\r\n\x1a\n")}
+	doc.Add(l)
+	ops = doc.Ops()
+	small, _ := json.Marshal(ops[len(ops)-1])
+	size := len(small) - base64.StdEncoding.EncodedLen(len(l.Image.Data)) + base64.StdEncoding.EncodedLen(model.MaxImageSize)
+	if size >= maxBatchSize {
+		t.Errorf("a line with the largest image takes %d bytes, more than %d", size, maxBatchSize)
+	}
+}
+
+func TestLargeBoardsAreSentInBatches(t *testing.T) {
+	room := newTestRoom(t)
+	room.server.batchSize = 300
+	small := func(c *Client) { c.batchSize = 300 }
+
+	// 1 つのメッセージに収まらない盤面も分けて送られ、後から来た人にも分けて届く
+	doc := model.NewDocument()
+	for i := 1; i <= 5; i++ {
+		doc.Add(testLine(float32(i)))
+	}
+	a := NewClient("ws"+strings.TrimPrefix(room.http.URL, "http")+"/?room=test", testToken, "alice", "#ff0000", doc)
+	small(a)
+	a.Start()
+	t.Cleanup(a.Close)
+	waitFor(t, "alice to connect", a.Connected)
+	waitFor(t, "the server to store the lines", func() bool {
+		r := room.server.room("test")
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return len(r.ops) == 5
+	})
+
+	_, docB := room.join("bob", small)
+	waitFor(t, "the lines to reach bob", func() bool { return xs(docB) == "12345" })
+	doc.Add(testLine(6))
+	waitFor(t, "a new line to reach bob", func() bool { return xs(docB) == "123456" })
+}
+
+func TestTooLargeLineIsReported(t *testing.T) {
+	room := newTestRoom(t)
+	room.server.batchSize = 1000
+
+	// 送れない線があれば再接続を繰り返さずに知らせる
+	long := testLine(1)
+	for i := 0; i < 100; i++ {
+		long.Points = append(long.Points, model.Point{X: float32(i)})
+	}
+	rejected := make(chan string, 1)
+	_, doc := room.join("alice", func(c *Client) {
+		c.batchSize = 1000
+		c.OnRejected = func(reason string) { rejected <- reason }
+	})
+	doc.Add(long)
+	select {
+	case reason := <-rejected:
+		if !strings.Contains(reason, "too large") {
+			t.Errorf("rejected because %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the line too large to share was not reported")
+	}
+
+	// 部屋も送れない大きさの操作は受け取らない
+	rejected = make(chan string, 1)
+	_, doc = room.join("bob", func(c *Client) {
+		c.OnRejected = func(reason string) { rejected <- reason }
+	})
+	doc.Add(long)
+	select {
+	case reason := <-rejected:
+		if !strings.Contains(reason, "too large") {
+			t.Errorf("rejected by the server because %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server took an operation too large to pass on")
+	}
+}
+
+func TestSlowPeerDoesNotBlockDrawing(t *testing.T) {
+	// 受け入れた後は何も読まないサーバー
+	h := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var hello message
+		websocket.JSON.Receive(ws, &hello)
+		websocket.JSON.Send(ws, message{Type: msgWelcome, From: "p1"})
+		time.Sleep(10 * time.Second)
+	}))
+	t.Cleanup(h.Close)
+
+	doc := model.NewDocument()
+	c := NewClient("ws"+strings.TrimPrefix(h.URL, "http")+"/", testToken, "alice", "#ff0000", doc)
+	c.Start()
+	t.Cleanup(c.Close)
+	waitFor(t, "alice to connect", c.Connected)
+
+	long := testLine(1)
+	for i := 0; i < 1000; i++ {
+		long.Points = append(long.Points, model.Point{X: float32(i), Y: float32(i)})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			doc.Add(long)
+			c.SendCursor(model.Point{X: float32(i)})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drawing blocked on a peer that does not read")
+	}
+}
@@ -0,0 +1,85 @@
+// Package collab shares a whiteboard between several people in real time.
+// A Server relays stroke operations and cursor positions between the Clients
+// in a room over WebSockets and keeps the room state for late joiners.
+package collab
+
+import (
+	"encoding/json"
+	"fmt"
+	"goWhiteBoard/model"
+)
+
+// maxMessageSize limits a single message in both directions (bytes). It
+// leaves room for a line with the largest image a board accepts, which
+// grows by a third in JSON.
+const maxMessageSize = 40 << 20
+
+// maxBatchSize is how much of a message its operations may take; the rest
+// is left for the envelope
+const maxBatchSize = maxMessageSize - 1<<20
+
+// Message types exchanged over the WebSocket
+//
+// Board edits travel as model.Op values. Operations commute and are applied
+// at most once, so the server only has to keep every operation of the room
+// and pass new ones on; on connecting, a client receives the room history and
+// sends back whatever it has that the room is missing (e.g. edits made
+// offline). Long lists of operations are split into batches that fit in a
+// message; a welcome with More set is followed by ops messages with the
+// rest of the history, the last of them without More.
+const (
+	msgHello    = "hello"    // client → server: token, name and color
+	msgWelcome  = "welcome"  // server → client: assigned id, room history and peers
+	msgRejected = "rejected" // server → client: wrong token or full room; the server disconnects
+	msgOps      = "ops"      // board operations
+	msgCursor   = "cursor"   // presence: a participant joined or moved the cursor
+	msgLeave    = "leave"    // presence: a participant left
+)
+
+// message is the single JSON envelope used in both directions
+type message struct {
+	Type   string       `json:"type"`
+	Token  string       `json:"token,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	From   string       `json:"from,omitempty"`
+	Name   string       `json:"name,omitempty"`
+	Color  string       `json:"color,omitempty"`
+	Ops    []model.Op   `json:"ops,omitempty"`
+	More   bool         `json:"more,omitempty"` // 部屋の履歴の続きがある
+	Cursor *model.Point `json:"cursor,omitempty"`
+	Peers  []Peer       `json:"peers,omitempty"`
+}
+
+// Peer is another participant in the room
+type Peer struct {
+	ID     string       `json:"id"`
+	Name   string       `json:"name"`
+	Color  string       `json:"color"` // "#rrggbb"
+	Cursor *model.Point `json:"cursor,omitempty"`
+}
+
+// batches splits ops into batches of at most limit bytes (maxBatchSize
+// outside tests). It fails if an operation is too large to be sent at all.
+func batches(ops []model.Op, limit int) ([][]model.Op, error) {
+	var out [][]model.Op
+	start, size := 0, 0
+	for i, op := range ops {
+		data, err := json.Marshal(op)
+		if err != nil {
+			return nil, err
+		}
+		n := len(data) + 1 // 区切りのカンマ
+		if n > limit {
+			return nil, fmt.Errorf("a line of %d MB is too large to share", len(data)>>20)
+		}
+		if size+n > limit {
+			out = append(out, ops[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	if start < len(ops) {
+		out = append(out, ops[start:])
+	}
+	return out, nil
+}
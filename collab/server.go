@@ -0,0 +1,369 @@
+package collab
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goWhiteBoard/model"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// 送信キューがあふれたクライアントは切断する
+const sendQueueSize = 256
+
+// errRejected ends the connection of a participant the server rejected
+var errRejected = errors.New("rejected")
+
+// helloTimeout is how long a new connection may take to introduce itself
+const helloTimeout = 10 * time.Second
+
+// Limits that keep a room from growing without bound
+const (
+	maxRoomOps = 100_000 // 部屋に残す操作の上限（消された線の操作は数えない）
+)
+
+// Server relays operations between the participants of each room and keeps
+// the operations of every room so late joiners receive the current board.
+// Every participant has to present the server's token, and browsers may
+// only connect from pages on the local machine. A room is dropped when the
+// last participant leaves; the boards of the participants keep its lines
+// and upload them again when they rejoin.
+type Server struct {
+	token     string
+	batchSize int // 一度に送る操作の大きさの上限（バイト）
+
+	mutex  sync.Mutex
+	rooms  map[string]*room
+	nextID int
+}
+
+type room struct {
+	mutex     sync.Mutex
+	ops       []model.Op
+	peers     map[string]*peerConn
+	seen      map[model.ID]bool // 受け取り済みの操作
+	batchSize int
+}
+
+type peerConn struct {
+	Peer
+	ws   *websocket.Conn
+	send chan message
+}
+
+// NewToken returns a random token for NewServer
+func NewToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewServer creates a server with no rooms that admits the participants
+// presenting token
+func NewServer(token string) *Server {
+	return &Server{token: token, batchSize: maxBatchSize, rooms: map[string]*room{}}
+}
+
+// ServeHTTP upgrades the request to a WebSocket. The room is chosen with the
+// "room" query parameter; without it everybody shares the default room.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	websocket.Server{Handshake: checkOrigin, Handler: s.handle}.ServeHTTP(w, r)
+}
+
+// checkOrigin refuses connections from web pages not served by the local
+// machine, so a page the user opens cannot join a room. Clients other than
+// browsers may leave the origin out.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	if r.Header.Get("Origin") == "" {
+		return nil
+	}
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || !isLocalHost(origin.Hostname()) {
+		return fmt.Errorf("origin %s may not join", r.Header.Get("Origin"))
+	}
+	config.Origin = origin
+	return nil
+}
+
+// isLocalHost reports whether host names the local machine
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ListenAndServe runs a collaboration server on addr with the WebSocket
+// endpoint at /ws, admitting the participants presenting token
+func ListenAndServe(addr, token string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", NewServer(token))
+	log.Printf("collaboration server listening on %s (ws://%s/ws)", addr, addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// DisconnectAll closes every client connection but keeps the room state.
+// Clients reconnect on their own.
+func (s *Server) DisconnectAll() {
+	s.mutex.Lock()
+	rooms := make([]*room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	s.mutex.Unlock()
+
+	for _, r := range rooms {
+		r.mutex.Lock()
+		for _, p := range r.peers {
+			p.ws.Close()
+		}
+		r.mutex.Unlock()
+	}
+}
+
+func (s *Server) room(name string) *room {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.roomLocked(name)
+}
+
+func (s *Server) roomLocked(name string) *room {
+	r, ok := s.rooms[name]
+	if !ok {
+		r = &room{peers: map[string]*peerConn{}, seen: map[model.ID]bool{}, batchSize: s.batchSize}
+		s.rooms[name] = r
+	}
+	return r
+}
+
+// join adds p to the room called name and returns the room
+func (s *Server) join(name string, p *peerConn) *room {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r := s.roomLocked(name)
+	r.join(p)
+	return r
+}
+
+// leave removes p from the room called name and drops the room once it is
+// empty
+func (s *Server) leave(name string, p *peerConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r := s.rooms[name]
+	if r == nil {
+		return
+	}
+	if r.leave(p) == 0 {
+		delete(s.rooms, name)
+	}
+}
+
+func (s *Server) newID() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextID++
+	return fmt.Sprintf("p%d", s.nextID)
+}
+
+func (s *Server) handle(ws *websocket.Conn) {
+	defer ws.Close()
+	ws.MaxPayloadBytes = maxMessageSize
+
+	var hello message
+	ws.SetReadDeadline(time.Now().Add(helloTimeout))
+	if err := websocket.JSON.Receive(ws, &hello); err != nil || hello.Type != msgHello {
+		return
+	}
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(hello.Token), []byte(s.token)) != 1 {
+		websocket.JSON.Send(ws, message{Type: msgRejected, Error: "wrong token"})
+		return
+	}
+	ws.SetReadDeadline(time.Time{})
+
+	name := ws.Request().URL.Query().Get("room")
+	p := &peerConn{
+		Peer: Peer{ID: s.newID(), Name: hello.Name, Color: hello.Color},
+		ws:   ws,
+		send: make(chan message, sendQueueSize),
+	}
+	r := s.join(name, p)
+	defer s.leave(name, p)
+
+	go p.writeLoop()
+
+	for {
+		var msg message
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return
+		}
+		r.handle(p, msg)
+	}
+}
+
+// join registers p and queues the welcome message. Both happen under the room
+// lock so p sees every operation after the history it was sent exactly once.
+func (r *room) join(p *peerConn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	peers := make([]Peer, 0, len(r.peers))
+	for _, other := range r.peers {
+		peers = append(peers, other.Peer)
+	}
+	// 履歴は受け取るときに分けられる大きさに限っているので、分けられないことはない
+	history, _ := batches(r.ops, r.batchSize)
+	welcome := message{Type: msgWelcome, From: p.ID, Peers: peers, More: len(history) > 1}
+	if len(history) > 0 {
+		welcome.Ops = history[0]
+	}
+	p.send <- welcome
+	for i := 1; i < len(history); i++ {
+		r.sendLocked(p, message{Type: msgOps, Ops: history[i], More: i < len(history)-1})
+	}
+
+	r.peers[p.ID] = p
+	r.broadcastLocked(p.ID, message{Type: msgCursor, From: p.ID, Name: p.Name, Color: p.Color})
+}
+
+// leave unregisters p and returns the number of participants left
+func (r *room) leave(p *peerConn) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.peers[p.ID] != p {
+		return len(r.peers)
+	}
+	delete(r.peers, p.ID)
+	close(p.send)
+	r.broadcastLocked(p.ID, message{Type: msgLeave, From: p.ID})
+	return len(r.peers)
+}
+
+func (r *room) handle(p *peerConn, msg message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	msg.From = p.ID
+	switch msg.Type {
+	case msgOps:
+		// 再送などで既に受け取った操作は配らない
+		var fresh []model.Op
+		for _, op := range msg.Ops {
+			if !op.ID.IsZero() && !r.seen[op.ID] {
+				fresh = append(fresh, op)
+			}
+		}
+		if len(fresh) == 0 {
+			return
+		}
+		if _, err := batches(fresh, r.batchSize); err != nil {
+			// 後から参加する人に送れない操作は受け取らない
+			r.sendLocked(p, message{Type: msgRejected, Error: err.Error()})
+			return
+		}
+		ops := append(r.ops, fresh...)
+		if len(ops) > maxRoomOps {
+			ops = compact(ops)
+		}
+		if len(ops) > maxRoomOps {
+			// 受け取れない操作を黙って捨てると盤面が食い違うので、送った人を切断する
+			r.sendLocked(p, message{Type: msgRejected, Error: "the room is full"})
+			return
+		}
+		for _, op := range fresh {
+			r.seen[op.ID] = true
+		}
+		r.ops = ops
+		msg.Ops = fresh
+		r.broadcastLocked(p.ID, msg)
+	case msgCursor:
+		p.Cursor = msg.Cursor
+		msg.Name, msg.Color = p.Name, p.Color
+		r.broadcastLocked(p.ID, msg)
+	}
+}
+
+// compact returns ops without the operations that no longer change the
+// board: the inserts of deleted lines and the moves of deleted lines only.
+// Deletes are kept for participants that still have the lines, and the
+// dropped operations stay seen, so uploading them again changes nothing.
+func compact(ops []model.Op) []model.Op {
+	deleted := map[model.ID]bool{}
+	for _, op := range ops {
+		if op.Kind == model.OpDelete {
+			for _, id := range op.Targets {
+				deleted[id] = true
+			}
+		}
+	}
+	// 参加する人に送る途中の履歴があるので、新しい配列に詰める
+	out := make([]model.Op, 0, len(ops))
+	for _, op := range ops {
+		switch op.Kind {
+		case model.OpInsert:
+			if deleted[op.ID] {
+				continue
+			}
+		case model.OpRaise, model.OpReorder:
+			live := false
+			for _, id := range op.Targets {
+				live = live || !deleted[id]
+			}
+			if !live {
+				continue
+			}
+		}
+		out = append(out, op)
+	}
+	return out
+}
+
+// broadcastLocked queues msg for everybody except the given peer id
+func (r *room) broadcastLocked(except string, msg message) {
+	for id, p := range r.peers {
+		if id != except {
+			r.sendLocked(p, msg)
+		}
+	}
+}
+
+// sendLocked queues msg for p. A client whose queue is full is too slow to
+// keep up and gets disconnected; it will resync when it reconnects.
+func (r *room) sendLocked(p *peerConn, msg message) {
+	select {
+	case p.send <- msg:
+	default:
+		// 書き込み中の送信を止めないと Close が待ち続ける
+		p.ws.SetWriteDeadline(time.Now())
+		p.ws.Close()
+	}
+}
+
+func (p *peerConn) writeLoop() {
+	for msg := range p.send {
+		err := websocket.JSON.Send(p.ws, msg)
+		if err == nil && msg.Type == msgRejected {
+			// 断った理由を伝えてから切断する
+			err = errRejected
+		}
+		if err != nil {
+			p.ws.Close()
+			// 残りのメッセージは leave で send が閉じられるまで読み捨てる
+			for range p.send {
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"goWhiteBoard/collab"
+	"goWhiteBoard/model"
+	"image/color"
+	"net/url"
+	"os"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// 参加者の表示色の候補
+var collabColors = map[string]string{
+	"Red":    "#e53935",
+	"Blue":   "#1e88e5",
+	"Green":  "#43a047",
+	"Orange": "#fb8c00",
+	"Purple": "#8e24aa",
+}
+
+// 現在の共同編集セッション（未参加なら nil）
+var collabClient *collab.Client
+
+// collabRoom is where the board is shared. Each page is shared in a room of
+// its own, so the client joins another room when another page is shown.
+type collabRoom struct {
+	server *url.URL // 部屋を指定していない ws:// または wss:// の URL
+	room   string
+	token  string
+	name   string
+	color  string // "#rrggbb"
+}
+
+// 参加中の部屋（collabClient が nil でなければ有効）
+var collabJoined collabRoom
+
+// pageURL returns the URL of the room for the page with the given ID. The
+// first page of a new board uses the room itself, and pages of older files
+// keep the rooms older clients used for them.
+func (r collabRoom) pageURL(id string) string {
+	room := r.room
+	if id != model.PageID(0) {
+		room = r.room + "/" + id
+	}
+	u := *r.server
+	query := u.Query()
+	query.Set("room", room)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// ShowCollabDialog lets the user join a shared board or leave the current one.
+// status shows the connection state and the number of participants.
+func ShowCollabDialog(w fyne.Window, board *whiteboard, status *widget.Label) {
+	if collabClient != nil {
+		dialog.ShowConfirm("Collaborate", "Leave the shared board?", func(leave bool) {
+			if leave {
+				leaveCollab(board, status)
+			}
+		}, w)
+		return
+	}
+
+	serverEntry := widget.NewEntry()
+	serverEntry.SetText("ws://localhost:8080/ws")
+	roomEntry := widget.NewEntry()
+	roomEntry.SetText("default")
+	// サーバーを起動したときに表示されるトークン
+	tokenEntry := widget.NewPasswordEntry()
+	tokenEntry.SetText(os.Getenv("WHITEBOARD_COLLAB_TOKEN"))
+	nameEntry := widget.NewEntry()
+	if user := os.Getenv("USER"); user != "" {
+		nameEntry.SetText(user)
+	}
+	colorSelect := widget.NewSelect([]string{"Red", "Blue", "Green", "Orange", "Purple"}, nil)
+	colorSelect.SetSelected("Blue")
+
+	items := []*widget.FormItem{
+		{Text: "Server", Widget: serverEntry},
+		{Text: "Room", Widget: roomEntry},
+		{Text: "Token", Widget: tokenEntry},
+		{Text: "Name", Widget: nameEntry},
+		{Text: "Color", Widget: colorSelect},
+	}
+	form := dialog.NewForm("Collaborate", "Join", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		u, err := url.Parse(serverEntry.Text)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+			dialog.ShowError(fmt.Errorf("server must be a ws:// or wss:// URL"), w)
+			return
+		}
+		collabJoined = collabRoom{server: u, room: roomEntry.Text, token: tokenEntry.Text, name: nameEntry.Text, color: collabColors[colorSelect.Selected]}
+		joinCollab(board, status)
+	}, w)
+	form.Resize(fyne.NewSize(400, 300))
+	form.Show()
+}
+
+// joinCollab starts syncing the page shown with its room
+func joinCollab(board *whiteboard, status *widget.Label) {
+	r := collabJoined
+	client := collab.NewClient(r.pageURL(board.CurrentPageID()), r.token, r.name, r.color, board.Document())
+
+	peerCount := 0
+	connected := false
+	showStatus := func() {
+		if connected {
+			status.SetText(fmt.Sprintf("Shared board: %d other(s) online", peerCount))
+		} else {
+			status.SetText("Shared board: connecting...")
+		}
+	}
+	client.OnStatus = func(ok bool) {
+		connected = ok
+		showStatus()
+	}
+	client.OnRejected = func(reason string) {
+		status.SetText("Shared board: " + reason)
+	}
+	client.OnPeers = func(peers []collab.Peer) {
+		peerCount = len(peers)
+		showStatus()
+
+		var cursors []remoteCursor
+		for _, p := range peers {
+			if p.Cursor == nil {
+				continue
+			}
+			c, err := model.ParseHexColor(p.Color)
+			if err != nil {
+				c = color.Black
+			}
+			cursors = append(cursors, remoteCursor{Name: p.Name, Color: c, Pos: *p.Cursor})
+		}
+		board.SetRemoteCursors(cursors)
+	}
+	board.OnCursorMoved = client.SendCursor
+
+	showStatus()
+	client.Start()
+	collabClient = client
+}
+
+// followCollabPage moves to the room of the page shown after another page
+// was shown. The page left behind keeps its lines.
+func followCollabPage(board *whiteboard, status *widget.Label) {
+	if collabClient == nil {
+		return
+	}
+	collabClient.Close()
+	board.SetRemoteCursors(nil)
+	joinCollab(board, status)
+}
+
+// leaveCollab disconnects from the room. The lines stay on the local board.
+func leaveCollab(board *whiteboard, status *widget.Label) {
+	board.OnCursorMoved = nil
+	collabClient.Close()
+	collabClient = nil
+	board.SetRemoteCursors(nil)
+	status.SetText("")
+}
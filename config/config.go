@@ -8,4 +8,12 @@ var (
 		"2. Accurately understand the relationships and connections between the elements and reconstruct it into a logical configuration diagram. \n" +
 		"3. Provide the output as an HTML file. \n" +
 		"4. Please correct any freehand distortions with an emphasis on the readability of the diagram using line , curve ,circle ,squire ,Square,triangle, etc..."
+
+	// TrustedOutput allows scripts in the generated HTML (e.g. Mermaid diagrams).
+	// Leave it off unless the output really needs JavaScript.
+	TrustedOutput = false
+
+	// APIAddr is where the local HTTP API listens when it is enabled. It must
+	// be a loopback address.
+	APIAddr = "127.0.0.1:7777"
 )
@@ -0,0 +1,235 @@
+// Package connect routes connectors, the arrows that stay attached to
+// shapes when the shapes move.
+package connect
+
+import (
+	"goWhiteBoard/model"
+	"goWhiteBoard/snap"
+	"math"
+)
+
+const (
+	// Stub is how far an elbow connector runs straight out of a shape
+	// before it turns
+	Stub = 20
+	// minBend is the shortest distance a curved connector bends over
+	minBend = 10
+)
+
+// Anchor returns the middle of side of r. SideAuto is the centre.
+func Anchor(r snap.Rect, side model.Side) model.Point {
+	c := center(r)
+	switch side {
+	case model.SideTop:
+		return model.Point{X: c.X, Y: r.Min.Y}
+	case model.SideRight:
+		return model.Point{X: r.Max.X, Y: c.Y}
+	case model.SideBottom:
+		return model.Point{X: c.X, Y: r.Max.Y}
+	case model.SideLeft:
+		return model.Point{X: r.Min.X, Y: c.Y}
+	}
+	return c
+}
+
+// Facing returns the side of r that faces p
+func Facing(r snap.Rect, p model.Point) model.Side {
+	c := center(r)
+	// 縦横比の違う図形でも対角線で分けるように、大きさで割って比べる
+	dx := (p.X - c.X) / max(r.Max.X-r.Min.X, 1)
+	dy := (p.Y - c.Y) / max(r.Max.Y-r.Min.Y, 1)
+	switch {
+	case abs(dx) >= abs(dy) && dx >= 0:
+		return model.SideRight
+	case abs(dx) >= abs(dy):
+		return model.SideLeft
+	case dy >= 0:
+		return model.SideBottom
+	}
+	return model.SideTop
+}
+
+// SideAt returns the side of r whose anchor is within tolerance of p
+func SideAt(r snap.Rect, p model.Point, tolerance float32) (model.Side, bool) {
+	for _, s := range []model.Side{model.SideTop, model.SideRight, model.SideBottom, model.SideLeft} {
+		a := Anchor(r, s)
+		if abs(a.X-p.X) <= tolerance && abs(a.Y-p.Y) <= tolerance {
+			return s, true
+		}
+	}
+	return model.SideAuto, false
+}
+
+// Reroute returns a copy of the connector l routed between the shapes its
+// ends are attached to. shapes holds the bounds of the lines by node name;
+// ends attached to lines that are not there stay where they are.
+func Reroute(l model.Line, shapes map[string]snap.Rect) model.Line {
+	c := l.Connector
+	if c == nil || len(l.Points) < 2 {
+		return l
+	}
+	a, b := l.Points[0], l.Points[len(l.Points)-1]
+	ra, okA := shapes[c.From.Node]
+	rb, okB := shapes[c.To.Node]
+	okA, okB = okA && c.From.Node != "", okB && c.To.Node != ""
+	// 向かい合う辺を選ぶときは相手の図形の中心を目指す
+	ta, tb := b, a
+	if okB {
+		ta = center(rb)
+	}
+	if okA {
+		tb = center(ra)
+	}
+	sa, sb := model.SideAuto, model.SideAuto
+	if okA {
+		if sa = c.From.Side; sa == model.SideAuto {
+			sa = Facing(ra, ta)
+		}
+		a = Anchor(ra, sa)
+	}
+	if okB {
+		if sb = c.To.Side; sb == model.SideAuto {
+			sb = Facing(rb, tb)
+		}
+		b = Anchor(rb, sb)
+	}
+	l.Points = Route(c.Style, a, sa, b, sb)
+	l.Curve = c.Style == model.ConnectorCurved
+	return l
+}
+
+// Route returns the points of a connector in style from a, leaving through
+// side sa, to b, entering through side sb. Free ends have SideAuto.
+func Route(style model.ConnectorStyle, a model.Point, sa model.Side, b model.Point, sb model.Side) []model.Point {
+	switch style {
+	case model.ConnectorElbow:
+		return elbow(a, sa, b, sb)
+	case model.ConnectorCurved:
+		d := max(distance(a, b)/4, minBend)
+		if sa == model.SideAuto && sb == model.SideAuto {
+			return []model.Point{a, b}
+		}
+		// 辺に垂直に出入りする点を通る滑らかな曲線にする
+		points := []model.Point{a}
+		if sa != model.SideAuto {
+			points = append(points, out(a, sa, d))
+		}
+		if sb != model.SideAuto {
+			points = append(points, out(b, sb, d))
+		}
+		return append(points, b)
+	}
+	return []model.Point{a, b}
+}
+
+// elbow routes from a to b with horizontal and vertical segments only,
+// leaving and entering the shapes straight
+func elbow(a model.Point, sa model.Side, b model.Point, sb model.Side) []model.Point {
+	p, q := out(a, sa, Stub), out(b, sb, Stub)
+	// 自由な端は遠い方向に進む
+	wide := abs(q.X-p.X) >= abs(q.Y-p.Y)
+	ha, hb := horizontal(sa, wide), horizontal(sb, wide)
+	mx, my := (p.X+q.X)/2, (p.Y+q.Y)/2
+	var corners []model.Point
+	switch {
+	case ha && hb:
+		corners = []model.Point{{X: mx, Y: p.Y}, {X: mx, Y: q.Y}}
+	case !ha && !hb:
+		corners = []model.Point{{X: p.X, Y: my}, {X: q.X, Y: my}}
+	case ha:
+		corners = []model.Point{{X: q.X, Y: p.Y}}
+	default:
+		corners = []model.Point{{X: p.X, Y: q.Y}}
+	}
+	// 図形の中に戻ってしまう場合は、もう一方の向きで中間を通る
+	if !ahead(p, corners[0], sa) || !ahead(q, corners[len(corners)-1], sb) {
+		if ha {
+			corners = []model.Point{{X: p.X, Y: my}, {X: q.X, Y: my}}
+		} else {
+			corners = []model.Point{{X: mx, Y: p.Y}, {X: mx, Y: q.Y}}
+		}
+	}
+	points := append([]model.Point{a, p}, corners...)
+	return straighten(append(points, q, b))
+}
+
+// ahead reports whether going from p to q does not turn back through side
+// s, which p left the shape by
+func ahead(p, q model.Point, s model.Side) bool {
+	switch s {
+	case model.SideTop:
+		return q.Y <= p.Y
+	case model.SideRight:
+		return q.X >= p.X
+	case model.SideBottom:
+		return q.Y >= p.Y
+	case model.SideLeft:
+		return q.X <= p.X
+	}
+	return true
+}
+
+// horizontal reports whether a connector leaves side s horizontally. Free
+// ends follow wide.
+func horizontal(s model.Side, wide bool) bool {
+	switch s {
+	case model.SideLeft, model.SideRight:
+		return true
+	case model.SideTop, model.SideBottom:
+		return false
+	}
+	return wide
+}
+
+// straighten removes repeated points and points in the middle of straight
+// runs
+func straighten(points []model.Point) []model.Point {
+	out := points[:1]
+	for _, p := range points[1:] {
+		if p == out[len(out)-1] {
+			continue
+		}
+		if n := len(out); n >= 2 {
+			o, m := out[n-2], out[n-1]
+			if (o.X == m.X && m.X == p.X) || (o.Y == m.Y && m.Y == p.Y) {
+				out[n-1] = p
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	if len(out) == 1 {
+		out = append(out, out[0])
+	}
+	return out
+}
+
+// out returns the point d away from p outward through side s
+func out(p model.Point, s model.Side, d float32) model.Point {
+	switch s {
+	case model.SideTop:
+		p.Y -= d
+	case model.SideRight:
+		p.X += d
+	case model.SideBottom:
+		p.Y += d
+	case model.SideLeft:
+		p.X -= d
+	}
+	return p
+}
+
+func center(r snap.Rect) model.Point {
+	return model.Point{X: (r.Min.X + r.Max.X) / 2, Y: (r.Min.Y + r.Max.Y) / 2}
+}
+
+func distance(a, b model.Point) float32 {
+	return float32(math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y)))
+}
+
+func abs(f float32) float32 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,69 @@
+package connect
+
+import (
+	"goWhiteBoard/model"
+	"goWhiteBoard/snap"
+	"reflect"
+	"testing"
+)
+
+func rect(x0, y0, x1, y1 float32) snap.Rect {
+	return snap.Rect{Min: model.Point{X: x0, Y: y0}, Max: model.Point{X: x1, Y: y1}}
+}
+
+func TestFacing(t *testing.T) {
+	r := rect(0, 0, 200, 50)
+	for p, want := range map[model.Point]model.Side{
+		{X: 400, Y: 40}:  model.SideRight,
+		{X: -50, Y: 0}:   model.SideLeft,
+		{X: 120, Y: 300}: model.SideBottom,
+		// 横長の図形の上は、中心から見て横にずれていても上の辺
+		{X: 180, Y: -40}: model.SideTop,
+	} {
+		if got := Facing(r, p); got != want {
+			t.Errorf("Facing(%v) = %q, want %q", p, got, want)
+		}
+	}
+	if s, ok := SideAt(r, model.Point{X: 198, Y: 27}, 4); !ok || s != model.SideRight {
+		t.Errorf("SideAt = %q, %v", s, ok)
+	}
+}
+
+func TestReroute(t *testing.T) {
+	shapes := map[string]snap.Rect{"a": rect(0, 0, 100, 50), "b": rect(300, 0, 400, 60)}
+	l := model.Line{
+		Points:    []model.Point{{}, {}},
+		Connector: &model.Connector{Style: model.ConnectorElbow, From: model.End{Node: "a"}, To: model.End{Node: "b"}},
+	}
+
+	// 右の辺から出て、左の辺に入る。途中は縦と横だけ
+	got := Reroute(l, shapes).Points
+	want := []model.Point{{X: 100, Y: 25}, {X: 200, Y: 25}, {X: 200, Y: 30}, {X: 300, Y: 30}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("elbow = %v, want %v", got, want)
+	}
+
+	// 辺を指定すると、そこから出る。図形の中には戻らない
+	c := *l.Connector
+	c.From.Side = model.SideBottom
+	l.Connector = &c
+	got = Reroute(l, shapes).Points
+	want = []model.Point{{X: 50, Y: 50}, {X: 50, Y: 70}, {X: 165, Y: 70}, {X: 165, Y: 30}, {X: 300, Y: 30}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("elbow from the bottom = %v, want %v", got, want)
+	}
+
+	// 図形がなくなった端はその場に残る
+	c.Style, c.To.Node = model.ConnectorStraight, "gone"
+	l.Points = []model.Point{{X: 1, Y: 2}, {X: 500, Y: 20}}
+	got = Reroute(l, shapes).Points
+	want = []model.Point{{X: 50, Y: 50}, {X: 500, Y: 20}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("straight to a free end = %v, want %v", got, want)
+	}
+
+	c.Style = model.ConnectorCurved
+	if r := Reroute(l, shapes); !r.Curve || len(r.Points) != 3 || r.Points[1] != (model.Point{X: 50, Y: 50 + distance(r.Points[0], r.Points[2])/4}) {
+		t.Errorf("curved = %+v", r)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"goWhiteBoard/connect"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"goWhiteBoard/snap"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// connectorStyleNames are the names of model.ConnectorStyles in menus
+var connectorStyleNames = []string{"Straight", "Elbow", "Curved"}
+
+// connectEnd is an end of the connector being drawn: on a shape, or free
+// at point
+type connectEnd struct {
+	shape model.Line // ID がゼロなら図形につながっていない
+	side  model.Side
+	point model.Point
+}
+
+// attached reports whether the end is on a shape
+func (e connectEnd) attached() bool {
+	return !e.shape.ID.IsZero()
+}
+
+// ConnectorStyle returns the style new connectors are drawn in
+func (w *whiteboard) ConnectorStyle() model.ConnectorStyle {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.connectorStyle
+}
+
+// SetConnectorStyle sets the style new connectors are drawn in and
+// restyles the selected connectors as one undoable edit
+func (w *whiteboard) SetConnectorStyle(style model.ConnectorStyle) {
+	w.mutex.Lock()
+	w.connectorStyle = style
+	w.mutex.Unlock()
+	w.editConnectors(func(c *model.Connector) { c.Style = style })
+}
+
+// SelectedConnectorLabel returns the label of the first selected
+// connector, and whether a connector is selected
+func (w *whiteboard) SelectedConnectorLabel() (string, bool) {
+	for _, l := range w.SelectedLines() {
+		if l.Connector != nil {
+			return l.Connector.Label, true
+		}
+	}
+	return "", false
+}
+
+// SetConnectorLabel sets the label of the selected connectors as one
+// undoable edit
+func (w *whiteboard) SetConnectorLabel(label string) {
+	label = render.NormalizeText(label)
+	w.editConnectors(func(c *model.Connector) { c.Label = label })
+}
+
+// editConnectors applies change to copies of the selected connectors and
+// replaces them as one undoable edit
+func (w *whiteboard) editConnectors(change func(*model.Connector)) {
+	w.mutex.Lock()
+	var remove []model.ID
+	var add []model.Line
+	for _, l := range w.selectedLinesLocked(w.doc.Snapshot().Lines) {
+		if l.Connector == nil {
+			continue
+		}
+		c := *l.Connector
+		change(&c)
+		if c == *l.Connector {
+			continue
+		}
+		remove = append(remove, l.ID)
+		l.Connector = &c
+		add = append(add, l)
+	}
+	w.mutex.Unlock()
+	if len(add) > 0 {
+		w.moveSelection(remove, add)
+	}
+}
+
+// shapeAtLocked returns the topmost line that can be selected whose bounds
+// contain p, other than connectors and except. The caller holds the mutex.
+func (w *whiteboard) shapeAtLocked(p model.Point, except model.ID) (model.Line, bool) {
+	lines := w.editableLinesLocked()
+	tolerance := hitTolerance / w.view.scale
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := lines[i]
+		if l.Connector != nil || l.Background || l.ID == except {
+			continue
+		}
+		b, ok := snap.Bounds([]model.Line{l})
+		if !ok {
+			continue
+		}
+		if p.X >= b.Min.X-tolerance && p.X <= b.Max.X+tolerance && p.Y >= b.Min.Y-tolerance && p.Y <= b.Max.Y+tolerance {
+			return l, true
+		}
+	}
+	return model.Line{}, false
+}
+
+// connectEndLocked returns the end of a connector at p: on the shape there
+// (not except), at the side whose middle p is on or else at the side facing
+// the other end, or free. The caller holds the mutex.
+func (w *whiteboard) connectEndLocked(p model.Point, except model.ID) connectEnd {
+	shape, ok := w.shapeAtLocked(p, except)
+	if !ok {
+		return connectEnd{point: p}
+	}
+	b, _ := snap.Bounds([]model.Line{shape})
+	side, _ := connect.SideAt(b, p, handleSize/w.view.scale)
+	return connectEnd{shape: shape, side: side, point: p}
+}
+
+// connectorLocked returns the connector being drawn, routed between its
+// ends. Ends on shapes without a node name are named "from" and "to".
+// The caller holds the mutex.
+func (w *whiteboard) connectorLocked() model.Line {
+	shapes := map[string]snap.Rect{}
+	c := &model.Connector{Style: w.connectorStyle}
+	for _, e := range []struct {
+		end  connectEnd
+		to   *model.End
+		name string
+	}{{w.connectFrom, &c.From, "from"}, {w.connectTo, &c.To, "to"}} {
+		if !e.end.attached() {
+			continue
+		}
+		name := e.end.shape.Node
+		if name == "" {
+			name = e.name
+		}
+		*e.to = model.End{Node: name, Side: e.end.side}
+		shapes[name], _ = snap.Bounds([]model.Line{e.end.shape})
+	}
+	l := model.Line{
+		Points:    []model.Point{w.connectFrom.point, w.connectTo.point},
+		Color:     render.Opaque(w.lineColor),
+		Width:     w.lineWidth,
+		Layer:     w.pages[w.pageIndex].layer,
+		Connector: c,
+	}
+	return connect.Reroute(l, shapes)
+}
+
+// connectDownLocked starts a connector at pos. The caller holds the mutex.
+func (w *whiteboard) connectDownLocked(pos fyne.Position) {
+	p := w.view.toWorld(pos)
+	w.connecting = true
+	w.connectFrom = w.connectEndLocked(p, model.ID{})
+	w.connectTo = connectEnd{point: p}
+	w.connector = w.connectorLocked()
+}
+
+// connectMovedLocked moves the loose end of the connector being drawn to
+// pos. The caller holds the mutex.
+func (w *whiteboard) connectMovedLocked(pos fyne.Position) {
+	w.connectTo = w.connectEndLocked(w.view.toWorld(pos), w.connectFrom.shape.ID)
+	w.connector = w.connectorLocked()
+}
+
+// connectUpLocked finishes the connector being drawn. It returns the
+// shapes to replace with copies that have node names and the connector,
+// which the caller adds outside the lock. A connector between two free
+// points that are close together is dropped. The caller holds the mutex.
+func (w *whiteboard) connectUpLocked() (remove []model.ID, add []model.Line) {
+	w.connecting = false
+	from, to := w.connectFrom, w.connectTo
+	w.connector = model.Line{}
+	if !from.attached() && !to.attached() {
+		d := model.Point{X: to.point.X - from.point.X, Y: to.point.Y - from.point.Y}
+		if limit := hitTolerance / w.view.scale; abs32(d.X) <= limit && abs32(d.Y) <= limit {
+			return nil, nil
+		}
+	}
+	// つなぐ図形には移動しても変わらない名前を付ける
+	for _, e := range []*connectEnd{&w.connectFrom, &w.connectTo} {
+		if !e.attached() || e.shape.Node != "" {
+			continue
+		}
+		remove = append(remove, e.shape.ID)
+		e.shape.Node = model.NewName()
+		add = append(add, e.shape)
+	}
+	add = append(add, w.connectorLocked())
+	w.connectFrom, w.connectTo = connectEnd{}, connectEnd{}
+	return remove, add
+}
+
+// rerouteLocked completes an edit that replaces the lines remove with add:
+// connectors among add are routed to the shapes as they will be, and other
+// connectors attached to shapes among add are replaced by rerouted copies,
+// which are appended. The caller holds the mutex.
+func (w *whiteboard) rerouteLocked(remove []model.ID, add []model.Line) ([]model.ID, []model.Line) {
+	lines := w.doc.Snapshot().Lines
+	removing := idSet(remove)
+	shapes := map[string]snap.Rect{}
+	for _, l := range lines {
+		if l.Node != "" && !removing[l.ID] {
+			shapes[l.Node], _ = snap.Bounds([]model.Line{l})
+		}
+	}
+	moved := map[string]bool{}
+	for _, l := range add {
+		if l.Node != "" {
+			shapes[l.Node], _ = snap.Bounds([]model.Line{l})
+			moved[l.Node] = true
+		}
+	}
+	for i, l := range add {
+		if l.Connector != nil {
+			add[i] = connect.Reroute(l, shapes)
+		}
+	}
+	if len(moved) == 0 {
+		return remove, add
+	}
+	for _, l := range lines {
+		if l.Connector == nil || removing[l.ID] {
+			continue
+		}
+		if moved[l.Connector.From.Node] || moved[l.Connector.To.Node] {
+			remove = append(remove, l.ID)
+			add = append(add, connect.Reroute(l, shapes))
+		}
+	}
+	return remove, add
+}
+
+// showConnectorLabelDialog asks for the label of the selected connectors
+func showConnectorLabelDialog(w fyne.Window, board *whiteboard) {
+	label, ok := board.SelectedConnectorLabel()
+	if !ok {
+		dialog.ShowInformation("Connector Label", "Select a connector first.", w)
+		return
+	}
+	entry := widget.NewEntry()
+	entry.SetText(label)
+	dialog.ShowForm("Connector Label", "Set", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Label", entry),
+	}, func(ok bool) {
+		if ok {
+			board.SetConnectorLabel(entry.Text)
+		}
+	}, w)
+}
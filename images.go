@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+)
+
+// imageFitRatio is how much of the visible area a large imported image may
+// cover at most
+const imageFitRatio = 0.8
+
+// imageExts are the file types offered when importing an image
+var imageExts = []string{".png", ".jpg", ".jpeg", ".gif", ".svg"}
+
+// errNoImage is returned for clipboard text that does not name or hold an
+// image
+var errNoImage = errors.New("the clipboard holds no image, image file path or SVG")
+
+// AddImage places img in the middle of the visible area. See AddImageAt.
+func (w *whiteboard) AddImage(img *model.Image, background bool) error {
+	w.mutex.Lock()
+	center := fyne.NewPos(w.size.Width/2, w.size.Height/2)
+	w.mutex.Unlock()
+	return w.AddImageAt(img, center, background)
+}
+
+// AddImageAt places img centred on pos (in screen coordinates) at its
+// natural size, shrunk to fit the visible area. A background covers the
+// page, or on a page without a size the visible area, and cannot be
+// selected. It goes to the current layer. Adding it is one undoable edit.
+func (w *whiteboard) AddImageAt(img *model.Image, pos fyne.Position, background bool) error {
+	width, height, err := render.ImageSize(img)
+	if err != nil {
+		return fmt.Errorf("cannot read the image: %w", err)
+	}
+	if width <= 0 || height <= 0 {
+		return errors.New("the image is empty")
+	}
+	size := model.Point{X: float32(width), Y: float32(height)}
+
+	w.mutex.Lock()
+	if w.layerLockedLocked() {
+		w.mutex.Unlock()
+		return errLayerLocked
+	}
+	var from, to model.Point
+	switch {
+	case background && w.pageSize.Fixed():
+		from, to = fitRect(size, model.Point{}, model.Point{X: w.pageSize.Width, Y: w.pageSize.Height})
+	case background:
+		from, to = fitRect(size, w.view.toWorld(fyne.Position{}), w.view.toWorld(fyne.NewPos(w.size.Width, w.size.Height)))
+	default:
+		// 画面の 8 割より大きい画像は縮小する（ズームに関係なく見た目で決める）
+		limit := model.Point{X: w.size.Width * imageFitRatio / w.view.scale, Y: w.size.Height * imageFitRatio / w.view.scale}
+		if f := min(limit.X/size.X, limit.Y/size.Y); f < 1 && f > 0 {
+			size = model.Point{X: size.X * f, Y: size.Y * f}
+		}
+		c := w.view.toWorld(pos)
+		from = model.Point{X: c.X - size.X/2, Y: c.Y - size.Y/2}
+		to = model.Point{X: c.X + size.X/2, Y: c.Y + size.Y/2}
+	}
+	l := model.NewImageLine(img, from, to, background)
+	l.Layer = w.pages[w.pageIndex].layer
+	history := w.history
+	selectNew := w.tool == toolSelect && !background
+	w.mutex.Unlock()
+
+	// 通知で再描画するのでロックの外で追加する
+	id := history.Add(l)
+	if selectNew {
+		w.mutex.Lock()
+		w.selection = []model.ID{id}
+		w.mutex.Unlock()
+		w.Refresh()
+	}
+	return nil
+}
+
+// fitRect returns the largest rectangle of the proportions of size that
+// fits into the rectangle from a to b, centred in it
+func fitRect(size, a, b model.Point) (model.Point, model.Point) {
+	f := min((b.X-a.X)/size.X, (b.Y-a.Y)/size.Y)
+	if f <= 0 {
+		// 表示領域がまだない場合は元の大きさにする
+		return a, model.Point{X: a.X + size.X, Y: a.Y + size.Y}
+	}
+	w, h := size.X*f, size.Y*f
+	x, y := a.X+(b.X-a.X-w)/2, a.Y+(b.Y-a.Y-h)/2
+	return model.Point{X: x, Y: y}, model.Point{X: x + w, Y: y + h}
+}
+
+// readImage reads an image file
+func readImage(r io.Reader) (*model.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, model.MaxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	return model.NewImage(data)
+}
+
+// readImageURI reads the image file at uri
+func readImageURI(uri fyne.URI) (*model.Image, error) {
+	r, err := storage.Reader(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	img, err := readImage(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", uri.Name(), err)
+	}
+	return img, nil
+}
+
+// showImportImageDialog lets the user pick an image file and places it as
+// an object or as the background
+func showImportImageDialog(w fyne.Window, board *whiteboard, background bool) {
+	open := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil || reader == nil {
+			return
+		}
+		defer reader.Close()
+		img, err := readImage(reader)
+		if err == nil {
+			err = board.AddImage(img, background)
+		}
+		if err != nil {
+			dialog.ShowError(err, w)
+		}
+	}, w)
+	open.SetFilter(storage.NewExtensionFileFilter(imageExts))
+	open.Show()
+}
+
+// dropImages places image files dropped on the window at the drop
+// position, each a little below and to the right of the one before
+func dropImages(w fyne.Window, board *whiteboard, pos fyne.Position, uris []fyne.URI) {
+	origin := fyne.CurrentApp().Driver().AbsolutePositionForObject(board)
+	pos = pos.Subtract(origin)
+	var errs []error
+	for _, uri := range uris {
+		img, err := readImageURI(uri)
+		if err == nil {
+			err = board.AddImageAt(img, pos, false)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pos = pos.Add(fyne.NewDelta(20, 20))
+	}
+	if len(errs) > 0 {
+		dialog.ShowError(errors.Join(errs...), w)
+	}
+}
+
+// clipboardImage turns clipboard text into an image: a data: URI, the path
+// or file:// URL of an image file, or SVG markup
+func clipboardImage(text string) (*model.Image, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case strings.HasPrefix(text, "data:image/"):
+		header, data, ok := strings.Cut(text, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return nil, errors.New("only base64 data: URIs are supported")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("the data: URI is broken: %w", err)
+		}
+		return model.NewImage(decoded)
+	case model.ImageFormat([]byte(text)) == model.ImageSVG:
+		return model.NewImage([]byte(text))
+	case strings.HasPrefix(text, "file://"):
+		u, err := url.Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		text = u.Path
+	}
+	if text == "" || strings.ContainsAny(text, "\n") {
+		return nil, errNoImage
+	}
+	f, err := os.Open(text)
+	if err != nil {
+		return nil, errNoImage
+	}
+	defer f.Close()
+	img, err := readImage(f)
+	if err != nil {
+		// 画像ではないファイルのパスは文字として扱う
+		return nil, errNoImage
+	}
+	return img, nil
+}
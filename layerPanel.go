@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// newLayerPanel returns the sidebar listing the layers of the page shown,
+// top layer first, with switches to show and lock them, the opacity of the
+// current layer, and buttons to add, reorder, rename and delete layers and
+// to move the selection to the current layer
+func newLayerPanel(w fyne.Window, board *whiteboard) fyne.CanvasObject {
+	// 一覧は上のレイヤーから並べるので、行番号とレイヤーの番号は逆になる
+	layerAt := func(id widget.ListItemID) int {
+		return len(board.Layers()) - 1 - id
+	}
+	list := widget.NewList(
+		func() int {
+			return len(board.Layers())
+		},
+		func() fyne.CanvasObject {
+			return container.NewBorder(nil, nil,
+				container.NewHBox(widget.NewCheck("", nil), widget.NewCheck("", nil)), nil,
+				widget.NewLabel("Layer 00"))
+		},
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			layers := board.Layers()
+			i := len(layers) - 1 - id
+			// 更新中にレイヤーが減っていることがある
+			if i < 0 || i >= len(layers) {
+				return
+			}
+			row := o.(*fyne.Container)
+			checks := row.Objects[1].(*fyne.Container)
+			visible, locked := checks.Objects[0].(*widget.Check), checks.Objects[1].(*widget.Check)
+			// 値を設定する前に前の行のコールバックを外す
+			visible.OnChanged, locked.OnChanged = nil, nil
+			visible.SetChecked(!layers[i].Hidden)
+			locked.SetChecked(layers[i].Locked)
+			visible.OnChanged = func(on bool) { board.SetLayerHidden(i, !on) }
+			locked.OnChanged = func(on bool) { board.SetLayerLocked(i, on) }
+			row.Objects[0].(*widget.Label).SetText(layers[i].Name)
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		board.SetCurrentLayer(layerAt(id))
+	}
+
+	opacity := widget.NewSlider(minLayerOpacity*100, 100)
+	opacity.OnChangeEnded = func(v float64) {
+		board.SetLayerOpacity(board.CurrentLayer(), float32(v/100))
+	}
+	showCurrent := func() {
+		i := board.CurrentLayer()
+		list.Select(len(board.Layers()) - 1 - i)
+		opacity.SetValue(float64(board.Layers()[i].Alpha() * 100))
+	}
+	board.OnLayersChanged = func() {
+		list.Refresh()
+		showCurrent()
+	}
+	showCurrent()
+
+	addButton := widget.NewButtonWithIcon("", theme.ContentAddIcon(), board.AddLayer)
+	upButton := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() {
+		i := board.CurrentLayer()
+		board.MoveLayer(i, i+1)
+	})
+	downButton := widget.NewButtonWithIcon("", theme.MoveDownIcon(), func() {
+		i := board.CurrentLayer()
+		board.MoveLayer(i, i-1)
+	})
+	renameButton := widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+		showRenameLayerDialog(w, board)
+	})
+	deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		i := board.CurrentLayer()
+		name := board.Layers()[i].Name
+		dialog.ShowConfirm("Delete Layer", fmt.Sprintf("Delete %q and its strokes?", name), func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := board.DeleteLayer(i); err != nil {
+				dialog.ShowError(err, w)
+			}
+		}, w)
+	})
+	moveButton := widget.NewButton("Move Selection Here", func() {
+		board.MoveSelectionToLayer(board.CurrentLayer())
+	})
+
+	buttons := container.NewVBox(
+		widget.NewForm(widget.NewFormItem("Opacity", opacity)),
+		container.NewGridWithColumns(3, addButton, renameButton, deleteButton),
+		container.NewGridWithColumns(2, upButton, downButton),
+		moveButton,
+	)
+	title := widget.NewLabel("Layers")
+	title.TextStyle = fyne.TextStyle{Bold: true}
+	return container.NewBorder(title, buttons, nil, nil, list)
+}
+
+// showRenameLayerDialog asks for a new name for the current layer
+func showRenameLayerDialog(w fyne.Window, board *whiteboard) {
+	i := board.CurrentLayer()
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(board.Layers()[i].Name)
+	dialog.ShowForm("Rename Layer", "Rename", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Name", nameEntry),
+	}, func(ok bool) {
+		if ok {
+			board.RenameLayer(i, nameEntry.Text)
+		}
+	}, w)
+}
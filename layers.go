@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"goWhiteBoard/model"
+	"slices"
+	"strings"
+)
+
+// minLayerOpacity keeps layers from disappearing through their opacity;
+// hiding them is what the visibility switch is for
+const minLayerOpacity = 0.05
+
+var (
+	// errLastLayer is returned when the only layer of a page would be
+	// deleted
+	errLastLayer = errors.New("a page needs at least one layer")
+	// errLayerLocked is returned when adding to a layer that is locked or
+	// hidden
+	errLayerLocked = errors.New("the current layer is locked or hidden")
+	// errLineLocked is returned when removing a line that cannot be
+	// selected: a locked line or one on a locked or hidden layer
+	errLineLocked = errors.New("the line is locked or on a locked or hidden layer")
+)
+
+// Layers returns the layers of the page shown, bottom layer first
+func (w *whiteboard) Layers() []model.Layer {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]model.Layer(nil), w.pages[w.pageIndex].layers...)
+}
+
+// CurrentLayer returns the index of the layer new lines go to
+func (w *whiteboard) CurrentLayer() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	p := w.pages[w.pageIndex]
+	return model.LayerIndex(p.layers, p.layer)
+}
+
+// SetCurrentLayer makes new lines go to layer i
+func (w *whiteboard) SetCurrentLayer(i int) {
+	w.updateLayer(i, func(p *boardPage, l *model.Layer) bool {
+		if p.layer == l.ID {
+			return false
+		}
+		p.layer = l.ID
+		return true
+	})
+}
+
+// AddLayer inserts a new layer above the current one and makes it current
+func (w *whiteboard) AddLayer() {
+	w.mutex.Lock()
+	p := w.pages[w.pageIndex]
+	i := model.LayerIndex(p.layers, p.layer) + 1
+	l := model.Layer{ID: model.NewLayerID(p.doc.Replica(), p.layers), Name: layerNameLocked(p.layers), Opacity: 1}
+	// 渡したスライスが変わらないよう、レイヤーの配列は書き換えずに作り直す
+	p.layers = slices.Insert(slices.Clone(p.layers), i, l)
+	p.layer = l.ID
+	w.pagesEditedLocked()
+	w.layersVersion++
+	w.mutex.Unlock()
+	w.layersUpdated()
+}
+
+// layerNameLocked returns "Layer n" with the lowest n not in use. The
+// caller holds the mutex.
+func layerNameLocked(layers []model.Layer) string {
+	used := map[string]bool{}
+	for _, l := range layers {
+		used[l.Name] = true
+	}
+	for n := 1; ; n++ {
+		if name := fmt.Sprintf("Layer %d", n); !used[name] {
+			return name
+		}
+	}
+}
+
+// DeleteLayer removes layer i and its lines as one undoable edit: undo
+// brings the layer back with its lines on it. The last layer cannot be
+// deleted.
+func (w *whiteboard) DeleteLayer(i int) error {
+	w.mutex.Lock()
+	p := w.pages[w.pageIndex]
+	if len(p.layers) == 1 {
+		w.mutex.Unlock()
+		return errLastLayer
+	}
+	if i < 0 || i >= len(p.layers) {
+		w.mutex.Unlock()
+		return nil
+	}
+	var ids []model.ID
+	for _, l := range p.doc.Snapshot().Lines {
+		if model.LayerIndex(p.layers, l.Layer) == i {
+			ids = append(ids, l.ID)
+		}
+	}
+	layer, current := p.layers[i], p.layer == p.layers[i].ID
+	w.removeLayerLocked(p, layer.ID)
+	history := p.history
+	w.mutex.Unlock()
+
+	// 通知で再描画するのでロックの外で消す。線が無くてもレイヤーの削除を履歴に残す
+	history.DeleteWith(ids, model.Effect{
+		// 線を戻す前にレイヤーを戻し、線がそのレイヤーに載るようにする
+		Undo: func() {
+			w.mutex.Lock()
+			if !slices.ContainsFunc(p.layers, func(l model.Layer) bool { return l.ID == layer.ID }) {
+				p.layers = slices.Insert(slices.Clone(p.layers), min(i, len(p.layers)), layer)
+				if current {
+					p.layer = layer.ID
+				}
+				w.pagesEditedLocked()
+				w.layersVersion++
+			}
+			w.mutex.Unlock()
+			w.layersUpdated()
+		},
+		Redo: func() {
+			w.mutex.Lock()
+			w.removeLayerLocked(p, layer.ID)
+			w.mutex.Unlock()
+			w.layersUpdated()
+		},
+	})
+	w.layersUpdated()
+	return nil
+}
+
+// removeLayerLocked removes the layer id from p unless it is missing or the
+// last one, and picks the layer below as current if it was. The caller
+// holds the mutex.
+func (w *whiteboard) removeLayerLocked(p *boardPage, id string) {
+	i := slices.IndexFunc(p.layers, func(l model.Layer) bool { return l.ID == id })
+	if i < 0 || len(p.layers) == 1 {
+		return
+	}
+	p.layers = slices.Delete(slices.Clone(p.layers), i, i+1)
+	if p.layer == id {
+		p.layer = p.layers[max(i-1, 0)].ID
+	}
+	w.selection = nil
+	w.pagesEditedLocked()
+	w.layersVersion++
+}
+
+// MoveLayer moves layer from to index to, which changes what is drawn on
+// top of what
+func (w *whiteboard) MoveLayer(from, to int) {
+	w.mutex.Lock()
+	p := w.pages[w.pageIndex]
+	if from < 0 || from >= len(p.layers) || to < 0 || to >= len(p.layers) || from == to {
+		w.mutex.Unlock()
+		return
+	}
+	l := p.layers[from]
+	p.layers = slices.Insert(slices.Delete(slices.Clone(p.layers), from, from+1), to, l)
+	w.pagesEditedLocked()
+	w.layersVersion++
+	w.mutex.Unlock()
+	w.layersUpdated()
+}
+
+// RenameLayer renames layer i. Empty names are ignored.
+func (w *whiteboard) RenameLayer(i int, name string) {
+	name = strings.TrimSpace(name)
+	w.updateLayer(i, func(_ *boardPage, l *model.Layer) bool {
+		if name == "" || name == l.Name {
+			return false
+		}
+		l.Name = name
+		return true
+	})
+}
+
+// SetLayerHidden shows or hides layer i. Hidden lines are left out of
+// the selection.
+func (w *whiteboard) SetLayerHidden(i int, hidden bool) {
+	w.updateLayer(i, func(_ *boardPage, l *model.Layer) bool {
+		if l.Hidden == hidden {
+			return false
+		}
+		l.Hidden = hidden
+		w.selection = nil
+		return true
+	})
+}
+
+// SetLayerLocked locks or unlocks layer i. The lines of a locked layer
+// cannot be selected and nothing can be added to it.
+func (w *whiteboard) SetLayerLocked(i int, locked bool) {
+	w.updateLayer(i, func(_ *boardPage, l *model.Layer) bool {
+		if l.Locked == locked {
+			return false
+		}
+		l.Locked = locked
+		w.selection = nil
+		return true
+	})
+}
+
+// SetLayerOpacity sets the opacity of layer i as a whole
+func (w *whiteboard) SetLayerOpacity(i int, opacity float32) {
+	opacity = max(minLayerOpacity, min(1, opacity))
+	w.updateLayer(i, func(_ *boardPage, l *model.Layer) bool {
+		if l.Alpha() == opacity {
+			return false
+		}
+		l.Opacity = opacity
+		return true
+	})
+}
+
+// updateLayer applies change to layer i of the page shown under the lock
+// and redraws if it reports a change
+func (w *whiteboard) updateLayer(i int, change func(p *boardPage, l *model.Layer) bool) {
+	w.mutex.Lock()
+	p := w.pages[w.pageIndex]
+	if i < 0 || i >= len(p.layers) {
+		w.mutex.Unlock()
+		return
+	}
+	layers := slices.Clone(p.layers)
+	if !change(p, &layers[i]) {
+		w.mutex.Unlock()
+		return
+	}
+	p.layers = layers
+	w.pagesEditedLocked()
+	w.layersVersion++
+	w.mutex.Unlock()
+	w.layersUpdated()
+}
+
+// MoveSelectionToLayer moves the selected lines to layer i as one undoable
+// edit. They stay selected unless that layer is locked or hidden.
+func (w *whiteboard) MoveSelectionToLayer(i int) {
+	w.mutex.Lock()
+	p := w.pages[w.pageIndex]
+	if i < 0 || i >= len(p.layers) {
+		w.mutex.Unlock()
+		return
+	}
+	id := p.layers[i].ID
+	keep := !p.layers[i].Hidden && !p.layers[i].Locked
+	var stay, remove []model.ID
+	var add []model.Line
+	for _, l := range w.selectedLinesLocked(w.doc.Snapshot().Lines) {
+		if model.LayerIndex(p.layers, l.Layer) == i {
+			stay = append(stay, l.ID)
+			continue
+		}
+		remove = append(remove, l.ID)
+		l.Layer = id
+		add = append(add, l)
+	}
+	history := w.history
+	w.mutex.Unlock()
+	if len(add) == 0 {
+		return
+	}
+
+	// 通知で再描画するのでロックの外で入れ替える
+	ids := history.Update(remove, add)
+	w.mutex.Lock()
+	w.selection = nil
+	if keep {
+		w.selection = append(stay, ids...)
+	}
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// MoveSelectionByLayers moves the selected lines to the layer delta places
+// above (or below, if negative) the current one and makes it current
+func (w *whiteboard) MoveSelectionByLayers(delta int) {
+	i := w.CurrentLayer() + delta
+	if i < 0 || i >= len(w.Layers()) || len(w.Selection()) == 0 {
+		return
+	}
+	w.SetCurrentLayer(i)
+	w.MoveSelectionToLayer(i)
+}
+
+// layerLockedLocked reports whether nothing can be added to the current
+// layer. The caller holds the mutex.
+func (w *whiteboard) layerLockedLocked() bool {
+	p := w.pages[w.pageIndex]
+	l := p.layers[model.LayerIndex(p.layers, p.layer)]
+	return l.Locked || l.Hidden
+}
+
+// editableLinesLocked returns the lines that can be selected: those on
+// layers that are shown and not locked. The caller holds the mutex.
+func (w *whiteboard) editableLinesLocked() []model.Line {
+	lines := w.doc.Snapshot().Lines
+	layers := w.pages[w.pageIndex].layers
+	editable := make([]bool, len(layers))
+	all := true
+	for i, l := range layers {
+		editable[i] = !l.Hidden && !l.Locked
+		all = all && editable[i]
+	}
+	if all {
+		return lines
+	}
+	out := make([]model.Line, 0, len(lines))
+	for _, l := range lines {
+		if editable[model.LayerIndex(layers, l.Layer)] {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// layersUpdated redraws the board and tells the layer panel
+func (w *whiteboard) layersUpdated() {
+	w.Refresh()
+	if w.OnLayersChanged != nil {
+		w.OnLayersChanged()
+	}
+}
+
+// showLayers returns layers with only those named in names shown
+func showLayers(layers []model.Layer, names []string) []model.Layer {
+	out := make([]model.Layer, len(layers))
+	for i, l := range layers {
+		l.Hidden = !slices.Contains(names, l.Name)
+		out[i] = l
+	}
+	return out
+}
+
+// LayerNames returns the names of the layers of every page, each once, and
+// whether a layer of that name is shown on any page
+func (w *whiteboard) LayerNames() (names []string, shown []bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	index := map[string]int{}
+	for _, p := range w.pages {
+		for _, l := range p.layers {
+			i, ok := index[l.Name]
+			if !ok {
+				i = len(names)
+				index[l.Name] = i
+				names = append(names, l.Name)
+				shown = append(shown, false)
+			}
+			shown[i] = shown[i] || !l.Hidden
+		}
+	}
+	return names, shown
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"goWhiteBoard/api"
+	"goWhiteBoard/config"
+	"goWhiteBoard/model"
+	"os"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// 起動中のローカル API（無効なら nil）
+var localAPI *api.Server
+
+// setLocalAPI starts or stops the local HTTP API for the board. The token is
+// taken from WHITEBOARD_API_TOKEN or generated, and shown once started.
+func setLocalAPI(w fyne.Window, board *whiteboard, enabled bool) {
+	if !enabled {
+		if localAPI != nil {
+			localAPI.Close()
+			localAPI = nil
+		}
+		return
+	}
+	if localAPI != nil {
+		return
+	}
+
+	token := os.Getenv("WHITEBOARD_API_TOKEN")
+	if token == "" {
+		token = api.NewToken()
+	}
+	server := api.New(board.Document(), token)
+	server.Layers, server.Grid = board.Layers, board.Grid
+	server.Edit, server.Open, server.Save = board.EditLines, board.LoadBoard, board.SaveBoard
+	addr, err := server.Start(config.APIAddr)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("local API: %w", err), w)
+		return
+	}
+	localAPI = server
+
+	// コピーできるように Entry で表示する
+	urlEntry := widget.NewEntry()
+	urlEntry.SetText("http://" + addr + "/api/board")
+	tokenEntry := widget.NewEntry()
+	tokenEntry.SetText(token)
+	info := container.NewVBox(
+		widget.NewLabel("Send the token as \"Authorization: Bearer <token>\"."),
+		widget.NewForm(
+			widget.NewFormItem("URL", urlEntry),
+			widget.NewFormItem("Token", tokenEntry),
+		),
+	)
+	infoDialog := dialog.NewCustom("Local API", "Close", info, w)
+	infoDialog.Resize(fyne.NewSize(500, 200))
+	infoDialog.Show()
+}
+
+// EditLines makes an edit of the local API on the page shown as one
+// undoable edit, like drawing on the board: it removes every line if clear
+// is set, or else the lines remove, and adds add to the current layer. It
+// returns the IDs of the added lines, or errLayerLocked when there are
+// lines to add and the current layer is locked or hidden, or errLineLocked
+// when a line to remove cannot be selected.
+func (w *whiteboard) EditLines(clear bool, remove []model.ID, add []model.Line) ([]model.ID, error) {
+	w.mutex.Lock()
+	if len(add) > 0 && w.layerLockedLocked() {
+		w.mutex.Unlock()
+		return nil, errLayerLocked
+	}
+	if clear {
+		remove = nil
+		for _, l := range w.doc.Snapshot().Lines {
+			remove = append(remove, l.ID)
+		}
+	} else if len(remove) > 0 {
+		editable := map[model.ID]bool{}
+		for _, l := range w.editableLinesLocked() {
+			editable[l.ID] = !l.Locked
+		}
+		for _, id := range remove {
+			if !editable[id] {
+				w.mutex.Unlock()
+				return nil, errLineLocked
+			}
+		}
+	}
+	layer := w.pages[w.pageIndex].layer
+	history := w.history
+	w.selection = nil
+	w.mutex.Unlock()
+
+	lines := make([]model.Line, len(add))
+	for i, l := range add {
+		l.Layer = layer
+		lines[i] = l
+	}
+	// 通知で再描画するのでロックの外で変える
+	return history.Update(remove, lines), nil
+}
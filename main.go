@@ -2,31 +2,39 @@ package main
 
 import (
 	"fmt"
+	"goWhiteBoard/cli"
+	"goWhiteBoard/config"
+	"goWhiteBoard/model"
+	"goWhiteBoard/recovery"
+	"goWhiteBoard/stroke"
 	"goWhiteBoard/util"
 	"image/color"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/widget"
 
 	"github.com/joho/godotenv"
 	webview "github.com/webview/webview_go"
 )
 
-// Initial board dimensions that will be updated when window is resized
-var (
-	BOARD_WIDTH  float32 = 800
-	BOARD_HEIGHT float32 = 600
-)
-
 // 修正：完全な実装での確認コード
 func main() {
+	// サブコマンド（render, convert, serve など）は画面を出さずに実行する
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
@@ -34,7 +42,6 @@ func main() {
 
 	a := app.New()
 	w := a.NewWindow("Whiteboard")
-	w.Resize(fyne.NewSize(BOARD_WIDTH, BOARD_HEIGHT))
 
 	board := newWhiteboard()
 
@@ -63,6 +70,10 @@ func main() {
 	headerLabel := widget.NewLabel("Whiteboard App")
 	headerLabel.TextStyle = fyne.TextStyle{Bold: true}
 
+	// 直近の送信結果（何を取り出したか）を表示するラベル
+	statusLabel := widget.NewLabel("")
+	var lastReply string
+
 	// ヘッダーのスタイル設定
 	headerBg := canvas.NewRectangle(color.RGBA{230, 230, 230, 255})
 
@@ -77,13 +88,26 @@ func main() {
 		widget.NewSeparator(), // ヘッダーと本文の区切り線
 	)
 
+	// ローカル API と共同編集は表示中のページに付いていく
+	board.OnPageShown = func() {
+		if localAPI != nil {
+			localAPI.SetDocument(board.Document())
+		}
+		followCollabPage(board, statusLabel)
+	}
+
+	// ページ一覧（左側）
+	navigator := newPageNavigator(w, board)
+	// レイヤー一覧（右側）
+	layers := newLayerPanel(w, board)
+
 	// コンテンツを更新する関数
 	updateContent := func() {
 		content := container.NewBorder(
 			headerContainer,
 			nil,
-			nil,
-			nil,
+			navigator,
+			layers,
 			currentContent,
 		)
 		w.SetContent(content)
@@ -91,24 +115,16 @@ func main() {
 
 	// ツールバー
 	clearButton := widget.NewButton("Clear", func() {
-		board.lines = []line{}
-		board.Refresh()
-
-		// Update dimensions
-		size := w.Canvas().Size()
-		BOARD_WIDTH = size.Width
-		BOARD_HEIGHT = size.Height
-		fmt.Printf("Window size: %v x %v\n", BOARD_WIDTH, BOARD_HEIGHT)
+		board.Clear()
 	})
 
-	// 画像生成ボタン
-	saveButton := widget.NewButton("SavePng", func() {
-		// Update dimensions before saving
-		size := w.Canvas().Size()
-		BOARD_WIDTH = size.Width
-		BOARD_HEIGHT = size.Height
-
-		board.SaveAsPNG("whiteboard.png", int(BOARD_WIDTH), int(BOARD_HEIGHT))
+	// 画像を書き出して表示する
+	exportPNG := func() {
+		// 書き出す範囲と大きさはボードが決める（ページまたは表示領域）
+		if err := board.Export("whiteboard.png"); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
 
 		img := canvas.NewImageFromFile("whiteboard.png")
 		img.FillMode = canvas.ImageFillOriginal
@@ -119,53 +135,255 @@ func main() {
 		// メインコンテンツを画像に切り替え
 		currentContent = imageContainer
 		updateContent()
-	})
+	}
+	saveButton := widget.NewButton("SavePng", exportPNG)
 
-	backButton := widget.NewButton("Back to Drawing", func() {
+	backToDrawing := func() {
+		// 発表中なら全画面をやめる
+		if board.Presenting() {
+			board.StopPresenting()
+			w.SetFullScreen(false)
+		}
 		// メインコンテンツをボードに切り替え
 		currentContent = board
 		updateContent()
-	})
+	}
+	backButton := widget.NewButton("Back to Drawing", backToDrawing)
+
+	// 発表モードではヘッダーやパネルを隠し、ボードだけを全画面で表示する
+	present := func() {
+		currentContent = board
+		board.StartPresenting()
+		w.SetContent(board)
+		w.SetFullScreen(true)
+	}
+
+	presentButton := widget.NewButton("Present", present)
 
-	// 画像送信ボタン。送信した結果をhtmlで受け取る。
-	sendButton := widget.NewButton("Send", func() {
-		// Update dimensions before sending
-		size := w.Canvas().Size()
-		BOARD_WIDTH = size.Width
-		BOARD_HEIGHT = size.Height
+	// 選んだページを画像にして送信し、結果をhtmlで受け取る
+	sendPages := func(pages []int, layers []string) {
+		imageData, err := board.PagesPNG(pages, layers)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
 
-		imagePath := "whiteboard.png" // 読み込むPNG画像のファイルパスを指定
-		imageData, err := os.ReadFile(imagePath)
+		result, err := util.ConvertImage(imageData)
 		if err != nil {
-			log.Fatalf("画像の読み込みに失敗しました: %v", err)
+			dialog.ShowError(err, w)
+			return
 		}
+		lastReply = result.Raw
+		status := result.Summary()
+		if result.NeedsScripts && !config.TrustedOutput {
+			status += " (enable trusted output in Settings to render it)"
+		}
+		statusLabel.SetText(status)
+
+		// モデルの出力はそのまま信用せず、ポリシーに従って無害化してから表示する
+		policy := util.StrictPolicy
+		if config.TrustedOutput {
+			policy = util.TrustedPolicy
+		}
+		htmlContent := util.SanitizeHTML(result.Content, policy)
 
-		htmlContent := util.SendImage(imageData)
-		// TODO: HTMLを画面に表示する
-		wv := webview.New(true)
+		wv := webview.New(false)
 		wv.SetTitle("Whiteboard")
-		wv.SetSize(int(BOARD_WIDTH), int(BOARD_HEIGHT), webview.HintNone)
+		size := board.Size()
+		wv.SetSize(int(size.Width), int(size.Height), webview.HintNone)
 		wv.SetHtml(htmlContent)
 		wv.Run()
+	}
+	send := func() {
+		showSendPagesDialog(w, board, sendPages)
+	}
+	sendButton := widget.NewButton("Send", send)
+
+	// モデルの応答をそのまま確認するボタン
+	rawButton := widget.NewButton("Raw Reply", func() {
+		rawEntry := widget.NewMultiLineEntry()
+		rawEntry.SetText(lastReply)
+		rawEntry.Wrapping = fyne.TextWrapWord
+		rawDialog := dialog.NewCustom("Raw Reply", "Close", container.NewScroll(rawEntry), w)
+		rawDialog.Resize(fyne.NewSize(600, 400))
+		rawDialog.Show()
 	})
 
+	// ズーム表示とズーム操作
+	zoomLabel := widget.NewLabel("100%")
+	board.OnViewportChanged = func() {
+		zoomLabel.SetText(fmt.Sprintf("%.0f%%", board.Zoom()*100))
+	}
+	fitButton := widget.NewButton("Fit", func() {
+		board.ZoomToFit()
+	})
+	actualSizeButton := widget.NewButton("100%", func() {
+		board.ResetZoom()
+	})
+
+	// ボードファイルの読み込みと保存
+	boardFilter := storage.NewExtensionFileFilter([]string{model.BoardExt})
+	openBoard := func() {
+		open := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			reader.Close()
+			if err := board.LoadBoard(reader.URI().Path()); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			board.ZoomToFit()
+		}, w)
+		open.SetFilter(boardFilter)
+		open.Show()
+	}
+	openButton := widget.NewButton("Open", openBoard)
+	saveBoard := func() {
+		save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			writer.Close()
+			if err := board.SaveBoard(writer.URI().Path()); err != nil {
+				dialog.ShowError(err, w)
+			}
+		}, w)
+		save.SetFilter(boardFilter)
+		save.SetFileName("whiteboard" + model.BoardExt)
+		save.Show()
+	}
+	saveBoardButton := widget.NewButton("Save", saveBoard)
+
+	// 共同編集への参加・退出
+	collabButton := widget.NewButton("Collaborate", func() {
+		ShowCollabDialog(w, board, statusLabel)
+	})
+
+	// 未保存の変更があれば確認してから終了する（会議中の誤操作を防ぐ）
+	quit := func() {
+		if !board.Modified() {
+			a.Quit()
+			return
+		}
+		dialog.ShowConfirm("Quit", "The board has unsaved changes. Quit anyway?", func(ok bool) {
+			if ok {
+				a.Quit()
+			}
+		}, w)
+	}
+	w.SetCloseIntercept(quit)
+
+	// キーボードショートカット（設定から変更できる）
+	var keys *shortcuts
+	clip := newBoardClipboard(w, board)
+	actions := []*shortcutAction{
+		{ID: "undo", Group: "Edit", Name: "Undo", Default: "Ctrl+Z", Run: func() { board.Undo() }},
+		{ID: "redo", Group: "Edit", Name: "Redo", Default: "Ctrl+Shift+Z", Run: func() { board.Redo() }},
+		{ID: "delete", Group: "Edit", Name: "Delete Selection", Default: "Delete", Run: board.DeleteSelection},
+		{ID: "copy", Group: "Edit", Name: "Copy", Default: "Ctrl+C", Run: func() { clip.Copy() }},
+		{ID: "cut", Group: "Edit", Name: "Cut", Default: "Ctrl+X", Run: clip.Cut},
+		{ID: "paste", Group: "Edit", Name: "Paste", Default: "Ctrl+V", Run: clip.Paste},
+		{ID: "copy-image", Group: "Edit", Name: "Copy as Image", Default: "Ctrl+Shift+C", Run: clip.CopyImage},
+		{ID: "open", Group: "File", Name: "Open Board", Default: "Ctrl+O", Run: openBoard},
+		{ID: "save", Group: "File", Name: "Save Board", Default: "Ctrl+S", Run: saveBoard},
+		{ID: "export", Group: "File", Name: "Export PNG", Default: "Ctrl+E", Run: exportPNG},
+		{ID: "send", Group: "File", Name: "Send", Default: "Ctrl+Return", Run: send},
+		{ID: "export-pdf", Group: "File", Name: "Export PDF", Default: "Ctrl+Shift+E", Run: func() { showExportPDFDialog(w, board) }},
+		{ID: "import-image", Group: "File", Name: "Import Image", Default: "Ctrl+I", Run: func() { showImportImageDialog(w, board, false) }},
+		{ID: "import-background", Group: "File", Name: "Import Background", Default: "Ctrl+Shift+I", Run: func() { showImportImageDialog(w, board, true) }},
+		{ID: "quit", Group: "File", Name: "Quit", Default: "Ctrl+Q", Run: quit},
+		{ID: "zoom-in", Group: "View", Name: "Zoom In", Default: "Ctrl+=", Run: func() { board.ZoomBy(1.25) }},
+		{ID: "zoom-out", Group: "View", Name: "Zoom Out", Default: "Ctrl+-", Run: func() { board.ZoomBy(1 / 1.25) }},
+		{ID: "zoom-fit", Group: "View", Name: "Fit to Content", Default: "Ctrl+0", Run: board.ZoomToFit},
+		{ID: "zoom-reset", Group: "View", Name: "Actual Size", Default: "Ctrl+1", Run: board.ResetZoom},
+		{ID: "replay", Group: "View", Name: "Replay", Default: "Ctrl+Shift+R", Run: func() { showReplay(a, board) }},
+		{ID: "back", Group: "View", Name: "Back to Drawing", Default: "Escape", Run: backToDrawing},
+		{ID: "view-save", Group: "View", Name: "Save View", Default: "Ctrl+Alt+S", Run: func() { showSaveViewDialog(w, board) }},
+		{ID: "views", Group: "View", Name: "Saved Views", Default: "Ctrl+Alt+V", Run: func() { showViewsDialog(w, board) }},
+		{ID: "present", Group: "Present", Name: "Start Presentation", Default: "F5", Run: present},
+		{ID: "present-next", Group: "Present", Name: "Next Step", Default: "Right", Run: board.PresentNext},
+		{ID: "present-prev", Group: "Present", Name: "Previous Step", Default: "Left", Run: board.PresentPrevious},
+		{ID: "present-laser", Group: "Present", Name: "Laser Pointer", Default: "L", Run: func() { board.SetPointer(pointerLaser) }},
+		{ID: "present-spotlight", Group: "Present", Name: "Spotlight", Default: "S", Run: func() { board.SetPointer(pointerSpotlight) }},
+		{ID: "cheat-sheet", Group: "View", Name: "Show Shortcuts", Default: "F1", Run: func() { keys.ShowCheatSheet() }},
+		{ID: "page-new", Group: "Pages", Name: "New Page", Default: "Ctrl+N", Run: board.AddPage},
+		{ID: "page-next", Group: "Pages", Name: "Next Page", Default: "Ctrl+PageDown", Run: func() { board.SetCurrentPage(board.CurrentPage() + 1) }},
+		{ID: "page-prev", Group: "Pages", Name: "Previous Page", Default: "Ctrl+PageUp", Run: func() { board.SetCurrentPage(board.CurrentPage() - 1) }},
+		{ID: "layer-new", Group: "Layers", Name: "New Layer", Default: "Ctrl+Shift+N", Run: board.AddLayer},
+		{ID: "layer-move-up", Group: "Layers", Name: "Move Selection to Layer Above", Default: "Ctrl+Shift+PageUp", Run: func() { board.MoveSelectionByLayers(1) }},
+		{ID: "layer-move-down", Group: "Layers", Name: "Move Selection to Layer Below", Default: "Ctrl+Shift+PageDown", Run: func() { board.MoveSelectionByLayers(-1) }},
+		{ID: "group", Group: "Arrange", Name: "Group", Default: "Ctrl+G", Run: board.GroupSelection},
+		{ID: "ungroup", Group: "Arrange", Name: "Ungroup", Default: "Ctrl+Shift+G", Run: board.UngroupSelection},
+		{ID: "bring-to-front", Group: "Arrange", Name: "Bring to Front", Default: "Ctrl+Shift+]", Run: func() { board.Arrange(toFront) }},
+		{ID: "bring-forward", Group: "Arrange", Name: "Bring Forward", Default: "Ctrl+]", Run: func() { board.Arrange(forward) }},
+		{ID: "send-backward", Group: "Arrange", Name: "Send Backward", Default: "Ctrl+[", Run: func() { board.Arrange(backward) }},
+		{ID: "send-to-back", Group: "Arrange", Name: "Send to Back", Default: "Ctrl+Shift+[", Run: func() { board.Arrange(toBack) }},
+		{ID: "lock", Group: "Arrange", Name: "Lock", Default: "Ctrl+L", Run: func() { board.LockSelection(true) }},
+		{ID: "unlock", Group: "Arrange", Name: "Unlock", Default: "Ctrl+Shift+L", Run: func() { board.LockSelection(false) }},
+		{ID: "align-left", Group: "Arrange", Name: "Align Left", Default: "Alt+Shift+L", Run: func() { board.Align(alignLeft) }},
+		{ID: "align-center", Group: "Arrange", Name: "Align Center", Default: "Alt+Shift+C", Run: func() { board.Align(alignCenter) }},
+		{ID: "align-right", Group: "Arrange", Name: "Align Right", Default: "Alt+Shift+R", Run: func() { board.Align(alignRight) }},
+		{ID: "align-top", Group: "Arrange", Name: "Align Top", Default: "Alt+Shift+T", Run: func() { board.Align(alignTop) }},
+		{ID: "align-middle", Group: "Arrange", Name: "Align Middle", Default: "Alt+Shift+M", Run: func() { board.Align(alignMiddle) }},
+		{ID: "align-bottom", Group: "Arrange", Name: "Align Bottom", Default: "Alt+Shift+B", Run: func() { board.Align(alignBottom) }},
+		{ID: "distribute-horizontally", Group: "Arrange", Name: "Distribute Horizontally", Default: "Alt+Shift+H", Run: func() { board.Distribute(false) }},
+		{ID: "distribute-vertically", Group: "Arrange", Name: "Distribute Vertically", Default: "Alt+Shift+V", Run: func() { board.Distribute(true) }},
+	}
+	for _, pen := range stroke.Pens {
+		actions = append(actions, &shortcutAction{
+			ID: "pen-" + strings.ToLower(pen.Name), Group: "Tools", Name: pen.Name,
+			Default: pen.Key, Run: func() { selectPen(board, pen) },
+		})
+	}
+	actions = append(actions, &shortcutAction{
+		ID: "tool-select", Group: "Tools", Name: "Select", Default: "V", Run: func() { board.SetTool(toolSelect) },
+	})
+	actions = append(actions, &shortcutAction{
+		ID: "tool-connector", Group: "Tools", Name: "Connector", Default: "C", Run: func() { board.SetTool(toolConnector) },
+	}, &shortcutAction{
+		ID: "connector-label", Group: "Connectors", Name: "Edit Label", Default: "F2", Run: func() { showConnectorLabelDialog(w, board) },
+	})
+	for i, style := range model.ConnectorStyles {
+		actions = append(actions, &shortcutAction{
+			ID: "connector-" + string(style), Group: "Connectors", Name: connectorStyleNames[i],
+			Default: "Alt+" + strconv.Itoa(i+1), Run: func() { board.SetConnectorStyle(style) },
+		})
+	}
+	for i, c := range penColors {
+		actions = append(actions, &shortcutAction{
+			ID: "color-" + strings.ToLower(c.Name), Group: "Colors", Name: c.Name,
+			Default: strconv.Itoa(i + 1), Run: func() { board.SetLineColor(c.Color) },
+		})
+	}
+	keys = newShortcuts(w, actions)
+
 	// 設定ボタン
 	settingsButton := widget.NewButton("Settings", func() {
-		ShowSettingDialog(w, board)
+		ShowSettingDialog(w, board, keys)
 	})
 
 	// ボタンコンテナ
 	buttonContainer := container.NewHBox(
+		zoomLabel,
+		fitButton,
+		actualSizeButton,
+		openButton,
+		saveBoardButton,
 		clearButton,
 		saveButton,
 		backButton,
+		presentButton,
 		sendButton,
+		rawButton,
+		collabButton,
 		settingsButton,
 	)
 
 	// ヘッダーコンテンツ
 	headerContent := container.NewHBox(
 		headerLabel,
+		statusLabel,
 		layout.NewSpacer(), // 左右の間隔を空ける
 		buttonContainer,
 	)
@@ -177,35 +395,66 @@ func main() {
 	content := container.NewBorder(
 		headerContainer,
 		nil,
-		nil,
-		nil,
+		navigator,
+		layers,
 		currentContent,
 	)
 
-	// 初期サイズを設定
-	var lastSize fyne.Size
-
-	// ウィンドウサイズ変更時のコールバック
-	w.Canvas().SetOnTypedRune(func(r rune) {
-		// This is just a hook to get called regularly
-		currentSize := w.Canvas().Size()
-		if currentSize != lastSize {
-			lastSize = currentSize
-			BOARD_WIDTH = currentSize.Width
-			BOARD_HEIGHT = currentSize.Height
-			fmt.Printf("Window size: %v x %v\n", BOARD_WIDTH, BOARD_HEIGHT)
-		}
-	})
+	// スペースキーを押している間はドラッグでパン
+	if dc, ok := w.Canvas().(desktop.Canvas); ok {
+		dc.SetOnKeyDown(func(ke *fyne.KeyEvent) {
+			if ke.Name == fyne.KeySpace {
+				board.SetPanMode(true)
+			}
+		})
+		dc.SetOnKeyUp(func(ke *fyne.KeyEvent) {
+			if ke.Name == fyne.KeySpace {
+				board.SetPanMode(false)
+			}
+		})
+	}
 
-	// ESCキーでアプリを終了
-	w.Canvas().SetOnTypedKey(func(ke *fyne.KeyEvent) {
-		if ke.Name == fyne.KeyEscape {
-			a.Quit()
-		}
+	// 画像ファイルをドロップするとその位置に置く
+	w.SetOnDropped(func(pos fyne.Position, uris []fyne.URI) {
+		dropImages(w, board, pos, uris)
 	})
+
 	w.SetContent(content)
-	w.Resize(fyne.NewSize(BOARD_WIDTH+100, BOARD_HEIGHT+100))
+	w.Resize(fyne.NewSize(900, 700))
+
+	// 自動保存し、前回正常に終了しなかったときはそのボードを復元できるようにする
+	var saver *autosaver
+	var recovered []recovery.Entry
+	if root, err := recovery.Dir(); err != nil {
+		log.Printf("autosave disabled: %v", err)
+	} else {
+		if recovered, err = recovery.List(root); err != nil {
+			log.Printf("recovery: %v", err)
+		}
+		if session, err := recovery.Start(root); err != nil {
+			log.Printf("autosave disabled: %v", err)
+		} else {
+			saver = startAutosave(board, session)
+		}
+	}
+	if len(recovered) > 0 {
+		showRecoveryDialog(w, board, recovered, func() {
+			// 復元したボードはすぐに今のセッションへ保存する
+			if saver != nil {
+				if err := saver.SaveNow(); err != nil {
+					log.Printf("autosave: %v", err)
+				}
+			}
+		})
+	}
 
 	// アプリを実行
 	w.ShowAndRun()
+
+	// 正常に終了したので自動保存は要らない
+	if saver != nil {
+		if err := saver.Close(); err != nil {
+			log.Printf("autosave: %v", err)
+		}
+	}
 }
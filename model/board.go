@@ -0,0 +1,155 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Board files are JSON documents. Version 1 holds a single page:
+//
+//	{"format": "goWhiteBoard", "version": 1, "lines": [{"points": [...], "color": "#rrggbbaa", "width": 2}]}
+//
+// Version 2 holds a list of pages:
+//
+//	{"format": "goWhiteBoard", "version": 2, "pages": [{"id": "page-1", "name": "Page 1", "size": {...}, "grid": {...}, "layers": [...], "views": [...], "lines": [...]}]}
+const (
+	boardFormat  = "goWhiteBoard"
+	boardVersion = 2
+)
+
+// BoardExt is the file extension used for saved boards
+const BoardExt = ".wbd"
+
+// BoardPage is one page of a board file
+type BoardPage struct {
+	// ID names the page's room when the board is shared. It stays the same
+	// when pages are moved; pages of older files get PageID.
+	ID     string     `json:"id,omitempty"`
+	Name   string     `json:"name"`
+	Size   PageSize   `json:"size"`
+	Grid   Grid       `json:"grid"`
+	Layers []Layer    `json:"layers,omitempty"` // 下から順に。なければ 1 層だけ
+	Views  []Viewport `json:"views,omitempty"`
+	Lines  []Line     `json:"lines"`
+}
+
+// PageID returns the ID of page i of a file written before pages had IDs.
+// The first page of a new board has PageID(0), so participants that start
+// afresh meet on it.
+func PageID(i int) string {
+	return fmt.Sprintf("page-%d", i+1)
+}
+
+// Viewport is a named area of a page that can be shown again, e.g. as a
+// step of a presentation
+type Viewport struct {
+	Name string `json:"name"`
+	Min  Point  `json:"min"`
+	Max  Point  `json:"max"`
+}
+
+type boardFile struct {
+	Format  string      `json:"format"`
+	Version int         `json:"version"`
+	Lines   []Line      `json:"lines,omitempty"` // version 1
+	Pages   []BoardPage `json:"pages,omitempty"`
+}
+
+// WriteBoard writes lines as a board with a single page
+func WriteBoard(w io.Writer, lines []Line) error {
+	return WritePages(w, []BoardPage{{ID: PageID(0), Name: "Page 1", Lines: lines}})
+}
+
+// WritePages writes pages in the board file format
+func WritePages(w io.Writer, pages []BoardPage) error {
+	pages = append([]BoardPage(nil), pages...)
+	for i := range pages {
+		if pages[i].Lines == nil {
+			pages[i].Lines = []Line{}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(boardFile{Format: boardFormat, Version: boardVersion, Pages: pages})
+}
+
+// ReadBoard reads the lines of the first page of a board file
+func ReadBoard(r io.Reader) ([]Line, error) {
+	pages, err := ReadPages(r)
+	if err != nil {
+		return nil, err
+	}
+	return pages[0].Lines, nil
+}
+
+// ReadPages reads every page of a board file. A board has at least one page.
+func ReadPages(r io.Reader) ([]BoardPage, error) {
+	var f boardFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("reading board: %w", err)
+	}
+	if f.Format != boardFormat {
+		return nil, fmt.Errorf("reading board: not a board file")
+	}
+	if f.Version > boardVersion {
+		return nil, fmt.Errorf("reading board: version %d is newer than this program supports", f.Version)
+	}
+	// version 1 は 1 ページだけ
+	if f.Version < 2 || len(f.Pages) == 0 {
+		return []BoardPage{{ID: PageID(0), Name: "Page 1", Layers: DefaultLayers(), Lines: f.Lines}}, nil
+	}
+	seen := map[string]bool{}
+	for i := range f.Pages {
+		if len(f.Pages[i].Layers) == 0 {
+			f.Pages[i].Layers = DefaultLayers()
+		}
+		// 同じ ID のページが 2 つあると同じ部屋に入ってしまう
+		if f.Pages[i].ID == "" {
+			f.Pages[i].ID = PageID(i)
+		}
+		if seen[f.Pages[i].ID] {
+			f.Pages[i].ID = NewName()
+		}
+		seen[f.Pages[i].ID] = true
+	}
+	return f.Pages, nil
+}
+
+// SaveBoard writes lines to a board file with a single page
+func SaveBoard(filename string, lines []Line) error {
+	return SavePages(filename, []BoardPage{{ID: PageID(0), Name: "Page 1", Lines: lines}})
+}
+
+// SavePages writes pages to a board file
+func SavePages(filename string, pages []BoardPage) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := WritePages(f, pages); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadBoard reads the lines of the first page of a board file
+func LoadBoard(filename string) ([]Line, error) {
+	pages, err := LoadPages(filename)
+	if err != nil {
+		return nil, err
+	}
+	return pages[0].Lines, nil
+}
+
+// LoadPages reads every page of a board file
+func LoadPages(filename string) ([]BoardPage, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPages(f)
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"bytes"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBoardRoundTrip(t *testing.T) {
+	d := NewDocument()
+	d.Add(Line{Points: []Point{{X: 1, Y: 2}, {X: 3, Y: 4}}, Color: color.NRGBA{R: 255, A: 128}, Width: 3})
+
+	var buf bytes.Buffer
+	if err := WriteBoard(&buf, d.Snapshot().Lines); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := ReadBoard(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0].Points[1] != (Point{X: 3, Y: 4}) || lines[0].Width != 3 ||
+		lines[0].Color != (color.NRGBA{R: 255, A: 128}) || lines[0].ID != d.Snapshot().Lines[0].ID {
+		t.Errorf("round trip = %+v", lines)
+	}
+}
+
+func TestBoardKeepsPressure(t *testing.T) {
+	lines := []Line{{Points: []Point{{X: 1, Y: 2, P: 0.25}, {X: 3, Y: 4, P: 1}}, Color: color.Black, Width: 2}}
+	var buf bytes.Buffer
+	if err := WriteBoard(&buf, lines); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"p": 0.25`) {
+		t.Errorf("pressure not written: %s", buf.String())
+	}
+	read, err := ReadBoard(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read[0].Points[0] != lines[0].Points[0] || read[0].Points[1] != lines[0].Points[1] {
+		t.Errorf("points = %v", read[0].Points)
+	}
+}
+
+func TestReadBoardRejectsOtherFiles(t *testing.T) {
+	for _, input := range []string{
+		`{"lines": []}`,
+		`{"format": "goWhiteBoard", "version": 99, "lines": []}`,
+		`not json`,
+	} {
+		if _, err := ReadBoard(strings.NewReader(input)); err == nil {
+			t.Errorf("accepted %s", input)
+		}
+	}
+}
+
+func TestBoardPages(t *testing.T) {
+	pages := []BoardPage{
+		{Name: "Intro", Size: PageSize{Width: 800, Height: 600},
+			Layers: []Layer{{Name: "Base", Opacity: 1}, {ID: "1", Name: "Notes", Hidden: true, Locked: true, Opacity: 0.5}},
+			Views:  []Viewport{{Name: "Title", Min: Point{X: 10, Y: 20}, Max: Point{X: 400, Y: 300}}},
+			Lines:  []Line{{Points: []Point{{X: 1}, {X: 2}}, Color: color.Black, Width: 2, Layer: "1"}}},
+		{Name: "Empty", Grid: Grid{Style: GridDots, Spacing: 25, Export: true}},
+	}
+	var buf bytes.Buffer
+	if err := WritePages(&buf, pages); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadPages(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 || read[0].Name != "Intro" || read[0].Size != pages[0].Size ||
+		len(read[0].Lines) != 1 || read[1].Name != "Empty" || len(read[1].Lines) != 0 || read[1].Grid != pages[1].Grid {
+		t.Errorf("pages = %+v", read)
+	}
+	if !reflect.DeepEqual(read[0].Views, pages[0].Views) || read[1].Views != nil {
+		t.Errorf("views = %+v, %+v", read[0].Views, read[1].Views)
+	}
+	if !reflect.DeepEqual(read[0].Layers, pages[0].Layers) || read[0].Lines[0].Layer != "1" {
+		t.Errorf("layers = %+v, line layer %q", read[0].Layers, read[0].Lines[0].Layer)
+	}
+	// レイヤーのないページには既定のレイヤーが 1 つある
+	if !reflect.DeepEqual(read[1].Layers, DefaultLayers()) {
+		t.Errorf("default layers = %+v", read[1].Layers)
+	}
+
+	// ID のないページは古いクライアントと同じ部屋を使う
+	if read[0].ID != PageID(0) || read[1].ID != PageID(1) {
+		t.Errorf("IDs of pages without one = %q, %q", read[0].ID, read[1].ID)
+	}
+	buf.Reset()
+	pages[0].ID, pages[1].ID = "b", "b"
+	if err := WritePages(&buf, pages[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = ReadPages(&buf); err != nil || read[0].ID != "b" {
+		t.Errorf("ID = %q, %v", read[0].ID, err)
+	}
+	// 同じ ID のページは別の部屋になる
+	buf.Reset()
+	if err := WritePages(&buf, pages); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = ReadPages(&buf); err != nil || read[0].ID != "b" || read[1].ID == "b" || read[1].ID == "" {
+		t.Errorf("duplicate IDs = %q, %q, %v", read[0].ID, read[1].ID, err)
+	}
+
+	// version 1 のファイルは 1 ページとして読む
+	v1 := `{"format": "goWhiteBoard", "version": 1, "lines": [{"points": [{"x": 1, "y": 2}], "color": "#000000ff", "width": 2}]}`
+	read, err = ReadPages(strings.NewReader(v1))
+	if err != nil || len(read) != 1 || len(read[0].Lines) != 1 || read[0].Lines[0].Points[0] != (Point{X: 1, Y: 2}) || len(read[0].Layers) != 1 {
+		t.Errorf("version 1 = %+v, %v", read, err)
+	}
+}
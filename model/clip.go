@@ -0,0 +1,50 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Board content on the clipboard is JSON text, so it can be pasted into
+// another window or program instance through a text-only clipboard:
+//
+//	{"format": "goWhiteBoard-clip", "version": 1, "lines": [...]}
+const (
+	clipFormat  = "goWhiteBoard-clip"
+	clipVersion = 1
+)
+
+type clipFile struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Lines   []Line `json:"lines"`
+}
+
+// WriteClip returns lines in the clipboard format. IDs are left out, since
+// pasted lines always get new ones.
+func WriteClip(lines []Line) (string, error) {
+	out := make([]Line, len(lines))
+	for i, l := range lines {
+		l.ID = ID{}
+		out[i] = l
+	}
+	data, err := json.Marshal(clipFile{Format: clipFormat, Version: clipVersion, Lines: out})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ReadClip returns the lines of clipboard text written by WriteClip. It
+// reports false for any other text.
+func ReadClip(text string) ([]Line, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "{") || !strings.Contains(text, clipFormat) {
+		return nil, false
+	}
+	var f clipFile
+	if err := json.Unmarshal([]byte(text), &f); err != nil || f.Format != clipFormat || f.Version > clipVersion {
+		return nil, false
+	}
+	return f.Lines, len(f.Lines) > 0
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestClip(t *testing.T) {
+	d := NewDocument()
+	d.Add(Line{Points: []Point{{X: 1, Y: 2}, {X: 3, Y: 4}}, Color: color.Black, Width: 2})
+	d.Add(Line{Points: []Point{{X: 5}, {X: 50, Y: 20}}, Color: color.Black, Text: "hello"})
+
+	text, err := WriteClip(d.Snapshot().Lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, ok := ReadClip(text)
+	if !ok || len(lines) != 2 || lines[1].Text != "hello" || lines[0].Points[1] != (Point{X: 3, Y: 4}) || !lines[0].ID.IsZero() {
+		t.Errorf("ReadClip = %+v, %v", lines, ok)
+	}
+
+	for _, other := range []string{"hello", `{"format": "goWhiteBoard", "version": 2, "pages": []}`, `{"format": "goWhiteBoard-clip", "lines": []}`} {
+		if _, ok := ReadClip(other); ok {
+			t.Errorf("accepted %s", other)
+		}
+	}
+}
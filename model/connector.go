@@ -0,0 +1,107 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// ConnectorStyle is how a connector runs between its ends
+type ConnectorStyle string
+
+const (
+	// ConnectorStraight is a straight line
+	ConnectorStraight ConnectorStyle = "straight"
+	// ConnectorElbow runs horizontally and vertically only
+	ConnectorElbow ConnectorStyle = "elbow"
+	// ConnectorCurved is a smooth curve leaving and entering the shapes
+	// at right angles
+	ConnectorCurved ConnectorStyle = "curved"
+)
+
+// ConnectorStyles are the styles in the order they are offered
+var ConnectorStyles = []ConnectorStyle{ConnectorStraight, ConnectorElbow, ConnectorCurved}
+
+// Side is the side of a shape a connector is attached to
+type Side string
+
+const (
+	// SideAuto attaches to the side facing the other end
+	SideAuto   Side = ""
+	SideTop    Side = "top"
+	SideRight  Side = "right"
+	SideBottom Side = "bottom"
+	SideLeft   Side = "left"
+)
+
+// End is one end of a connector. Node names the line it is attached to;
+// an end without one is free and stays where it is.
+type End struct {
+	Node string `json:"node,omitempty"`
+	Side Side   `json:"side,omitempty"`
+}
+
+// Connector makes a line an arrow from one shape to another that is
+// routed again when the shapes move. The line's points are the route.
+type Connector struct {
+	Style ConnectorStyle `json:"style"`
+	From  End            `json:"from"`
+	To    End            `json:"to"`
+	Label string         `json:"label,omitempty"`
+}
+
+// validate checks the style and sides of c
+func (c *Connector) validate() error {
+	switch c.Style {
+	case ConnectorStraight, ConnectorElbow, ConnectorCurved:
+	default:
+		return fmt.Errorf("unknown connector style %q", c.Style)
+	}
+	for _, s := range []Side{c.From.Side, c.To.Side} {
+		switch s {
+		case SideAuto, SideTop, SideRight, SideBottom, SideLeft:
+		default:
+			return fmt.Errorf("unknown connector side %q", s)
+		}
+	}
+	return nil
+}
+
+// NewName returns a new random name for a node or a group
+func NewName() string {
+	token := make([]byte, 8)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// RenameCopies returns copies of lines with new node and group names, so
+// they can be added next to the lines they were copied from. Connectors
+// among them follow the new names; ends attached to lines that are not
+// among them become free.
+func RenameCopies(lines []Line) []Line {
+	names := map[string]string{}
+	groups := map[string]string{}
+	out := make([]Line, len(lines))
+	for i, l := range lines {
+		if l.Node != "" {
+			names[l.Node] = NewName()
+			l.Node = names[l.Node]
+		}
+		if l.Group != "" {
+			if _, ok := groups[l.Group]; !ok {
+				groups[l.Group] = NewName()
+			}
+			l.Group = groups[l.Group]
+		}
+		out[i] = l
+	}
+	for i, l := range out {
+		if l.Connector == nil {
+			continue
+		}
+		c := *l.Connector
+		c.From.Node, c.To.Node = names[c.From.Node], names[c.To.Node]
+		out[i].Connector = &c
+	}
+	return out
+}
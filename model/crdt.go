@@ -0,0 +1,274 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ID is a Lamport timestamp that names an operation. The ID of the insert
+// operation also identifies the object it created. IDs are unique because
+// every replica has its own name, and totally ordered (by time, then by
+// replica), which every replica uses to settle concurrent edits the same way.
+type ID struct {
+	Time    uint64
+	Replica string
+}
+
+// IsZero reports whether id is unset
+func (id ID) IsZero() bool {
+	return id.Time == 0 && id.Replica == ""
+}
+
+// Less orders IDs by time and breaks ties by replica name
+func (id ID) Less(other ID) bool {
+	if id.Time != other.Time {
+		return id.Time < other.Time
+	}
+	return id.Replica < other.Replica
+}
+
+// String formats id as "time@replica"
+func (id ID) String() string {
+	return fmt.Sprintf("%d@%s", id.Time, id.Replica)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (id *ID) UnmarshalText(text []byte) error {
+	t, replica, ok := strings.Cut(string(text), "@")
+	if !ok {
+		return fmt.Errorf("invalid id %q", text)
+	}
+	time, err := strconv.ParseUint(t, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %q: %w", text, err)
+	}
+	*id = ID{Time: time, Replica: replica}
+	return nil
+}
+
+// OpKind is the type of a document operation
+type OpKind string
+
+const (
+	// OpInsert adds Line as a new object named by the operation ID.
+	OpInsert OpKind = "insert"
+	// OpDelete removes the Targets. Deleted objects stay as tombstones, so
+	// a delete that arrives before its insert still wins.
+	OpDelete OpKind = "delete"
+	// OpRaise moves the Targets to the top of the z-order. Objects raised
+	// together are ordered by their own IDs.
+	OpRaise OpKind = "raise"
+	// OpReorder moves each of the Targets to the place in Z with the same
+	// index, above or below the other objects.
+	OpReorder OpKind = "reorder"
+)
+
+// Z is a place in the z-order. Objects are stacked by their Z and, at the
+// same Z, by their IDs. An insert or raise puts its objects at the Z of its
+// own ID, above everything there was; a place between two others can be
+// made by its Sub, so an object can be moved without moving any other.
+type Z struct {
+	At  ID      `json:"at"`
+	Sub float64 `json:"sub,omitempty"`
+}
+
+// IsZero reports whether z is unset
+func (z Z) IsZero() bool {
+	return z.At.IsZero() && z.Sub == 0
+}
+
+// Less reports whether z is below other
+func (z Z) Less(other Z) bool {
+	if z.At != other.At {
+		return z.At.Less(other.At)
+	}
+	return z.Sub < other.Sub
+}
+
+// between returns a place strictly between lo, or the bottom if lo is nil,
+// and hi. It reports false when there is none, as between two equal places.
+func between(lo *Z, hi Z) (Z, bool) {
+	switch {
+	case lo == nil:
+		return Z{At: hi.At, Sub: hi.Sub - 1}, true
+	case lo.At != hi.At:
+		return Z{At: lo.At, Sub: lo.Sub + 1}, lo.Less(hi)
+	}
+	z := Z{At: lo.At, Sub: (lo.Sub + hi.Sub) / 2}
+	return z, lo.Less(z) && z.Less(hi)
+}
+
+// Op is one edit of a document. Operations commute and applying one twice
+// has no effect, so replicas that received the same set of operations in
+// any order show the same lines.
+type Op struct {
+	Kind OpKind `json:"kind"`
+	ID   ID     `json:"id"`
+	// Time is when the operation was made, in milliseconds since the Unix
+	// epoch, for replays. 0 means unknown.
+	Time    int64 `json:"time,omitempty"`
+	Line    *Line `json:"line,omitempty"`
+	Targets []ID  `json:"targets,omitempty"`
+	// Z is where an insert puts its line, if set and not zero, and where a
+	// reorder puts each target
+	Z []Z `json:"z,omitempty"`
+}
+
+// object is the state of one board object on a replica. Entries are created
+// for deletes and moves of objects whose insert has not arrived yet.
+type object struct {
+	id       ID
+	line     Line
+	inserted bool
+	deleted  bool
+	z        Z
+	zBy      ID // z を決めた操作（新しい操作が勝つ）
+}
+
+// batch collects what a group of operations did to the visible lines
+type batch struct {
+	applied  []Op
+	appended int
+	last     Line
+	reshaped bool // 末尾への追加以外の変更があった
+}
+
+// applyLocked applies op unless it was seen before
+func (d *Document) applyLocked(op Op, b *batch) {
+	if op.ID.IsZero() || d.seen[op.ID] {
+		return
+	}
+	if op.Kind == OpInsert && op.Line == nil {
+		return
+	}
+	d.seen[op.ID] = true
+	d.ops = append(d.ops, op)
+	b.applied = append(b.applied, op)
+	if op.ID.Time > d.clock {
+		d.clock = op.ID.Time
+	}
+
+	switch op.Kind {
+	case OpInsert:
+		o := d.object(op.ID)
+		o.line = *op.Line
+		o.line.ID = op.ID
+		o.inserted = true
+		if o.zBy.Less(op.ID) {
+			o.z, o.zBy = Z{At: op.ID}, op.ID
+			if len(op.Z) > 0 && !op.Z[0].IsZero() {
+				o.z = op.Z[0]
+			}
+		}
+		if !o.deleted {
+			d.placeLocked(op.ID, o, b)
+		}
+	case OpDelete:
+		removed := map[ID]bool{}
+		for _, target := range op.Targets {
+			o := d.object(target)
+			if o.deleted {
+				continue
+			}
+			o.deleted = true
+			if o.inserted {
+				removed[target] = true
+			}
+		}
+		d.removeLocked(removed, b)
+	case OpRaise, OpReorder:
+		moved := map[ID]bool{}
+		for i, target := range op.Targets {
+			z := Z{At: op.ID}
+			if op.Kind == OpReorder {
+				if i >= len(op.Z) {
+					break // 位置のない対象は動かさない
+				}
+				z = op.Z[i]
+			}
+			o := d.object(target)
+			if !o.zBy.Less(op.ID) {
+				continue
+			}
+			o.z, o.zBy = z, op.ID
+			if o.inserted && !o.deleted {
+				moved[target] = true
+			}
+		}
+		d.removeLocked(moved, b)
+		for _, target := range op.Targets {
+			if moved[target] {
+				d.placeLocked(target, d.objects[target], b)
+				moved[target] = false
+			}
+		}
+	}
+}
+
+func (d *Document) object(id ID) *object {
+	o, ok := d.objects[id]
+	if !ok {
+		o = &object{id: id}
+		d.objects[id] = o
+	}
+	return o
+}
+
+// below reports whether o is stacked under other
+func (o *object) below(other *object) bool {
+	if o.z != other.z {
+		return o.z.Less(other.z)
+	}
+	return o.id.Less(other.id)
+}
+
+// placeLocked inserts a visible object at its z position
+func (d *Document) placeLocked(id ID, o *object, b *batch) {
+	i := len(d.order)
+	for i > 0 && o.below(d.objects[d.order[i-1]]) {
+		i--
+	}
+	if i == len(d.order) {
+		// スナップショットは長さで切り詰めてあるので追記しても影響しない
+		d.order = append(d.order, id)
+		d.lines = append(d.lines, o.line)
+		b.appended++
+		b.last = o.line
+		return
+	}
+
+	d.order = append(d.order, ID{})
+	copy(d.order[i+1:], d.order[i:])
+	d.order[i] = id
+
+	// 既存のスナップショットが参照している配列は書き換えない
+	lines := make([]Line, 0, len(d.lines)+1)
+	lines = append(lines, d.lines[:i]...)
+	lines = append(lines, o.line)
+	d.lines = append(lines, d.lines[i:]...)
+	b.reshaped = true
+}
+
+// removeLocked takes the given visible objects out of the line list
+func (d *Document) removeLocked(ids map[ID]bool, b *batch) {
+	if len(ids) == 0 {
+		return
+	}
+	order := d.order[:0]
+	// 既存のスナップショットが参照している配列は書き換えない
+	lines := make([]Line, 0, len(d.lines))
+	for i, id := range d.order {
+		if !ids[id] {
+			order = append(order, id)
+			lines = append(lines, d.lines[i])
+		}
+	}
+	d.order, d.lines = order, lines
+	b.reshaped = true
+}
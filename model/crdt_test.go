@@ -0,0 +1,261 @@
+package model
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// describe lists the IDs of the visible lines in z-order
+func describe(d *Document) string {
+	var ids []string
+	for _, l := range d.Snapshot().Lines {
+		ids = append(ids, l.ID.String())
+	}
+	return strings.Join(ids, " ")
+}
+
+// merge delivers every operation of from to to
+func merge(to, from *Document) {
+	to.Apply("test", from.Ops()...)
+}
+
+func TestConcurrentAddsAreKept(t *testing.T) {
+	a, b := newDocument("a"), newDocument("b")
+	a.Add(testLine(1))
+	b.Add(testLine(2))
+	b.Add(testLine(3))
+
+	merge(a, b)
+	merge(b, a)
+	if a.Len() != 3 || describe(a) != describe(b) {
+		t.Fatalf("replicas differ: %q / %q", describe(a), describe(b))
+	}
+	// 同じ時刻の挿入はレプリカ名で順序が決まる
+	if want := "1@a 1@b 2@b"; describe(a) != want {
+		t.Errorf("order = %q, want %q", describe(a), want)
+	}
+}
+
+func TestDeleteBeforeInsert(t *testing.T) {
+	a, b := newDocument("a"), newDocument("b")
+	id := a.Add(testLine(1))
+	merge(b, a)
+	b.Delete(id)
+
+	// 削除が挿入より先に届いても線は復活しない
+	c := newDocument("c")
+	c.Apply("test", b.Ops()[1])
+	c.Apply("test", b.Ops()[0])
+	if c.Len() != 0 {
+		t.Errorf("deleted line came back: %q", describe(c))
+	}
+}
+
+func TestClearKeepsConcurrentAdds(t *testing.T) {
+	a, b := newDocument("a"), newDocument("b")
+	a.Add(testLine(1))
+	merge(b, a)
+
+	// b が消している間に a が描いた線は消えない
+	b.Clear()
+	kept := a.Add(testLine(2))
+	merge(a, b)
+	merge(b, a)
+	if describe(a) != kept.String() || describe(b) != kept.String() {
+		t.Errorf("after clear: %q / %q, want %q", describe(a), describe(b), kept)
+	}
+}
+
+func TestBringToFront(t *testing.T) {
+	a, b := newDocument("a"), newDocument("b")
+	first := a.Add(testLine(1))
+	a.Add(testLine(2))
+	merge(b, a)
+
+	a.BringToFront(first)
+	if want := "2@a 1@a"; describe(a) != want {
+		t.Fatalf("order = %q, want %q", describe(a), want)
+	}
+
+	// 同時に描かれた線とは Lamport 時刻（同じならレプリカ名）で順序が決まる
+	b.Add(testLine(3))
+	merge(a, b)
+	merge(b, a)
+	if describe(a) != describe(b) {
+		t.Fatalf("replicas differ: %q / %q", describe(a), describe(b))
+	}
+	if want := "2@a 1@a 3@b"; describe(a) != want {
+		t.Errorf("order = %q, want %q", describe(a), want)
+	}
+}
+
+func TestArrange(t *testing.T) {
+	a, b := newDocument("a"), newDocument("b")
+	for i := 1; i <= 4; i++ {
+		a.Add(testLine(i))
+	}
+	merge(b, a)
+	lines := a.Snapshot().Lines
+
+	// 一番上の線を一番下へ動かし、2 番目の線を書き換える。ほかの線は触らない
+	changed := lines[1]
+	changed.Width = 9
+	n := len(a.Ops())
+	ids := a.Arrange([]ID{changed.ID}, []Line{lines[3], lines[0], changed, lines[2]})
+	ops := a.Ops()[n:]
+	if len(ops) != 3 || ops[0].Kind != OpDelete || ops[1].Kind != OpReorder || len(ops[1].Targets) != 1 || ops[2].Kind != OpInsert {
+		t.Fatalf("ops = %+v", ops)
+	}
+	want := strings.Join([]string{lines[3].ID.String(), lines[0].ID.String(), ids[0].String(), lines[2].ID.String()}, " ")
+	if describe(a) != want {
+		t.Fatalf("order = %q, want %q", describe(a), want)
+	}
+
+	// 同時に他の参加者が消した線は消えたまま、描いた線は一番上に残る
+	b.Delete(lines[3].ID)
+	added := b.Add(testLine(5))
+	merge(a, b)
+	merge(b, a)
+	want = strings.Join([]string{lines[0].ID.String(), ids[0].String(), lines[2].ID.String(), added.String()}, " ")
+	if describe(a) != want || describe(b) != want {
+		t.Errorf("after merging: %q / %q, want %q", describe(a), describe(b), want)
+	}
+
+	// 消えた線は並べ替えても戻らない
+	a.Arrange(nil, []Line{lines[2], lines[3], lines[0]})
+	if a.Len() != 4 || a.Snapshot().Lines[0].ID != lines[2].ID {
+		t.Errorf("after arranging a deleted line: %q", describe(a))
+	}
+}
+
+func TestApplyIsIdempotent(t *testing.T) {
+	a, b := newDocument("a"), newDocument("b")
+	a.Add(testLine(1))
+	a.Add(testLine(2))
+	merge(b, a)
+	version := b.Snapshot().Version
+	merge(b, a)
+	if b.Len() != 2 || b.Snapshot().Version != version {
+		t.Errorf("reapplying changed the document: %d lines, version %d -> %d", b.Len(), version, b.Snapshot().Version)
+	}
+}
+
+func TestOpJSON(t *testing.T) {
+	a := newDocument("a")
+	id := a.Add(testLine(2))
+	a.BringToFront(id)
+
+	data, err := json.Marshal(a.Ops())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []Op
+	if err := json.Unmarshal(data, &ops); err != nil {
+		t.Fatal(err)
+	}
+	b := newDocument("b")
+	b.Apply("test", ops...)
+	if describe(b) != describe(a) || b.Snapshot().Lines[0].Points[1] != (Point{X: 1, Y: 2}) {
+		t.Errorf("round trip: %q, %s", describe(b), data)
+	}
+}
+
+// randomEdit makes one local edit on d
+func randomEdit(rng *rand.Rand, d *Document) {
+	lines := d.Snapshot().Lines
+	switch n := rng.Intn(10); {
+	case n < 5 || len(lines) == 0:
+		d.Add(testLine(rng.Intn(4) + 1))
+	case n < 7:
+		d.Delete(pick(rng, lines)...)
+	case n < 8:
+		d.BringToFront(pick(rng, lines)...)
+	case n < 9:
+		shuffled := append([]Line(nil), lines...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		d.Arrange(pick(rng, lines), shuffled)
+	default:
+		d.Clear()
+	}
+}
+
+// pick returns the IDs of up to three random lines
+func pick(rng *rand.Rand, lines []Line) []ID {
+	var ids []ID
+	for i := rng.Intn(3); i >= 0; i-- {
+		ids = append(ids, lines[rng.Intn(len(lines))].ID)
+	}
+	return ids
+}
+
+// deliver applies a random subset of from's operations to to, in random
+// order and possibly twice
+func deliver(rng *rand.Rand, to, from *Document) {
+	ops := from.Ops()
+	for _, i := range rng.Perm(len(ops)) {
+		if rng.Intn(3) > 0 {
+			to.Apply("test", ops[i])
+		}
+	}
+}
+
+// TestRandomOrdersConverge checks the CRDT property: replicas that edit
+// concurrently and exchange operations in arbitrary orders end up with
+// identical lines once every operation has reached every replica.
+func TestRandomOrdersConverge(t *testing.T) {
+	converges := func(seed int64) bool {
+		rng := rand.New(rand.NewSource(seed))
+		replicas := []*Document{newDocument("a"), newDocument("b"), newDocument("c")}
+
+		for step := 0; step < 60; step++ {
+			d := replicas[rng.Intn(len(replicas))]
+			if rng.Intn(4) == 0 {
+				deliver(rng, d, replicas[rng.Intn(len(replicas))])
+			} else {
+				randomEdit(rng, d)
+			}
+		}
+
+		// 最後に全操作をばらばらの順序で全員に届ける
+		var all []Op
+		for _, d := range replicas {
+			all = append(all, d.Ops()...)
+		}
+		for _, d := range replicas {
+			for _, i := range rng.Perm(len(all)) {
+				d.Apply("test", all[i])
+			}
+		}
+
+		want := describe(replicas[0])
+		for _, d := range replicas[1:] {
+			if got := describe(d); got != want {
+				t.Logf("seed %d: %q != %q", seed, got, want)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(converges, &quick.Config{MaxCount: 300}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocalAddAppends(t *testing.T) {
+	a, b := newDocument("a"), newDocument("b")
+	for i := 0; i < 3; i++ {
+		b.Add(testLine(1))
+	}
+	merge(a, b)
+	generation := a.Snapshot().Generation
+
+	// 受け取った操作より後の時刻が振られるので常に末尾に追加される
+	id := a.Add(testLine(2))
+	snap := a.Snapshot()
+	if snap.Generation != generation || snap.Lines[len(snap.Lines)-1].ID != id {
+		t.Errorf("local add was not appended: %q", describe(a))
+	}
+}
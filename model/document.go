@@ -0,0 +1,591 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"image/color"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Point represents a point on the whiteboard in world coordinates
+type Point struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+	// P is the pen pressure in (0, 1]. 0 means the point has no pressure
+	// and is drawn at the line's width.
+	P float32 `json:"p,omitempty"`
+	// T is when the point was drawn, in milliseconds after the first point
+	// of its line. Lines without timing have 0 everywhere.
+	T int32 `json:"t,omitempty"`
+}
+
+// PressureWidth returns the width of a line of the given width at pressure
+// p. Half pressure is the nominal width; 0 (no pressure data) is too.
+func PressureWidth(width, p float32) float32 {
+	if p <= 0 {
+		return width
+	}
+	return width * (0.25 + 1.5*min32(p, 1))
+}
+
+// Line represents a finished stroke. A line is never modified after it has
+// been added to a Document, so snapshots can share it safely.
+type Line struct {
+	ID     ID // set by the Document when the line is added
+	Points []Point
+	Color  color.Color
+	Width  float32
+	Curve  bool // draw a smooth curve through the points instead of segments
+	// Highlighter lines are drawn beneath all other lines and multiply with
+	// what is under them, like a highlighter pen on paper.
+	Highlighter bool
+	// Image, if set, makes the line a picture that fills the rectangle
+	// between its two points. Color and Width are not used.
+	Image *Image
+	// Background lines are drawn beneath everything else and cannot be
+	// selected, e.g. a screenshot to annotate.
+	Background bool
+	// Text, if set, makes the line a text object in Color whose lines fill
+	// the rectangle between its two points. Width is not used.
+	Text string
+	// Layer is the ID of the layer the line is on
+	Layer string
+	// Node, if set, names the line for connectors attached to it. Unlike
+	// the ID it stays the same when the line is moved.
+	Node string
+	// Connector, if set, makes the line an arrow between two shapes whose
+	// points are its route
+	Connector *Connector
+	// Group, if set, names the group the line belongs to. The lines of a
+	// group are selected, moved and arranged together.
+	Group string
+	// Locked lines can be selected but not moved, resized or deleted
+	Locked bool
+}
+
+// Boxed reports whether the line is an image or text that fills the
+// rectangle between its two points rather than a stroke
+func (l Line) Boxed() bool {
+	return l.Image != nil || l.Text != ""
+}
+
+// WidthAt returns the width of the line at point i
+func (l Line) WidthAt(i int) float32 {
+	return PressureWidth(l.Width, l.Points[i].P)
+}
+
+// Variable reports whether the width changes along the line
+func (l Line) Variable() bool {
+	for _, p := range l.Points {
+		if p.P > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the rectangle covered by the line including its width
+func (l Line) Bounds() (min, max Point, ok bool) {
+	for i, p := range l.Points {
+		half := l.WidthAt(i) / 2
+		if i == 0 {
+			min = Point{X: p.X - half, Y: p.Y - half}
+			max = Point{X: p.X + half, Y: p.Y + half}
+			continue
+		}
+		min.X = min32(min.X, p.X-half)
+		min.Y = min32(min.Y, p.Y-half)
+		max.X = max32(max.X, p.X+half)
+		max.Y = max32(max.Y, p.Y+half)
+	}
+	return min, max, len(l.Points) > 0
+}
+
+// ChangeKind tells listeners what happened to the document
+type ChangeKind int
+
+const (
+	// LineAdded means lines were appended; earlier lines are unchanged.
+	LineAdded ChangeKind = iota
+	// Cleared means every line was removed.
+	Cleared
+	// Replaced means the whole line list was swapped out.
+	Replaced
+)
+
+// Change is delivered to subscribers after the document was modified
+type Change struct {
+	Kind    ChangeKind
+	Version uint64
+	Line    Line   // the (last) added line for LineAdded
+	Origin  string // who made the change; empty for local edits
+	Ops     []Op   // the operations that were applied
+}
+
+// Snapshot is an immutable view of the document at one version. It stays
+// valid (and unchanged) however the document is modified afterwards.
+type Snapshot struct {
+	Lines []Line
+	// Version increases with every change.
+	Version uint64
+	// Generation increases only when lines are removed or replaced. While it
+	// stays the same, a later snapshot only has more lines appended.
+	Generation uint64
+}
+
+// Bounds returns the rectangle covering every line in the snapshot
+func (s Snapshot) Bounds() (min, max Point, ok bool) {
+	for _, l := range s.Lines {
+		lmin, lmax, lok := l.Bounds()
+		if !lok {
+			continue
+		}
+		if !ok {
+			min, max, ok = lmin, lmax, true
+			continue
+		}
+		min = Point{X: min32(min.X, lmin.X), Y: min32(min.Y, lmin.Y)}
+		max = Point{X: max32(max.X, lmax.X), Y: max32(max.Y, lmax.Y)}
+	}
+	return min, max, ok
+}
+
+// Document holds the strokes of a board. It is one replica of a CRDT: every
+// edit becomes an Op that can be sent to other replicas, and replicas that
+// applied the same operations show the same lines regardless of the order
+// they arrived in. All methods are safe for concurrent use; readers take a
+// Snapshot instead of touching the lines directly.
+type Document struct {
+	mutex      sync.RWMutex
+	replica    string
+	clock      uint64 // Lamport clock
+	objects    map[ID]*object
+	seen       map[ID]bool // 適用済みの操作
+	ops        []Op        // 適用した順の操作履歴
+	order      []ID        // 表示中のオブジェクト（z 順、lines と対応）
+	lines      []Line
+	version    uint64
+	generation uint64
+	stamp      int64 // 最後のローカルな編集の時刻（ミリ秒）
+
+	listeners    map[int]func(Change)
+	nextListener int
+}
+
+// NewDocument creates an empty document with a random replica name
+func NewDocument() *Document {
+	token := make([]byte, 8)
+	rand.Read(token)
+	return newDocument(hex.EncodeToString(token))
+}
+
+func newDocument(replica string) *Document {
+	return &Document{
+		replica:   replica,
+		objects:   map[ID]*object{},
+		seen:      map[ID]bool{},
+		listeners: map[int]func(Change){},
+	}
+}
+
+// Replica returns the name that makes this document's operation IDs unique
+func (d *Document) Replica() string {
+	return d.replica
+}
+
+// Add appends a copy of l and returns its ID
+func (d *Document) Add(l Line) ID {
+	d.mutex.Lock()
+	d.stampLocked()
+	op := d.insertOpLocked(l)
+	change, ok := d.commitLocked("", op)
+	d.mutex.Unlock()
+
+	if ok {
+		d.notify(change)
+	}
+	return op.ID
+}
+
+// Clear removes every line
+func (d *Document) Clear() {
+	d.mutex.Lock()
+	d.stampLocked()
+	change, ok := d.commitLocked("", d.deleteOpLocked(d.order...))
+	d.mutex.Unlock()
+
+	if ok {
+		d.notify(change)
+	}
+}
+
+// Replace swaps the whole line list, e.g. after loading a file
+func (d *Document) Replace(lines []Line) {
+	d.mutex.Lock()
+	d.stampLocked()
+	ops := []Op{d.deleteOpLocked(d.order...)}
+	for _, l := range lines {
+		ops = append(ops, d.insertOpLocked(l))
+	}
+	change, ok := d.commitLocked("", ops...)
+	d.mutex.Unlock()
+
+	if ok {
+		d.notify(change)
+	}
+}
+
+// Delete removes the lines with the given IDs
+func (d *Document) Delete(ids ...ID) {
+	d.mutex.Lock()
+	d.stampLocked()
+	change, ok := d.commitLocked("", d.deleteOpLocked(ids...))
+	d.mutex.Unlock()
+
+	if ok {
+		d.notify(change)
+	}
+}
+
+// Update removes the lines with the given IDs and adds lines in one change,
+// returning the IDs of the added lines
+func (d *Document) Update(remove []ID, add []Line) []ID {
+	return lineIDs(d.edit(remove, add, nil, nil, nil).added)
+}
+
+// Arrange stacks lines, listed from the bottom up, in that order and deletes
+// the lines remove, in one change. Listed lines without an ID, or with one
+// in remove, are inserted; the others stay the lines with their IDs and are
+// only moved if they have to be, or are left out if they are gone. Lines
+// that are not listed stay where they are. It returns the IDs of the
+// inserted lines.
+func (d *Document) Arrange(remove []ID, lines []Line) []ID {
+	return lineIDs(d.arrange(remove, lines).added)
+}
+
+// edit deletes the lines remove, inserts add, add[i] at addAt[i] or on top
+// if there is none or it is zero, and moves move[i] to moveTo[i], in one
+// change. It returns what was done as an undoable edit.
+func (d *Document) edit(remove []ID, add []Line, addAt []Z, move []ID, moveTo []Z) edit {
+	d.mutex.Lock()
+	d.stampLocked()
+	ops, e := d.editLocked(remove, add, addAt, move, moveTo)
+	change, ok := d.commitLocked("", ops...)
+	d.mutex.Unlock()
+
+	if ok {
+		d.notify(change)
+	}
+	return e
+}
+
+// arrange is Arrange returning what was done as an undoable edit
+func (d *Document) arrange(remove []ID, lines []Line) edit {
+	d.mutex.Lock()
+	d.stampLocked()
+	add, addAt, move, moveTo := d.arrangeLocked(remove, lines)
+	ops, e := d.editLocked(remove, add, addAt, move, moveTo)
+	change, ok := d.commitLocked("", ops...)
+	d.mutex.Unlock()
+
+	if ok {
+		d.notify(change)
+	}
+	return e
+}
+
+// editLocked makes the operations for edit and records, for undo, the
+// visible lines they delete and move and where those were. The caller holds
+// the mutex and commits the operations.
+func (d *Document) editLocked(remove []ID, add []Line, addAt []Z, move []ID, moveTo []Z) ([]Op, edit) {
+	var ops []Op
+	var e edit
+	if len(remove) > 0 {
+		removed := make(map[ID]bool, len(remove))
+		for _, id := range remove {
+			removed[id] = true
+		}
+		for i, id := range d.order {
+			if removed[id] {
+				e.removed = append(e.removed, d.lines[i])
+				e.removedAt = append(e.removedAt, d.objects[id].z)
+			}
+		}
+		ops = append(ops, d.deleteOpLocked(remove...))
+	}
+	if len(move) > 0 {
+		for _, id := range move {
+			if o, ok := d.objects[id]; ok && o.inserted && !o.deleted {
+				e.moved = append(e.moved, id)
+				e.movedFrom = append(e.movedFrom, o.z)
+			}
+		}
+		ops = append(ops, Op{Kind: OpReorder, ID: d.nextIDLocked(), Time: d.stamp,
+			Targets: append([]ID(nil), move...), Z: append([]Z(nil), moveTo...)})
+	}
+	for i, l := range add {
+		op := d.insertOpLocked(l)
+		if i < len(addAt) && !addAt[i].IsZero() {
+			op.Z = []Z{addAt[i]}
+		}
+		ops = append(ops, op)
+		l.ID = op.ID
+		e.added = append(e.added, l)
+	}
+	return ops, e
+}
+
+// arrangeLocked works out how to stack lines in their order for Arrange:
+// the lines to insert and the lines to move, with their places. Of the
+// listed lines in the document, the most that are already in order stay
+// where they are; every other one gets a place between its neighbours. The
+// caller holds the mutex.
+func (d *Document) arrangeLocked(remove []ID, lines []Line) (add []Line, addAt []Z, move []ID, moveTo []Z) {
+	removed := make(map[ID]bool, len(remove))
+	for _, id := range remove {
+		removed[id] = true
+	}
+	index := make(map[ID]int, len(d.order))
+	for i, id := range d.order {
+		index[id] = i
+	}
+	kept := make([]bool, len(lines))
+	var order, at []int
+	var listed []Line
+	for _, l := range lines {
+		j, ok := index[l.ID]
+		switch {
+		case l.ID.IsZero() || removed[l.ID]:
+		case ok:
+			kept[len(listed)] = true
+			order, at = append(order, j), append(at, len(listed))
+		default:
+			// 他の参加者が消した線は戻さない
+			continue
+		}
+		listed = append(listed, l)
+	}
+	lines = listed
+	fixed := make([]bool, len(lines))
+	for _, k := range longestIncreasing(order) {
+		fixed[at[k]] = true
+	}
+
+	// 一番上に置く線は、どの操作よりも新しい top の上に並べる
+	top := d.nextIDLocked()
+	var lo *Z
+	for i, l := range lines {
+		if fixed[i] {
+			z := d.objects[l.ID].z
+			lo = &z
+			continue
+		}
+		var z Z
+		for j := i + 1; ; j++ {
+			for j < len(lines) && !fixed[j] {
+				j++
+			}
+			if j == len(lines) {
+				z = Z{At: top}
+				if lo != nil && lo.At == top {
+					z.Sub = lo.Sub + 1
+				}
+				break
+			}
+			var ok bool
+			if z, ok = between(lo, d.objects[lines[j].ID].z); ok {
+				break
+			}
+			// 同じ位置に並んだ線の間には入れないので、上の線も動かす
+			fixed[j] = false
+		}
+		lo = &z
+		if kept[i] {
+			move, moveTo = append(move, l.ID), append(moveTo, z)
+		} else {
+			add, addAt = append(add, l), append(addAt, z)
+		}
+	}
+	return add, addAt, move, moveTo
+}
+
+// longestIncreasing returns the indices of a longest strictly increasing
+// subsequence of keys
+func longestIncreasing(keys []int) []int {
+	var tails []int // tails[n] は長さ n+1 の列の末尾の添字
+	prev := make([]int, len(keys))
+	for i, k := range keys {
+		n := sort.Search(len(tails), func(n int) bool { return keys[tails[n]] >= k })
+		prev[i] = -1
+		if n > 0 {
+			prev[i] = tails[n-1]
+		}
+		if n == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[n] = i
+		}
+	}
+	out := make([]int, len(tails))
+	for n, i := len(tails)-1, -1; n >= 0; n-- {
+		if i < 0 {
+			i = tails[n]
+		} else {
+			i = prev[i]
+		}
+		out[n] = i
+	}
+	return out
+}
+
+// BringToFront moves the lines with the given IDs above every other line
+func (d *Document) BringToFront(ids ...ID) {
+	d.mutex.Lock()
+	d.stampLocked()
+	op := Op{Kind: OpRaise, ID: d.nextIDLocked(), Time: d.stamp, Targets: append([]ID(nil), ids...)}
+	change, ok := d.commitLocked("", op)
+	d.mutex.Unlock()
+
+	if ok {
+		d.notify(change)
+	}
+}
+
+// Apply merges operations from another replica on behalf of origin (e.g. a
+// remote peer), so listeners can tell them apart from local edits.
+// Operations that were applied before are ignored.
+func (d *Document) Apply(origin string, ops ...Op) {
+	d.mutex.Lock()
+	change, ok := d.commitLocked(origin, ops...)
+	d.mutex.Unlock()
+
+	if ok {
+		d.notify(change)
+	}
+}
+
+// Ops returns every operation applied so far. Sending them to another
+// replica brings it up to date.
+func (d *Document) Ops() []Op {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.ops[:len(d.ops):len(d.ops)]
+}
+
+func (d *Document) nextIDLocked() ID {
+	d.clock++
+	return ID{Time: d.clock, Replica: d.replica}
+}
+
+func (d *Document) insertOpLocked(l Line) Op {
+	l.Points = append([]Point(nil), l.Points...)
+	return Op{Kind: OpInsert, ID: d.nextIDLocked(), Time: d.stamp, Line: &l}
+}
+
+func (d *Document) deleteOpLocked(ids ...ID) Op {
+	return Op{Kind: OpDelete, ID: d.nextIDLocked(), Time: d.stamp, Targets: append([]ID(nil), ids...)}
+}
+
+// stampLocked starts a local edit: its operations are stamped with the
+// current time, kept after the time of the previous edit so that separate
+// edits never share a stamp. The caller holds the mutex.
+func (d *Document) stampLocked() {
+	d.stamp = max(time.Now().UnixMilli(), d.stamp+1)
+}
+
+// commitLocked applies ops and describes the result. It reports false when
+// every operation had been applied before.
+func (d *Document) commitLocked(origin string, ops ...Op) (Change, bool) {
+	var b batch
+	for _, op := range ops {
+		d.applyLocked(op, &b)
+	}
+	if len(b.applied) == 0 {
+		return Change{}, false
+	}
+
+	d.version++
+	change := Change{Version: d.version, Origin: origin, Ops: b.applied}
+	switch {
+	case b.reshaped || (b.appended == 0 && len(d.lines) == 0):
+		if b.reshaped {
+			d.generation++
+		}
+		change.Kind = Replaced
+		if len(d.lines) == 0 {
+			change.Kind = Cleared
+		}
+	case b.appended > 0:
+		change.Kind = LineAdded
+		change.Line = b.last
+	default:
+		// 見えない状態だけが変わった（未着の線の削除など）
+		change.Kind = Replaced
+	}
+	return change, true
+}
+
+// Snapshot returns the current state. It does not copy the lines: the slice
+// is capped at its length so later appends can never show through it.
+func (d *Document) Snapshot() Snapshot {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return Snapshot{
+		Lines:      d.lines[:len(d.lines):len(d.lines)],
+		Version:    d.version,
+		Generation: d.generation,
+	}
+}
+
+// Len returns the number of lines
+func (d *Document) Len() int {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return len(d.lines)
+}
+
+// Subscribe registers fn to be called after every change. fn runs on the
+// goroutine that made the change, outside the document lock, so it may read
+// the document but should not block. The returned function unsubscribes.
+func (d *Document) Subscribe(fn func(Change)) (unsubscribe func()) {
+	d.mutex.Lock()
+	id := d.nextListener
+	d.nextListener++
+	d.listeners[id] = fn
+	d.mutex.Unlock()
+
+	return func() {
+		d.mutex.Lock()
+		delete(d.listeners, id)
+		d.mutex.Unlock()
+	}
+}
+
+func (d *Document) notify(change Change) {
+	d.mutex.RLock()
+	listeners := make([]func(Change), 0, len(d.listeners))
+	for _, fn := range d.listeners {
+		listeners = append(listeners, fn)
+	}
+	d.mutex.RUnlock()
+
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+func min32(a, b float32) float32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b float32) float32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,173 @@
+package model
+
+import (
+	"image/color"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func testLine(n int) Line {
+	l := Line{Color: color.Black, Width: 2}
+	for i := 0; i < n; i++ {
+		l.Points = append(l.Points, Point{X: float32(i), Y: float32(i * 2)})
+	}
+	return l
+}
+
+func TestSnapshotIsImmutable(t *testing.T) {
+	d := NewDocument()
+	d.Add(testLine(3))
+	d.Add(testLine(4))
+
+	snap := d.Snapshot()
+	d.Add(testLine(5))
+	if len(snap.Lines) != 2 {
+		t.Fatalf("snapshot grew to %d lines", len(snap.Lines))
+	}
+
+	// 追記でスナップショットの要素が上書きされないこと
+	grown := append(snap.Lines, testLine(9))
+	if d.Snapshot().Lines[2].Points[4] != (Point{X: 4, Y: 8}) || len(grown[2].Points) != 9 {
+		t.Fatal("appending to a snapshot modified the document")
+	}
+
+	d.Clear()
+	if len(snap.Lines) != 2 || len(snap.Lines[1].Points) != 4 {
+		t.Fatal("Clear modified an earlier snapshot")
+	}
+	if after := d.Snapshot(); after.Generation == snap.Generation || len(after.Lines) != 0 {
+		t.Errorf("Clear: generation %d -> %d, %d lines", snap.Generation, after.Generation, len(after.Lines))
+	}
+}
+
+func TestAddCopiesPoints(t *testing.T) {
+	d := NewDocument()
+	l := testLine(2)
+	d.Add(l)
+	l.Points[0] = Point{X: 100, Y: 100}
+	if d.Snapshot().Lines[0].Points[0] != (Point{}) {
+		t.Error("document shares the caller's point slice")
+	}
+}
+
+func TestOpTimes(t *testing.T) {
+	d := NewDocument()
+	d.Update(nil, []Line{testLine(2), testLine(2)})
+	d.Add(testLine(2))
+	d.Clear()
+	ops := d.Ops()
+	// ひとつの編集の操作は同じ時刻、別の編集は同じミリ秒でも別の時刻
+	if ops[0].Time == 0 || ops[1].Time != ops[0].Time || ops[2].Time <= ops[1].Time || ops[3].Time <= ops[2].Time {
+		t.Errorf("times %d %d %d %d", ops[0].Time, ops[1].Time, ops[2].Time, ops[3].Time)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	d := NewDocument()
+	var changes []Change
+	unsubscribe := d.Subscribe(func(c Change) {
+		changes = append(changes, c)
+	})
+
+	d.Add(testLine(2))
+	d.Replace([]Line{testLine(1), testLine(1)})
+	d.Clear()
+	unsubscribe()
+	d.Add(testLine(2))
+
+	want := []ChangeKind{LineAdded, Replaced, Cleared}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(want))
+	}
+	for i, c := range changes {
+		if c.Kind != want[i] || c.Version != uint64(i+1) {
+			t.Errorf("change %d = %+v", i, c)
+		}
+	}
+}
+
+func TestSnapshotBounds(t *testing.T) {
+	s := Snapshot{Lines: []Line{
+		{Points: []Point{{X: 10, Y: 10}, {X: 20, Y: 5}}, Width: 2},
+		{Points: []Point{{X: -5, Y: 30}}, Width: 4},
+		{},
+	}}
+	min, max, ok := s.Bounds()
+	if !ok || min != (Point{X: -7, Y: 4}) || max != (Point{X: 21, Y: 32}) {
+		t.Errorf("Bounds = %v %v %v", min, max, ok)
+	}
+	if _, _, ok := (Snapshot{}).Bounds(); ok {
+		t.Error("empty snapshot has bounds")
+	}
+}
+
+func TestPressureWidth(t *testing.T) {
+	l := Line{Points: []Point{{X: 0, Y: 0}, {X: 10, Y: 0, P: 0.5}, {X: 20, Y: 0, P: 1}}, Width: 4}
+	if !l.Variable() || (Line{Points: []Point{{X: 1}}}).Variable() {
+		t.Error("Variable is wrong")
+	}
+	if w := []float32{l.WidthAt(0), l.WidthAt(1), l.WidthAt(2)}; w[0] != 4 || w[1] != 4 || w[2] != 7 {
+		t.Errorf("widths = %v", w)
+	}
+	// 一番太い点が範囲を決める
+	if min, max, _ := l.Bounds(); min.Y != -3.5 || max.Y != 3.5 || max.X != 23.5 {
+		t.Errorf("Bounds = %v %v", min, max)
+	}
+}
+
+// TestConcurrentAccess hammers the document from writers, readers and
+// subscribers at once. Run with -race.
+func TestConcurrentAccess(t *testing.T) {
+	d := NewDocument()
+	var notified atomic.Int64
+	d.Subscribe(func(Change) {
+		notified.Add(1)
+		// 通知の中からドキュメントを読んでもデッドロックしないこと
+		_ = d.Len()
+	})
+
+	const writers, readers, iterations = 8, 8, 200
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				switch {
+				case j%50 == 49:
+					d.Clear()
+				case j%20 == 19:
+					d.Replace([]Line{testLine(3)})
+				default:
+					d.Add(testLine(i + 2))
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				snap := d.Snapshot()
+				for _, l := range snap.Lines {
+					for _, p := range l.Points {
+						_ = p.X + p.Y
+					}
+				}
+				snap.Bounds()
+				unsubscribe := d.Subscribe(func(Change) {})
+				unsubscribe()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := notified.Load(); got != writers*iterations {
+		t.Errorf("notified %d times, want %d", got, writers*iterations)
+	}
+	if v := d.Snapshot().Version; v != writers*iterations {
+		t.Errorf("Version = %d, want %d", v, writers*iterations)
+	}
+}
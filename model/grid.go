@@ -0,0 +1,70 @@
+package model
+
+import "math"
+
+// GridStyle is the background pattern of a page
+type GridStyle string
+
+const (
+	GridNone      GridStyle = ""
+	GridDots      GridStyle = "dots"
+	GridSquares   GridStyle = "squares"
+	GridIsometric GridStyle = "isometric"
+	GridRuled     GridStyle = "ruled"
+)
+
+// GridStyles lists every style in the order they are offered to the user
+var GridStyles = []GridStyle{GridNone, GridDots, GridSquares, GridIsometric, GridRuled}
+
+// DefaultGridSpacing is the distance between grid lines in world units
+const DefaultGridSpacing = 20
+
+// Grid is the background pattern of a page. Its points are also where
+// shapes and moved objects snap to.
+type Grid struct {
+	Style   GridStyle `json:"style,omitempty"`
+	Spacing float32   `json:"spacing,omitempty"`
+	// Export draws the pattern into exported images as well
+	Export bool `json:"export,omitempty"`
+}
+
+// Visible reports whether the grid draws anything
+func (g Grid) Visible() bool {
+	return g.Style != GridNone && g.Spacing > 0
+}
+
+// RowHeight returns the distance between rows of grid points. Isometric
+// grids have rows of equilateral triangles.
+func (g Grid) RowHeight() float32 {
+	if g.Style == GridIsometric {
+		return g.Spacing * float32(math.Sqrt(3)) / 2
+	}
+	return g.Spacing
+}
+
+// Snap returns the grid point nearest to p. Isometric grids shift every
+// other row by half the spacing.
+func (g Grid) Snap(p Point) Point {
+	if !g.Visible() {
+		return p
+	}
+	s, h := float64(g.Spacing), float64(g.RowHeight())
+	if g.Style != GridIsometric {
+		return Point{X: float32(math.Round(float64(p.X)/s) * s), Y: float32(math.Round(float64(p.Y)/h) * h), P: p.P}
+	}
+	// 近い 2 行の格子点を比べる
+	best, bestDist := p, math.Inf(1)
+	row := math.Floor(float64(p.Y) / h)
+	for _, j := range []float64{row, row + 1} {
+		shift := 0.0
+		if int(j)%2 != 0 {
+			shift = s / 2
+		}
+		x := math.Round((float64(p.X)-shift)/s)*s + shift
+		y := j * h
+		if d := math.Hypot(x-float64(p.X), y-float64(p.Y)); d < bestDist {
+			best, bestDist = Point{X: float32(x), Y: float32(y), P: p.P}, d
+		}
+	}
+	return best
+}
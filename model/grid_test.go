@@ -0,0 +1,26 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGridSnap(t *testing.T) {
+	squares := Grid{Style: GridSquares, Spacing: 20}
+	if p := squares.Snap(Point{X: 29, Y: -11}); p != (Point{X: 20, Y: -20}) {
+		t.Errorf("squares: %v", p)
+	}
+	if p := (Grid{Spacing: 20}).Snap(Point{X: 29, Y: 31}); p != (Point{X: 29, Y: 31}) {
+		t.Errorf("no grid: %v", p)
+	}
+
+	// 奇数行は半分ずれる
+	iso := Grid{Style: GridIsometric, Spacing: 20}
+	h := float32(20 * math.Sqrt(3) / 2)
+	if p := iso.Snap(Point{X: 12, Y: h + 1}); p.X != 10 || math.Abs(float64(p.Y-h)) > 1e-4 {
+		t.Errorf("isometric odd row: %v", p)
+	}
+	if p := iso.Snap(Point{X: 18, Y: 2*h - 1}); p.X != 20 || math.Abs(float64(p.Y-2*h)) > 1e-4 {
+		t.Errorf("isometric even row: %v", p)
+	}
+}
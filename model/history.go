@@ -0,0 +1,219 @@
+package model
+
+import "sync"
+
+// maxHistory bounds the number of edits that can be undone
+const maxHistory = 200
+
+// edit is one undoable step: lines that were added by it, lines that were
+// removed and where they were stacked, lines that were moved in the
+// z-order and where from, and what else it changed outside the document
+type edit struct {
+	added     []Line
+	removed   []Line
+	removedAt []Z
+	moved     []ID
+	movedFrom []Z
+	effect    Effect
+}
+
+// Effect is a change outside the document that belongs to an edit, such as
+// a layer removed together with its lines. Undo reverts the change when the
+// edit is undone, before its lines come back; Redo makes it again when the
+// edit is redone. Either may be nil.
+type Effect struct {
+	Undo func()
+	Redo func()
+}
+
+// History makes local edits of a Document undoable. Only edits made through
+// the History are recorded, so undo never touches what other participants
+// drew. A removed line comes back as a new line (the CRDT never revives a
+// deleted one) at the place it had, which other replicas see as an ordinary
+// insert.
+type History struct {
+	doc *Document
+
+	mutex sync.Mutex
+	undo  []edit
+	redo  []edit
+}
+
+// NewHistory creates an empty history for doc
+func NewHistory(doc *Document) *History {
+	return &History{doc: doc}
+}
+
+// Add adds a line and records it
+func (h *History) Add(l Line) ID {
+	ids := h.do(nil, []Line{l}, Effect{})
+	return ids[0]
+}
+
+// Delete removes lines and records them
+func (h *History) Delete(ids ...ID) {
+	h.do(ids, nil, Effect{})
+}
+
+// DeleteWith removes lines like Delete and records effect, a change the
+// caller made outside the document, in the same edit. The edit is recorded
+// even if no line is removed, as long as there is an effect.
+func (h *History) DeleteWith(ids []ID, effect Effect) {
+	h.do(ids, nil, effect)
+}
+
+// Clear removes every line and records them
+func (h *History) Clear() {
+	h.do(lineIDs(h.doc.Snapshot().Lines), nil, Effect{})
+}
+
+// Replace swaps every line for lines and records both
+func (h *History) Replace(lines []Line) {
+	h.do(lineIDs(h.doc.Snapshot().Lines), lines, Effect{})
+}
+
+// Update removes lines and adds others in one recorded edit, e.g. to move
+// lines, and returns the IDs of the added lines
+func (h *History) Update(remove []ID, add []Line) []ID {
+	return h.do(remove, add, Effect{})
+}
+
+// Arrange deletes and stacks lines like Document.Arrange in one recorded
+// edit, and returns the IDs of the inserted lines. Undo puts the moved
+// lines back where they were.
+func (h *History) Arrange(remove []ID, lines []Line) []ID {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	e := h.doc.arrange(remove, lines)
+	h.recordLocked(e)
+	return lineIDs(e.added)
+}
+
+// Undo reverts the latest recorded edit. It reports false if there is none.
+func (h *History) Undo() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.undo) == 0 {
+		return false
+	}
+	e := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+	h.redo = append(h.redo, h.revert(e))
+	return true
+}
+
+// Redo applies the latest undone edit again. It reports false if there is
+// none.
+func (h *History) Redo() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.redo) == 0 {
+		return false
+	}
+	e := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+	h.undo = append(h.undo, h.revert(e))
+	return true
+}
+
+// CanUndo reports whether Undo would do anything
+func (h *History) CanUndo() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.undo) > 0
+}
+
+// CanRedo reports whether Redo would do anything
+func (h *History) CanRedo() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.redo) > 0
+}
+
+// do removes lines and adds others, records the edit with effect and
+// returns the IDs of the added lines
+func (h *History) do(remove []ID, add []Line, effect Effect) []ID {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	e := h.doc.edit(remove, add, nil, nil, nil)
+	e.effect = effect
+	h.recordLocked(e)
+	return lineIDs(e.added)
+}
+
+// recordLocked records e and forgets the redo steps, unless e did nothing.
+// The caller holds the mutex.
+func (h *History) recordLocked(e edit) {
+	if len(e.added) == 0 && len(e.removed) == 0 && len(e.moved) == 0 && e.effect.Undo == nil && e.effect.Redo == nil {
+		return
+	}
+	h.undo = append(h.undo, e)
+	if len(h.undo) > maxHistory {
+		h.undo = h.undo[len(h.undo)-maxHistory:]
+	}
+	h.redo = nil
+}
+
+// revert removes what e added, adds back what it removed where it was and
+// moves back what it moved. It returns the edit that reverts the revert.
+// The caller holds the mutex.
+func (h *History) revert(e edit) edit {
+	// 線を戻す前にドキュメントの外の変更（レイヤーなど）を戻す
+	if e.effect.Undo != nil {
+		e.effect.Undo()
+	}
+	// 他の参加者がすでに消した線は消さない（存在する線だけ対象にする）
+	present := h.lines(lineIDs(e.added))
+	r := h.doc.edit(lineIDs(present), e.removed, e.removedAt, e.moved, e.movedFrom)
+	h.renumber(e.removed, r.added)
+	r.effect = Effect{Undo: e.effect.Redo, Redo: e.effect.Undo}
+	return r
+}
+
+// renumber points the recorded edits at the new IDs of lines that were added
+// back. The caller holds the mutex.
+func (h *History) renumber(lines, added []Line) {
+	renamed := make(map[ID]ID, len(lines))
+	for i, l := range lines {
+		renamed[l.ID] = added[i].ID
+	}
+	for _, stack := range [][]edit{h.undo, h.redo} {
+		for _, e := range stack {
+			for _, l := range [][]Line{e.added, e.removed} {
+				for i := range l {
+					if id, ok := renamed[l[i].ID]; ok {
+						l[i].ID = id
+					}
+				}
+			}
+			for i, id := range e.moved {
+				if n, ok := renamed[id]; ok {
+					e.moved[i] = n
+				}
+			}
+		}
+	}
+}
+
+// lines returns the lines with the given IDs that are still in the document
+func (h *History) lines(ids []ID) []Line {
+	wanted := make(map[ID]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	var lines []Line
+	for _, l := range h.doc.Snapshot().Lines {
+		if wanted[l.ID] {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func lineIDs(lines []Line) []ID {
+	ids := make([]ID, len(lines))
+	for i, l := range lines {
+		ids[i] = l.ID
+	}
+	return ids
+}
@@ -0,0 +1,139 @@
+package model
+
+import "testing"
+
+func TestHistoryUndoRedo(t *testing.T) {
+	d := NewDocument()
+	h := NewHistory(d)
+	h.Add(testLine(2))
+	h.Add(testLine(3))
+	h.Clear()
+
+	if !h.Undo() || d.Len() != 2 {
+		t.Fatalf("undo clear: %d lines", d.Len())
+	}
+	if !h.Undo() || d.Len() != 1 || len(d.Snapshot().Lines[0].Points) != 2 {
+		t.Fatalf("undo add: %d lines", d.Len())
+	}
+	if !h.Redo() || !h.Redo() || d.Len() != 0 {
+		t.Fatalf("redo: %d lines", d.Len())
+	}
+	if h.Redo() {
+		t.Error("redo with nothing to redo")
+	}
+
+	// 新しい編集で redo は消える
+	h.Undo()
+	h.Add(testLine(4))
+	if h.CanRedo() || d.Len() != 3 {
+		t.Errorf("after a new edit: can redo %v, %d lines", h.CanRedo(), d.Len())
+	}
+}
+
+func TestHistoryLeavesOthersAlone(t *testing.T) {
+	a, b := newDocument("a"), newDocument("b")
+	h := NewHistory(a)
+	h.Add(testLine(2))
+	b.Apply("a", a.Ops()...)
+	b.Add(testLine(5))
+	a.Apply("b", b.Ops()...)
+
+	// 自分の線だけを取り消す
+	h.Undo()
+	if a.Len() != 1 || len(a.Snapshot().Lines[0].Points) != 5 {
+		t.Errorf("undo removed the wrong line: %d lines", a.Len())
+	}
+
+	// 取り消す前に他の参加者が消していた線は復活させない
+	h.Redo()
+	id := a.Snapshot().Lines[1].ID
+	b.Apply("a", a.Ops()...)
+	b.Delete(id)
+	a.Apply("b", b.Ops()...)
+	h.Undo()
+	h.Redo()
+	if a.Len() != 1 {
+		t.Errorf("after undo/redo of a line deleted remotely: %d lines", a.Len())
+	}
+}
+
+func TestHistoryEffect(t *testing.T) {
+	d := NewDocument()
+	h := NewHistory(d)
+	id := h.Add(testLine(2))
+
+	// 線と一緒に消したものは同じ手順で戻る
+	var log []string
+	h.DeleteWith([]ID{id}, Effect{
+		Undo: func() { log = append(log, "undo") },
+		Redo: func() { log = append(log, "redo") },
+	})
+	h.Undo()
+	if d.Len() != 1 || len(log) != 1 || log[0] != "undo" {
+		t.Fatalf("undo: %d lines, %v", d.Len(), log)
+	}
+	h.Redo()
+	h.Undo()
+	if d.Len() != 1 || len(log) != 3 || log[1] != "redo" || log[2] != "undo" {
+		t.Errorf("redo and undo again: %d lines, %v", d.Len(), log)
+	}
+}
+
+func TestHistoryArrange(t *testing.T) {
+	d := NewDocument()
+	h := NewHistory(d)
+	for i := 1; i <= 3; i++ {
+		h.Add(testLine(i))
+	}
+	lines := d.Snapshot().Lines
+	describe := func() string {
+		var s string
+		for _, l := range d.Snapshot().Lines {
+			s += string(rune('0' + len(l.Points)))
+		}
+		return s
+	}
+
+	// 並べ替えと書き換えは 1 回で取り消せ、元の重なり順に戻る
+	changed := lines[0]
+	changed.Points = append(changed.Points, Point{}, Point{}, Point{})
+	h.Arrange([]ID{changed.ID}, []Line{lines[2], lines[1], changed})
+	if got := describe(); got != "324" {
+		t.Fatalf("arranged: %s", got)
+	}
+	h.Undo()
+	if got := describe(); got != "123" || d.Snapshot().Lines[1].ID != lines[1].ID {
+		t.Errorf("undo: %s", got)
+	}
+	h.Redo()
+	if got := describe(); got != "324" {
+		t.Errorf("redo: %s", got)
+	}
+
+	// 消した線は元の位置に戻る
+	h.Delete(d.Snapshot().Lines[1].ID)
+	h.Undo()
+	if got := describe(); got != "324" {
+		t.Errorf("undo delete: %s", got)
+	}
+}
+
+func TestHistorySkipsEmptyEdits(t *testing.T) {
+	d := NewDocument()
+	h := NewHistory(d)
+	id := h.Add(testLine(2))
+	h.Undo()
+
+	// 何も変えない編集は記録せず、やり直しも残る
+	h.Delete(id)
+	h.Replace(nil)
+	h.DeleteWith(nil, Effect{})
+	h.Arrange(nil, nil)
+	if h.CanUndo() || !h.CanRedo() {
+		t.Errorf("empty edits recorded: undo %v, redo %v", h.CanUndo(), h.CanRedo())
+	}
+	h.DeleteWith(nil, Effect{Undo: func() {}})
+	if !h.CanUndo() {
+		t.Error("edit with an effect not recorded")
+	}
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Image formats that can be placed on a board
+const (
+	ImagePNG  = "png"
+	ImageJPEG = "jpeg"
+	ImageGIF  = "gif"
+	ImageSVG  = "svg"
+)
+
+// MaxImageSize limits the size of an imported image file
+const MaxImageSize = 20 << 20
+
+// Image is a picture placed on the board. The file is kept as it was
+// imported, so saving and sending it loses nothing. An Image is never
+// modified after it has been added, so lines can share it.
+type Image struct {
+	Format string `json:"format"`
+	Data   []byte `json:"data"`
+}
+
+// NewImage detects the format of an image file's contents
+func NewImage(data []byte) (*Image, error) {
+	if len(data) > MaxImageSize {
+		return nil, fmt.Errorf("the image is larger than %d MB", MaxImageSize>>20)
+	}
+	format := ImageFormat(data)
+	if format == "" {
+		return nil, fmt.Errorf("not a PNG, JPEG, GIF or SVG image")
+	}
+	return &Image{Format: format, Data: data}, nil
+}
+
+// ImageFormat returns the format of data, or "" if it is not a supported
+// image
+func ImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return ImagePNG
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return ImageJPEG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return ImageGIF
+	}
+	// SVG は XML 宣言やコメントの後に <svg が来る
+	head := data[:min(len(data), 1024)]
+	if strings.Contains(strings.ToLower(string(head)), "<svg") {
+		return ImageSVG
+	}
+	return ""
+}
+
+// MIMEType returns the media type of the image
+func (img *Image) MIMEType() string {
+	if img.Format == ImageSVG {
+		return "image/svg+xml"
+	}
+	return "image/" + img.Format
+}
+
+// NewImageLine returns a line that shows img in the rectangle from a to b
+func NewImageLine(img *Image, a, b Point, background bool) Line {
+	return Line{Points: []Point{a, b}, Image: img, Background: background}
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestImageFormat(t *testing.T) {
+	for data, want := range map[string]string{
+		"\x89PNG\r\n\x1a\n....": ImagePNG,
+		"\xff\xd8\xff\xe0":      ImageJPEG,
+		"GIF89a..":              ImageGIF,
+		`<?xml version="1.0"?><svg width="1"></svg>`: ImageSVG,
+		"hello": "",
+	} {
+		if got := ImageFormat([]byte(data)); got != want {
+			t.Errorf("ImageFormat(%q) = %q, want %q", data, got, want)
+		}
+	}
+	if _, err := NewImage([]byte("hello")); err == nil {
+		t.Error("accepted text as an image")
+	}
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	img, err := NewImage([]byte("GIF89a-data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []Line{NewImageLine(img, Point{X: 1, Y: 2}, Point{X: 30, Y: 40}, true)}
+	var buf bytes.Buffer
+	if err := WriteBoard(&buf, lines); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadBoard(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 1 || read[0].Image == nil || read[0].Image.Format != ImageGIF ||
+		string(read[0].Image.Data) != "GIF89a-data" || !read[0].Background || read[0].Points[1] != (Point{X: 30, Y: 40}) {
+		t.Errorf("round trip = %+v", read)
+	}
+}
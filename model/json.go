@@ -0,0 +1,102 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+)
+
+// lineJSON is the serialized form of a Line. Colors are stored as
+// "#rrggbbaa" so the format stays readable and independent of color types.
+type lineJSON struct {
+	ID     *ID     `json:"id,omitempty"`
+	Points []Point `json:"points"`
+	Color  string  `json:"color"`
+	Width  float32 `json:"width"`
+	Curve  bool    `json:"curve,omitempty"`
+	// 蛍光ペン
+	Highlighter bool       `json:"highlighter,omitempty"`
+	Image       *Image     `json:"image,omitempty"`
+	Background  bool       `json:"background,omitempty"`
+	Text        string     `json:"text,omitempty"`
+	Layer       string     `json:"layer,omitempty"`
+	Node        string     `json:"node,omitempty"`
+	Connector   *Connector `json:"connector,omitempty"`
+	Group       string     `json:"group,omitempty"`
+	Locked      bool       `json:"locked,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (l Line) MarshalJSON() ([]byte, error) {
+	points := l.Points
+	if points == nil {
+		points = []Point{}
+	}
+	v := lineJSON{Points: points, Color: ColorToHex(l.Color), Width: l.Width, Curve: l.Curve, Highlighter: l.Highlighter,
+		Image: l.Image, Background: l.Background, Text: l.Text, Layer: l.Layer,
+		Node: l.Node, Connector: l.Connector, Group: l.Group, Locked: l.Locked}
+	if !l.ID.IsZero() {
+		v.ID = &l.ID
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (l *Line) UnmarshalJSON(data []byte) error {
+	var v lineJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	c, err := ParseHexColor(v.Color)
+	if err != nil {
+		return err
+	}
+	if (v.Image != nil || v.Text != "") && len(v.Points) < 2 {
+		return fmt.Errorf("image or text without a rectangle")
+	}
+	if v.Connector != nil {
+		if len(v.Points) < 2 {
+			return fmt.Errorf("connector without a route")
+		}
+		if err := v.Connector.validate(); err != nil {
+			return err
+		}
+	}
+	*l = Line{Points: v.Points, Color: c, Width: v.Width, Curve: v.Curve, Highlighter: v.Highlighter,
+		Image: v.Image, Background: v.Background, Text: v.Text, Layer: v.Layer,
+		Node: v.Node, Connector: v.Connector, Group: v.Group, Locked: v.Locked}
+	if v.ID != nil {
+		l.ID = *v.ID
+	}
+	return nil
+}
+
+// ColorToHex formats c as "#rrggbbaa" (non-premultiplied). nil is black.
+func ColorToHex(c color.Color) string {
+	if c == nil {
+		c = color.Black
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+// ParseHexColor parses "#rrggbb" or "#rrggbbaa". An empty string is black.
+func ParseHexColor(s string) (color.Color, error) {
+	if s == "" {
+		return color.NRGBA{A: 255}, nil
+	}
+	c := color.NRGBA{A: 255}
+	var err error
+	switch len(s) {
+	case 7:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 9:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A)
+	default:
+		err = fmt.Errorf("invalid color %q", s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q: %w", s, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,60 @@
+package model
+
+import "strconv"
+
+// Layer is a named group of lines on a page. Lines name their layer by ID;
+// the ID stays the same when the layer is renamed or reordered.
+type Layer struct {
+	// ID is "" for the first layer of a page, so lines written before
+	// there were layers belong to it
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Hidden bool   `json:"hidden,omitempty"`
+	// Locked layers are shown but their lines cannot be selected and
+	// nothing can be drawn on them
+	Locked bool `json:"locked,omitempty"`
+	// Opacity in (0, 1] applies to the layer as a whole. Zero means fully
+	// opaque, so layers written without it stay visible.
+	Opacity float32 `json:"opacity,omitempty"`
+}
+
+// DefaultLayers is the layer list of a new page
+func DefaultLayers() []Layer {
+	return []Layer{{Name: "Layer 1", Opacity: 1}}
+}
+
+// Alpha returns the opacity of the layer in (0, 1]
+func (l Layer) Alpha() float32 {
+	if l.Opacity <= 0 || l.Opacity > 1 {
+		return 1
+	}
+	return l.Opacity
+}
+
+// NewLayerID returns an ID that none of layers uses for a layer made on
+// the replica of a document. Layers are not shared with other replicas, so
+// the ID starts with the replica name: a line a participant draws on a
+// layer of theirs never lands on an unrelated layer of someone else.
+func NewLayerID(replica string, layers []Layer) string {
+	used := make(map[string]bool, len(layers))
+	for _, l := range layers {
+		used[l.ID] = true
+	}
+	for n := len(layers) + 1; ; n++ {
+		if id := replica + "-" + strconv.Itoa(n); !used[id] {
+			return id
+		}
+	}
+}
+
+// LayerIndex returns the position of the layer a line with the given layer
+// ID is drawn on. Lines of a layer that does not exist (any more) are drawn
+// on the bottom layer.
+func LayerIndex(layers []Layer, id string) int {
+	for i, l := range layers {
+		if l.ID == id {
+			return i
+		}
+	}
+	return 0
+}
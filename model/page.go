@@ -0,0 +1,28 @@
+package model
+
+// PageSize is the size of a page's canvas in world units, which are pixels
+// at 100% zoom. The zero PageSize is unbounded: the page grows with its
+// content.
+type PageSize struct {
+	Width  float32 `json:"width"`
+	Height float32 `json:"height"`
+}
+
+// Fixed reports whether the page has a size
+func (s PageSize) Fixed() bool {
+	return s.Width > 0 && s.Height > 0
+}
+
+// PagePreset is a named page size
+type PagePreset struct {
+	Name string
+	Size PageSize
+}
+
+// PagePresets are the page sizes offered to the user. A4 is landscape at 96
+// dpi.
+var PagePresets = []PagePreset{
+	{"A4", PageSize{Width: 1123, Height: 794}},
+	{"16:9", PageSize{Width: 1920, Height: 1080}},
+	{"4:3", PageSize{Width: 1600, Height: 1200}},
+}
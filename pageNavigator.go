@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// newPageNavigator returns the sidebar listing the pages of the board, with
+// buttons to add, duplicate, reorder, rename and delete pages and to export
+// all of them as a PDF
+func newPageNavigator(w fyne.Window, board *whiteboard) fyne.CanvasObject {
+	list := widget.NewList(
+		func() int {
+			return len(board.Pages())
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Page 00")
+		},
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			// 更新中にページが減っていることがある
+			if names := board.Pages(); id < len(names) {
+				o.(*widget.Label).SetText(names[id])
+			}
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		board.SetCurrentPage(id)
+	}
+	board.OnPagesChanged = func() {
+		list.Refresh()
+		list.Select(board.CurrentPage())
+	}
+	list.Select(board.CurrentPage())
+
+	addButton := widget.NewButtonWithIcon("", theme.ContentAddIcon(), board.AddPage)
+	duplicateButton := widget.NewButtonWithIcon("", theme.ContentCopyIcon(), board.DuplicatePage)
+	upButton := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() {
+		i := board.CurrentPage()
+		board.MovePage(i, i-1)
+	})
+	downButton := widget.NewButtonWithIcon("", theme.MoveDownIcon(), func() {
+		i := board.CurrentPage()
+		board.MovePage(i, i+1)
+	})
+	renameButton := widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+		showRenamePageDialog(w, board)
+	})
+	deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		i := board.CurrentPage()
+		name := board.Pages()[i]
+		dialog.ShowConfirm("Delete Page", fmt.Sprintf("Delete %q and its strokes?", name), func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := board.DeletePage(i); err != nil {
+				dialog.ShowError(err, w)
+			}
+		}, w)
+	})
+	exportButton := widget.NewButtonWithIcon("PDF", theme.DocumentPrintIcon(), func() {
+		showExportPDFDialog(w, board)
+	})
+
+	buttons := container.NewVBox(
+		container.NewGridWithColumns(3, addButton, duplicateButton, renameButton),
+		container.NewGridWithColumns(3, upButton, downButton, deleteButton),
+		exportButton,
+	)
+	title := widget.NewLabel("Pages")
+	title.TextStyle = fyne.TextStyle{Bold: true}
+	return container.NewBorder(title, buttons, nil, nil, list)
+}
+
+// showRenamePageDialog asks for a new name for the page shown
+func showRenamePageDialog(w fyne.Window, board *whiteboard) {
+	i := board.CurrentPage()
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(board.Pages()[i])
+	dialog.ShowForm("Rename Page", "Rename", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Name", nameEntry),
+	}, func(ok bool) {
+		if ok {
+			board.RenamePage(i, nameEntry.Text)
+		}
+	}, w)
+}
+
+// showExportPDFDialog writes every page into one PDF file
+func showExportPDFDialog(w fyne.Window, board *whiteboard) {
+	save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil || writer == nil {
+			return
+		}
+		writer.Close()
+		if err := board.ExportPDF(writer.URI().Path(), nil); err != nil {
+			dialog.ShowError(err, w)
+		}
+	}, w)
+	save.SetFilter(storage.NewExtensionFileFilter([]string{".pdf"}))
+	save.SetFileName("whiteboard.pdf")
+	save.Show()
+}
+
+// showSendPagesDialog lets the user choose the pages and layers to send.
+// The page shown and the layers shown are selected initially; sending the
+// layers as shown passes nil layers. Boards with a single page and layer
+// are sent right away.
+func showSendPagesDialog(w fyne.Window, board *whiteboard, send func(pages []int, layers []string)) {
+	names := board.Pages()
+	current := board.CurrentPage()
+	layerNames, shown := board.LayerNames()
+	if len(names) == 1 && len(layerNames) == 1 {
+		send([]int{current}, nil)
+		return
+	}
+	// 名前は重複しうるので、ページごとにチェックボックスを作る
+	checks := container.NewVBox()
+	for i, name := range names {
+		check := widget.NewCheck(name, nil)
+		check.SetChecked(i == current)
+		checks.Add(check)
+	}
+	layerChecks := container.NewVBox()
+	for i, name := range layerNames {
+		check := widget.NewCheck(name, nil)
+		check.SetChecked(shown[i])
+		layerChecks.Add(check)
+	}
+	var items []*widget.FormItem
+	if len(names) > 1 {
+		items = append(items, widget.NewFormItem("Pages", checks))
+	}
+	if len(layerNames) > 1 {
+		items = append(items, widget.NewFormItem("Layers", layerChecks))
+	}
+	dialog.ShowForm("Send", "Send", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		var pages []int
+		for i, o := range checks.Objects {
+			if o.(*widget.Check).Checked {
+				pages = append(pages, i)
+			}
+		}
+		layers := []string{}
+		asShown := true
+		for i, o := range layerChecks.Objects {
+			checked := o.(*widget.Check).Checked
+			if checked {
+				layers = append(layers, layerNames[i])
+			}
+			asShown = asShown && checked == shown[i]
+		}
+		if asShown {
+			layers = nil
+		}
+		if len(pages) > 0 {
+			send(pages, layers)
+		}
+	}, w)
+}
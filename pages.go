@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+)
+
+// boardPage is one page of the board with its own strokes, undo history,
+// view and canvas size. While a page is shown its view and size live in the
+// whiteboard and are stored back when another page is shown.
+type boardPage struct {
+	id         string // 共有するときの部屋の名前になる
+	name       string
+	doc        *model.Document
+	history    *model.History
+	view       viewport
+	pageSize   model.PageSize
+	followPage bool
+	grid       model.Grid
+	layers     []model.Layer    // 下のレイヤーから順に
+	layer      string           // 新しい線を描くレイヤーの ID
+	views      []model.Viewport // 保存した表示範囲
+	saved      uint64           // 最後に保存・読み込みしたときのドキュメントのバージョン
+}
+
+// newPage creates an empty page
+func (w *whiteboard) newPage(name string, size model.PageSize) *boardPage {
+	doc := model.NewDocument()
+	// ドキュメントが変更されたら（どのゴルーチンからでも）再描画する
+	doc.Subscribe(func(model.Change) {
+		w.Refresh()
+	})
+	return &boardPage{
+		id:         model.NewName(),
+		name:       name,
+		doc:        doc,
+		history:    model.NewHistory(doc),
+		view:       newViewport(),
+		pageSize:   size,
+		followPage: size.Fixed(),
+		layers:     model.DefaultLayers(),
+	}
+}
+
+// storePageLocked copies the view of the page shown back into its page.
+// The caller holds the mutex.
+func (w *whiteboard) storePageLocked() {
+	p := w.pages[w.pageIndex]
+	p.view, p.pageSize, p.followPage, p.grid = w.view, w.pageSize, w.followPage, w.grid
+}
+
+// showPageLocked shows page i without storing the page shown before. A
+// stroke in progress is dropped. The caller holds the mutex.
+func (w *whiteboard) showPageLocked(i int) {
+	p := w.pages[i]
+	w.pageIndex = i
+	w.doc, w.history = p.doc, p.history
+	w.view, w.pageSize, w.followPage, w.grid = p.view, p.pageSize, p.followPage, p.grid
+	if w.followPage && w.pageSize.Fixed() {
+		w.fitPageLocked(w.size)
+	}
+	w.drawing, w.panning = false, false
+	w.currentLine = model.Line{}
+	w.selection, w.selecting, w.moving, w.guides = nil, false, false, nil
+	w.connecting, w.connector = false, model.Line{}
+	w.layersVersion++
+}
+
+// pagesUpdated redraws the board and tells the page navigator, the layer
+// panel and, if another page is shown, whatever follows the page shown
+func (w *whiteboard) pagesUpdated() {
+	w.viewportChanged()
+	if w.OnPagesChanged != nil {
+		w.OnPagesChanged()
+	}
+	if w.OnLayersChanged != nil {
+		w.OnLayersChanged()
+	}
+	w.mutex.Lock()
+	shown := w.doc != w.shownDoc
+	w.shownDoc = w.doc
+	w.mutex.Unlock()
+	if shown && w.OnPageShown != nil {
+		w.OnPageShown()
+	}
+}
+
+// Pages returns the names of the pages in order
+func (w *whiteboard) Pages() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	names := make([]string, len(w.pages))
+	for i, p := range w.pages {
+		names[i] = p.name
+	}
+	return names
+}
+
+// CurrentPage returns the index of the page shown
+func (w *whiteboard) CurrentPage() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.pageIndex
+}
+
+// CurrentPageID returns the ID of the page shown, which names its room when
+// the board is shared
+func (w *whiteboard) CurrentPageID() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.pages[w.pageIndex].id
+}
+
+// SetCurrentPage shows page i. Each page keeps its view and undo history.
+func (w *whiteboard) SetCurrentPage(i int) {
+	w.mutex.Lock()
+	if i < 0 || i >= len(w.pages) || i == w.pageIndex {
+		w.mutex.Unlock()
+		return
+	}
+	w.storePageLocked()
+	w.showPageLocked(i)
+	w.mutex.Unlock()
+	w.pagesUpdated()
+}
+
+// AddPage inserts an empty page with the size and grid of the page shown
+// after it and shows it
+func (w *whiteboard) AddPage() {
+	w.mutex.Lock()
+	w.storePageLocked()
+	p := w.newPage(w.pageNameLocked("Page"), w.pageSize)
+	p.grid = w.grid
+	w.insertPageLocked(w.pageIndex+1, p)
+	w.mutex.Unlock()
+	w.pagesUpdated()
+}
+
+// DuplicatePage inserts a copy of the page shown after it and shows it
+func (w *whiteboard) DuplicatePage() {
+	w.mutex.Lock()
+	w.storePageLocked()
+	from := w.pages[w.pageIndex]
+	p := w.newPage(w.pageNameLocked(from.name+" copy"), from.pageSize)
+	p.view, p.followPage, p.grid = from.view, from.followPage, from.grid
+	p.layers, p.layer = append([]model.Layer(nil), from.layers...), from.layer
+	p.views = append([]model.Viewport(nil), from.views...)
+	lines := from.doc.Snapshot().Lines
+	w.insertPageLocked(w.pageIndex+1, p)
+	w.mutex.Unlock()
+
+	// 通知で再描画するのでロックの外で追加する
+	p.doc.Replace(lines)
+	w.pagesUpdated()
+}
+
+// insertPageLocked inserts p at index i and shows it. The caller holds the
+// mutex and has stored the page shown.
+func (w *whiteboard) insertPageLocked(i int, p *boardPage) {
+	w.pages = append(w.pages[:i], append([]*boardPage{p}, w.pages[i:]...)...)
+	w.pagesEditedLocked()
+	w.showPageLocked(i)
+}
+
+// pageNameLocked returns base, or base followed by the lowest number that
+// makes it unique. The caller holds the mutex.
+func (w *whiteboard) pageNameLocked(base string) string {
+	used := map[string]bool{}
+	for _, p := range w.pages {
+		used[p.name] = true
+	}
+	if base != "Page" && !used[base] {
+		return base
+	}
+	for n := 1; ; n++ {
+		if name := fmt.Sprintf("%s %d", base, n); !used[name] {
+			return name
+		}
+	}
+}
+
+// MovePage moves page from to index to, keeping the page shown
+func (w *whiteboard) MovePage(from, to int) {
+	w.mutex.Lock()
+	if from < 0 || from >= len(w.pages) || to < 0 || to >= len(w.pages) || from == to {
+		w.mutex.Unlock()
+		return
+	}
+	shown := w.pages[w.pageIndex]
+	p := w.pages[from]
+	w.pages = append(w.pages[:from], w.pages[from+1:]...)
+	w.pages = append(w.pages[:to], append([]*boardPage{p}, w.pages[to:]...)...)
+	for i, q := range w.pages {
+		if q == shown {
+			w.pageIndex = i
+		}
+	}
+	w.pagesEditedLocked()
+	w.mutex.Unlock()
+	w.pagesUpdated()
+}
+
+// RenamePage renames page i. Empty names are ignored.
+func (w *whiteboard) RenamePage(i int, name string) {
+	name = strings.TrimSpace(name)
+	w.mutex.Lock()
+	if i < 0 || i >= len(w.pages) || name == "" || name == w.pages[i].name {
+		w.mutex.Unlock()
+		return
+	}
+	w.pages[i].name = name
+	w.pagesEditedLocked()
+	w.mutex.Unlock()
+	w.pagesUpdated()
+}
+
+// errLastPage is returned when the only page would be deleted
+var errLastPage = errors.New("a board needs at least one page")
+
+// DeletePage removes page i with its strokes. The last page cannot be
+// deleted.
+func (w *whiteboard) DeletePage(i int) error {
+	w.mutex.Lock()
+	if len(w.pages) == 1 {
+		w.mutex.Unlock()
+		return errLastPage
+	}
+	if i < 0 || i >= len(w.pages) {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.storePageLocked()
+	shown := w.pageIndex
+	w.pages = append(w.pages[:i], w.pages[i+1:]...)
+	w.pagesEditedLocked()
+	switch {
+	case i < shown:
+		w.pageIndex--
+	case i == shown:
+		w.showPageLocked(min(i, len(w.pages)-1))
+	}
+	w.mutex.Unlock()
+	w.pagesUpdated()
+	return nil
+}
+
+// pagesEditedLocked records that pages, their layers or their saved views
+// changed. The caller holds the mutex.
+func (w *whiteboard) pagesEditedLocked() {
+	w.pagesChanged = true
+	w.edits++
+}
+
+// Modified reports whether any page or its layers changed, or pages were
+// added, removed, reordered or renamed, since the board was last saved or loaded
+func (w *whiteboard) Modified() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.pagesChanged {
+		return true
+	}
+	for _, p := range w.pages {
+		if p.doc.Snapshot().Version != p.saved {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveBoard writes every page to a board file
+func (w *whiteboard) SaveBoard(filename string) error {
+	w.mutex.Lock()
+	pages := append([]*boardPage(nil), w.pages...)
+	file, versions := w.boardPagesLocked()
+	w.mutex.Unlock()
+
+	if err := model.SavePages(filename, file); err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	for i, p := range pages {
+		p.saved = versions[i]
+	}
+	w.pagesChanged = false
+	w.mutex.Unlock()
+	return nil
+}
+
+// boardPagesLocked returns every page as written to a board file and the
+// version of each page's document. The caller holds the mutex.
+func (w *whiteboard) boardPagesLocked() ([]model.BoardPage, []uint64) {
+	w.storePageLocked()
+	file := make([]model.BoardPage, len(w.pages))
+	versions := make([]uint64, len(w.pages))
+	for i, p := range w.pages {
+		snapshot := p.doc.Snapshot()
+		file[i] = model.BoardPage{ID: p.id, Name: p.name, Size: p.pageSize, Grid: p.grid, Layers: append([]model.Layer(nil), p.layers...),
+			Views: append([]model.Viewport(nil), p.views...), Lines: snapshot.Lines}
+		versions[i] = snapshot.Version
+	}
+	return file, versions
+}
+
+// LoadBoard replaces the pages with those of a board file and shows the
+// first one. Existing pages are reused in order, so loading can be undone
+// on them and a shared page stays shared.
+func (w *whiteboard) LoadBoard(filename string) error {
+	read, err := model.LoadPages(filename)
+	if err != nil {
+		return err
+	}
+	w.setPages(read, true)
+	return nil
+}
+
+// RestorePages replaces the pages with recovered ones like LoadBoard. The
+// board stays modified, since the pages were never saved to a file.
+func (w *whiteboard) RestorePages(read []model.BoardPage) {
+	w.setPages(read, false)
+}
+
+// setPages replaces the pages with read and shows the first one. If saved,
+// the board is no longer modified afterwards.
+func (w *whiteboard) setPages(read []model.BoardPage, saved bool) {
+	w.mutex.Lock()
+	pages := make([]*boardPage, len(read))
+	reused := min(len(read), len(w.pages))
+	for i, bp := range read {
+		if i >= reused {
+			pages[i] = w.newPage(bp.Name, bp.Size)
+			pages[i].id, pages[i].grid = bp.ID, bp.Grid
+			pages[i].layers, pages[i].layer = bp.Layers, bp.Layers[len(bp.Layers)-1].ID
+			pages[i].views = bp.Views
+			continue
+		}
+		p := w.pages[i]
+		p.id, p.name, p.pageSize, p.grid = bp.ID, bp.Name, bp.Size, bp.Grid
+		p.layers, p.layer = bp.Layers, bp.Layers[len(bp.Layers)-1].ID
+		p.views = bp.Views
+		p.view, p.followPage = newViewport(), bp.Size.Fixed()
+		pages[i] = p
+	}
+	w.pages = pages
+	w.showPageLocked(0)
+	w.mutex.Unlock()
+
+	// 通知で再描画するのでロックの外で入れ替える
+	for i, p := range pages {
+		if i < reused {
+			// 読み込みも取り消せる
+			p.history.Replace(read[i].Lines)
+		} else {
+			p.doc.Replace(read[i].Lines)
+		}
+	}
+
+	w.mutex.Lock()
+	w.pagesEditedLocked()
+	if saved {
+		for _, p := range pages {
+			p.saved = p.doc.Snapshot().Version
+		}
+		w.pagesChanged = false
+	}
+	w.mutex.Unlock()
+	w.pagesUpdated()
+}
+
+// pageStates returns the export state of the given pages, or of every page
+// if pages is empty
+func (w *whiteboard) pageStates(pages []int) []exportState {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(pages) == 0 {
+		for i := range w.pages {
+			pages = append(pages, i)
+		}
+	}
+	var states []exportState
+	for _, i := range pages {
+		if i >= 0 && i < len(w.pages) {
+			states = append(states, w.pageStateLocked(i))
+		}
+	}
+	return states
+}
+
+// ExportPDF writes the given pages, or every page if pages is empty, as one
+// PDF with a PDF page per board page
+func (w *whiteboard) ExportPDF(filename string, pages []int) error {
+	var out []render.Page
+	for _, s := range w.pageStates(pages) {
+		view := s.exportView()
+		out = append(out, render.Page{Groups: s.exportGroups(view), View: view})
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := render.WritePDFPages(f, out); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pageGap is the space between pages stacked into one image
+const pageGap = 40
+
+// PagesPNG renders the given pages below each other into one PNG image.
+// With layers nil the layers are drawn as shown; otherwise only the layers
+// with those names are drawn, whether they are hidden or not.
+func (w *whiteboard) PagesPNG(pages []int, layers []string) ([]byte, error) {
+	var images []*image.RGBA
+	for _, s := range w.pageStates(pages) {
+		if layers != nil {
+			s.layers = showLayers(s.layers, layers)
+		}
+		view := s.exportView()
+		images = append(images, render.GroupsImage(s.exportGroups(view), view))
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, render.Stack(images, pageGap)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
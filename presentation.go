@@ -0,0 +1,290 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+)
+
+// pointerMode is what the pointer shows while presenting
+type pointerMode int
+
+const (
+	// pointerLaser leaves a red trail that fades out
+	pointerLaser pointerMode = iota
+	// pointerSpotlight dims the board except around the pointer
+	pointerSpotlight
+)
+
+const (
+	laserFade       = 800 * time.Millisecond // レーザーの軌跡が消えるまでの時間
+	laserWidth      = 6                      // レーザーの太さ（画面上のピクセル）
+	spotlightRadius = 120                    // スポットライトの半径（画面上のピクセル）
+	spotlightEdge   = 12                     // スポットライトの縁をぼかす幅（画面上のピクセル）
+	presentFPS      = 30                     // 軌跡を薄くしていく頻度
+)
+
+// Colours of the presentation pointers
+var (
+	laserColor     = color.NRGBA{R: 0xff, G: 0x1a, B: 0x1a, A: 0xff}
+	spotlightShade = color.NRGBA{A: 0xb4}
+)
+
+// laserPoint is a point of the laser trail on screen and when the pointer
+// was there
+type laserPoint struct {
+	pos fyne.Position
+	at  time.Time
+}
+
+// presentStep is what one step of a presentation shows: a saved view of a
+// page, or with view -1 the whole page
+type presentStep struct {
+	page int
+	view int
+}
+
+// Presenting reports whether the board is in presentation mode
+func (w *whiteboard) Presenting() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.presenting
+}
+
+// StartPresenting switches to presentation mode at the first step of the
+// page shown. The pointer shows a laser or a spotlight instead of drawing;
+// neither ever becomes part of the document or of exports.
+func (w *whiteboard) StartPresenting() {
+	w.mutex.Lock()
+	if w.presenting {
+		w.mutex.Unlock()
+		return
+	}
+	w.presenting = true
+	w.pointer = pointerLaser
+	w.stopPresenting = make(chan struct{})
+	step := 0
+	for i, s := range w.presentStepsLocked() {
+		if s.page == w.pageIndex {
+			step = i
+			break
+		}
+	}
+	w.showStepLocked(step)
+	stop := w.stopPresenting
+	w.mutex.Unlock()
+
+	go w.fadeLaser(stop)
+	w.pagesUpdated()
+}
+
+// StopPresenting leaves presentation mode where it is
+func (w *whiteboard) StopPresenting() {
+	w.mutex.Lock()
+	if !w.presenting {
+		w.mutex.Unlock()
+		return
+	}
+	w.presenting = false
+	close(w.stopPresenting)
+	w.laser, w.spotShown = nil, false
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// PresentNext goes to the next step of the presentation
+func (w *whiteboard) PresentNext() {
+	w.presentBy(1)
+}
+
+// PresentPrevious goes back to the previous step of the presentation
+func (w *whiteboard) PresentPrevious() {
+	w.presentBy(-1)
+}
+
+// presentBy moves delta steps through the presentation
+func (w *whiteboard) presentBy(delta int) {
+	w.mutex.Lock()
+	i := w.presentStep + delta
+	if !w.presenting || i < 0 || i >= len(w.presentStepsLocked()) {
+		w.mutex.Unlock()
+		return
+	}
+	w.showStepLocked(i)
+	w.mutex.Unlock()
+	w.pagesUpdated()
+}
+
+// PresentStep returns the step of the presentation shown and the number of
+// steps
+func (w *whiteboard) PresentStep() (step, steps int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.presentStep, len(w.presentStepsLocked())
+}
+
+// SetPointer chooses what the pointer shows while presenting
+func (w *whiteboard) SetPointer(mode pointerMode) {
+	w.mutex.Lock()
+	w.pointer = mode
+	w.laser = nil
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// presentStepsLocked returns the steps of the presentation: the saved
+// views of every page in order, or the whole page for a page without
+// saved views. The caller holds the mutex.
+func (w *whiteboard) presentStepsLocked() []presentStep {
+	var steps []presentStep
+	for i, p := range w.pages {
+		if len(p.views) == 0 {
+			steps = append(steps, presentStep{page: i, view: -1})
+		}
+		for j := range p.views {
+			steps = append(steps, presentStep{page: i, view: j})
+		}
+	}
+	return steps
+}
+
+// showStepLocked shows step i of the presentation. Anything in progress on
+// the page shown before is dropped. The caller holds the mutex.
+func (w *whiteboard) showStepLocked(i int) {
+	w.storePageLocked()
+	w.showPageLocked(w.presentStepsLocked()[i].page)
+	w.presentStep = i
+	w.laser = nil
+	w.fitStepLocked(w.size)
+}
+
+// fitStepLocked fits the step shown into size: a saved view, the page if
+// it has a size, or else its content. The caller holds the mutex.
+func (w *whiteboard) fitStepLocked(size fyne.Size) {
+	steps := w.presentStepsLocked()
+	if w.presentStep >= len(steps) {
+		return
+	}
+	s := steps[w.presentStep]
+	views := w.pages[s.page].views
+	switch {
+	case s.view >= 0 && s.view < len(views):
+		w.view.fit(views[s.view].Min, views[s.view].Max, size, 0)
+		w.followPage = false
+	case w.pageSize.Fixed():
+		w.fitPageLocked(size)
+	default:
+		if min, max, ok := w.doc.Snapshot().Bounds(); ok {
+			w.view.fit(min, max, size, 20)
+		}
+		w.followPage = false
+	}
+}
+
+// pointerMovedLocked moves the spotlight and extends the laser trail to
+// pos. The caller holds the mutex.
+func (w *whiteboard) pointerMovedLocked(pos fyne.Position) {
+	w.spot, w.spotShown = pos, true
+	if w.pointer == pointerLaser {
+		w.laser = append(w.laser, laserPoint{pos: pos, at: time.Now()})
+	}
+}
+
+// fadeLaser drops the points of the laser trail that have faded out and
+// redraws while the trail is shown, until stop is closed
+func (w *whiteboard) fadeLaser(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second / presentFPS)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			w.mutex.Lock()
+			shown := len(w.laser) > 0
+			i := 0
+			for i < len(w.laser) && now.Sub(w.laser[i].at) >= laserFade {
+				i++
+			}
+			w.laser = w.laser[i:]
+			w.mutex.Unlock()
+			if shown {
+				w.Refresh()
+			}
+		}
+	}
+}
+
+// updatePointer shows the laser trail or the spotlight while presenting
+func (r *whiteboardRenderer) updatePointer(state exportState) {
+	r.pointer = r.pointer[:0]
+	if !state.presenting {
+		return
+	}
+	if state.pointer == pointerSpotlight {
+		if state.spotShown {
+			spotlight := canvas.NewRaster(func(w, h int) image.Image {
+				return spotlightImage(w, h, state.spot, state.size)
+			})
+			spotlight.Resize(r.size)
+			r.pointer = append(r.pointer, spotlight)
+		}
+		return
+	}
+
+	// 古い部分ほど細く薄くする
+	now := time.Now()
+	fade := func(p laserPoint) float32 {
+		return max(0, 1-float32(now.Sub(p.at))/float32(laserFade))
+	}
+	for i := 1; i < len(state.laser); i++ {
+		from, to := state.laser[i-1], state.laser[i]
+		f := fade(to)
+		c := laserColor
+		c.A = uint8(float32(c.A) * f)
+		segment := canvas.NewLine(c)
+		segment.StrokeWidth = laserWidth * (0.3 + 0.7*f)
+		segment.Position1, segment.Position2 = from.pos, to.pos
+		r.pointer = append(r.pointer, segment)
+	}
+	if n := len(state.laser); n > 0 {
+		if f := fade(state.laser[n-1]); f > 0 {
+			c := laserColor
+			c.A = uint8(float32(c.A) * f)
+			dot := canvas.NewCircle(c)
+			dot.Move(state.laser[n-1].pos.SubtractXY(laserWidth, laserWidth))
+			dot.Resize(fyne.NewSize(2*laserWidth, 2*laserWidth))
+			r.pointer = append(r.pointer, dot)
+		}
+	}
+}
+
+// spotlightImage returns a width × height pixel shade over a board of size
+// with a soft-edged hole around center
+func spotlightImage(width, height int, center fyne.Position, size fyne.Size) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if size.Width <= 0 {
+		return img
+	}
+	scale := float64(width) / float64(size.Width)
+	cx, cy := float64(center.X)*scale, float64(center.Y)*scale
+	inner, edge := spotlightRadius*scale, spotlightEdge*scale
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			if d <= inner {
+				continue
+			}
+			c := spotlightShade
+			if d < inner+edge {
+				c.A = uint8(float64(c.A) * (d - inner) / edge)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
@@ -0,0 +1,245 @@
+// Package recovery keeps autosaved copies of the boards being edited, so
+// that a board can be restored after the app ended without saving it, such
+// as after a crash.
+//
+// Every run of the app is a session with its own directory under the
+// recovery directory. A session holds the last autosaved board, a thumbnail
+// of it and a heartbeat file the running app touches regularly. A session
+// closed on a clean exit is removed; one whose heartbeat has stopped, or
+// whose process is no longer running, was left behind by an unclean exit
+// and can be restored.
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"goWhiteBoard/config"
+	"goWhiteBoard/model"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// Heartbeat is how often a running session should be touched
+	Heartbeat = 10 * time.Second
+	// StaleAfter is how long after its last heartbeat a session is taken to
+	// have ended without closing
+	StaleAfter = 3 * Heartbeat
+)
+
+// ファイル名
+const (
+	boardFile     = "board" + model.BoardExt
+	thumbnailFile = "thumbnail.png"
+	aliveFile     = "alive"
+)
+
+// Dir returns the directory holding the recovery sessions
+func Dir() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "recovery"), nil
+}
+
+// Session is the recovery session of the running app
+type Session struct {
+	dir string
+}
+
+// Start begins a new session in root. The name of its directory ends with
+// the process ID, which tells whether the session is still running.
+func Start(root string) (*Session, error) {
+	name := fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405"), os.Getpid())
+	s := &Session{dir: filepath.Join(root, name)}
+	if err := s.Touch(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Dir returns the directory of the session
+func (s *Session) Dir() string {
+	return s.dir
+}
+
+// Touch records that the session is still running
+func (s *Session) Touch() error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(s.dir, aliveFile)
+	now := time.Now()
+	err := os.Chtimes(name, now, now)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.WriteFile(name, nil, 0o644)
+	}
+	return err
+}
+
+// Save replaces the autosaved board of the session. Each file is written
+// to a temporary file first and renamed, so a crash while saving leaves
+// the previous copy intact.
+func (s *Session) Save(pages []model.BoardPage, thumbnail image.Image) error {
+	if err := s.Touch(); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(s.dir, boardFile), func(w io.Writer) error {
+		return model.WritePages(w, pages)
+	}); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.dir, thumbnailFile), func(w io.Writer) error {
+		return png.Encode(w, thumbnail)
+	})
+}
+
+// Close ends the session on a clean exit and removes its files
+func (s *Session) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// writeFile writes a file atomically through a temporary file in the same
+// directory
+func writeFile(name string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	// 名前を変える前に中身をディスクに書き切る
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Entry is a session left behind by an unclean exit
+type Entry struct {
+	Dir   string
+	Saved time.Time // 最後に自動保存した時刻
+	Pages int
+	// Thumbnail shows the page that was shown; nil if it cannot be read
+	Thumbnail image.Image
+}
+
+// List returns the sessions in root that can be restored, newest first.
+// Sessions still running, with a recent heartbeat and their process alive,
+// are skipped, and sessions that ended before anything was saved are removed. A missing root has no sessions.
+func List(root string) ([]Entry, error) {
+	dirs, err := os.ReadDir(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, d.Name())
+		if running(dir) {
+			continue
+		}
+		board, err := os.Stat(filepath.Join(dir, boardFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			os.RemoveAll(dir)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 読めない自動保存は一覧に出さずに残しておく
+		pages, err := model.LoadPages(filepath.Join(dir, boardFile))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, Entry{Dir: dir, Saved: board.ModTime(), Pages: len(pages), Thumbnail: readThumbnail(dir)})
+	}
+	slices.SortFunc(entries, func(a, b Entry) int { return b.Saved.Compare(a.Saved) })
+	return entries, nil
+}
+
+// running reports whether the session in dir is still running. A crashed
+// session keeps a recent heartbeat for a while, so its process is checked
+// too; otherwise a restart soon after a crash would not offer its board.
+func running(dir string) bool {
+	alive, err := os.Stat(filepath.Join(dir, aliveFile))
+	if err != nil || time.Since(alive.ModTime()) >= StaleAfter {
+		return false
+	}
+	name := filepath.Base(dir)
+	pid, err := strconv.Atoi(name[strings.LastIndexByte(name, '-')+1:])
+	if err != nil {
+		// プロセスが分からなければ心拍だけで決める
+		return true
+	}
+	return processRunning(pid)
+}
+
+// processRunning reports whether a process with the ID pid is running
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	defer p.Release()
+	if runtime.GOOS == "windows" {
+		// Windows では終了したプロセスは FindProcess で見つからない
+		return true
+	}
+	// シグナル 0 は送らずにプロセスがあるかだけを確かめる。ほかのユーザーのプロセスなら EPERM になる
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// readThumbnail reads the thumbnail of the session in dir, or returns nil
+func readThumbnail(dir string) image.Image {
+	f, err := os.Open(filepath.Join(dir, thumbnailFile))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// Load reads the autosaved board of the session
+func (e Entry) Load() ([]model.BoardPage, error) {
+	return model.LoadPages(filepath.Join(e.Dir, boardFile))
+}
+
+// Discard removes the session
+func (e Entry) Discard() error {
+	return os.RemoveAll(e.Dir)
+}
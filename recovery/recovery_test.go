@@ -0,0 +1,107 @@
+package recovery
+
+import (
+	"fmt"
+	"goWhiteBoard/model"
+	"image"
+	"image/color"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	root := t.TempDir()
+	running, err := Start(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := &Session{dir: filepath.Join(root, "empty")}
+	if err := empty.Touch(); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := []model.BoardPage{
+		{Name: "Page 1", Lines: []model.Line{{Points: []model.Point{{X: 1, Y: 2}}, Color: color.Black, Width: 2}}},
+		{Name: "Page 2"},
+	}
+	thumbnail := image.NewRGBA(image.Rect(0, 0, 16, 12))
+	if err := running.Save(pages, thumbnail); err != nil {
+		t.Fatal(err)
+	}
+	if err := running.Save(pages, thumbnail); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := os.ReadDir(running.Dir())
+	if len(files) != 3 {
+		t.Errorf("session holds %d files, want board, thumbnail and heartbeat", len(files))
+	}
+
+	// 動いているセッションは復元の対象にしない
+	if entries, err := List(root); err != nil || len(entries) != 0 {
+		t.Fatalf("running sessions listed: %v, %v", entries, err)
+	}
+
+	// 心拍が止まったセッションは復元でき、何も保存していないものは消す
+	old := time.Now().Add(-StaleAfter)
+	for _, s := range []*Session{running, empty} {
+		if err := os.Chtimes(filepath.Join(s.Dir(), aliveFile), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := List(root)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("List = %v, %v", entries, err)
+	}
+	e := entries[0]
+	if e.Dir != running.Dir() || e.Pages != 2 || e.Thumbnail == nil || e.Thumbnail.Bounds().Dx() != 16 {
+		t.Errorf("entry = %+v", e)
+	}
+	if _, err := os.Stat(empty.Dir()); !os.IsNotExist(err) {
+		t.Errorf("empty session kept: %v", err)
+	}
+	read, err := e.Load()
+	if err != nil || len(read) != 2 || len(read[0].Lines) != 1 {
+		t.Errorf("Load = %v, %v", read, err)
+	}
+
+	if err := e.Discard(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := List(root); len(entries) != 0 {
+		t.Errorf("discarded session listed: %v", entries)
+	}
+
+	// 落ちてすぐ起動し直しても、プロセスが終わっていれば心拍が新しくても復元できる
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	crashed := &Session{dir: filepath.Join(root, fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405"), exited.Process.Pid))}
+	if err := crashed.Save(pages, thumbnail); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := List(root); len(entries) != 1 || entries[0].Dir != crashed.Dir() {
+		t.Errorf("session of an exited process: %v", entries)
+	}
+	if err := crashed.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 正常に終了したセッションは残さない
+	closed, err := Start(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Save(pages, thumbnail); err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(closed.Dir()); !os.IsNotExist(err) {
+		t.Errorf("closed session kept: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"goWhiteBoard/recovery"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// showRecoveryDialog lists the boards autosaved by sessions that did not
+// exit cleanly, to restore one into board or discard them. Sessions left
+// alone are offered again at the next start. restored is called after a
+// board was restored.
+func showRecoveryDialog(w fyne.Window, board *whiteboard, entries []recovery.Entry, restored func()) {
+	rows := container.NewVBox(widget.NewLabel("The whiteboard did not exit cleanly. These boards were autosaved:"))
+	var d dialog.Dialog
+	for _, e := range entries {
+		var thumbnail fyne.CanvasObject = widget.NewLabel("No preview")
+		if e.Thumbnail != nil {
+			img := canvas.NewImageFromImage(e.Thumbnail)
+			img.FillMode = canvas.ImageFillContain
+			img.SetMinSize(fyne.NewSize(thumbnailWidth, thumbnailHeight))
+			thumbnail = img
+		}
+		pages := "1 page"
+		if e.Pages != 1 {
+			pages = fmt.Sprintf("%d pages", e.Pages)
+		}
+		info := widget.NewLabel(fmt.Sprintf("Saved %s\n%s", e.Saved.Format("2006-01-02 15:04"), pages))
+
+		var row *fyne.Container
+		restore := widget.NewButton("Restore", func() {
+			read, err := e.Load()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			// 復元すると今のボードを置き換えるので、ほかのセッションは次の起動まで残す
+			board.RestorePages(read)
+			board.ZoomToFit()
+			restored()
+			if err := e.Discard(); err != nil {
+				dialog.ShowError(err, w)
+			}
+			d.Hide()
+		})
+		discard := widget.NewButton("Discard", func() {
+			dialog.ShowConfirm("Discard", "Delete this autosaved board?", func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := e.Discard(); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				rows.Remove(row)
+			}, w)
+		})
+		row = container.NewBorder(nil, nil, thumbnail, container.NewHBox(restore, discard), info)
+		rows.Add(row)
+	}
+	d = dialog.NewCustom("Recover Boards", "Later", container.NewVScroll(rows), w)
+	d.Resize(fyne.NewSize(520, 400))
+	d.Show()
+}
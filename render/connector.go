@@ -0,0 +1,115 @@
+package render
+
+import (
+	"goWhiteBoard/model"
+	"goWhiteBoard/stroke"
+	"math"
+)
+
+const (
+	// ConnectorLabelSize is the font size of connector labels
+	ConnectorLabelSize = 14
+	// arrowAngle is half the opening angle of an arrowhead in radians
+	arrowAngle = math.Pi / 7
+)
+
+// ConnectorParts returns what a connector is drawn as: its route, an
+// arrowhead at the end and its label next to the middle of the route.
+// Other lines are returned as they are.
+func ConnectorParts(l model.Line) []model.Line {
+	c := l.Connector
+	if c == nil || len(l.Points) < 2 {
+		return []model.Line{l}
+	}
+	path := l
+	path.Connector = nil
+	parts := []model.Line{path}
+
+	// 矢じりは終点での向きに合わせる
+	tip := l.Points[len(l.Points)-1]
+	from := l.Points[len(l.Points)-2]
+	if l.Curve && len(l.Points) > 2 {
+		segments := stroke.Curve(l.Points)
+		from = segments[len(segments)-1].C2
+	}
+	if dx, dy := float64(tip.X-from.X), float64(tip.Y-from.Y); dx != 0 || dy != 0 {
+		size := float64(max(10, l.Width*4))
+		angle := math.Atan2(dy, dx)
+		wing := func(a float64) model.Point {
+			return model.Point{X: tip.X - float32(size*math.Cos(angle+a)), Y: tip.Y - float32(size*math.Sin(angle+a))}
+		}
+		parts = append(parts, model.Line{Points: []model.Point{wing(arrowAngle), tip, wing(-arrowAngle)},
+			Color: l.Color, Width: l.Width, Layer: l.Layer})
+	}
+
+	if c.Label != "" {
+		parts = append(parts, connectorLabel(l))
+	}
+	return parts
+}
+
+// connectorLabel returns the label of a connector as a text object above
+// the middle of the route, or to its right where the route runs
+// vertically there
+func connectorLabel(l model.Line) model.Line {
+	points := l.Points
+	if l.Curve {
+		points = stroke.Sample(points, curveStep)
+	}
+	var length float32
+	for i := 1; i < len(points); i++ {
+		length += distance(points[i-1], points[i])
+	}
+	// 経路の長さの半分の位置を探す
+	half := length / 2
+	mid, a, b := points[0], points[0], points[1]
+	for i := 1; i < len(points); i++ {
+		d := distance(points[i-1], points[i])
+		if half <= d || i == len(points)-1 {
+			a, b = points[i-1], points[i]
+			t := float32(1)
+			if d > 0 {
+				t = min(half/d, 1)
+			}
+			mid = model.Point{X: a.X + (b.X-a.X)*t, Y: a.Y + (b.Y-a.Y)*t}
+			break
+		}
+		half -= d
+	}
+
+	text := TextLine(l.Connector.Label, model.Point{}, ConnectorLabelSize, Opaque(l.Color))
+	w, h := text.Points[1].X, text.Points[1].Y
+	gap := l.Width/2 + 4
+	var topLeft model.Point
+	if abs32(b.Y-a.Y) > abs32(b.X-a.X) {
+		topLeft = model.Point{X: mid.X + gap, Y: mid.Y - h/2}
+	} else {
+		topLeft = model.Point{X: mid.X - w/2, Y: mid.Y - gap - h}
+	}
+	text.Points = []model.Point{topLeft, {X: topLeft.X + w, Y: topLeft.Y + h}}
+	text.Layer = l.Layer
+	return text
+}
+
+// expandConnectors returns lines with every connector replaced by its
+// parts
+func expandConnectors(lines []model.Line) []model.Line {
+	n := 0
+	for _, l := range lines {
+		if l.Connector != nil {
+			n++
+		}
+	}
+	if n == 0 {
+		return lines
+	}
+	out := make([]model.Line, 0, len(lines)+2*n)
+	for _, l := range lines {
+		out = append(out, ConnectorParts(l)...)
+	}
+	return out
+}
+
+func distance(a, b model.Point) float32 {
+	return float32(math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y)))
+}
@@ -0,0 +1,146 @@
+package render
+
+import (
+	"goWhiteBoard/model"
+	"image"
+	"image/color"
+	"math"
+)
+
+// fillStroke draws a line as a filled outline: a disc at every point
+// joined by trapezoids, so the width may change from point to point. The
+// shape is collected in a mask first and then composited once, so a
+// translucent line does not darken where it overlaps itself.
+func fillStroke(img *image.RGBA, l model.Line) {
+	if len(l.Points) == 0 {
+		return
+	}
+	radii := make([]float32, len(l.Points))
+	var min, max model.Point
+	for i, p := range l.Points {
+		radii[i] = float32(math.Max(float64(l.WidthAt(i))/2, 0.5))
+		if i == 0 {
+			min, max = p, p
+		}
+		min = model.Point{X: float32(math.Min(float64(min.X), float64(p.X-radii[i]))), Y: float32(math.Min(float64(min.Y), float64(p.Y-radii[i])))}
+		max = model.Point{X: float32(math.Max(float64(max.X), float64(p.X+radii[i]))), Y: float32(math.Max(float64(max.Y), float64(p.Y+radii[i])))}
+	}
+	rect := image.Rect(int(math.Floor(float64(min.X))), int(math.Floor(float64(min.Y))),
+		int(math.Ceil(float64(max.X)))+1, int(math.Ceil(float64(max.Y)))+1).Intersect(img.Bounds())
+	if rect.Empty() {
+		return
+	}
+
+	mask := image.NewAlpha(rect)
+	for i, p := range l.Points {
+		fillDisc(mask, p, radii[i])
+		if i == 0 {
+			continue
+		}
+		// 2 点の円に接する台形で間を埋める
+		q := l.Points[i-1]
+		dx, dy := p.X-q.X, p.Y-q.Y
+		length := float32(math.Hypot(float64(dx), float64(dy)))
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length, dx/length
+		fillConvex(mask, []model.Point{
+			{X: q.X + nx*radii[i-1], Y: q.Y + ny*radii[i-1]},
+			{X: p.X + nx*radii[i], Y: p.Y + ny*radii[i]},
+			{X: p.X - nx*radii[i], Y: p.Y - ny*radii[i]},
+			{X: q.X - nx*radii[i-1], Y: q.Y - ny*radii[i-1]},
+		})
+	}
+	composite(img, mask, rgba(l.Color), l.Highlighter)
+}
+
+// composite paints c onto img wherever mask is set, either with normal
+// source-over or, for highlighters, with the multiply blend mode. img is
+// premultiplied; the blend follows the W3C compositing rules, so a
+// transparent backdrop shows the plain colour.
+func composite(img *image.RGBA, mask *image.Alpha, c color.NRGBA, multiply bool) {
+	if c.A == 0 {
+		return
+	}
+	rect := mask.Bounds()
+	src := [3]uint32{uint32(c.R), uint32(c.G), uint32(c.B)}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			m := uint32(mask.Pix[mask.PixOffset(x, y)])
+			if m == 0 {
+				continue
+			}
+			as := uint32(c.A) * m / 255
+			i := img.PixOffset(x, y)
+			dst := img.Pix[i : i+4 : i+4]
+			ab := uint32(dst[3])
+			for k := 0; k < 3; k++ {
+				cs := src[k]
+				if multiply && ab > 0 {
+					// cs' = (1-αb)·Cs + αb·Cb·Cs。dst は αb を掛けた値なので αb·Cb = dst
+					cs = (255-ab)*src[k]/255 + uint32(dst[k])*src[k]/255
+				}
+				dst[k] = uint8((as*cs + (255-as)*uint32(dst[k])) / 255)
+			}
+			dst[3] = uint8(as + ab*(255-as)/255)
+		}
+	}
+}
+
+// fillDisc sets the mask pixels whose centres lie inside the circle
+func fillDisc(mask *image.Alpha, c model.Point, r float32) {
+	b := mask.Bounds()
+	y0 := max(b.Min.Y, int(math.Floor(float64(c.Y-r))))
+	y1 := min(b.Max.Y, int(math.Ceil(float64(c.Y+r)))+1)
+	for y := y0; y < y1; y++ {
+		dy := float32(y) + 0.5 - c.Y
+		if dy*dy > r*r {
+			continue
+		}
+		half := float32(math.Sqrt(float64(r*r - dy*dy)))
+		fillSpan(mask, y, c.X-half, c.X+half)
+	}
+}
+
+// fillConvex sets the mask pixels whose centres lie inside a convex polygon
+func fillConvex(mask *image.Alpha, polygon []model.Point) {
+	top, bottom := polygon[0].Y, polygon[0].Y
+	for _, p := range polygon[1:] {
+		top = float32(math.Min(float64(top), float64(p.Y)))
+		bottom = float32(math.Max(float64(bottom), float64(p.Y)))
+	}
+	b := mask.Bounds()
+	y0 := max(b.Min.Y, int(math.Floor(float64(top))))
+	y1 := min(b.Max.Y, int(math.Ceil(float64(bottom)))+1)
+	for y := y0; y < y1; y++ {
+		sy := float32(y) + 0.5
+		left, right := float32(math.Inf(1)), float32(math.Inf(-1))
+		for i, a := range polygon {
+			c := polygon[(i+1)%len(polygon)]
+			if (a.Y <= sy) == (c.Y <= sy) {
+				continue
+			}
+			x := a.X + (sy-a.Y)*(c.X-a.X)/(c.Y-a.Y)
+			left = float32(math.Min(float64(left), float64(x)))
+			right = float32(math.Max(float64(right), float64(x)))
+		}
+		if left <= right {
+			fillSpan(mask, y, left, right)
+		}
+	}
+}
+
+// fillSpan sets the pixels of row y whose centres lie in [left, right]
+func fillSpan(mask *image.Alpha, y int, left, right float32) {
+	b := mask.Bounds()
+	x0 := max(b.Min.X, int(math.Ceil(float64(left-0.5))))
+	x1 := min(b.Max.X-1, int(math.Floor(float64(right-0.5))))
+	if x0 > x1 {
+		return
+	}
+	off := mask.PixOffset(x0, y)
+	for i := range mask.Pix[off : off+x1-x0+1] {
+		mask.Pix[off+i] = 255
+	}
+}
@@ -0,0 +1,86 @@
+package render
+
+import (
+	"goWhiteBoard/model"
+	"image/color"
+	"math"
+)
+
+// GridColor is the colour of the background pattern
+var GridColor = color.NRGBA{R: 0xd4, G: 0xd8, B: 0xde, A: 0xff}
+
+const (
+	minGridPixels = 6   // 格子の間隔がこれより狭くなったら間引く
+	gridLineWidth = 1   // 出力上のピクセル
+	gridDotSize   = 2.5 // 出力上のピクセル
+)
+
+// GridLines returns the pattern of g covering the view as lines in world
+// coordinates. They are drawn like highlighters, so they stay beneath every
+// stroke. When the grid would be denser than a few output pixels, only
+// every second (fourth, ...) line is drawn.
+func GridLines(g model.Grid, v View) []model.Line {
+	if !g.Visible() || v.Scale <= 0 {
+		return nil
+	}
+	step := g
+	for step.Spacing*v.Scale < minGridPixels {
+		step.Spacing *= 2
+	}
+	s, h := step.Spacing, step.RowHeight()
+	min := v.Origin
+	max := model.Point{X: min.X + float32(v.Width)/v.Scale, Y: min.Y + float32(v.Height)/v.Scale}
+	width := gridLineWidth / v.Scale
+	line := func(points ...model.Point) model.Line {
+		return model.Line{Points: points, Color: GridColor, Width: width, Highlighter: true}
+	}
+
+	var lines []model.Line
+	switch g.Style {
+	case model.GridDots:
+		for y := first(min.Y, h); y <= max.Y; y += h {
+			for x := first(min.X, s); x <= max.X; x += s {
+				p := model.Point{X: x, Y: y}
+				l := line(p, p)
+				l.Width = gridDotSize / v.Scale
+				lines = append(lines, l)
+			}
+		}
+	case model.GridSquares:
+		for x := first(min.X, s); x <= max.X; x += s {
+			lines = append(lines, line(model.Point{X: x, Y: min.Y}, model.Point{X: x, Y: max.Y}))
+		}
+		fallthrough
+	case model.GridRuled:
+		for y := first(min.Y, h); y <= max.Y; y += h {
+			lines = append(lines, line(model.Point{X: min.X, Y: y}, model.Point{X: max.X, Y: y}))
+		}
+	case model.GridIsometric:
+		for y := first(min.Y, h); y <= max.Y; y += h {
+			lines = append(lines, line(model.Point{X: min.X, Y: y}, model.Point{X: max.X, Y: y}))
+		}
+		// 60° の線は y = 0 の行の格子点を通る。x = c ± y * slope
+		slope := s / 2 / h
+		for c := first(min.X-max.Y*slope, s); c <= max.X-min.Y*slope; c += s {
+			lines = append(lines, line(model.Point{X: c + min.Y*slope, Y: min.Y}, model.Point{X: c + max.Y*slope, Y: max.Y}))
+		}
+		for c := first(min.X+min.Y*slope, s); c <= max.X+max.Y*slope; c += s {
+			lines = append(lines, line(model.Point{X: c - min.Y*slope, Y: min.Y}, model.Point{X: c - max.Y*slope, Y: max.Y}))
+		}
+	}
+	return lines
+}
+
+// first returns the first multiple of step at or after from
+func first(from, step float32) float32 {
+	return float32(math.Ceil(float64(from/step))) * step
+}
+
+// WithGrid returns lines with the pattern of g covering the view beneath
+// them, or lines unchanged when g is not exported
+func WithGrid(lines []model.Line, g model.Grid, v View) []model.Line {
+	if !g.Export {
+		return lines
+	}
+	return append(GridLines(g, v), lines...)
+}
@@ -0,0 +1,171 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"goWhiteBoard/model"
+	"image"
+	_ "image/gif"  // GIF を読めるようにする
+	_ "image/jpeg" // JPEG を読めるようにする
+	"math"
+	"sync"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	xdraw "golang.org/x/image/draw"
+)
+
+// maxSVGPixels limits the side of the bitmap an SVG is rasterized to; when
+// zoomed in further it is scaled up from that
+const maxSVGPixels = 2048
+
+// maxImagePixels limits the size of a bitmap image. Pictures come from
+// files, other participants and the API, and a small file can claim a huge
+// size that the decoder would allocate before reading any pixel.
+const maxImagePixels = 50_000_000
+
+// maxCachedImages is how many decoded images are kept in memory
+const maxCachedImages = 32
+
+// decoded is an image ready to be drawn. SVGs keep the last bitmap they
+// were rasterized to, since most redraws use the same size.
+type decoded struct {
+	bitmap image.Image
+	icon   *oksvg.SvgIcon
+	size   image.Point
+	err    error
+}
+
+var (
+	cacheMutex sync.Mutex
+	cache      = map[*model.Image]*decoded{}
+)
+
+// ImageSize returns the natural size of img in pixels
+func ImageSize(img *model.Image) (width, height int, err error) {
+	d := decode(img)
+	if d.err != nil {
+		return 0, 0, d.err
+	}
+	if d.icon != nil {
+		return int(math.Ceil(d.icon.ViewBox.W)), int(math.Ceil(d.icon.ViewBox.H)), nil
+	}
+	b := d.bitmap.Bounds()
+	return b.Dx(), b.Dy(), nil
+}
+
+// decode returns the cached decoded form of img
+func decode(img *model.Image) *decoded {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if d, ok := cache[img]; ok {
+		return d
+	}
+	if len(cache) >= maxCachedImages {
+		// どれを捨てても次に使うときに読み直すだけ
+		for k := range cache {
+			delete(cache, k)
+			break
+		}
+	}
+	d := &decoded{}
+	if img.Format == model.ImageSVG {
+		d.icon, d.err = oksvg.ReadIconStream(bytes.NewReader(img.Data))
+		if d.err == nil && (d.icon.ViewBox.W <= 0 || d.icon.ViewBox.H <= 0) {
+			d.err = errors.New("the SVG has no size")
+		}
+	} else {
+		d.bitmap, d.err = decodeBitmap(img.Data)
+	}
+	cache[img] = d
+	return d
+}
+
+// decodeBitmap decodes a PNG, JPEG or GIF after checking from its header
+// that it is not larger than maxImagePixels
+func decodeBitmap(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("the image has no size")
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, fmt.Errorf("the image is larger than %d megapixels", maxImagePixels/1_000_000)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// svgBitmap returns the icon rasterized to about the given size
+func (d *decoded) svgBitmap(width, height int) (image.Image, error) {
+	if f := float64(maxSVGPixels) / float64(max(width, height)); f < 1 {
+		width, height = int(float64(width)*f), int(float64(height)*f)
+	}
+	width, height = max(width, 1), max(height, 1)
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if d.bitmap != nil && d.size == (image.Point{X: width, Y: height}) {
+		return d.bitmap, nil
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	d.icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, out, out.Bounds())
+	if err := drawSVGSafely(d.icon, rasterx.NewDasher(width, height, scanner)); err != nil {
+		return nil, err
+	}
+	d.bitmap, d.size = out, image.Point{X: width, Y: height}
+	return out, nil
+}
+
+// drawSVGSafely draws the icon, turning a panic in the SVG renderer on a
+// malformed file into an error
+func drawSVGSafely(icon *oksvg.SvgIcon, raster *rasterx.Dasher) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("the SVG could not be drawn")
+		}
+	}()
+	icon.Draw(raster, 1)
+	return nil
+}
+
+// imageRect returns the output rectangle of an image line in output
+// coordinates
+func imageRect(l model.Line) image.Rectangle {
+	a, b := l.Points[0], l.Points[1]
+	return image.Rect(
+		int(math.Round(float64(min(a.X, b.X)))), int(math.Round(float64(min(a.Y, b.Y)))),
+		int(math.Round(float64(max(a.X, b.X)))), int(math.Round(float64(max(a.Y, b.Y)))))
+}
+
+// pixels returns the bitmap of img, rasterizing SVGs to about the given
+// size
+func pixels(img *model.Image, width, height int) (image.Image, error) {
+	d := decode(img)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.icon != nil {
+		return d.svgBitmap(width, height)
+	}
+	return d.bitmap, nil
+}
+
+// drawImage draws an image line in output coordinates. Images that cannot
+// be decoded are left out.
+func drawImage(dst *image.RGBA, l model.Line) {
+	r := imageRect(l)
+	if r.Empty() || !r.Overlaps(dst.Bounds()) {
+		return
+	}
+	src, err := pixels(l.Image, r.Dx(), r.Dy())
+	if err != nil {
+		return
+	}
+	// 見えている部分だけを拡大・縮小する
+	xdraw.ApproxBiLinear.Scale(dst, r, src, src.Bounds(), xdraw.Over, nil)
+}
@@ -0,0 +1,72 @@
+package render
+
+import (
+	"goWhiteBoard/model"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Group is lines that are composited together at an opacity, such as the
+// lines of a layer. An opaque group is drawn straight onto what is beneath
+// it; a translucent one is drawn on its own and then faded onto it, so its
+// own lines do not show through each other.
+type Group struct {
+	Lines   []model.Line
+	Opacity float32 // 0 より大きく 1 以下
+}
+
+// Flat returns lines as a single opaque group
+func Flat(lines []model.Line) []Group {
+	return []Group{{Lines: lines, Opacity: 1}}
+}
+
+// Layers splits lines into one group per visible layer, bottom layer
+// first. Lines of layers that do not exist go to the bottom layer. Without
+// layers all lines form one group.
+func Layers(lines []model.Line, layers []model.Layer) []Group {
+	if len(layers) == 0 {
+		return Flat(lines)
+	}
+	split := make([][]model.Line, len(layers))
+	for _, l := range lines {
+		i := model.LayerIndex(layers, l.Layer)
+		split[i] = append(split[i], l)
+	}
+	var groups []Group
+	for i, layer := range layers {
+		if !layer.Hidden {
+			groups = append(groups, Group{Lines: split[i], Opacity: layer.Alpha()})
+		}
+	}
+	return groups
+}
+
+// LayersWithGrid returns the groups of Layers with the grid beneath them
+// when it is exported
+func LayersWithGrid(lines []model.Line, layers []model.Layer, g model.Grid, v View) []Group {
+	groups := Layers(lines, layers)
+	if grid := WithGrid(nil, g, v); len(grid) > 0 {
+		groups = append(Flat(grid), groups...)
+	}
+	return groups
+}
+
+// translucent reports whether the group has to be composited on its own
+func (g Group) translucent() bool {
+	return g.Opacity > 0 && g.Opacity < 1
+}
+
+// RasterizeGroups draws groups onto img, bottom first, without clearing it
+func RasterizeGroups(img *image.RGBA, groups []Group, v View) {
+	for _, g := range groups {
+		if !g.translucent() {
+			Rasterize(img, g.Lines, v)
+			continue
+		}
+		layer := image.NewRGBA(img.Bounds())
+		Rasterize(layer, g.Lines, v)
+		mask := image.NewUniform(color.Alpha{A: uint8(g.Opacity*255 + 0.5)})
+		draw.DrawMask(img, img.Bounds(), layer, img.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+}
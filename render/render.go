@@ -0,0 +1,239 @@
+// Package render draws board lines into images and documents (PNG, SVG and
+// PDF). It has no GUI dependencies, so the desktop app and the command line
+// tools share the same drawing code.
+package render
+
+import (
+	"fmt"
+	"goWhiteBoard/model"
+	"goWhiteBoard/stroke"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// Output formats
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+	FormatPDF = "pdf"
+)
+
+// Formats lists every supported output format
+var Formats = []string{FormatPNG, FormatSVG, FormatPDF}
+
+// View maps world coordinates onto the output: out = (p - Origin) * Scale.
+// Width and height are the size of the output in pixels (points for PDF).
+type View struct {
+	Origin model.Point
+	Scale  float32
+	Width  int
+	Height int
+}
+
+// ContentView returns a view at the given scale that covers every line plus
+// margin (in output pixels) on each side
+func ContentView(lines []model.Line, margin int, scale float32) View {
+	min, max, ok := model.Snapshot{Lines: lines}.Bounds()
+	if !ok {
+		min, max = model.Point{}, model.Point{}
+	}
+	m := float32(margin) / scale
+	return View{
+		Origin: model.Point{X: min.X - m, Y: min.Y - m},
+		Scale:  scale,
+		Width:  int(math.Ceil(float64((max.X-min.X)*scale))) + 2*margin,
+		Height: int(math.Ceil(float64((max.Y-min.Y)*scale))) + 2*margin,
+	}
+}
+
+// Transform maps a world point to output coordinates
+func (v View) Transform(p model.Point) model.Point {
+	return model.Point{X: (p.X - v.Origin.X) * v.Scale, Y: (p.Y - v.Origin.Y) * v.Scale, P: p.P}
+}
+
+// FormatOf returns the format for a file name based on its extension
+func FormatOf(filename string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	for _, f := range Formats {
+		if ext == f {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q (use %s)", filepath.Ext(filename), strings.Join(Formats, ", "))
+}
+
+// Write renders lines in the given format
+func Write(w io.Writer, format string, lines []model.Line, v View) error {
+	return WriteGroups(w, format, Flat(lines), v)
+}
+
+// WriteGroups renders groups of lines, bottom first, in the given format
+func WriteGroups(w io.Writer, format string, groups []Group, v View) error {
+	switch format {
+	case FormatPNG:
+		return png.Encode(w, GroupsImage(groups, v))
+	case FormatSVG:
+		return writeSVG(w, groups, v)
+	case FormatPDF:
+		return WritePDFPages(w, []Page{{Groups: groups, View: v}})
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// Image rasterizes lines onto a white image of the view's size
+func Image(lines []model.Line, v View) *image.RGBA {
+	return GroupsImage(Flat(lines), v)
+}
+
+// GroupsImage rasterizes groups of lines onto a white image of the view's
+// size
+func GroupsImage(groups []Group, v View) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, v.Width, v.Height))
+
+	// Fill with white background
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	RasterizeGroups(img, groups, v)
+	return img
+}
+
+// Stack places images below each other on white, gap pixels apart, so
+// several pages can be sent as one image
+func Stack(images []*image.RGBA, gap int) *image.RGBA {
+	width, height := 0, 0
+	for i, img := range images {
+		width = max(width, img.Bounds().Dx())
+		height += img.Bounds().Dy()
+		if i > 0 {
+			height += gap
+		}
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+	y := 0
+	for _, img := range images {
+		r := img.Bounds()
+		draw.Draw(out, image.Rect(0, y, r.Dx(), y+r.Dy()), img, r.Min, draw.Src)
+		y += r.Dy() + gap
+	}
+	return out
+}
+
+// WritePNG encodes lines as a PNG image
+func WritePNG(w io.Writer, lines []model.Line, v View) error {
+	return png.Encode(w, Image(lines, v))
+}
+
+// Rasterize draws lines onto img without clearing it. Backgrounds and
+// highlighter lines are drawn first so they end up beneath the ink.
+func Rasterize(img *image.RGBA, lines []model.Line, v View) {
+	for _, l := range expandConnectors(PaintOrder(lines)) {
+		drawLine(img, transformLine(l, v))
+	}
+}
+
+// layer returns where a line is painted: backgrounds at the bottom, then
+// highlighters, then everything else
+func layer(l model.Line) int {
+	switch {
+	case l.Background:
+		return 0
+	case l.Highlighter:
+		return 1
+	}
+	return 2
+}
+
+// PaintOrder returns lines in the order they are painted: backgrounds
+// first, then highlighters, then everything else, each group in its
+// original order
+func PaintOrder(lines []model.Line) []model.Line {
+	sorted := true
+	for i := 1; i < len(lines); i++ {
+		if layer(lines[i]) < layer(lines[i-1]) {
+			sorted = false
+			break
+		}
+	}
+	if sorted {
+		return lines
+	}
+	ordered := make([]model.Line, 0, len(lines))
+	for n := 0; n < 3; n++ {
+		for _, l := range lines {
+			if layer(l) == n {
+				ordered = append(ordered, l)
+			}
+		}
+	}
+	return ordered
+}
+
+// transformLine returns a copy of l in output coordinates
+func transformLine(l model.Line, v View) model.Line {
+	points := make([]model.Point, len(l.Points))
+	for i, p := range l.Points {
+		points[i] = v.Transform(p)
+	}
+	l.Points, l.Width = points, l.Width*v.Scale
+	return l
+}
+
+// curveStep is the longest straight piece (in output pixels) used to draw
+// a curve
+const curveStep = 2
+
+// drawLine draws a line on the image
+func drawLine(img *image.RGBA, l model.Line) {
+	if len(l.Points) < 2 {
+		return
+	}
+	if l.Image != nil {
+		drawImage(img, l)
+		return
+	}
+	if l.Text != "" {
+		drawText(img, l)
+		return
+	}
+	if l.Curve {
+		l.Points = stroke.Sample(l.Points, curveStep)
+	}
+	fillStroke(img, l)
+}
+
+// Opacity returns the alpha of c in [0, 1]
+func Opacity(c color.Color) float64 {
+	return float64(rgba(c).A) / 255
+}
+
+// Opaque returns c without its transparency
+func Opaque(c color.Color) color.Color {
+	n := rgba(c)
+	n.A = 255
+	return n
+}
+
+// rgba returns the non-premultiplied components of c (nil is black)
+func rgba(c color.Color) color.NRGBA {
+	if c == nil {
+		c = color.Black
+	}
+	return color.NRGBAModel.Convert(c).(color.NRGBA)
+}
+
+// num formats a coordinate compactly for the vector formats
+func num(f float32) string {
+	s := fmt.Sprintf("%.2f", f)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
@@ -0,0 +1,420 @@
+package render
+
+import (
+	"bytes"
+	"goWhiteBoard/model"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testLines = []model.Line{
+	{Points: []model.Point{{X: 10, Y: 10}, {X: 50, Y: 30}}, Color: color.NRGBA{R: 255, A: 255}, Width: 4},
+	{Points: []model.Point{{X: 60, Y: 80}, {X: 20, Y: 70}, {X: 30, Y: 40}}, Color: color.Black, Width: 2},
+}
+
+func TestContentView(t *testing.T) {
+	v := ContentView(testLines, 10, 2)
+	// 線幅を含めた範囲 (8,8)-(61,81) を 2 倍して余白 10 を足す
+	if v.Width != 126 || v.Height != 166 {
+		t.Errorf("size = %dx%d", v.Width, v.Height)
+	}
+	if p := v.Transform(model.Point{X: 8, Y: 8}); p != (model.Point{X: 10, Y: 10}) {
+		t.Errorf("top-left maps to %v", p)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	img := Image(testLines, ContentView(testLines, 0, 1))
+	if c := img.RGBAAt(2, 2); c != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("line start = %v", c)
+	}
+	if c := img.RGBAAt(50, 2); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("background = %v", c)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSVG(&buf, testLines, ContentView(testLines, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	for _, want := range []string{
+		`width="53" height="73"`,
+		`<polyline points="2,2 42,22" fill="none" stroke="#ff0000" stroke-width="4"`,
+		`<polyline points="52,72 12,62 22,32"`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("missing %q in\n%s", want, svg)
+		}
+	}
+}
+
+func TestPDF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, testLines, ContentView(testLines, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("missing PDF header or trailer")
+	}
+	if !strings.Contains(pdf, "/MediaBox [0 0 53 73]") || !strings.Contains(pdf, "2 71 m\n42 51 l\nS") {
+		t.Errorf("unexpected content:\n%s", pdf)
+	}
+
+	// xref のオフセットが各オブジェクトの先頭を指していること
+	start, _ := strconv.Atoi(regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(pdf)[1])
+	if !strings.HasPrefix(pdf[start:], "xref\n") {
+		t.Fatalf("startxref %d does not point at the xref table", start)
+	}
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllStringSubmatch(pdf, -1)
+	for i, m := range offsets {
+		off, _ := strconv.Atoi(m[1])
+		if want := strconv.Itoa(i+1) + " 0 obj"; !strings.HasPrefix(pdf[off:], want) {
+			t.Errorf("xref entry %d points at %q", i+1, pdf[off:off+10])
+		}
+	}
+}
+
+func TestPDFPages(t *testing.T) {
+	var buf bytes.Buffer
+	pages := []Page{
+		{Lines: testLines, View: ContentView(testLines, 0, 1)},
+		{View: View{Scale: 1, Width: 100, Height: 50}},
+	}
+	if err := WritePDFPages(&buf, pages); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.String()
+	if !strings.Contains(pdf, "/Kids [3 0 R 5 0 R] /Count 2") ||
+		!strings.Contains(pdf, "/MediaBox [0 0 53 73]") || !strings.Contains(pdf, "/MediaBox [0 0 100 50]") ||
+		!strings.Contains(pdf, "/Contents 6 0 R") {
+		t.Errorf("unexpected PDF:\n%s", pdf)
+	}
+}
+
+func TestStack(t *testing.T) {
+	a := Image(nil, View{Scale: 1, Width: 30, Height: 10})
+	b := Image(testLines, View{Scale: 1, Width: 20, Height: 20})
+	out := Stack([]*image.RGBA{a, b}, 5)
+	if out.Bounds() != image.Rect(0, 0, 30, 35) {
+		t.Errorf("bounds = %v", out.Bounds())
+	}
+	if out.RGBAAt(25, 30) != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("padding is %v", out.RGBAAt(25, 30))
+	}
+}
+
+func TestCurves(t *testing.T) {
+	curved := []model.Line{{Points: []model.Point{{X: 0, Y: 0}, {X: 20, Y: 20}, {X: 40, Y: 0}}, Color: color.Black, Width: 2, Curve: true}}
+	v := View{Scale: 1, Width: 50, Height: 30}
+
+	var svg bytes.Buffer
+	WriteSVG(&svg, curved, v)
+	if !strings.Contains(svg.String(), `<path d="M0,0 C3.33,3.33 13.33,20 20,20 C26.67,20 36.67,3.33 40,0" fill="none"`) {
+		t.Errorf("unexpected SVG:\n%s", svg.String())
+	}
+	var pdf bytes.Buffer
+	WritePDF(&pdf, curved, v)
+	if !strings.Contains(pdf.String(), "0 30 m\n3.33 26.67 13.33 10 20 10 c\n") {
+		t.Errorf("unexpected PDF content:\n%s", pdf.String())
+	}
+
+	// 曲線は頂点を通るが、直線で結んだ場合の中点 (10,10) からは外れる
+	img := Image(curved, v)
+	if c := img.RGBAAt(20, 20); c.R != 0 {
+		t.Errorf("curve misses its middle point: %v", c)
+	}
+	if c := img.RGBAAt(10, 10); c.R == 0 {
+		t.Error("curve was drawn as straight segments")
+	}
+}
+
+func TestVariableWidth(t *testing.T) {
+	// 左端は細く (幅 1)、右端は太い (幅 7)
+	l := model.Line{Points: []model.Point{{X: 10, Y: 20, P: 0.001}, {X: 90, Y: 20, P: 1}}, Color: color.Black, Width: 4}
+	img := Image([]model.Line{l}, View{Scale: 1, Width: 100, Height: 40})
+	thickness := func(x int) int {
+		n := 0
+		for y := 0; y < 40; y++ {
+			if img.RGBAAt(x, y).R == 0 {
+				n++
+			}
+		}
+		return n
+	}
+	if left, right := thickness(12), thickness(88); left > 2 || right < 6 || right > 8 {
+		t.Errorf("thickness left = %d, right = %d", left, right)
+	}
+
+	var svg bytes.Buffer
+	WriteSVG(&svg, []model.Line{l}, View{Scale: 1, Width: 100, Height: 40})
+	if !strings.Contains(svg.String(), `<line x1="10" y1="20" x2="90" y2="20" stroke-width="4"/>`) {
+		t.Errorf("unexpected SVG:\n%s", svg.String())
+	}
+}
+
+func TestHighlighter(t *testing.T) {
+	yellow := color.NRGBA{R: 255, G: 230, A: 100}
+	lines := []model.Line{
+		{Points: []model.Point{{X: 50, Y: 0}, {X: 50, Y: 40}}, Color: color.Black, Width: 4},
+		// 同じ場所を往復する蛍光ペン（ストローク内の重なり）
+		{Points: []model.Point{{X: 0, Y: 20}, {X: 90, Y: 20}, {X: 30, Y: 22}}, Color: yellow, Width: 10, Highlighter: true},
+	}
+	v := View{Scale: 1, Width: 100, Height: 40}
+	img := Image(lines, v)
+	if c := img.RGBAAt(50, 20); c != (color.RGBA{A: 255}) {
+		t.Errorf("ink under the highlighter: %v", c)
+	}
+	once, twice := img.RGBAAt(10, 20), img.RGBAAt(70, 21)
+	if once != twice {
+		t.Errorf("overlap within one stroke darkened: %v vs %v", once, twice)
+	}
+	// 白の上では 255·(1-α) + C·α
+	if want := (color.RGBA{R: 255, G: 245, B: 155, A: 255}); once != want {
+		t.Errorf("highlighter over white = %v, want %v", once, want)
+	}
+
+	var svg bytes.Buffer
+	WriteSVG(&svg, lines, v)
+	if i, j := strings.Index(svg.String(), "mix-blend-mode:multiply"), strings.Index(svg.String(), `stroke="#000000"`); i < 0 || j < i {
+		t.Errorf("highlighter not multiplied beneath the ink:\n%s", svg.String())
+	}
+	var pdf bytes.Buffer
+	WritePDF(&pdf, lines, v)
+	if !strings.Contains(pdf.String(), "/GS0 << /CA 0.39 /BM /Multiply >>") || !strings.Contains(pdf.String(), "q /GS0 gs\n") {
+		t.Errorf("unexpected PDF:\n%s", pdf.String())
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	if f, err := FormatOf("out/Board.SVG"); err != nil || f != FormatSVG {
+		t.Errorf("FormatOf = %q, %v", f, err)
+	}
+	if _, err := FormatOf("board.gif"); err == nil {
+		t.Error("gif accepted")
+	}
+}
+
+func TestGridLines(t *testing.T) {
+	v := View{Scale: 1, Width: 100, Height: 60}
+	squares := GridLines(model.Grid{Style: model.GridSquares, Spacing: 20}, v)
+	// x = 0..100 の 6 本と y = 0..60 の 4 本
+	if len(squares) != 10 {
+		t.Errorf("squares: %d lines", len(squares))
+	}
+	if dots := GridLines(model.Grid{Style: model.GridDots, Spacing: 20}, v); len(dots) != 24 {
+		t.Errorf("dots: %d", len(dots))
+	}
+	// 縮小すると間引く
+	far := View{Scale: 0.1, Width: 100, Height: 60}
+	if n := len(GridLines(model.Grid{Style: model.GridRuled, Spacing: 20}, far)); n > 60/minGridPixels+1 {
+		t.Errorf("ruled at 10%%: %d lines", n)
+	}
+
+	// 格子は線の下に描かれ、書き出すときだけ加わる
+	ink := []model.Line{{Points: []model.Point{{X: 10, Y: 10}, {X: 90, Y: 10}}, Color: color.Black, Width: 4}}
+	g := model.Grid{Style: model.GridSquares, Spacing: 20}
+	if got := WithGrid(ink, g, v); len(got) != 1 {
+		t.Errorf("grid exported without Export: %d lines", len(got))
+	}
+	g.Export = true
+	img := Image(WithGrid(ink, g, v), v)
+	if c := img.RGBAAt(20, 10); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("ink over the grid: %v", c)
+	}
+	if c := img.RGBAAt(40, 30); c == (color.RGBA{255, 255, 255, 255}) {
+		t.Error("no grid line at x = 40")
+	}
+}
+
+func TestImages(t *testing.T) {
+	// 赤い 2x2 の PNG を 20x20 に引き伸ばし、その上にペンの線を描く
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	var data bytes.Buffer
+	png.Encode(&data, src)
+	img, err := model.NewImage(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h, err := ImageSize(img); w != 2 || h != 2 || err != nil {
+		t.Errorf("ImageSize = %d, %d, %v", w, h, err)
+	}
+	lines := []model.Line{
+		{Points: []model.Point{{X: 0, Y: 10}, {X: 40, Y: 10}}, Color: color.Black, Width: 4},
+		model.NewImageLine(img, model.Point{X: 0, Y: 0}, model.Point{X: 20, Y: 20}, true),
+	}
+	v := View{Scale: 1, Width: 40, Height: 20}
+	out := Image(lines, v)
+	if c := out.RGBAAt(5, 5); c != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("image pixel = %v", c)
+	}
+	if c := out.RGBAAt(5, 10); c != (color.RGBA{A: 255}) {
+		t.Errorf("ink over the background = %v", c)
+	}
+	if c := out.RGBAAt(30, 5); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("beside the image = %v", c)
+	}
+
+	svg, err := model.NewImage([]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="5"><rect width="10" height="5" fill="#0000ff"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h, err := ImageSize(svg); w != 10 || h != 5 || err != nil {
+		t.Errorf("SVG size = %d, %d, %v", w, h, err)
+	}
+	out = Image([]model.Line{model.NewImageLine(svg, model.Point{X: 20}, model.Point{X: 40, Y: 10}, false)}, v)
+	if c := out.RGBAAt(30, 5); c != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("SVG pixel = %v", c)
+	}
+
+	var doc bytes.Buffer
+	WriteSVG(&doc, lines, v)
+	if i, j := strings.Index(doc.String(), `<image x="0" y="0" width="20" height="20" preserveAspectRatio="none" href="data:image/png;base64,`), strings.Index(doc.String(), "<polyline"); i < 0 || j < i {
+		t.Errorf("background not embedded beneath the ink:\n%s", doc.String())
+	}
+	doc.Reset()
+	WritePDF(&doc, lines, v)
+	for _, want := range []string{"/XObject << /Im0 5 0 R >>", "q 20 0 0 20 0 0 cm /Im0 Do Q", "/SMask 6 0 R", "/ColorSpace /DeviceGray"} {
+		if !strings.Contains(doc.String(), want) {
+			t.Errorf("missing %q in the PDF", want)
+		}
+	}
+}
+
+func TestHugeImage(t *testing.T) {
+	// ヘッダーだけ巨大な GIF は画素を読む前に断る
+	var data bytes.Buffer
+	gif.Encode(&data, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil)
+	huge := data.Bytes()
+	copy(huge[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+	img, err := model.NewImage(huge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ImageSize(img); err == nil || !strings.Contains(err.Error(), "megapixels") {
+		t.Errorf("ImageSize of a 65535x65535 GIF: %v", err)
+	}
+	// 描くときは他の線だけを描く
+	out := Image([]model.Line{model.NewImageLine(img, model.Point{}, model.Point{X: 20, Y: 20}, false)}, View{Scale: 1, Width: 20, Height: 20})
+	if c := out.RGBAAt(5, 5); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("pixel of a refused image = %v", c)
+	}
+}
+
+func TestText(t *testing.T) {
+	l := TextLine("Hi\r\nthere", model.Point{X: 10, Y: 10}, 20, color.Black)
+	if l.Text != "Hi\nthere" || l.Points[1].Y != 10+2*TextLineHeight*20 || l.Points[1].X < 50 || l.Points[1].X > 80 {
+		t.Fatalf("text line = %+v", l)
+	}
+	v := View{Scale: 1, Width: 100, Height: 70}
+	img := Image([]model.Line{l}, v)
+	ink := 0
+	for y := 10; y < 60; y++ {
+		for x := 10; x < int(l.Points[1].X); x++ {
+			if img.RGBAAt(x, y).R < 128 {
+				ink++
+			}
+		}
+	}
+	if ink < 50 {
+		t.Errorf("only %d dark pixels in the text", ink)
+	}
+	if c := img.RGBAAt(90, 5); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("outside the text = %v", c)
+	}
+
+	var doc bytes.Buffer
+	WriteSVG(&doc, []model.Line{l}, v)
+	if !regexp.MustCompile(`<path d="M[^"]+Q[^"]+" fill="#000000"/>`).MatchString(doc.String()) {
+		t.Errorf("text not written as outlines:\n%s", doc.String())
+	}
+	doc.Reset()
+	WritePDF(&doc, []model.Line{l}, v)
+	if !strings.Contains(doc.String(), "0 0 0 rg\n") || !strings.Contains(doc.String(), " c\n") || !strings.Contains(doc.String(), "f\n") {
+		t.Errorf("text not filled as outlines in the PDF")
+	}
+}
+
+func TestLayers(t *testing.T) {
+	layers := []model.Layer{{Name: "Base", Opacity: 1}, {ID: "2", Name: "Notes", Opacity: 0.5}, {ID: "3", Name: "Hidden", Hidden: true}}
+	// 下のレイヤーの線が後から描かれていても、上のレイヤーが上に来る
+	lines := []model.Line{
+		{Points: []model.Point{{X: 0, Y: 10}, {X: 40, Y: 10}}, Color: color.NRGBA{B: 255, A: 255}, Width: 6, Layer: "2"},
+		{Points: []model.Point{{X: 30, Y: 10}, {X: 30, Y: 10}, {X: 38, Y: 10}}, Color: color.NRGBA{B: 255, A: 255}, Width: 6, Layer: "2"},
+		{Points: []model.Point{{X: 20, Y: 0}, {X: 20, Y: 20}}, Color: color.Black, Width: 6, Layer: "gone"},
+		{Points: []model.Point{{X: 5, Y: 0}, {X: 5, Y: 20}}, Color: color.NRGBA{R: 255, A: 255}, Width: 4, Layer: "3"},
+	}
+	groups := Layers(lines, layers)
+	if len(groups) != 2 || len(groups[0].Lines) != 1 || len(groups[1].Lines) != 2 || groups[1].Opacity != 0.5 {
+		t.Fatalf("groups = %+v", groups)
+	}
+
+	v := View{Scale: 1, Width: 40, Height: 20}
+	img := GroupsImage(groups, v)
+	// 半透明の青が黒の上に乗り、重なった部分も濃くならない
+	if c := img.RGBAAt(20, 10); c != (color.RGBA{B: 128, A: 255}) {
+		t.Errorf("translucent layer over the base = %v", c)
+	}
+	if a, b := img.RGBAAt(10, 10), img.RGBAAt(34, 10); a != b || a != (color.RGBA{R: 127, G: 127, B: 255, A: 255}) {
+		t.Errorf("overlap within the layer: %v vs %v", a, b)
+	}
+	if c := img.RGBAAt(5, 3); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("hidden layer drawn: %v", c)
+	}
+
+	var doc bytes.Buffer
+	WriteGroups(&doc, FormatSVG, groups, v)
+	if i, j := strings.Index(doc.String(), `stroke="#000000"`), strings.Index(doc.String(), `<g opacity="0.5">`); i < 0 || j < i || strings.Contains(doc.String(), "#ff0000") {
+		t.Errorf("unexpected SVG:\n%s", doc.String())
+	}
+	doc.Reset()
+	WriteGroups(&doc, FormatPDF, groups, v)
+	for _, want := range []string{"/GS0 << /CA 0.5 /ca 0.5 >>", "q /GS0 gs /Gp0 Do Q", "/Gp0 5 0 R", "/Group << /S /Transparency >> /Resources"} {
+		if !strings.Contains(doc.String(), want) {
+			t.Errorf("missing %q in the PDF:\n%s", want, doc.String())
+		}
+	}
+}
+
+func TestConnectors(t *testing.T) {
+	box := model.Line{Points: []model.Point{{X: 10, Y: 10}, {X: 40, Y: 10}, {X: 40, Y: 40}, {X: 10, Y: 40}, {X: 10, Y: 10}}, Color: color.Black, Width: 2, Node: "a"}
+	arrow := model.Line{
+		Points:    []model.Point{{X: 40, Y: 25}, {X: 150, Y: 25}},
+		Color:     color.Black,
+		Width:     2,
+		Connector: &model.Connector{Style: model.ConnectorStraight, From: model.End{Node: "a", Side: model.SideRight}, Label: "calls & <returns>"},
+	}
+	parts := ConnectorParts(arrow)
+	if len(parts) != 3 || parts[0].Connector != nil || len(parts[1].Points) != 3 || parts[1].Points[1] != (model.Point{X: 150, Y: 25}) || parts[2].Text == "" {
+		t.Fatalf("parts = %+v", parts)
+	}
+	// ラベルは経路の中ほどの上に置く
+	if l := parts[2]; l.Points[1].Y > 25 || l.Points[0].X > 95 || l.Points[1].X < 95 {
+		t.Errorf("label at %v", l.Points)
+	}
+
+	v := View{Scale: 1, Width: 160, Height: 50}
+	img := Image([]model.Line{box, arrow}, v)
+	// 矢じりの翼
+	if c := img.RGBAAt(143, 22); c.R > 128 {
+		t.Errorf("no arrowhead: %v", c)
+	}
+
+	var doc bytes.Buffer
+	WriteSVG(&doc, []model.Line{box, arrow}, v)
+	for _, want := range []string{`<g data-node="a">`, `<g class="connector" data-style="straight" data-from="a" data-from-side="right" data-label="calls &amp; &lt;returns&gt;">`} {
+		if !strings.Contains(doc.String(), want) {
+			t.Errorf("SVG without %s:\n%s", want, doc.String())
+		}
+	}
+}
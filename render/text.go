@@ -0,0 +1,225 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"goWhiteBoard/model"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// TextLineHeight is the distance between the lines of a text object in
+// multiples of the font size
+const TextLineHeight = 1.25
+
+// textPPEM is the size the glyph outlines are loaded at; they are scaled
+// linearly from there, so text looks the same at every zoom and in every
+// output format
+const textPPEM = 1024
+
+var (
+	textFont     = mustParseFont(goregular.TTF)
+	textMutex    sync.Mutex
+	textOutlines = map[sfnt.GlyphIndex]sfnt.Segments{}
+	textBaseline = sync.OnceValue(baseline)
+)
+
+func mustParseFont(data []byte) *sfnt.Font {
+	f, err := sfnt.Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// glyph is one glyph of laid out text. x is in multiples of the font size
+// from the left edge, row counts from the top.
+type glyph struct {
+	index sfnt.GlyphIndex
+	x     float32
+	row   int
+}
+
+// NormalizeText returns text with line ends and tabs the way text objects
+// keep them
+func NormalizeText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.ReplaceAll(text, "\t", "    ")
+}
+
+// TextLine returns a text object with its top left corner at p, in the
+// given font size and color
+func TextLine(text string, p model.Point, size float32, c color.Color) model.Line {
+	text = NormalizeText(text)
+	_, width, rows := layoutText(text)
+	return model.Line{
+		Points: []model.Point{p, {X: p.X + width*size, Y: p.Y + float32(rows)*TextLineHeight*size}},
+		Color:  c,
+		Text:   text,
+	}
+}
+
+// layoutText places the glyphs of text without hinting. It returns the
+// width of the widest line in multiples of the font size and the number of
+// lines.
+func layoutText(text string) (glyphs []glyph, width float32, rows int) {
+	for row, s := range strings.Split(text, "\n") {
+		var x fixed.Int26_6
+		prev := sfnt.GlyphIndex(0)
+		for i, r := range s {
+			index, err := textFont.GlyphIndex(nil, r)
+			if err != nil {
+				continue
+			}
+			if i > 0 {
+				if kern, err := textFont.Kern(nil, prev, index, textPPEM<<6, font.HintingNone); err == nil {
+					x += kern
+				}
+			}
+			glyphs = append(glyphs, glyph{index: index, x: float32(x) / (textPPEM << 6), row: row})
+			if advance, err := textFont.GlyphAdvance(nil, index, textPPEM<<6, font.HintingNone); err == nil {
+				x += advance
+			}
+			prev = index
+		}
+		width = max(width, float32(x)/(textPPEM<<6))
+		rows = row + 1
+	}
+	return glyphs, width, rows
+}
+
+// glyphOutline returns the cached outline of a glyph at textPPEM
+func glyphOutline(index sfnt.GlyphIndex) sfnt.Segments {
+	textMutex.Lock()
+	defer textMutex.Unlock()
+	if s, ok := textOutlines[index]; ok {
+		return s
+	}
+	s, err := textFont.LoadGlyph(nil, index, textPPEM<<6, nil)
+	if err != nil {
+		s = nil
+	}
+	// LoadGlyph は Buffer がないと新しいスライスを返すので、そのまま持っておける
+	textOutlines[index] = s
+	return s
+}
+
+// baseline returns the distance from the top of a row to its baseline in
+// multiples of the font size. The text is centred vertically in the row.
+func baseline() float32 {
+	m, _ := textFont.Metrics(nil, textPPEM<<6, font.HintingNone)
+	ascent := float32(m.Ascent) / (textPPEM << 6)
+	descent := float32(m.Descent) / (textPPEM << 6)
+	return (TextLineHeight-ascent-descent)/2 + ascent
+}
+
+// pathSink receives the outline of text. *vector.Rasterizer is one.
+type pathSink interface {
+	MoveTo(x, y float32)
+	LineTo(x, y float32)
+	QuadTo(x1, y1, x, y float32)
+	CubeTo(x1, y1, x2, y2, x, y float32)
+}
+
+// textOutline sends the glyph outlines of a text object in output
+// coordinates to sink, shifted by -offset. The font size follows from the
+// height of the object.
+func textOutline(sink pathSink, l model.Line, offset model.Point) {
+	glyphs, _, rows := layoutText(l.Text)
+	top := model.Point{X: min(l.Points[0].X, l.Points[1].X) - offset.X, Y: min(l.Points[0].Y, l.Points[1].Y) - offset.Y}
+	size := abs32(l.Points[1].Y-l.Points[0].Y) / (float32(rows) * TextLineHeight)
+	base := textBaseline()
+	scale := size / (textPPEM << 6)
+	for _, g := range glyphs {
+		ox := top.X + g.x*size
+		oy := top.Y + (float32(g.row)*TextLineHeight+base)*size
+		pt := func(p fixed.Point26_6) (float32, float32) {
+			return ox + float32(p.X)*scale, oy + float32(p.Y)*scale
+		}
+		for _, s := range glyphOutline(g.index) {
+			x0, y0 := pt(s.Args[0])
+			switch s.Op {
+			case sfnt.SegmentOpMoveTo:
+				sink.MoveTo(x0, y0)
+			case sfnt.SegmentOpLineTo:
+				sink.LineTo(x0, y0)
+			case sfnt.SegmentOpQuadTo:
+				x1, y1 := pt(s.Args[1])
+				sink.QuadTo(x0, y0, x1, y1)
+			case sfnt.SegmentOpCubeTo:
+				x1, y1 := pt(s.Args[1])
+				x2, y2 := pt(s.Args[2])
+				sink.CubeTo(x0, y0, x1, y1, x2, y2)
+			}
+		}
+	}
+}
+
+// drawText fills a text object in output coordinates into dst
+func drawText(dst *image.RGBA, l model.Line) {
+	r := imageRect(l).Inset(-1).Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+	// 見えている部分だけをラスタライズする
+	z := vector.NewRasterizer(r.Dx(), r.Dy())
+	textOutline(z, l, model.Point{X: float32(r.Min.X), Y: float32(r.Min.Y)})
+	z.Draw(dst, r, image.NewUniform(rgba(l.Color)), image.Point{})
+}
+
+// svgPath collects an outline as SVG path data
+type svgPath struct{ bytes.Buffer }
+
+func (p *svgPath) MoveTo(x, y float32) { fmt.Fprintf(p, "M%s ", svgPoint(model.Point{X: x, Y: y})) }
+func (p *svgPath) LineTo(x, y float32) { fmt.Fprintf(p, "L%s ", svgPoint(model.Point{X: x, Y: y})) }
+func (p *svgPath) QuadTo(x1, y1, x, y float32) {
+	fmt.Fprintf(p, "Q%s %s ", svgPoint(model.Point{X: x1, Y: y1}), svgPoint(model.Point{X: x, Y: y}))
+}
+func (p *svgPath) CubeTo(x1, y1, x2, y2, x, y float32) {
+	fmt.Fprintf(p, "C%s %s %s ", svgPoint(model.Point{X: x1, Y: y1}), svgPoint(model.Point{X: x2, Y: y2}), svgPoint(model.Point{X: x, Y: y}))
+}
+
+// pdfPath collects an outline as PDF path operators on a page of the given
+// height. PDF has no quadratic curves, so they become cubic ones.
+type pdfPath struct {
+	bytes.Buffer
+	height float32
+	x, y   float32 // 現在の点
+}
+
+func (p *pdfPath) point(x, y float32) string { return num(x) + " " + num(p.height-y) }
+
+func (p *pdfPath) MoveTo(x, y float32) {
+	fmt.Fprintf(p, "%s m\n", p.point(x, y))
+	p.x, p.y = x, y
+}
+
+func (p *pdfPath) LineTo(x, y float32) {
+	fmt.Fprintf(p, "%s l\n", p.point(x, y))
+	p.x, p.y = x, y
+}
+
+func (p *pdfPath) QuadTo(x1, y1, x, y float32) {
+	p.CubeTo(p.x+(x1-p.x)*2/3, p.y+(y1-p.y)*2/3, x+(x1-x)*2/3, y+(y1-y)*2/3, x, y)
+}
+
+func (p *pdfPath) CubeTo(x1, y1, x2, y2, x, y float32) {
+	fmt.Fprintf(p, "%s %s %s c\n", p.point(x1, y1), p.point(x2, y2), p.point(x, y))
+	p.x, p.y = x, y
+}
+
+func abs32(f float32) float32 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,413 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"goWhiteBoard/model"
+	"goWhiteBoard/stroke"
+	"html"
+	"image"
+	"io"
+	"strings"
+)
+
+// WriteSVG writes lines as an SVG document with one polyline per stroke, or
+// a path of cubic Béziers for curved strokes. Highlighters come first and
+// multiply with the background.
+func WriteSVG(w io.Writer, lines []model.Line, v View) error {
+	return writeSVG(w, Flat(lines), v)
+}
+
+// writeSVG writes groups of lines as an SVG document. Translucent groups
+// become <g> elements with an opacity.
+func writeSVG(w io.Writer, groups []Group, v View) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		v.Width, v.Height, v.Width, v.Height)
+	fmt.Fprintf(b, "<rect width=\"%d\" height=\"%d\" fill=\"#ffffff\"/>\n", v.Width, v.Height)
+
+	for _, g := range groups {
+		if g.translucent() {
+			fmt.Fprintf(b, "<g opacity=\"%s\">\n", num(g.Opacity))
+		}
+		writeSVGLines(b, g.Lines, v)
+		if g.translucent() {
+			b.WriteString("</g>\n")
+		}
+	}
+
+	fmt.Fprintln(b, "</svg>")
+	return b.Flush()
+}
+
+// writeSVGLines writes the elements of lines in paint order. Connectors
+// become groups that name the lines they connect, and lines connectors are
+// attached to are wrapped in groups with their node name, so the drawing
+// can be read back as a graph.
+func writeSVGLines(b *bufio.Writer, lines []model.Line, v View) {
+	for _, l := range PaintOrder(lines) {
+		if len(l.Points) < 2 {
+			continue
+		}
+		switch {
+		case l.Connector != nil:
+			c := l.Connector
+			fmt.Fprintf(b, "<g class=\"connector\" data-style=\"%s\"", c.Style)
+			for _, a := range []struct{ name, value string }{
+				{"data-from", c.From.Node}, {"data-from-side", string(c.From.Side)},
+				{"data-to", c.To.Node}, {"data-to-side", string(c.To.Side)},
+				{"data-label", c.Label},
+			} {
+				if a.value != "" {
+					fmt.Fprintf(b, " %s=\"%s\"", a.name, html.EscapeString(a.value))
+				}
+			}
+			b.WriteString(">\n")
+			for _, part := range ConnectorParts(l) {
+				writeSVGLine(b, part, v)
+			}
+			b.WriteString("</g>\n")
+		case l.Node != "":
+			fmt.Fprintf(b, "<g data-node=\"%s\">\n", html.EscapeString(l.Node))
+			writeSVGLine(b, l, v)
+			b.WriteString("</g>\n")
+		default:
+			writeSVGLine(b, l, v)
+		}
+	}
+}
+
+// writeSVGLine writes the element of one line
+func writeSVGLine(b *bufio.Writer, l model.Line, v View) {
+	c := rgba(l.Color)
+	t := transformLine(l, v)
+	if l.Image != nil {
+		// 読み込んだファイルをそのまま埋め込む
+		r := imageRect(t)
+		fmt.Fprintf(b, "<image x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" preserveAspectRatio=\"none\" href=\"data:%s;base64,%s\"/>\n",
+			r.Min.X, r.Min.Y, r.Dx(), r.Dy(), l.Image.MIMEType(), base64.StdEncoding.EncodeToString(l.Image.Data))
+		return
+	}
+	if l.Text != "" {
+		// 文字は輪郭で書き出すので、フォントがなくても同じに見える
+		var path svgPath
+		textOutline(&path, t, model.Point{})
+		fmt.Fprintf(b, "<path d=\"%s\" fill=\"#%02x%02x%02x\"", strings.TrimSpace(path.String()), c.R, c.G, c.B)
+		if c.A != 255 {
+			fmt.Fprintf(b, " fill-opacity=\"%s\"", num(float32(c.A)/255))
+		}
+		b.WriteString("/>\n")
+		return
+	}
+	blend := ""
+	if l.Highlighter {
+		blend = ` style="mix-blend-mode:multiply"`
+	}
+	if l.Variable() {
+		// 太さの変わる線は線分ごとに幅を変えて描き、グループ全体に不透明度をかける
+		fmt.Fprintf(b, "<g fill=\"none\" stroke=\"#%02x%02x%02x\" stroke-linecap=\"round\"%s", c.R, c.G, c.B, blend)
+		if c.A != 255 {
+			fmt.Fprintf(b, " opacity=\"%s\"", num(float32(c.A)/255))
+		}
+		b.WriteString(">\n")
+		for _, s := range variableSegments(t) {
+			fmt.Fprintf(b, "<line x1=\"%s\" y1=\"%s\" x2=\"%s\" y2=\"%s\" stroke-width=\"%s\"/>\n",
+				num(s.from.X), num(s.from.Y), num(s.to.X), num(s.to.Y), num(s.width))
+		}
+		b.WriteString("</g>\n")
+		return
+	}
+	if l.Curve && len(t.Points) > 2 {
+		d := []string{"M" + svgPoint(t.Points[0])}
+		for _, s := range stroke.Curve(t.Points) {
+			d = append(d, "C"+svgPoint(s.C1)+" "+svgPoint(s.C2)+" "+svgPoint(s.P3))
+		}
+		fmt.Fprintf(b, "<path d=\"%s\"", strings.Join(d, " "))
+	} else {
+		points := make([]string, len(t.Points))
+		for i, p := range t.Points {
+			points[i] = svgPoint(p)
+		}
+		fmt.Fprintf(b, "<polyline points=\"%s\"", strings.Join(points, " "))
+	}
+	fmt.Fprintf(b, " fill=\"none\" stroke=\"#%02x%02x%02x\"", c.R, c.G, c.B)
+	if c.A != 255 {
+		fmt.Fprintf(b, " stroke-opacity=\"%s\"", num(float32(c.A)/255))
+	}
+	fmt.Fprintf(b, " stroke-width=\"%s\" stroke-linecap=\"round\" stroke-linejoin=\"round\"%s/>\n", num(l.Width*v.Scale), blend)
+}
+
+// segment is a straight piece of a variable-width line
+type segment struct {
+	from, to model.Point
+	width    float32
+}
+
+// variableSegments splits a line in output coordinates into straight pieces,
+// each drawn at the mean width of its ends
+func variableSegments(l model.Line) []segment {
+	points := l.Points
+	if l.Curve {
+		points = stroke.Sample(points, 2*curveStep)
+	}
+	segments := make([]segment, 0, len(points))
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		width := (model.PressureWidth(l.Width, a.P) + model.PressureWidth(l.Width, b.P)) / 2
+		segments = append(segments, segment{from: a, to: b, width: width})
+	}
+	return segments
+}
+
+// writePDFPath writes the stroked path of l
+func writePDFPath(b *bytes.Buffer, l model.Line, v View) {
+	if l.Variable() {
+		for _, s := range variableSegments(transformLine(l, v)) {
+			fmt.Fprintf(b, "%s w %s %s m %s %s l S\n",
+				num(s.width), num(s.from.X), num(float32(v.Height)-s.from.Y), num(s.to.X), num(float32(v.Height)-s.to.Y))
+		}
+		return
+	}
+	// y 軸を反転した座標系に変換する
+	pdf := func(p model.Point) string {
+		q := v.Transform(p)
+		return num(q.X) + " " + num(float32(v.Height)-q.Y)
+	}
+	fmt.Fprintf(b, "%s m\n", pdf(l.Points[0]))
+	if l.Curve && len(l.Points) > 2 {
+		for _, s := range stroke.Curve(l.Points) {
+			fmt.Fprintf(b, "%s %s %s c\n", pdf(s.C1), pdf(s.C2), pdf(s.P3))
+		}
+	} else {
+		for _, p := range l.Points[1:] {
+			fmt.Fprintf(b, "%s l\n", pdf(p))
+		}
+	}
+	b.WriteString("S\n")
+}
+
+// svgPoint formats an output point as "x,y"
+func svgPoint(p model.Point) string {
+	return num(p.X) + "," + num(p.Y)
+}
+
+// Page is one page of a multi-page document
+type Page struct {
+	Lines  []model.Line
+	Groups []Group // あれば Lines の代わりに使う
+	View   View
+}
+
+// WritePDF writes lines as a single-page PDF. One output pixel is one point;
+// PDF's y axis points up, so the page is flipped. Translucent lines and
+// highlighters use graphics states for their opacity and blend mode.
+func WritePDF(w io.Writer, lines []model.Line, v View) error {
+	return WritePDFPages(w, []Page{{Lines: lines, View: v}})
+}
+
+// WritePDFPages writes one PDF page per page, each with the size of its view
+func WritePDFPages(w io.Writer, pages []Page) error {
+	// 1: カタログ、2: ページツリー、以降にページごとのオブジェクトが続く
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R >>", ""}
+	var kids []string
+	for _, p := range pages {
+		groups := p.Groups
+		if groups == nil {
+			groups = Flat(p.Lines)
+		}
+		pageObjects := pdfPage(groups, p.View, len(objects)+1)
+		kids = append(kids, fmt.Sprintf("%d 0 R", len(objects)+1))
+		objects = append(objects, pageObjects...)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfPage returns the objects of one page: the page itself, its content
+// stream, the transparency groups of variable-width lines, its images and
+// the transparency groups of translucent groups, numbered from first
+func pdfPage(groups []Group, v View, first int) []string {
+	p := &pdfPageBuilder{v: v, states: map[string]string{}, imageNames: map[*model.Image]int{}}
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "1 1 1 rg 0 0 %d %d re f\n1 J 1 j\n", v.Width, v.Height)
+	var layers []bytes.Buffer // 半透明のグループの内容
+	for _, g := range groups {
+		if !g.translucent() {
+			p.writeLines(&content, g.Lines)
+			continue
+		}
+		var layer bytes.Buffer
+		layer.WriteString("1 J 1 j\n")
+		p.writeLines(&layer, g.Lines)
+		layers = append(layers, layer)
+		name := p.state(fmt.Sprintf("<< /CA %s /ca %s >>", num(g.Opacity), num(g.Opacity)))
+		fmt.Fprintf(&content, "q /%s gs /Gp%d Do Q\n", name, len(layers)-1)
+	}
+
+	resources := ""
+	if len(p.stateNames) > 0 {
+		resources += " /ExtGState << " + strings.Join(p.stateNames, " ") + " >>"
+	}
+	imagesFrom := first + 2 + len(p.forms)
+	layersFrom := imagesFrom + 2*len(p.images)
+	if len(p.forms) > 0 || len(p.images) > 0 || len(layers) > 0 {
+		resources += " /XObject <<"
+		for i := range p.forms {
+			resources += fmt.Sprintf(" /Fm%d %d 0 R", i, first+2+i)
+		}
+		for i := range p.images {
+			resources += fmt.Sprintf(" /Im%d %d 0 R", i, imagesFrom+2*i)
+		}
+		for i := range layers {
+			resources += fmt.Sprintf(" /Gp%d %d 0 R", i, layersFrom+i)
+		}
+		resources += " >>"
+	}
+	objects := []string{
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources <<%s >> /Contents %d 0 R >>", v.Width, v.Height, resources, first+1),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+	objects = append(objects, p.forms...)
+	for i, img := range p.images {
+		// 画像の直後にそのアルファのマスクを置く
+		objects = append(objects, img.objects(imagesFrom+2*i+1)...)
+	}
+	for _, layer := range layers {
+		// グループの中の線もページと同じリソースを使う
+		objects = append(objects, fmt.Sprintf("<< /Type /XObject /Subtype /Form /BBox [0 0 %d %d] /Group << /S /Transparency >> /Resources <<%s >> /Length %d >>\nstream\n%sendstream",
+			v.Width, v.Height, resources, layer.Len(), layer.Bytes()))
+	}
+	return objects
+}
+
+// pdfPageBuilder collects the resources that the lines of a page use
+type pdfPageBuilder struct {
+	v          View
+	states     map[string]string // グラフィックス状態の定義 → 名前
+	stateNames []string
+	forms      []string      // 透明グループとして描くフォーム XObject
+	images     []pdfImageUse // 画像 XObject
+	imageNames map[*model.Image]int
+}
+
+// state returns the name of the graphics state with the given definition
+func (p *pdfPageBuilder) state(def string) string {
+	name, ok := p.states[def]
+	if !ok {
+		name = fmt.Sprintf("GS%d", len(p.states))
+		p.states[def] = name
+		p.stateNames = append(p.stateNames, "/"+name+" "+def)
+	}
+	return name
+}
+
+// writeLines writes the drawing operators of lines in paint order to
+// content
+func (p *pdfPageBuilder) writeLines(content *bytes.Buffer, lines []model.Line) {
+	v := p.v
+	for _, l := range expandConnectors(PaintOrder(lines)) {
+		if len(l.Points) < 2 {
+			continue
+		}
+		if l.Image != nil {
+			r := imageRect(transformLine(l, v))
+			n, ok := p.imageNames[l.Image]
+			if !ok {
+				src, err := pixels(l.Image, r.Dx(), r.Dy())
+				if err != nil {
+					continue
+				}
+				n = len(p.images)
+				p.imageNames[l.Image] = n
+				p.images = append(p.images, pdfImageUse{src})
+			}
+			fmt.Fprintf(content, "q %d 0 0 %d %d %d cm /Im%d Do Q\n", r.Dx(), r.Dy(), r.Min.X, v.Height-r.Max.Y, n)
+			continue
+		}
+		c := rgba(l.Color)
+		var path bytes.Buffer
+		if l.Text != "" {
+			text := pdfPath{height: float32(v.Height)}
+			textOutline(&text, transformLine(l, v), model.Point{})
+			fmt.Fprintf(&path, "%s %s %s rg\n%sf\n",
+				num(float32(c.R)/255), num(float32(c.G)/255), num(float32(c.B)/255), text.Bytes())
+		} else {
+			fmt.Fprintf(&path, "%s %s %s RG %s w\n",
+				num(float32(c.R)/255), num(float32(c.G)/255), num(float32(c.B)/255), num(l.Width*v.Scale))
+			writePDFPath(&path, l, v)
+		}
+
+		if c.A == 255 && !l.Highlighter {
+			content.Write(path.Bytes())
+			continue
+		}
+		state := fmt.Sprintf("<< /CA %s", num(float32(c.A)/255))
+		if l.Text != "" {
+			// 文字は塗りつぶすので塗りの不透明度を使う
+			state = fmt.Sprintf("<< /ca %s", num(float32(c.A)/255))
+		}
+		if l.Highlighter {
+			state += " /BM /Multiply"
+		}
+		name := p.state(state + " >>")
+		if !l.Variable() {
+			fmt.Fprintf(content, "q /%s gs\n%sQ\n", name, path.Bytes())
+			continue
+		}
+		// 線分が重なる部分が濃くならないように、まとめて不透明度をかける
+		p.forms = append(p.forms, fmt.Sprintf("<< /Type /XObject /Subtype /Form /BBox [0 0 %d %d] /Group << /S /Transparency >> /Length %d >>\nstream\n1 J 1 j\n%sendstream",
+			v.Width, v.Height, path.Len()+len("1 J 1 j\n"), path.Bytes()))
+		fmt.Fprintf(content, "q /%s gs /Fm%d Do Q\n", name, len(p.forms)-1)
+	}
+}
+
+// pdfImageUse is an image drawn on a PDF page
+type pdfImageUse struct {
+	src image.Image
+}
+
+// objects returns the image XObject and its soft mask, which gets the
+// object number mask
+func (u pdfImageUse) objects(mask int) []string {
+	b := u.src.Bounds()
+	rgb := make([]byte, 0, b.Dx()*b.Dy()*3)
+	alpha := make([]byte, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := rgba(u.src.At(x, y))
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+		}
+	}
+	stream := func(dict string, data []byte) string {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(data)
+		w.Close()
+		return fmt.Sprintf("<< /Type /XObject /Subtype /Image %s /Width %d /Height %d /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+			dict, b.Dx(), b.Dy(), z.Len(), z.Bytes())
+	}
+	return []string{
+		stream(fmt.Sprintf("/ColorSpace /DeviceRGB /SMask %d 0 R", mask), rgb),
+		stream("/ColorSpace /DeviceGray", alpha),
+	}
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"goWhiteBoard/snap"
+	"goWhiteBoard/stroke"
+	"image"
+	"image/color"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+)
+
+// strokeCacheKey identifies what the backing image was rendered for. When any
+// field changes the finished strokes have to be rasterized again.
+type strokeCacheKey struct {
+	doc        *model.Document // ページを切り替えると変わる
+	view       viewport
+	width      int
+	height     int
+	generation uint64
+	grid       model.Grid
+	layers     uint64 // レイヤーの版
+	hidden     uint64 // 移動中の線を隠している間は移動の番号
+}
+
+// whiteboardRenderer implements the fyne.WidgetRenderer interface.
+//
+// Finished strokes are rasterized once into a backing image; only the stroke
+// being drawn is kept as canvas.Line objects, and only its new segments are
+// created on each mouse move. The cost of a frame while drawing is therefore
+// independent of how many strokes the board already holds.
+type whiteboardRenderer struct {
+	whiteboard *whiteboard
+
+	// Refresh はドキュメントの変更通知から別のゴルーチンで呼ばれることがある
+	mutex   sync.Mutex
+	objects []fyne.CanvasObject // 描画するオブジェクトをキャッシュ
+
+	size       fyne.Size
+	background *canvas.Image // 確定済みの線をラスタライズした画像
+	backing    *image.RGBA
+	cacheKey   strokeCacheKey
+	drawn      int // backing に描画済みの線の数
+
+	live       []fyne.CanvasObject // 描画中の線のセグメント
+	livePoints int                 // live に変換済みの点の数
+	liveTail   []fyne.CanvasObject // 曲線の最後の区間（次の点で形が変わる）
+	liveStroke uint64
+	liveView   viewport
+	liveFill   *canvas.Image // 太さの変わる線の確定した部分
+	fill       *image.RGBA
+	fillDirty  bool
+
+	cursors []fyne.CanvasObject // 他の参加者のカーソルと名前
+	page    *canvas.Rectangle   // ページの境界
+
+	moved     *canvas.Image       // 移動中の線
+	selected  *canvas.Rectangle   // 選択範囲の枠
+	handle    *canvas.Rectangle   // 拡大・縮小のハンドル
+	band      *canvas.Rectangle   // 範囲選択の矩形
+	guides    []fyne.CanvasObject // 揃っている位置を示すガイド
+	connector []fyne.CanvasObject // 引いているコネクター
+	selection []fyne.CanvasObject // 選択ツールの表示（moved, selected, handle, band, guides, connector のうち表示するもの）
+	pointer   []fyne.CanvasObject // 発表中のレーザーポインターまたはスポットライト
+}
+
+func newWhiteboardRenderer(w *whiteboard) *whiteboardRenderer {
+	background := &canvas.Image{FillMode: canvas.ImageFillStretch, ScaleMode: canvas.ImageScaleFastest}
+	liveFill := &canvas.Image{FillMode: canvas.ImageFillStretch, ScaleMode: canvas.ImageScaleFastest}
+	page := canvas.NewRectangle(color.Transparent)
+	page.StrokeColor = pageBorderColor
+	page.StrokeWidth = 1
+	moved := &canvas.Image{FillMode: canvas.ImageFillStretch, ScaleMode: canvas.ImageScaleFastest}
+	selected := canvas.NewRectangle(color.Transparent)
+	selected.StrokeColor = selectionColor
+	selected.StrokeWidth = 1
+	handle := canvas.NewRectangle(color.White)
+	handle.StrokeColor = selectionColor
+	handle.StrokeWidth = 1
+	band := canvas.NewRectangle(bandColor)
+	band.StrokeColor = selectionColor
+	band.StrokeWidth = 1
+	return &whiteboardRenderer{whiteboard: w, background: background, liveFill: liveFill, page: page,
+		moved: moved, selected: selected, handle: handle, band: band}
+}
+
+// MinSize implements fyne.WidgetRenderer
+func (r *whiteboardRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(300, 200)
+}
+
+// Layout implements fyne.WidgetRenderer
+func (r *whiteboardRenderer) Layout(size fyne.Size) {
+	// 固定ページを表示中ならビューを合わせ直す
+	if r.whiteboard.resized(size) && r.whiteboard.OnViewportChanged != nil {
+		r.whiteboard.OnViewportChanged()
+	}
+	r.mutex.Lock()
+	if size == r.size {
+		r.mutex.Unlock()
+		return
+	}
+	r.size = size
+	r.background.Move(fyne.NewPos(0, 0))
+	r.background.Resize(size)
+	r.liveFill.Resize(size)
+	r.mutex.Unlock()
+	r.updateObjects()
+}
+
+// Refresh implements fyne.WidgetRenderer
+func (r *whiteboardRenderer) Refresh() {
+	if r.updateObjects() {
+		canvas.Refresh(r.background)
+	}
+	r.mutex.Lock()
+	fillDirty := r.fillDirty
+	r.fillDirty = false
+	r.mutex.Unlock()
+	if fillDirty {
+		canvas.Refresh(r.liveFill)
+	}
+	canvas.Refresh(r.whiteboard)
+}
+
+// Objects implements fyne.WidgetRenderer
+func (r *whiteboardRenderer) Objects() []fyne.CanvasObject {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.objects
+}
+
+// Destroy implements fyne.WidgetRenderer
+func (r *whiteboardRenderer) Destroy() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.backing = nil
+	r.background.Image = nil
+	r.fill = nil
+	r.liveFill.Image = nil
+	r.moved.Image = nil
+}
+
+// updateObjects は確定済みの線をキャッシュ画像に、描画中の線を canvas.Line に反映する。
+// 戻り値はキャッシュ画像を更新したかどうか
+func (r *whiteboardRenderer) updateObjects() bool {
+	state := r.whiteboard.exportState()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	changed := r.updateBacking(state)
+	r.updateLive(state)
+	r.updateSelection(state)
+	r.updateCursors(state)
+	r.updatePointer(state)
+
+	r.objects = make([]fyne.CanvasObject, 0, len(r.live)+len(r.liveTail)+len(r.selection)+len(r.cursors)+3)
+	r.objects = append(r.objects, r.background)
+	if state.pageSize.Fixed() {
+		r.updatePage(state)
+		r.objects = append(r.objects, r.page)
+	}
+	if r.liveFill.Image != nil {
+		r.objects = append(r.objects, r.liveFill)
+	}
+	r.objects = append(r.objects, r.live...)
+	r.objects = append(r.objects, r.liveTail...)
+	r.objects = append(r.objects, r.selection...)
+	r.objects = append(r.objects, r.cursors...)
+	r.objects = append(r.objects, r.pointer...)
+	return changed
+}
+
+// updateBacking rasterizes finished strokes that are not in the backing image
+// yet, above the grid. Appended strokes are drawn incrementally; anything
+// else (zoom, pan, resize, clear, another page, another grid, changed
+// layers, lines picked up or put down by the select tool, a new highlighter
+// or background that belongs beneath the ink, or a line on a layer below
+// the top or a translucent one) redraws the image from scratch.
+func (r *whiteboardRenderer) updateBacking(state exportState) bool {
+	snapshot, view := state.snapshot, state.view
+	pixelScale := r.pixelScale()
+	key := strokeCacheKey{
+		doc:        state.doc,
+		view:       view,
+		width:      int(r.size.Width * pixelScale),
+		height:     int(r.size.Height * pixelScale),
+		generation: snapshot.Generation,
+		grid:       state.grid,
+		layers:     state.layersVersion,
+	}
+	var hidden map[model.ID]bool
+	if state.moving {
+		// 移動中の線は元の位置に描かない
+		key.hidden = state.moveID
+		hidden = idSet(state.selection)
+	}
+	if key.width <= 0 || key.height <= 0 {
+		return false
+	}
+
+	if r.backing == nil || key != r.cacheKey || r.drawn > len(snapshot.Lines) ||
+		(r.drawn > 0 && (paintedBeneath(snapshot.Lines[r.drawn:]) || !onTopLayer(snapshot.Lines[r.drawn:], state.layers))) {
+		r.backing = image.NewRGBA(image.Rect(0, 0, key.width, key.height))
+		r.background.Image = r.backing
+		r.cacheKey = key
+		r.drawn = 0
+		grid := render.View{Origin: view.origin, Scale: view.scale * pixelScale, Width: key.width, Height: key.height}
+		render.Rasterize(r.backing, render.GridLines(state.grid, grid), grid)
+	} else if r.drawn == len(snapshot.Lines) {
+		return false
+	}
+
+	lines := snapshot.Lines[r.drawn:]
+	if hidden != nil {
+		lines = without(lines, hidden)
+	}
+	render.RasterizeGroups(r.backing, render.Layers(lines, state.layers), render.View{Origin: view.origin, Scale: view.scale * pixelScale})
+	r.drawn = len(snapshot.Lines)
+	return true
+}
+
+// idSet returns ids as a set
+func idSet(ids []model.ID) map[model.ID]bool {
+	set := make(map[model.ID]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// without returns the lines whose IDs are not in ids
+func without(lines []model.Line, ids map[model.ID]bool) []model.Line {
+	out := make([]model.Line, 0, len(lines))
+	for _, l := range lines {
+		if !ids[l.ID] {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// updateLive creates canvas.Line objects for the points of the stroke in
+// progress that have arrived since the last frame. A curved stroke's last
+// span still changes with the next point, so it is rebuilt every frame.
+// Variable-width strokes are filled into an overlay image instead, except
+// for that last span.
+func (r *whiteboardRenderer) updateLive(state exportState) {
+	current := state.current
+	if !state.drawing || len(current.Points) < 2 {
+		r.live = nil
+		r.liveTail = nil
+		r.livePoints = 0
+		r.liveFill.Image = nil
+		return
+	}
+	if state.view != r.liveView || state.strokeID != r.liveStroke {
+		r.live = nil
+		r.livePoints = 0
+		r.liveFill.Image = nil
+		r.liveView = state.view
+		r.liveStroke = state.strokeID
+	}
+
+	start := r.livePoints
+	if start < 1 {
+		start = 1
+	}
+	if !current.Curve {
+		for i := start; i < len(current.Points); i++ {
+			r.addLive(state, current.Points[i-1:i+1])
+		}
+		r.livePoints = len(current.Points)
+		r.liveTail = nil
+		return
+	}
+
+	// 区間 i は点 i+2 が届いた時点で確定する
+	final := len(current.Points) - 1
+	for i := start; i < final; i++ {
+		r.addLive(state, r.curvePiece(state, current.Points, i-1))
+	}
+	if final > start {
+		r.livePoints = final
+	}
+	r.liveTail = nil
+	if !translucent(current) {
+		r.liveTail = r.appendSegments(nil, state, r.curvePiece(state, current.Points, len(current.Points)-2))
+	}
+}
+
+// curvePiece returns span i of a curved stroke as a polyline
+func (r *whiteboardRenderer) curvePiece(state exportState, points []model.Point, i int) []model.Point {
+	b := stroke.Segment(points, i)
+	// 画面上で数ピクセルごとに折れ線で近似する
+	return b.Flatten([]model.Point{b.P0}, 4/state.view.scale)
+}
+
+// addLive adds a finished piece of the stroke in progress. Translucent
+// strokes are filled opaque into the overlay, which is shown with the
+// stroke's opacity, so overlapping pieces do not darken each other.
+func (r *whiteboardRenderer) addLive(state exportState, piece []model.Point) {
+	current := state.current
+	if (!current.Variable() && !translucent(current)) || r.backing == nil {
+		r.live = r.appendSegments(r.live, state, piece)
+		return
+	}
+
+	if r.liveFill.Image == nil {
+		if r.fill == nil || r.fill.Bounds() != r.backing.Bounds() {
+			r.fill = image.NewRGBA(r.backing.Bounds())
+		} else {
+			clear(r.fill.Pix)
+		}
+		r.liveFill.Image = r.fill
+		r.liveFill.Translucency = 1 - render.Opacity(current.Color)
+	}
+	l := model.Line{Points: piece, Color: render.Opaque(current.Color), Width: current.Width}
+	render.Rasterize(r.fill, []model.Line{l}, render.View{Origin: state.view.origin, Scale: state.view.scale * r.pixelScale()})
+	r.fillDirty = true
+}
+
+// translucent reports whether a line has to be composited as a whole
+func translucent(l model.Line) bool {
+	return l.Highlighter || render.Opacity(l.Color) < 1
+}
+
+// paintedBeneath reports whether any of lines is a highlighter or a
+// background, which go beneath the lines already drawn
+func paintedBeneath(lines []model.Line) bool {
+	for _, l := range lines {
+		if l.Highlighter || l.Background {
+			return true
+		}
+	}
+	return false
+}
+
+// onTopLayer reports whether every line is on the topmost visible layer,
+// which is opaque, or on a hidden one, so it can be drawn over what is
+// there already
+func onTopLayer(lines []model.Line, layers []model.Layer) bool {
+	top := -1
+	for i, l := range layers {
+		if !l.Hidden {
+			top = i
+		}
+	}
+	for _, l := range lines {
+		i := model.LayerIndex(layers, l.Layer)
+		if layers[i].Hidden {
+			continue
+		}
+		if i != top || layers[i].Alpha() < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// appendSegments appends a canvas.Line for every segment of piece
+func (r *whiteboardRenderer) appendSegments(objects []fyne.CanvasObject, state exportState, piece []model.Point) []fyne.CanvasObject {
+	for i := 1; i < len(piece); i++ {
+		from, to := piece[i-1], piece[i]
+		segment := canvas.NewLine(state.current.Color)
+		width := (model.PressureWidth(state.current.Width, from.P) + model.PressureWidth(state.current.Width, to.P)) / 2
+		segment.StrokeWidth = width * state.view.scale
+		segment.Position1 = state.view.toScreen(from)
+		segment.Position2 = state.view.toScreen(to)
+		objects = append(objects, segment)
+	}
+	return objects
+}
+
+// pageBorderColor is the colour of the page boundary
+var pageBorderColor = color.NRGBA{R: 0x90, G: 0x90, B: 0x90, A: 0xff}
+
+// updatePage places the page boundary
+func (r *whiteboardRenderer) updatePage(state exportState) {
+	topLeft := state.view.toScreen(model.Point{})
+	bottomRight := state.view.toScreen(model.Point{X: state.pageSize.Width, Y: state.pageSize.Height})
+	r.page.Move(topLeft)
+	r.page.Resize(fyne.NewSize(bottomRight.X-topLeft.X, bottomRight.Y-topLeft.Y))
+	r.page.Refresh()
+}
+
+// Colours of the select tool
+var (
+	selectionColor = color.NRGBA{R: 0x1e, G: 0x88, B: 0xe5, A: 0xff}
+	bandColor      = color.NRGBA{R: 0x1e, G: 0x88, B: 0xe5, A: 0x22}
+	guideColor     = color.NRGBA{R: 0xe9, G: 0x1e, B: 0x63, A: 0xff}
+)
+
+// selectionPadding is the space between the selected lines and their frame
+const selectionPadding = 4
+
+// updateSelection shows the selection frame, the lines being moved at
+// their new place, the selection rectangle, the alignment guides and the
+// connector being drawn. The moved lines are rasterized into an image
+// covering only their bounds.
+func (r *whiteboardRenderer) updateSelection(state exportState) {
+	r.selection = r.selection[:0]
+	var selected []model.Line
+	if len(state.selection) > 0 {
+		set := idSet(state.selection)
+		for _, l := range state.snapshot.Lines {
+			if set[l.ID] {
+				selected = append(selected, state.place.apply(l))
+			}
+		}
+	}
+
+	r.moved.Image = nil
+	if b, ok := snap.Bounds(selected); ok {
+		topLeft := state.view.toScreen(b.Min)
+		bottomRight := state.view.toScreen(b.Max)
+		if state.moving {
+			pixelScale := r.pixelScale()
+			view := render.View{
+				Origin: b.Min,
+				Scale:  state.view.scale * pixelScale,
+				Width:  int((bottomRight.X-topLeft.X)*pixelScale) + 1,
+				Height: int((bottomRight.Y-topLeft.Y)*pixelScale) + 1,
+			}
+			img := image.NewRGBA(image.Rect(0, 0, view.Width, view.Height))
+			render.Rasterize(img, selected, view)
+			r.moved.Image = img
+			r.moved.Move(topLeft)
+			r.moved.Resize(fyne.NewSize(float32(view.Width)/pixelScale, float32(view.Height)/pixelScale))
+			r.moved.Refresh()
+			r.selection = append(r.selection, r.moved)
+		}
+		r.selected.Move(fyne.NewPos(topLeft.X-selectionPadding, topLeft.Y-selectionPadding))
+		r.selected.Resize(fyne.NewSize(bottomRight.X-topLeft.X+2*selectionPadding, bottomRight.Y-topLeft.Y+2*selectionPadding))
+		r.selected.Refresh()
+		r.handle.Move(fyne.NewPos(bottomRight.X+selectionPadding-handleSize/2, bottomRight.Y+selectionPadding-handleSize/2))
+		r.handle.Resize(fyne.NewSize(handleSize, handleSize))
+		r.handle.Refresh()
+		r.selection = append(r.selection, r.selected)
+		// ロックした線は拡大・縮小できないのでハンドルを出さない
+		if !lockedLines(selected) {
+			r.selection = append(r.selection, r.handle)
+		}
+	}
+
+	if state.selecting {
+		topLeft := state.view.toScreen(state.band.Min)
+		bottomRight := state.view.toScreen(state.band.Max)
+		r.band.Move(topLeft)
+		r.band.Resize(fyne.NewSize(bottomRight.X-topLeft.X, bottomRight.Y-topLeft.Y))
+		r.band.Refresh()
+		r.selection = append(r.selection, r.band)
+	}
+
+	// ガイドは数本なので毎回作り直す
+	r.guides = r.guides[:0]
+	for _, g := range state.guides {
+		guide := canvas.NewLine(guideColor)
+		guide.StrokeWidth = 1
+		if g.Vertical {
+			guide.Position1 = state.view.toScreen(model.Point{X: g.Pos, Y: g.From})
+			guide.Position2 = state.view.toScreen(model.Point{X: g.Pos, Y: g.To})
+		} else {
+			guide.Position1 = state.view.toScreen(model.Point{X: g.From, Y: g.Pos})
+			guide.Position2 = state.view.toScreen(model.Point{X: g.To, Y: g.Pos})
+		}
+		r.guides = append(r.guides, guide)
+	}
+	r.selection = append(r.selection, r.guides...)
+
+	// 引いているコネクターも数本の線なので毎回作り直す
+	r.connector = r.connector[:0]
+	if state.connecting {
+		for _, part := range render.ConnectorParts(state.connector) {
+			points := part.Points
+			if part.Curve {
+				points = stroke.Sample(points, 2/state.view.scale)
+			}
+			for i := 1; i < len(points); i++ {
+				segment := canvas.NewLine(part.Color)
+				segment.StrokeWidth = part.Width * state.view.scale
+				segment.Position1 = state.view.toScreen(points[i-1])
+				segment.Position2 = state.view.toScreen(points[i])
+				r.connector = append(r.connector, segment)
+			}
+		}
+	}
+	r.selection = append(r.selection, r.connector...)
+}
+
+// updateCursors draws a dot and a name tag for every remote participant.
+// There are only a handful, so they are simply recreated on each refresh.
+func (r *whiteboardRenderer) updateCursors(state exportState) {
+	r.cursors = r.cursors[:0]
+	for _, c := range state.cursors {
+		pos := state.view.toScreen(c.Pos)
+
+		dot := canvas.NewCircle(c.Color)
+		dot.Resize(fyne.NewSize(8, 8))
+		dot.Move(fyne.NewPos(pos.X-4, pos.Y-4))
+
+		label := canvas.NewText(c.Name, c.Color)
+		label.TextSize = 12
+		label.Move(fyne.NewPos(pos.X+6, pos.Y+2))
+		label.Resize(label.MinSize())
+
+		r.cursors = append(r.cursors, dot, label)
+	}
+}
+
+// pixelScale returns the number of device pixels per logical pixel so the
+// backing image stays sharp on HiDPI screens
+func (r *whiteboardRenderer) pixelScale() float32 {
+	app := fyne.CurrentApp()
+	if app == nil || app.Driver() == nil {
+		return 1
+	}
+	if c := app.Driver().CanvasForObject(r.whiteboard); c != nil && c.Scale() > 0 {
+		return c.Scale()
+	}
+	return 1
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"goWhiteBoard/model"
+	"image/color"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+)
+
+// newBenchBoard returns a laid out renderer for a board holding n finished
+// strokes of 50 points each
+func newBenchBoard(tb testing.TB, n int) (*whiteboard, *whiteboardRenderer) {
+	test.NewTempApp(tb)
+	w := newWhiteboard()
+	lines := make([]model.Line, 0, n)
+	for i := 0; i < n; i++ {
+		l := model.Line{Color: color.Black, Width: 2}
+		for j := 0; j < 50; j++ {
+			l.Points = append(l.Points, model.Point{X: float32((i*7 + j*3) % 800), Y: float32((i*13 + j) % 600)})
+		}
+		lines = append(lines, l)
+	}
+	w.doc.Replace(lines)
+	r := newWhiteboardRenderer(w)
+	r.Layout(fyne.NewSize(800, 600))
+	return w, r
+}
+
+// startStroke simulates MouseDown without going through the event system
+func startStroke(w *whiteboard, points ...model.Point) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.drawing = true
+	w.strokeID++
+	w.currentLine = model.Line{Color: color.Black, Width: 2, Points: points}
+}
+
+func TestRendererCachesFinishedStrokes(t *testing.T) {
+	w, r := newBenchBoard(t, 3)
+	if r.drawn != 3 {
+		t.Fatalf("drawn = %d, want 3", r.drawn)
+	}
+
+	startStroke(w, model.Point{X: 1, Y: 1}, model.Point{X: 2, Y: 2}, model.Point{X: 3, Y: 3})
+	if r.updateObjects() {
+		t.Error("backing image redrawn while only the live stroke changed")
+	}
+	if len(r.live) != 2 || len(r.objects) != 3 {
+		t.Errorf("live = %d, objects = %d", len(r.live), len(r.objects))
+	}
+
+	w.MouseUp(nil)
+	if !r.updateObjects() || r.drawn != 4 {
+		t.Errorf("finished stroke not added to backing image (drawn = %d)", r.drawn)
+	}
+	if len(r.live) != 0 {
+		t.Errorf("live segments left after stroke ended: %d", len(r.live))
+	}
+
+	w.Clear()
+	r.updateObjects()
+	if r.drawn != 0 {
+		t.Errorf("drawn = %d after Clear", r.drawn)
+	}
+}
+
+func TestRendererFillsVariableStrokes(t *testing.T) {
+	w, r := newBenchBoard(t, 0)
+	startStroke(w, model.Point{X: 1, Y: 1, P: 0.5}, model.Point{X: 20, Y: 1, P: 1}, model.Point{X: 40, Y: 1, P: 0.2})
+	r.updateObjects()
+	if len(r.live) != 0 || r.liveFill.Image == nil || len(r.objects) != 2 {
+		t.Fatalf("live = %d, objects = %d", len(r.live), len(r.objects))
+	}
+	// 筆圧の高い中央は太く、低い右端は細い
+	if c := r.fill.RGBAAt(20, 2); c.A == 0 {
+		t.Error("the wide middle of the stroke is not filled")
+	}
+	if c := r.fill.RGBAAt(39, 2); c.A != 0 {
+		t.Error("the thin end of the stroke is too wide")
+	}
+
+	w.MouseUp(nil)
+	r.updateObjects()
+	if r.liveFill.Image != nil {
+		t.Error("fill overlay left after the stroke ended")
+	}
+}
+
+func TestRendererTranslucentStrokes(t *testing.T) {
+	w, r := newBenchBoard(t, 1)
+	startStroke(w, model.Point{X: 1, Y: 5}, model.Point{X: 40, Y: 5}, model.Point{X: 10, Y: 6})
+	w.currentLine.Color = color.NRGBA{R: 255, A: 102}
+	w.currentLine.Highlighter = true
+	r.updateObjects()
+	if len(r.live) != 0 || r.liveFill.Image == nil || r.liveFill.Translucency != 0.6 {
+		t.Fatalf("live = %d, translucency = %v", len(r.live), r.liveFill.Translucency)
+	}
+	// オーバーレイには不透明で描き、重なっても濃くならない
+	if a, b := r.fill.RGBAAt(5, 5), r.fill.RGBAAt(20, 5); a != (color.RGBA{R: 255, A: 255}) || a != b {
+		t.Errorf("overlay pixels %v, %v", a, b)
+	}
+
+	w.MouseUp(nil)
+	if !r.updateObjects() || r.drawn != 2 {
+		t.Errorf("highlighter not drawn (drawn = %d)", r.drawn)
+	}
+}
+
+// BenchmarkDrawingFrame measures one MouseMoved frame while a stroke is being
+// drawn. The time per op should not grow with the number of finished strokes.
+func BenchmarkDrawingFrame(b *testing.B) {
+	for _, n := range []int{0, 100, 1000, 5000} {
+		b.Run(fmt.Sprintf("strokes=%d", n), func(b *testing.B) {
+			w, r := newBenchBoard(b, n)
+			startStroke(w, model.Point{})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.currentLine.Points = append(w.currentLine.Points, model.Point{X: float32(i % 800), Y: float32(i % 600)})
+				r.updateObjects()
+			}
+		})
+	}
+}
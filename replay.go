@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"goWhiteBoard/replay"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+)
+
+// replaySpeeds are the playback speeds offered, by name
+var replaySpeeds = []struct {
+	Name  string
+	Speed float64
+}{{"0.5×", 0.5}, {"1×", 1}, {"2×", 2}, {"4×", 4}, {"8×", 8}, {"16×", 16}}
+
+// replayFPS is how often the replay window redraws while playing
+const replayFPS = 30
+
+// Replay returns the replay of the page shown and the options to render it
+// the way Export does
+func (w *whiteboard) Replay() (*replay.Replay, replay.Options) {
+	state := w.exportState()
+	return replay.New(state.doc.Ops()), replay.Options{View: state.exportView(), Layers: state.layers, Grid: state.grid}
+}
+
+// showReplay opens a window that plays back how the page shown was built,
+// with play/pause, speed and position controls, and exports the playback
+// as an animated GIF or PNG frames
+func showReplay(a fyne.App, board *whiteboard) {
+	r, o := board.Replay()
+	o.Speed = 1
+	win := a.NewWindow("Replay")
+
+	// r と再生状態は mutex で守る（再生はタイマーのゴルーチンで進む）
+	var mutex sync.Mutex
+	var at time.Duration
+	playing := false
+
+	img := canvas.NewImageFromImage(r.Frame(0, o))
+	img.FillMode = canvas.ImageFillContain
+	img.SetMinSize(fyne.NewSize(480, 320))
+	position := widget.NewSlider(0, max(r.Duration().Seconds(), 0.01))
+	position.Step = 0.01
+	timeLabel := widget.NewLabel("")
+	playButton := widget.NewButton("Play", nil)
+
+	// show draws the frame at the position. The caller holds the mutex.
+	show := func() {
+		img.Image = r.Frame(at, o)
+		img.Refresh()
+		position.Value = at.Seconds()
+		position.Refresh()
+		timeLabel.SetText(fmt.Sprintf("%s / %s", formatReplayTime(at), formatReplayTime(r.Duration())))
+		if playing {
+			playButton.SetText("Pause")
+		} else {
+			playButton.SetText("Play")
+		}
+	}
+	playButton.OnTapped = func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		playing = !playing
+		if playing && at >= r.Duration() {
+			at = 0
+		}
+		show()
+	}
+	position.OnChanged = func(seconds float64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		at = min(time.Duration(seconds*float64(time.Second)), r.Duration())
+		show()
+	}
+	names := make([]string, len(replaySpeeds))
+	for i, s := range replaySpeeds {
+		names[i] = s.Name
+	}
+	speed := widget.NewSelect(names, func(name string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, s := range replaySpeeds {
+			if s.Name == name {
+				o.Speed = s.Speed
+			}
+		}
+	})
+	speed.SetSelected("1×")
+
+	// 書き出しのあいだは再生を止める
+	export := func(write func() error) {
+		mutex.Lock()
+		playing = false
+		show()
+		go func() {
+			defer mutex.Unlock()
+			if err := write(); err != nil {
+				dialog.ShowError(err, win)
+			}
+		}()
+	}
+	gifButton := widget.NewButton("Export GIF", func() {
+		save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			export(func() error {
+				if err := r.WriteGIF(writer, o); err != nil {
+					writer.Close()
+					return err
+				}
+				return writer.Close()
+			})
+		}, win)
+		save.SetFilter(storage.NewExtensionFileFilter([]string{".gif"}))
+		save.SetFileName("replay.gif")
+		save.Show()
+	})
+	framesButton := widget.NewButton("Export Frames", func() {
+		dialog.ShowFolderOpen(func(dir fyne.ListableURI, err error) {
+			if err != nil || dir == nil {
+				return
+			}
+			export(func() error {
+				_, err := r.WriteFrames(dir.Path(), "frame", o)
+				return err
+			})
+		}, win)
+	})
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second / replayFPS)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				// 書き出し中は飛ばす
+				if mutex.TryLock() {
+					if playing {
+						at = min(at+time.Duration(float64(now.Sub(last))*o.Speed), r.Duration())
+						playing = at < r.Duration()
+						show()
+					}
+					mutex.Unlock()
+				}
+				last = now
+			}
+		}
+	}()
+	win.SetOnClosed(func() { close(stop) })
+
+	mutex.Lock()
+	show()
+	mutex.Unlock()
+	controls := container.NewBorder(nil, nil,
+		container.NewHBox(playButton, speed),
+		container.NewHBox(timeLabel, gifButton, framesButton),
+		position)
+	win.SetContent(container.NewBorder(nil, controls, nil, nil, img))
+	win.Resize(fyne.NewSize(800, 600))
+	win.Show()
+}
+
+// formatReplayTime formats d as minutes and seconds
+func formatReplayTime(d time.Duration) string {
+	s := int(d.Seconds())
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
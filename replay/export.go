@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"fmt"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaxFrames is the most frames an export writes. Longer replays are sampled
+// less often.
+const MaxFrames = 1000
+
+// Options say how a replay is rendered and exported
+type Options struct {
+	View   render.View
+	Layers []model.Layer
+	Grid   model.Grid
+	// Speed is how many times faster than it was drawn the replay runs.
+	// 0 means 1.
+	Speed float64
+	// FPS is the number of frames per second of the export. 0 means 10.
+	FPS int
+}
+
+// Frame renders the board at t on the replay clock
+func (r *Replay) Frame(t time.Duration, o Options) *image.RGBA {
+	return render.GroupsImage(render.LayersWithGrid(r.Lines(t), o.Layers, o.Grid, o.View), o.View)
+}
+
+// Times returns the replay clock times of the frames of an export: one per
+// frame at o.FPS and o.Speed, at most MaxFrames, the last one showing the
+// finished board
+func (r *Replay) Times(o Options) []time.Duration {
+	speed, fps := o.Speed, o.FPS
+	if speed <= 0 {
+		speed = 1
+	}
+	if fps <= 0 {
+		fps = 10
+	}
+	step := time.Duration(float64(time.Second) * speed / float64(fps))
+	if n := r.duration / step; n >= MaxFrames {
+		step = r.duration / (MaxFrames - 1)
+	}
+	var times []time.Duration
+	for t := time.Duration(0); t < r.duration && step > 0; t += step {
+		times = append(times, t)
+	}
+	return append(times, r.duration)
+}
+
+// WriteGIF writes the replay as an animated GIF that holds the finished
+// board for a moment before it loops
+func (r *Replay) WriteGIF(w io.Writer, o Options) error {
+	fps := o.FPS
+	if fps <= 0 {
+		fps = 10
+	}
+	anim := &gif.GIF{}
+	times := r.Times(o)
+	for i, t := range times {
+		img := r.Frame(t, o)
+		// ホワイトボードは色数が少ないので、固定パレットに誤差拡散なしで落とす
+		frame := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.Draw(frame, img.Bounds(), img, image.Point{}, draw.Src)
+		delay := 100 / fps
+		if i == len(times)-1 {
+			delay = 200
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, max(delay, 2))
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// WriteFrames writes the frames of the replay into dir as numbered PNG
+// files, prefix-0001.png and on, and returns their paths
+func (r *Replay) WriteFrames(dir, prefix string, o Options) ([]string, error) {
+	var paths []string
+	for i, t := range r.Times(o) {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%04d.png", prefix, i+1))
+		if err := writePNG(path, r.Frame(t, o)); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,130 @@
+// Package replay plays back how a page was built from the operations of its
+// document, and exports the playback as an animated GIF or as numbered PNG
+// frames.
+package replay
+
+import (
+	"goWhiteBoard/model"
+	"time"
+)
+
+// MaxPause is the longest pause a replay keeps between two edits. Longer
+// pauses, such as a break in a meeting, are shortened to it.
+const MaxPause = time.Second
+
+// step is one operation on the replay clock
+type step struct {
+	op    model.Op
+	start time.Duration
+	// draw is how long drawing the inserted line takes; 0 shows the
+	// operation at once
+	draw time.Duration
+}
+
+// Replay rebuilds the lines of a document at any moment of its history. It
+// is not safe for concurrent use.
+type Replay struct {
+	steps    []step
+	duration time.Duration
+
+	// 再生位置の状態。先へ進むときは続きから適用する
+	doc  *model.Document
+	next int
+	at   time.Duration
+}
+
+// New returns the replay of ops, the operations of a document in the order
+// they were applied. A line added on its own is drawn point by point over
+// the time it took to draw; lines added together with other edits, such as
+// moved, pasted or loaded lines, appear at once.
+func New(ops []model.Op) *Replay {
+	r := &Replay{}
+	var clock time.Duration
+	var last int64 // 直前の操作の時刻（ミリ秒）
+	for i, op := range ops {
+		at := op.Time
+		if at == 0 {
+			at = last
+		}
+		draw := time.Duration(0)
+		if op.Kind == model.OpInsert && op.Line != nil && alone(ops, i) {
+			draw = drawTime(*op.Line)
+		}
+		if last != 0 {
+			pause := time.Duration(at-last)*time.Millisecond - draw
+			clock += min(max(pause, 0), MaxPause)
+		}
+		r.steps = append(r.steps, step{op: op, start: clock, draw: draw})
+		clock += draw
+		last = max(last, at)
+	}
+	r.duration = clock
+	r.reset()
+	return r
+}
+
+// alone reports whether ops[i] was made on its own rather than together
+// with the operations next to it. Operations made together share their
+// replica and time; a document stamps each of its edits with a new time.
+func alone(ops []model.Op, i int) bool {
+	op := ops[i]
+	if op.Time == 0 {
+		return true
+	}
+	for _, j := range []int{i - 1, i + 1} {
+		if j >= 0 && j < len(ops) && ops[j].Time == op.Time && ops[j].ID.Replica == op.ID.Replica {
+			return false
+		}
+	}
+	return true
+}
+
+// drawTime returns how long l took to draw
+func drawTime(l model.Line) time.Duration {
+	if len(l.Points) == 0 {
+		return 0
+	}
+	return time.Duration(l.Points[len(l.Points)-1].T-l.Points[0].T) * time.Millisecond
+}
+
+// Duration returns how long the replay lasts
+func (r *Replay) Duration() time.Duration {
+	return r.duration
+}
+
+// Lines returns the lines at t on the replay clock in document order. A
+// line being drawn at t comes last, with the points drawn so far.
+func (r *Replay) Lines(t time.Duration) []model.Line {
+	if t < r.at {
+		r.reset()
+	}
+	r.at = t
+	for r.next < len(r.steps) && r.steps[r.next].start+r.steps[r.next].draw <= t {
+		r.doc.Apply("replay", r.steps[r.next].op)
+		r.next++
+	}
+	lines := r.doc.Snapshot().Lines
+	if r.next == len(r.steps) {
+		return lines
+	}
+	s := r.steps[r.next]
+	if s.draw == 0 || t < s.start {
+		return lines
+	}
+	// 描いている途中の線は、その時点までの点だけを描く
+	l := *s.op.Line
+	elapsed := int32((t - s.start) / time.Millisecond)
+	n := 1
+	for n < len(l.Points) && l.Points[n].T-l.Points[0].T <= elapsed {
+		n++
+	}
+	l.Points = l.Points[:n:n]
+	return append(lines[:len(lines):len(lines)], l)
+}
+
+// reset goes back to the start
+func (r *Replay) reset() {
+	r.doc = model.NewDocument()
+	r.next = 0
+	r.at = 0
+}
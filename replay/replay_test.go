@@ -0,0 +1,102 @@
+package replay
+
+import (
+	"bytes"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testOps draws a line over half a second, another one ten seconds later
+// and then moves the second one
+func testOps() []model.Op {
+	line := func(x float32, times ...int32) *model.Line {
+		l := &model.Line{Color: color.Black, Width: 4}
+		for i, t := range times {
+			l.Points = append(l.Points, model.Point{X: x + float32(10*i), Y: 20, T: t})
+		}
+		return l
+	}
+	moved := line(50, 0, 100)
+	moved.Points[0].Y, moved.Points[1].Y = 40, 40
+	id := func(t uint64) model.ID { return model.ID{Time: t, Replica: "a"} }
+	return []model.Op{
+		{Kind: model.OpInsert, ID: id(1), Time: 10_000, Line: line(10, 0, 250, 500)},
+		{Kind: model.OpInsert, ID: id(2), Time: 20_000, Line: line(50, 0, 100)},
+		{Kind: model.OpDelete, ID: id(3), Time: 20_500, Targets: []model.ID{id(2)}},
+		{Kind: model.OpInsert, ID: id(4), Time: 20_500, Line: moved},
+	}
+}
+
+func TestLines(t *testing.T) {
+	r := New(testOps())
+	// 描くのに 0.5 秒、10 秒の間は 1 秒に縮め、0.1 秒描いて 0.4 秒後に動かす
+	if d := r.Duration(); d != 2100*time.Millisecond {
+		t.Fatalf("Duration = %v", d)
+	}
+	for _, c := range []struct {
+		at     time.Duration
+		points []int
+	}{
+		{250 * time.Millisecond, []int{2}},
+		{time.Second, []int{3}},
+		{1550 * time.Millisecond, []int{3, 1}},
+		{100 * time.Millisecond, []int{1}},
+		{2 * time.Second, []int{3, 2}},
+		{r.Duration(), []int{3, 2}},
+	} {
+		lines := r.Lines(c.at)
+		var got []int
+		for _, l := range lines {
+			got = append(got, len(l.Points))
+		}
+		if len(got) != len(c.points) || got[len(got)-1] != c.points[len(c.points)-1] {
+			t.Errorf("at %v: points %v, want %v", c.at, got, c.points)
+		}
+	}
+	if l := r.Lines(r.Duration()); l[1].Points[0].Y != 40 {
+		t.Errorf("the moved line is not at its new place: %v", l[1].Points)
+	}
+}
+
+func TestExport(t *testing.T) {
+	r := New(testOps())
+	o := Options{View: render.View{Scale: 1, Width: 100, Height: 60}, FPS: 10}
+	times := r.Times(o)
+	if len(times) != 22 || times[len(times)-1] != r.Duration() {
+		t.Fatalf("Times = %v", times)
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteGIF(&b, o); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != len(times) {
+		t.Errorf("GIF has %d frames, want %d", len(anim.Image), len(times))
+	}
+
+	// 4 倍速なら 0.4 秒ごとに 1 枚
+	o.Speed = 4
+	dir := t.TempDir()
+	paths, err := r.WriteFrames(dir, "frame", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 7 || paths[0] != filepath.Join(dir, "frame-0001.png") {
+		t.Fatalf("frames = %v", paths)
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			t.Error(err)
+		}
+	}
+}
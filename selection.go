@@ -0,0 +1,374 @@
+package main
+
+import (
+	"goWhiteBoard/model"
+	"goWhiteBoard/snap"
+	"math"
+
+	"fyne.io/fyne/v2"
+)
+
+// tool is what dragging with the left button does
+type tool int
+
+const (
+	// toolDraw draws strokes with the current pen
+	toolDraw tool = iota
+	// toolSelect selects lines by clicking or with a rectangle and moves
+	// them by dragging
+	toolSelect
+	// toolConnector draws connectors from shape to shape
+	toolConnector
+)
+
+const (
+	hitTolerance  = 4    // 線をクリックで選べる距離（画面上のピクセル）
+	snapTolerance = 6    // ほかの図形に吸着する距離（画面上のピクセル）
+	handleSize    = 8    // 拡大・縮小のハンドルの大きさ（画面上のピクセル）
+	minScale      = 0.05 // 一度のドラッグで縮小できる限度
+)
+
+// placement is where the selection is dragged to: moved by offset, then
+// scaled by scale about anchor
+type placement struct {
+	offset model.Point
+	anchor model.Point
+	scale  float32 // 0 は等倍
+}
+
+// identity reports whether p leaves lines where they are
+func (p placement) identity() bool {
+	return p.offset == (model.Point{}) && (p.scale == 0 || p.scale == 1)
+}
+
+// apply returns a copy of l at its new place
+func (p placement) apply(l model.Line) model.Line {
+	l = translateLine(l, p.offset)
+	if p.scale == 0 || p.scale == 1 {
+		return l
+	}
+	for i, q := range l.Points {
+		l.Points[i] = model.Point{X: p.anchor.X + (q.X-p.anchor.X)*p.scale, Y: p.anchor.Y + (q.Y-p.anchor.Y)*p.scale, P: q.P, T: q.T}
+	}
+	l.Width *= p.scale
+	return l
+}
+
+// SetTool switches between drawing, selecting and connecting. Switching
+// away from selecting drops the selection.
+func (w *whiteboard) SetTool(t tool) {
+	w.mutex.Lock()
+	w.tool = t
+	if t != toolSelect {
+		w.selection = nil
+	}
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// Tool returns what dragging does
+func (w *whiteboard) Tool() tool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.tool
+}
+
+// Selection returns the IDs of the selected lines that are still on the
+// page shown
+func (w *whiteboard) Selection() []model.ID {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var ids []model.ID
+	for _, l := range w.selectedLinesLocked(w.doc.Snapshot().Lines) {
+		ids = append(ids, l.ID)
+	}
+	return ids
+}
+
+// DeleteSelection removes the selected lines. Locked lines stay selected.
+func (w *whiteboard) DeleteSelection() {
+	w.mutex.Lock()
+	var ids, locked []model.ID
+	for _, l := range w.selectedLinesLocked(w.doc.Snapshot().Lines) {
+		if l.Locked {
+			locked = append(locked, l.ID)
+		} else {
+			ids = append(ids, l.ID)
+		}
+	}
+	w.selection = locked
+	history := w.history
+	w.mutex.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	// 通知で再描画するのでロックの外で消す
+	history.Delete(ids...)
+}
+
+// SetSnapping turns snapping to the grid and to other objects on or off
+func (w *whiteboard) SetSnapping(grid, objects bool) {
+	w.mutex.Lock()
+	w.snapGrid, w.snapObjects = grid, objects
+	w.mutex.Unlock()
+}
+
+// Snapping reports whether shapes and moved lines snap to the grid and to
+// other objects
+func (w *whiteboard) Snapping() (grid, objects bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.snapGrid, w.snapObjects
+}
+
+// SetGrid sets the background pattern of the page shown
+func (w *whiteboard) SetGrid(g model.Grid) {
+	w.mutex.Lock()
+	w.grid = g
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// Grid returns the background pattern of the page shown
+func (w *whiteboard) Grid() model.Grid {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.grid
+}
+
+// selectedLinesLocked returns the lines of the selection in document
+// order. The caller holds the mutex.
+func (w *whiteboard) selectedLinesLocked(lines []model.Line) []model.Line {
+	if len(w.selection) == 0 {
+		return nil
+	}
+	selected := make(map[model.ID]bool, len(w.selection))
+	for _, id := range w.selection {
+		selected[id] = true
+	}
+	var out []model.Line
+	for _, l := range lines {
+		if selected[l.ID] {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// selectDownLocked starts a drag with the select tool: on the handle at
+// the bottom right of the selection it scales the selection, on the
+// selection or a line it moves them, elsewhere it starts a selection
+// rectangle. A line is selected with the rest of its group, and a
+// selection with locked lines is not moved. Lines on hidden or locked
+// layers are left alone. The caller holds the mutex.
+func (w *whiteboard) selectDownLocked(pos fyne.Position) {
+	p := w.view.toWorld(pos)
+	lines := w.editableLinesLocked()
+	selected := w.selectedLinesLocked(lines)
+	if b, ok := snap.Bounds(selected); ok {
+		corner := w.view.toScreen(b.Max)
+		if abs32(pos.X-corner.X-selectionPadding) <= handleSize && abs32(pos.Y-corner.Y-selectionPadding) <= handleSize {
+			if !lockedLines(selected) {
+				w.startMoveLocked(p, lines)
+				w.scaling = true
+			}
+			return
+		}
+		if b.Has(p) {
+			if !lockedLines(selected) {
+				w.startMoveLocked(p, lines)
+			}
+			return
+		}
+	}
+	if l, ok := hitTest(lines, p, hitTolerance/w.view.scale); ok {
+		w.selection = withGroups(lines, []model.ID{l.ID})
+		if !lockedLines(w.selectedLinesLocked(lines)) {
+			w.startMoveLocked(p, lines)
+		}
+		return
+	}
+	w.selection = nil
+	w.selecting = true
+	w.bandFrom = p
+	w.band = snap.Rect{Min: p, Max: p}
+}
+
+// startMoveLocked starts moving the selection. The bounds of the other
+// lines are collected once for snapping. The caller holds the mutex.
+func (w *whiteboard) startMoveLocked(p model.Point, lines []model.Line) {
+	selected := w.selectedLinesLocked(lines)
+	w.moveBounds, _ = snap.Bounds(selected)
+	w.others = w.others[:0]
+	moving := make(map[model.ID]bool, len(selected))
+	for _, l := range selected {
+		moving[l.ID] = true
+	}
+	for _, l := range lines {
+		if moving[l.ID] || l.Background {
+			continue
+		}
+		if b, ok := snap.Bounds([]model.Line{l}); ok {
+			w.others = append(w.others, b)
+		}
+	}
+	w.moving = true
+	w.scaling = false
+	w.moveID++
+	w.moveFrom = p
+	w.place = placement{}
+	w.guides = nil
+}
+
+// selectMovedLocked updates the selection rectangle or the placement of the
+// lines being moved or scaled. The caller holds the mutex.
+func (w *whiteboard) selectMovedLocked(pos fyne.Position) {
+	p := w.view.toWorld(pos)
+	if w.selecting {
+		w.band = snap.Rect{
+			Min: model.Point{X: min(w.bandFrom.X, p.X), Y: min(w.bandFrom.Y, p.Y)},
+			Max: model.Point{X: max(w.bandFrom.X, p.X), Y: max(w.bandFrom.Y, p.Y)},
+		}
+		return
+	}
+	if w.scaling {
+		// 左上を固定して縦横同じ比率で拡大・縮小する
+		b := w.moveBounds
+		width, height := max(b.Max.X-b.Min.X, 1e-3), max(b.Max.Y-b.Min.Y, 1e-3)
+		sx := (width + p.X - w.moveFrom.X) / width
+		sy := (height + p.Y - w.moveFrom.Y) / height
+		w.place = placement{anchor: b.Min, scale: max(sx, sy, minScale)}
+		return
+	}
+
+	offset := model.Point{X: p.X - w.moveFrom.X, Y: p.Y - w.moveFrom.Y}
+	if w.snapGrid && w.grid.Visible() {
+		d := snap.Grid(w.moveBounds.Translate(offset), w.grid)
+		offset.X, offset.Y = offset.X+d.X, offset.Y+d.Y
+	}
+	w.guides = nil
+	if w.snapObjects {
+		// ほかの図形に揃う軸は格子より優先する
+		r := snap.Objects(w.moveBounds.Translate(offset), w.others, snapTolerance/w.view.scale)
+		offset.X, offset.Y = offset.X+r.Offset.X, offset.Y+r.Offset.Y
+		w.guides = r.Guides
+	}
+	w.place = placement{offset: offset}
+}
+
+// selectUpLocked finishes a drag with the select tool. After a move it
+// returns the lines to replace and their moved copies, which the caller
+// applies outside the lock. The caller holds the mutex.
+func (w *whiteboard) selectUpLocked() (remove []model.ID, add []model.Line) {
+	lines := w.editableLinesLocked()
+	if w.selecting {
+		w.selecting = false
+		w.selection = nil
+		for _, l := range lines {
+			if b, ok := snap.Bounds([]model.Line{l}); ok && !l.Background && w.band.Contains(b) {
+				w.selection = append(w.selection, l.ID)
+			}
+		}
+		w.selection = withGroups(lines, w.selection)
+		return nil, nil
+	}
+
+	w.moving, w.scaling = false, false
+	w.guides = nil
+	place := w.place
+	w.place = placement{}
+	if place.identity() {
+		return nil, nil
+	}
+	for _, l := range w.selectedLinesLocked(lines) {
+		remove = append(remove, l.ID)
+		add = append(add, place.apply(l))
+	}
+	return remove, add
+}
+
+// moveSelection replaces selected lines with their changed copies as one
+// undoable edit and selects the copies. Connectors attached to the changed
+// lines are rerouted in the same edit.
+func (w *whiteboard) moveSelection(remove []model.ID, add []model.Line) {
+	w.mutex.Lock()
+	n := len(add)
+	remove, add = w.rerouteLocked(remove, add)
+	history := w.history
+	w.mutex.Unlock()
+
+	// 通知で再描画するのでロックの外で入れ替える
+	ids := history.Update(remove, add)
+	w.mutex.Lock()
+	// 入れ替えなかった選択中の線はそのまま選んでおく
+	removed := idSet(remove)
+	var selection []model.ID
+	for _, id := range w.selection {
+		if !removed[id] {
+			selection = append(selection, id)
+		}
+	}
+	w.selection = append(selection, ids[:n]...)
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// translateLine returns a copy of l moved by d
+func translateLine(l model.Line, d model.Point) model.Line {
+	points := make([]model.Point, len(l.Points))
+	for i, p := range l.Points {
+		points[i] = model.Point{X: p.X + d.X, Y: p.Y + d.Y, P: p.P, T: p.T}
+	}
+	l.Points = points
+	return l
+}
+
+// hitTest returns the topmost line that passes within tolerance of p, or
+// the topmost image or text p lies on. Backgrounds are never hit.
+func hitTest(lines []model.Line, p model.Point, tolerance float32) (model.Line, bool) {
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := lines[i]
+		b, ok := snap.Bounds([]model.Line{l})
+		if !ok || l.Background {
+			continue
+		}
+		if l.Boxed() {
+			if b.Has(p) {
+				return l, true
+			}
+			continue
+		}
+		grown := snap.Rect{
+			Min: model.Point{X: b.Min.X - tolerance, Y: b.Min.Y - tolerance},
+			Max: model.Point{X: b.Max.X + tolerance, Y: b.Max.Y + tolerance},
+		}
+		if !grown.Has(p) {
+			continue
+		}
+		for j := range l.Points {
+			a, c := l.Points[max(j-1, 0)], l.Points[j]
+			if segmentDistance(p, a, c) <= l.WidthAt(j)/2+tolerance {
+				return l, true
+			}
+		}
+	}
+	return model.Line{}, false
+}
+
+func abs32(f float32) float32 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// segmentDistance returns the distance from p to the segment a-b
+func segmentDistance(p, a, b model.Point) float32 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := float32(0)
+	if l := dx*dx + dy*dy; l > 0 {
+		t = max(0, min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/l))
+	}
+	return float32(math.Hypot(float64(p.X-a.X-t*dx), float64(p.Y-a.Y-t*dy)))
+}
@@ -3,7 +3,13 @@ package main
 import (
 	"fmt"
 	"goWhiteBoard/config"
+	"goWhiteBoard/model"
+	"goWhiteBoard/shape"
+	"goWhiteBoard/stroke"
 	"image/color"
+	"math"
+	"strconv"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -12,22 +18,167 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+// penColor is a colour preset for new lines
+type penColor struct {
+	Name  string
+	Color color.Color
+}
+
+// penColors are the colour presets offered in the settings and bound to the
+// number keys
+var penColors = []penColor{
+	{"Black", color.Black},
+	{"Red", color.RGBA{R: 255, G: 0, B: 0, A: 255}},
+	{"Blue", color.RGBA{R: 0, G: 0, B: 255, A: 255}},
+	{"Green", color.RGBA{R: 0, G: 255, B: 0, A: 255}},
+	{"Yellow", color.RGBA{R: 255, G: 230, B: 0, A: 255}},
+}
+
+// penColorName returns the name of the preset matching c, or "" if there is
+// none
+func penColorName(c color.Color) string {
+	r, g, b, a := c.RGBA()
+	for _, p := range penColors {
+		if pr, pg, pb, pa := p.Color.RGBA(); pr == r && pg == g && pb == b && pa == a {
+			return p.Name
+		}
+	}
+	return ""
+}
+
 // Create form with settings
-func ShowSettingDialog(w fyne.Window, board *whiteboard) {
+func ShowSettingDialog(w fyne.Window, board *whiteboard, keys *shortcuts) {
 
-	penColorSelect := widget.NewSelect([]string{"Black", "Red", "Blue", "Green"}, nil)
-	penColorSelect.SetSelected("Black")
+	var colorNames []string
+	for _, p := range penColors {
+		colorNames = append(colorNames, p.Name)
+	}
+	penColorSelect := widget.NewSelect(colorNames, nil)
+	// ショートカットで変えた色・太さを反映する
+	if name := penColorName(board.LineColor()); name != "" {
+		penColorSelect.SetSelected(name)
+	} else {
+		penColorSelect.SetSelected("Black")
+	}
 
-	// default width is set to 2
-	DEFAULT_PEN_WIDTH := 2.0
-	penWidthSlider := widget.NewSlider(1, 10)
-	penWidthSlider.SetValue(DEFAULT_PEN_WIDTH) // Default width
-	penWidthLabel := widget.NewLabel(fmt.Sprintf("%.0f", DEFAULT_PEN_WIDTH))
+	penWidthSlider := widget.NewSlider(1, 30)
+	penWidthSlider.SetValue(float64(board.LineWidth()))
+	penWidthLabel := widget.NewLabel(fmt.Sprintf("%.0f", penWidthSlider.Value))
 
 	penWidthSlider.OnChanged = func(value float64) {
     penWidthLabel.SetText(fmt.Sprintf("%.0f", value))
   }
 
+	// ペンの種類（ペン / マーカー / 蛍光ペン）。選ぶと既定の太さになる
+	var penNames []string
+	for _, p := range stroke.Pens {
+		penNames = append(penNames, p.Name)
+	}
+	penTypeSelect := widget.NewSelect(penNames, nil)
+	for i, p := range stroke.Pens {
+		if p.Name == board.Pen().Name {
+			penTypeSelect.SetSelectedIndex(i)
+		}
+	}
+	penTypeSelect.OnChanged = func(string) {
+		pen := stroke.Pens[penTypeSelect.SelectedIndex()]
+		penWidthSlider.SetValue(float64(pen.Width))
+		if pen.Highlighter && penColorSelect.Selected == "Black" {
+			penColorSelect.SetSelected("Yellow")
+		}
+	}
+
+	// 入力の平滑化と簡略化
+	input := board.InputOptions()
+	smoothingNames := []string{"Off", "Light", "Strong"}
+	smoothingSelect := widget.NewSelect(smoothingNames, nil)
+	smoothingSelect.SetSelectedIndex(int(input.Smoothing))
+	simplifyNames := []string{"Off", "Fine", "Coarse"}
+	simplifyTolerances := []float32{0, 1, 3}
+	simplifySelect := widget.NewSelect(simplifyNames, nil)
+	for i, t := range simplifyTolerances {
+		if input.Tolerance >= t {
+			simplifySelect.SetSelectedIndex(i)
+		}
+	}
+	curvesCheck := widget.NewCheck("Draw smooth curves", nil)
+	curvesCheck.SetChecked(input.Curves)
+	pressureCheck := widget.NewCheck("Vary width with speed", nil)
+	pressureCheck.SetChecked(input.Pressure)
+
+	// 手書きの図形を認識して整形する（Hold: ペンを止めてから離すと整形）
+	shapeSelect := widget.NewSelect([]string{"Off", "Hold to snap", "Auto"}, nil)
+	shapeSelect.SetSelectedIndex(int(board.ShapeMode()))
+
+	// スクリプトを含む出力（Mermaid など）を許可するかどうか
+	trustedCheck := widget.NewCheck("Allow scripts in generated HTML", nil)
+	trustedCheck.SetChecked(config.TrustedOutput)
+
+	// 外部ツール向けのローカル HTTP API
+	apiCheck := widget.NewCheck("Enable on "+config.APIAddr, nil)
+	apiCheck.SetChecked(localAPI != nil)
+
+	// 書き出し範囲（表示範囲 / 全コンテンツ）
+	exportSelect := widget.NewSelect([]string{"Visible Area", "Whole Board"}, nil)
+	if board.ExportAll() {
+		exportSelect.SetSelected("Whole Board")
+	} else {
+		exportSelect.SetSelected("Visible Area")
+	}
+
+	// キャンバスの大きさ（Auto は無制限、Custom は幅と高さを指定）
+	pageNames := []string{"Auto"}
+	for _, p := range model.PagePresets {
+		pageNames = append(pageNames, p.Name)
+	}
+	pageNames = append(pageNames, "Custom")
+	page := board.PageSize()
+	pageWidthEntry := widget.NewEntry()
+	pageHeightEntry := widget.NewEntry()
+	pageWidthEntry.SetText(fmt.Sprintf("%.0f", page.Width))
+	pageHeightEntry.SetText(fmt.Sprintf("%.0f", page.Height))
+	pageSelect := widget.NewSelect(pageNames, func(name string) {
+		custom := name == "Custom"
+		for _, e := range []*widget.Entry{pageWidthEntry, pageHeightEntry} {
+			if custom {
+				e.Enable()
+			} else {
+				e.Disable()
+			}
+		}
+	})
+	pageSelect.SetSelected("Auto")
+	if page.Fixed() {
+		pageSelect.SetSelected("Custom")
+		for _, p := range model.PagePresets {
+			if p.Size == page {
+				pageSelect.SetSelected(p.Name)
+			}
+		}
+	}
+
+	// 背景の格子と吸着
+	grid := board.Grid()
+	gridNames := []string{"None", "Dots", "Squares", "Isometric", "Ruled"}
+	gridSelect := widget.NewSelect(gridNames, nil)
+	for i, style := range model.GridStyles {
+		if style == grid.Style {
+			gridSelect.SetSelectedIndex(i)
+		}
+	}
+	if grid.Spacing <= 0 {
+		grid.Spacing = model.DefaultGridSpacing
+	}
+	gridSpacingEntry := widget.NewEntry()
+	gridSpacingEntry.SetText(fmt.Sprintf("%.0f", grid.Spacing))
+	gridExportCheck := widget.NewCheck("Include in exports", nil)
+	gridExportCheck.SetChecked(grid.Export)
+	snapGrid, snapObjects := board.Snapping()
+	snapGridCheck := widget.NewCheck("Grid", nil)
+	snapGridCheck.SetChecked(snapGrid)
+	snapObjectsCheck := widget.NewCheck("Objects", nil)
+	snapObjectsCheck.SetChecked(snapObjects)
+
 	var customDialog dialog.Dialog
 
 	// Create buttons for input forms
@@ -39,32 +190,76 @@ func ShowSettingDialog(w fyne.Window, board *whiteboard) {
 		showUserPromptForm(w, board)
 	})
 
+	EditShortcuts := widget.NewButton("Shortcuts", func() {
+		keys.ShowEditor()
+	})
+
 	// Create button container with horizontal layout
-	buttonContainer := container.New(layout.NewHBoxLayout(), EditSystemPrompt, EditUserPrompt)
+	buttonContainer := container.New(layout.NewHBoxLayout(), EditSystemPrompt, EditUserPrompt, EditShortcuts)
 
 	form := &widget.Form{
 		Items: []*widget.FormItem{
+			{Text: "Pen Type", Widget: penTypeSelect},
 			{Text: "Pen Color", Widget: penColorSelect},
 			{Text: "Pen Width", Widget: container.NewBorder(nil, nil, nil, penWidthLabel, penWidthSlider)},
+			{Text: "Smoothing", Widget: smoothingSelect},
+			{Text: "Simplify", Widget: simplifySelect},
+			{Text: "Curves", Widget: curvesCheck},
+			{Text: "Pressure", Widget: pressureCheck},
+			{Text: "Shapes", Widget: shapeSelect},
+			{Text: "Page Size", Widget: pageSelect},
+			{Text: "Custom Size", Widget: container.NewGridWithColumns(2, pageWidthEntry, pageHeightEntry)},
+			{Text: "Grid", Widget: container.NewGridWithColumns(2, gridSelect, gridSpacingEntry)},
+			{Text: "Grid Export", Widget: gridExportCheck},
+			{Text: "Snap To", Widget: container.NewHBox(snapGridCheck, snapObjectsCheck)},
+			{Text: "Export Area", Widget: exportSelect},
+			{Text: "Trusted Output", Widget: trustedCheck},
+			{Text: "Local API", Widget: apiCheck},
 			{Text: "Additional Options", Widget: buttonContainer},
 		},
 		OnSubmit: func() {
+			page, err := selectedPageSize(pageSelect.Selected, pageWidthEntry.Text, pageHeightEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			spacing, err := strconv.ParseFloat(strings.TrimSpace(gridSpacingEntry.Text), 32)
+			if err != nil || spacing < minGridSpacing || spacing > maxGridSpacing {
+				dialog.ShowError(fmt.Errorf("the grid spacing must be between %d and %d pixels", minGridSpacing, maxGridSpacing), w)
+				return
+			}
+
 			// Apply settings to the whiteboard
-			var penColor color.Color
-			switch penColorSelect.Selected {
-			case "Red":
-				penColor = color.RGBA{R: 255, G: 0, B: 0, A: 255}
-			case "Blue":
-				penColor = color.RGBA{R: 0, G: 0, B: 255, A: 255}
-			case "Green":
-				penColor = color.RGBA{R: 0, G: 255, B: 0, A: 255}
-			default:
-				penColor = color.Black
+			var penColor color.Color = color.Black
+			for _, p := range penColors {
+				if p.Name == penColorSelect.Selected {
+					penColor = p.Color
+				}
 			}
 
-			// Update the current line settings
-			board.currentLine.color = penColor
-			board.currentLine.width = float32(penWidthSlider.Value)
+			// Update the pen used for new lines
+			board.SetPen(stroke.Pens[penTypeSelect.SelectedIndex()])
+			board.SetLineColor(penColor)
+			board.SetLineWidth(float32(penWidthSlider.Value))
+			board.SetInputOptions(stroke.Options{
+				Smoothing: stroke.Smoothing(smoothingSelect.SelectedIndex()),
+				Tolerance: simplifyTolerances[simplifySelect.SelectedIndex()],
+				Curves:    curvesCheck.Checked,
+				Pressure:  pressureCheck.Checked,
+			})
+			board.SetShapeMode(shape.Mode(shapeSelect.SelectedIndex()))
+			config.TrustedOutput = trustedCheck.Checked
+			board.SetExportAll(exportSelect.Selected == "Whole Board")
+			if page != board.PageSize() {
+				board.SetPageSize(page)
+			}
+			board.SetGrid(model.Grid{
+				Style:   model.GridStyles[gridSelect.SelectedIndex()],
+				Spacing: float32(math.Round(spacing)),
+				Export:  gridExportCheck.Checked,
+			})
+			board.SetSnapping(snapGridCheck.Checked, snapObjectsCheck.Checked)
+			setLocalAPI(w, board, apiCheck.Checked)
 
 			// Close the dialog
 			if customDialog != nil {
@@ -82,10 +277,38 @@ func ShowSettingDialog(w fyne.Window, board *whiteboard) {
 
 	// Create and show the dialog
 	customDialog = dialog.NewCustomWithoutButtons("Settings", form, w)
-	customDialog.Resize(fyne.NewSize(300, 250))
+	customDialog.Resize(fyne.NewSize(300, 800))
 	customDialog.Show()
 }
 
+// maxPageSize limits custom pages so exports stay a reasonable size
+const maxPageSize = 10000
+
+// Limits of the grid spacing in world units
+const (
+	minGridSpacing = 4
+	maxGridSpacing = 500
+)
+
+// selectedPage returns the page chosen in the settings: a preset by name,
+// the zero Page for "Auto", or the custom width and height
+func selectedPageSize(name, width, height string) (model.PageSize, error) {
+	for _, p := range model.PagePresets {
+		if p.Name == name {
+			return p.Size, nil
+		}
+	}
+	if name != "Custom" {
+		return model.PageSize{}, nil
+	}
+	w, errW := strconv.ParseFloat(strings.TrimSpace(width), 32)
+	h, errH := strconv.ParseFloat(strings.TrimSpace(height), 32)
+	if errW != nil || errH != nil || w < 1 || h < 1 || w > maxPageSize || h > maxPageSize {
+		return model.PageSize{}, fmt.Errorf("the page size must be between 1 and %d pixels", maxPageSize)
+	}
+	return model.PageSize{Width: float32(math.Round(w)), Height: float32(math.Round(h))}, nil
+}
+
 // Input form for the first button
 func showSystemPromptForm(w fyne.Window, board *whiteboard) {
 	systemEntry := widget.NewMultiLineEntry()
@@ -0,0 +1,311 @@
+// Package shape recognizes hand-drawn strokes as simple diagram shapes
+// (lines, arrows, rectangles, ellipses, triangles and diamonds) and returns
+// clean versions of them. It runs entirely offline.
+package shape
+
+import (
+	"goWhiteBoard/model"
+	"goWhiteBoard/stroke"
+	"math"
+	"time"
+)
+
+// Kind is a recognized shape
+type Kind int
+
+const (
+	None Kind = iota
+	Line
+	Arrow
+	Rectangle
+	Ellipse
+	Triangle
+	Diamond
+)
+
+var kindNames = []string{"none", "line", "arrow", "rectangle", "ellipse", "triangle", "diamond"}
+
+// String returns the lower case name of the kind
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "unknown"
+	}
+	return kindNames[k]
+}
+
+// Mode says when a recognized stroke is replaced by its shape
+type Mode int
+
+const (
+	// Off never snaps
+	Off Mode = iota
+	// Hold snaps when the pen rests for HoldDuration before it is lifted
+	Hold
+	// Auto snaps every stroke that is recognized
+	Auto
+)
+
+// HoldDuration is how long the pen has to rest at the end of a stroke to
+// snap it in Hold mode
+const HoldDuration = 500 * time.Millisecond
+
+// Result is a recognized shape
+type Result struct {
+	Kind Kind
+	// Points is the clean outline. Closed shapes repeat their first point at
+	// the end.
+	Points []model.Point
+	// Error is the mean distance of the stroke from the outline relative to
+	// the stroke's size; lower is a better match.
+	Error float32
+}
+
+const (
+	minSize      = 12   // これより小さいストロークは図形として扱わない
+	closedGap    = 0.2  // 始点と終点の距離が全長のこの割合以下なら閉じた図形
+	straightness = 0.95 // 始点と終点の距離 / 全長 がこれ以上なら直線
+	maxError     = 0.04 // 許容するフィット誤差（サイズ比）
+	ellipseSides = 48
+	minLength    = 0.7 // 閉じた図形のストローク長 / 輪郭の長さ の範囲
+	maxLength    = 1.4
+)
+
+// Recognize classifies points. It returns a result with Kind None when the
+// stroke does not look like any of the shapes.
+func Recognize(points []model.Point) Result {
+	min, max := bounds(points)
+	size := distance(min, max)
+	if len(points) < 2 || size < minSize {
+		return Result{}
+	}
+	length := pathLength(points)
+	gap := distance(points[0], points[len(points)-1])
+
+	if gap > closedGap*length {
+		if gap >= straightness*length {
+			ends := []model.Point{points[0], points[len(points)-1]}
+			return Result{Kind: Line, Points: ends, Error: fitError(points, ends, size)}
+		}
+		// 矢じりが小さいと粗い簡略化では消えてしまうので、細かくしながら試す
+		for _, tolerance := range []float32{0.06, 0.04, 0.025} {
+			if r, ok := arrow(points, tolerance*size, size); ok {
+				return r
+			}
+		}
+		return Result{}
+	}
+
+	center := model.Point{X: (min.X + max.X) / 2, Y: (min.Y + max.Y) / 2}
+	candidates := []Result{
+		{Kind: Rectangle, Points: closed(min, model.Point{X: max.X, Y: min.Y}, max, model.Point{X: min.X, Y: max.Y})},
+		{Kind: Diamond, Points: closed(
+			model.Point{X: center.X, Y: min.Y}, model.Point{X: max.X, Y: center.Y},
+			model.Point{X: center.X, Y: max.Y}, model.Point{X: min.X, Y: center.Y})},
+		{Kind: Ellipse, Points: ellipse(center, (max.X-min.X)/2, (max.Y-min.Y)/2)},
+	}
+	if corners := triangle(points); corners != nil {
+		candidates = append(candidates, Result{Kind: Triangle, Points: closed(corners...)})
+	}
+
+	best := Result{Error: float32(math.Inf(1))}
+	for _, c := range candidates {
+		// なぞり書きや塗りつぶしは輪郭よりずっと長い
+		if ratio := length / pathLength(c.Points); ratio < minLength || ratio > maxLength {
+			continue
+		}
+		c.Error = fitError(points, c.Points, size)
+		if c.Error < best.Error {
+			best = c
+		}
+	}
+	if best.Error > maxError {
+		return Result{}
+	}
+	return best
+}
+
+// arrow recognizes a shaft followed by a head drawn without lifting the pen,
+// e.g. start → tip → barb → tip → barb
+func arrow(points []model.Point, tolerance, size float32) (Result, bool) {
+	corners := stroke.Simplify(points, tolerance)
+	if len(corners) < 4 || len(corners) > 5 {
+		return Result{}, false
+	}
+	start, tip := corners[0], corners[1]
+	shaft := distance(start, tip)
+	if shaft < minSize {
+		return Result{}, false
+	}
+	// 矢じりの点はすべて先端の近くにある
+	var head float32
+	for _, p := range corners[2:] {
+		d := distance(p, tip)
+		if d > 0.45*shaft {
+			return Result{}, false
+		}
+		head = float32(math.Max(float64(head), float64(d)))
+	}
+	if head < 0.08*shaft {
+		return Result{}, false
+	}
+	// 矢じりは軸の両側に開いている
+	var left, right bool
+	for _, p := range corners[2:] {
+		if distance(p, tip) < 0.3*head {
+			continue
+		}
+		switch side := cross(start, tip, p); {
+		case side > 0:
+			left = true
+		case side < 0:
+			right = true
+		}
+		// 矢じりは先端から手前側に戻る
+		if dot(start, tip, p) > 0 {
+			return Result{}, false
+		}
+	}
+	if !left || !right {
+		return Result{}, false
+	}
+
+	// 軸から ±30° の矢じりを描く
+	angle := math.Atan2(float64(start.Y-tip.Y), float64(start.X-tip.X))
+	barb := func(delta float64) model.Point {
+		return model.Point{
+			X: tip.X + head*float32(math.Cos(angle+delta)),
+			Y: tip.Y + head*float32(math.Sin(angle+delta)),
+		}
+	}
+	clean := []model.Point{start, tip, barb(math.Pi / 6), tip, barb(-math.Pi / 6)}
+	return Result{Kind: Arrow, Points: clean, Error: fitError(points, clean, size)}, true
+}
+
+// triangle returns the three points of the stroke that span the largest
+// triangle, or nil if the stroke has too few distinct points
+func triangle(points []model.Point) []model.Point {
+	min, max := bounds(points)
+	corners := stroke.Simplify(points, 0.03*distance(min, max))
+	if len(corners) < 3 {
+		return nil
+	}
+	var best []model.Point
+	var bestArea float32
+	for i := 0; i < len(corners); i++ {
+		for j := i + 1; j < len(corners); j++ {
+			for k := j + 1; k < len(corners); k++ {
+				if a := float32(math.Abs(float64(cross(corners[i], corners[j], corners[k])))); a > bestArea {
+					best, bestArea = []model.Point{corners[i], corners[j], corners[k]}, a
+				}
+			}
+		}
+	}
+	return best
+}
+
+// fitError returns the mean distance of points from the polyline outline,
+// relative to size. Outline points that the stroke never comes near count
+// as well, so a stroke covering only part of a shape does not match it.
+func fitError(points, outline []model.Point, size float32) float32 {
+	var sum float32
+	for _, p := range points {
+		sum += polylineDistance(p, outline)
+	}
+	covered := sum / float32(len(points))
+
+	var missed float32
+	samples := samplePolyline(outline, size/20)
+	for _, q := range samples {
+		missed += polylineDistance(q, points)
+	}
+	missed /= float32(len(samples))
+	return (covered + missed) / 2 / size
+}
+
+func closed(corners ...model.Point) []model.Point {
+	return append(corners, corners[0])
+}
+
+func ellipse(center model.Point, rx, ry float32) []model.Point {
+	points := make([]model.Point, 0, ellipseSides+1)
+	for i := 0; i <= ellipseSides; i++ {
+		// 上から時計回り（画面座標）に一周する
+		a := 2*math.Pi*float64(i)/ellipseSides - math.Pi/2
+		points = append(points, model.Point{X: center.X + rx*float32(math.Cos(a)), Y: center.Y + ry*float32(math.Sin(a))})
+	}
+	return points
+}
+
+// samplePolyline returns points along the polyline about step apart
+func samplePolyline(points []model.Point, step float32) []model.Point {
+	out := []model.Point{points[0]}
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		n := int(math.Ceil(float64(distance(a, b) / step)))
+		for j := 1; j <= n; j++ {
+			t := float32(j) / float32(n)
+			out = append(out, model.Point{X: a.X + t*(b.X-a.X), Y: a.Y + t*(b.Y-a.Y)})
+		}
+	}
+	return out
+}
+
+func polylineDistance(p model.Point, line []model.Point) float32 {
+	if len(line) == 1 {
+		return distance(p, line[0])
+	}
+	best := float32(math.Inf(1))
+	for i := 1; i < len(line); i++ {
+		if d := segmentDistance(p, line[i-1], line[i]); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+func segmentDistance(p, a, b model.Point) float32 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	length := dx*dx + dy*dy
+	t := float32(0)
+	if length > 0 {
+		t = ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / length
+		t = float32(math.Max(0, math.Min(1, float64(t))))
+	}
+	return distance(p, model.Point{X: a.X + t*dx, Y: a.Y + t*dy})
+}
+
+func bounds(points []model.Point) (min, max model.Point) {
+	for i, p := range points {
+		if i == 0 {
+			min, max = p, p
+			continue
+		}
+		min.X = float32(math.Min(float64(min.X), float64(p.X)))
+		min.Y = float32(math.Min(float64(min.Y), float64(p.Y)))
+		max.X = float32(math.Max(float64(max.X), float64(p.X)))
+		max.Y = float32(math.Max(float64(max.Y), float64(p.Y)))
+	}
+	return min, max
+}
+
+func pathLength(points []model.Point) float32 {
+	var length float32
+	for i := 1; i < len(points); i++ {
+		length += distance(points[i-1], points[i])
+	}
+	return length
+}
+
+// cross returns the z component of (b-a) × (c-a)
+func cross(a, b, c model.Point) float32 {
+	return (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+}
+
+// dot returns (b-a) · (c-b): positive when c lies beyond b seen from a
+func dot(a, b, c model.Point) float32 {
+	return (b.X-a.X)*(c.X-b.X) + (b.Y-a.Y)*(c.Y-b.Y)
+}
+
+func distance(a, b model.Point) float32 {
+	return float32(math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y)))
+}
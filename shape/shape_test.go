@@ -0,0 +1,78 @@
+package shape
+
+import (
+	"encoding/json"
+	"goWhiteBoard/model"
+	"os"
+	"testing"
+)
+
+// sample is one stroke of the corpus in testdata/strokes.json. The strokes
+// imitate freehand drawing: wobbly edges, corners that overshoot or leave a
+// gap, slow starts and ends, and a few strokes that are no shape at all.
+type sample struct {
+	Name   string        `json:"name"`
+	Want   string        `json:"want"`
+	Points []model.Point `json:"points"`
+}
+
+func loadCorpus(t *testing.T) []sample {
+	data, err := os.ReadFile("testdata/strokes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corpus []sample
+	if err := json.Unmarshal(data, &corpus); err != nil {
+		t.Fatal(err)
+	}
+	return corpus
+}
+
+func TestCorpus(t *testing.T) {
+	for _, s := range loadCorpus(t) {
+		t.Run(s.Name, func(t *testing.T) {
+			r := Recognize(s.Points)
+			if r.Kind.String() != s.Want {
+				t.Errorf("recognized as %s (error %.3f), want %s", r.Kind, r.Error, s.Want)
+			}
+		})
+	}
+}
+
+func TestCleanShapes(t *testing.T) {
+	rect := []model.Point{{X: 10, Y: 10}, {X: 110, Y: 10}, {X: 110, Y: 60}, {X: 10, Y: 60}, {X: 10, Y: 12}}
+	r := Recognize(samplePolyline(rect, 5))
+	if r.Kind != Rectangle {
+		t.Fatalf("rectangle recognized as %s", r.Kind)
+	}
+	want := []model.Point{{X: 10, Y: 10}, {X: 110, Y: 10}, {X: 110, Y: 60}, {X: 10, Y: 60}, {X: 10, Y: 10}}
+	for i, p := range want {
+		if r.Points[i] != p {
+			t.Fatalf("outline = %v, want %v", r.Points, want)
+		}
+	}
+
+	r = Recognize(ellipse(model.Point{X: 50, Y: 50}, 40, 20))
+	if r.Kind != Ellipse || r.Points[0] != (model.Point{X: 50, Y: 30}) {
+		t.Errorf("ellipse recognized as %s starting at %v", r.Kind, r.Points[0])
+	}
+
+	if r := Recognize([]model.Point{{X: 0, Y: 0}, {X: 5, Y: 5}}); r.Kind != None {
+		t.Errorf("tiny stroke recognized as %s", r.Kind)
+	}
+}
+
+func TestArrowHead(t *testing.T) {
+	points := samplePolyline([]model.Point{{X: 0, Y: 0}, {X: 100, Y: 0}, {X: 85, Y: -10}, {X: 100, Y: 0}, {X: 85, Y: 10}}, 2)
+	r := Recognize(points)
+	if r.Kind != Arrow {
+		t.Fatalf("recognized as %s", r.Kind)
+	}
+	if r.Points[0] != (model.Point{}) || r.Points[1] != (model.Point{X: 100}) {
+		t.Errorf("shaft = %v - %v", r.Points[0], r.Points[1])
+	}
+	// 矢じりは軸に対して対称
+	if b1, b2 := r.Points[2], r.Points[4]; b1.X != b2.X || b1.Y != -b2.Y || b1.X >= 100 {
+		t.Errorf("barbs = %v, %v", b1, b2)
+	}
+}
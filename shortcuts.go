@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"goWhiteBoard/config"
+	"goWhiteBoard/stroke"
+	"log"
+	"runtime"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/widget"
+)
+
+// shortcutAction is a command that can be bound to a key
+type shortcutAction struct {
+	ID      string // 設定ファイルに保存する識別子
+	Group   string
+	Name    string
+	Default string // 既定のキー（"Ctrl+Z" の形式、空なら割り当てなし）
+	Run     func()
+}
+
+// keyBinding is a key together with the modifiers held with it
+type keyBinding struct {
+	Key fyne.KeyName
+	Mod fyne.KeyModifier
+}
+
+// modifierNames are the spellings of the modifiers in bindings, in the order
+// they are written
+var modifierNames = []struct {
+	Name string
+	Mod  fyne.KeyModifier
+}{
+	{"Ctrl", fyne.KeyModifierControl},
+	{"Alt", fyne.KeyModifierAlt},
+	{"Shift", fyne.KeyModifierShift},
+	{"Super", fyne.KeyModifierSuper},
+}
+
+// namedKeys are the keys other than letters and digits that can be bound
+var namedKeys = []fyne.KeyName{
+	fyne.KeyEscape, fyne.KeyReturn, fyne.KeyTab, fyne.KeyBackspace, fyne.KeyInsert, fyne.KeyDelete,
+	fyne.KeyRight, fyne.KeyLeft, fyne.KeyDown, fyne.KeyUp, fyne.KeyPageUp, fyne.KeyPageDown,
+	fyne.KeyHome, fyne.KeyEnd, fyne.KeyF1, fyne.KeyF2, fyne.KeyF3, fyne.KeyF4, fyne.KeyF5,
+	fyne.KeyF6, fyne.KeyF7, fyne.KeyF8, fyne.KeyF9, fyne.KeyF10, fyne.KeyF11, fyne.KeyF12,
+	fyne.KeyApostrophe, fyne.KeyComma, fyne.KeyMinus, fyne.KeyPeriod, fyne.KeySlash,
+	fyne.KeyBackslash, fyne.KeyLeftBracket, fyne.KeyRightBracket, fyne.KeySemicolon,
+	fyne.KeyEqual, fyne.KeyBackTick,
+}
+
+// parseShortcut parses a binding such as "Ctrl+Shift+Z" or "F1". Names are
+// not case sensitive.
+func parseShortcut(s string) (keyBinding, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return keyBinding{}, errors.New("empty shortcut")
+	}
+	// "Ctrl+-" のようにキー自体が記号の場合に備えて、最後の区切りで分ける
+	var b keyBinding
+	mods, key := "", s
+	if i := strings.LastIndex(s[:len(s)-1], "+"); i >= 0 {
+		mods, key = s[:i], s[i+1:]
+	}
+	if mods != "" {
+		for _, name := range strings.Split(mods, "+") {
+			mod, ok := parseModifier(strings.TrimSpace(name))
+			if !ok {
+				return keyBinding{}, fmt.Errorf("unknown modifier %q in %q", name, s)
+			}
+			b.Mod |= mod
+		}
+	}
+	b.Key = parseKey(strings.TrimSpace(key))
+	if b.Key == fyne.KeyUnknown {
+		return keyBinding{}, fmt.Errorf("unknown key %q in %q", key, s)
+	}
+	// Shift だけの組み合わせはドライバーがショートカットとして通知しない
+	if b.Mod == fyne.KeyModifierShift {
+		return keyBinding{}, fmt.Errorf("%q: Shift needs Ctrl, Alt or Super as well", s)
+	}
+	return b, nil
+}
+
+func parseModifier(name string) (fyne.KeyModifier, bool) {
+	switch strings.ToLower(name) {
+	case "control":
+		return fyne.KeyModifierControl, true
+	case "cmd", "command":
+		return fyne.KeyModifierSuper, true
+	}
+	for _, m := range modifierNames {
+		if strings.EqualFold(m.Name, name) {
+			return m.Mod, true
+		}
+	}
+	return 0, false
+}
+
+func parseKey(name string) fyne.KeyName {
+	if len(name) == 1 && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z' || name[0] >= '0' && name[0] <= '9') {
+		return fyne.KeyName(strings.ToUpper(name))
+	}
+	switch strings.ToLower(name) {
+	case "enter":
+		return fyne.KeyReturn
+	case "esc":
+		return fyne.KeyEscape
+	}
+	for k, display := range keyDisplayNames {
+		if strings.EqualFold(display, name) {
+			return k
+		}
+	}
+	for _, k := range namedKeys {
+		if strings.EqualFold(string(k), name) {
+			return k
+		}
+	}
+	return fyne.KeyUnknown
+}
+
+// keyDisplayNames are the names shown for keys whose Fyne names are unusual
+var keyDisplayNames = map[fyne.KeyName]string{
+	fyne.KeyPageUp:   "PageUp",
+	fyne.KeyPageDown: "PageDown",
+}
+
+// String formats the binding the way parseShortcut reads it
+func (b keyBinding) String() string {
+	var parts []string
+	for _, m := range modifierNames {
+		if b.Mod&m.Mod != 0 {
+			parts = append(parts, m.Name)
+		}
+	}
+	key := string(b.Key)
+	if display, ok := keyDisplayNames[b.Key]; ok {
+		key = display
+	}
+	return strings.Join(append(parts, key), "+")
+}
+
+// shortcut returns the Fyne shortcut the driver sends for the binding. The
+// driver turns the usual clipboard and undo keys into its own shortcuts, so
+// those have to be registered under their standard names.
+func (b keyBinding) shortcut() fyne.Shortcut {
+	primary := fyne.KeyModifierControl
+	if runtime.GOOS == "darwin" {
+		primary = fyne.KeyModifierSuper
+	}
+	if b.Mod == primary {
+		switch b.Key {
+		case fyne.KeyZ:
+			return &fyne.ShortcutUndo{}
+		case fyne.KeyY:
+			return &fyne.ShortcutRedo{}
+		case fyne.KeyA:
+			return &fyne.ShortcutSelectAll{}
+		case fyne.KeyC, fyne.KeyInsert:
+			return &fyne.ShortcutCopy{}
+		case fyne.KeyX:
+			return &fyne.ShortcutCut{}
+		case fyne.KeyV:
+			return &fyne.ShortcutPaste{}
+		}
+	}
+	return &desktop.CustomShortcut{KeyName: b.Key, Modifier: b.Mod}
+}
+
+// selectPen switches to drawing with a pen preset at its default width. The
+// highlighter does not work in black, so it switches to yellow.
+func selectPen(board *whiteboard, pen stroke.Pen) {
+	board.SetTool(toolDraw)
+	board.SetPen(pen)
+	board.SetLineWidth(pen.Width)
+	if pen.Highlighter && penColorName(board.LineColor()) == "Black" {
+		board.SetLineColor(penColors[4].Color)
+	}
+}
+
+// shortcuts is the registry of actions and the keys bound to them
+type shortcuts struct {
+	window   fyne.Window
+	actions  []*shortcutAction
+	bindings map[string]string // アクション ID → キー（ユーザーが変更したものを含む）
+
+	installed []fyne.Shortcut
+	plain     map[fyne.KeyName]*shortcutAction // 修飾キーなしのキー
+}
+
+// newShortcuts creates the registry with the default bindings overridden by
+// the ones saved by the user, and installs it in the window
+func newShortcuts(w fyne.Window, actions []*shortcutAction) *shortcuts {
+	s := &shortcuts{window: w, actions: actions, bindings: map[string]string{}}
+	for _, a := range actions {
+		s.bindings[a.ID] = a.Default
+	}
+	saved, err := config.LoadBindings()
+	if err != nil {
+		log.Printf("shortcuts: %v", err)
+	}
+	for id, key := range saved {
+		if s.action(id) == nil {
+			continue
+		}
+		if key != "" {
+			if _, err := parseShortcut(key); err != nil {
+				log.Printf("shortcuts: %s: %v", id, err)
+				continue
+			}
+		}
+		s.bindings[id] = key
+	}
+
+	w.Canvas().SetOnTypedKey(func(ke *fyne.KeyEvent) {
+		if a := s.plain[ke.Name]; a != nil {
+			a.Run()
+		}
+	})
+	s.install()
+	return s
+}
+
+func (s *shortcuts) action(id string) *shortcutAction {
+	for _, a := range s.actions {
+		if a.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// Binding returns the key bound to the action, or "" if there is none
+func (s *shortcuts) Binding(id string) string {
+	return s.bindings[id]
+}
+
+// install registers the current bindings with the canvas, replacing the
+// previous ones
+func (s *shortcuts) install() {
+	c := s.window.Canvas()
+	for _, sc := range s.installed {
+		c.RemoveShortcut(sc)
+	}
+	s.installed = nil
+	s.plain = map[fyne.KeyName]*shortcutAction{}
+
+	for _, a := range s.actions {
+		b, err := parseShortcut(s.bindings[a.ID])
+		if err != nil {
+			continue
+		}
+		if b.Mod == 0 {
+			s.plain[b.Key] = a
+			continue
+		}
+		sc := b.shortcut()
+		run := a.Run
+		c.AddShortcut(sc, func(fyne.Shortcut) { run() })
+		s.installed = append(s.installed, sc)
+	}
+}
+
+// Rebind replaces every binding, keyed by action ID, saves the ones that
+// differ from the defaults and installs them. Nothing changes if a binding
+// is invalid or a key is bound twice.
+func (s *shortcuts) Rebind(bindings map[string]string) error {
+	used := map[keyBinding]string{}
+	for _, a := range s.actions {
+		key := strings.TrimSpace(bindings[a.ID])
+		if key == "" {
+			continue
+		}
+		b, err := parseShortcut(key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.Name, err)
+		}
+		if other, ok := used[b]; ok {
+			return fmt.Errorf("%s is bound to both %s and %s", b, other, a.Name)
+		}
+		used[b] = a.Name
+	}
+
+	changed := map[string]string{}
+	for _, a := range s.actions {
+		key := strings.TrimSpace(bindings[a.ID])
+		if key != "" {
+			b, _ := parseShortcut(key)
+			key = b.String()
+		}
+		s.bindings[a.ID] = key
+		if key != a.Default {
+			changed[a.ID] = key
+		}
+	}
+	s.install()
+	return config.SaveBindings(changed)
+}
+
+// ShowCheatSheet lists every action with its key
+func (s *shortcuts) ShowCheatSheet() {
+	list := container.NewVBox()
+	group := ""
+	for _, a := range s.actions {
+		if a.Group != group {
+			group = a.Group
+			heading := widget.NewLabel(group)
+			heading.TextStyle = fyne.TextStyle{Bold: true}
+			list.Add(heading)
+		}
+		key := s.bindings[a.ID]
+		if key == "" {
+			key = "—"
+		}
+		list.Add(container.NewGridWithColumns(2, widget.NewLabel(a.Name), widget.NewLabel(key)))
+	}
+	d := dialog.NewCustom("Keyboard Shortcuts", "Close", container.NewVScroll(list), s.window)
+	d.Resize(fyne.NewSize(420, 560))
+	d.Show()
+}
+
+// ShowEditor lets the user change the bindings
+func (s *shortcuts) ShowEditor() {
+	entries := map[string]*widget.Entry{}
+	form := widget.NewForm()
+	for _, a := range s.actions {
+		e := widget.NewEntry()
+		e.SetText(s.bindings[a.ID])
+		e.SetPlaceHolder("none")
+		entries[a.ID] = e
+		form.Append(a.Name, e)
+	}
+	resetButton := widget.NewButton("Reset to Defaults", func() {
+		for _, a := range s.actions {
+			entries[a.ID].SetText(a.Default)
+		}
+	})
+
+	var d dialog.Dialog
+	form.SubmitText = "Save"
+	form.OnSubmit = func() {
+		bindings := map[string]string{}
+		for id, e := range entries {
+			bindings[id] = e.Text
+		}
+		if err := s.Rebind(bindings); err != nil {
+			dialog.ShowError(err, s.window)
+			return
+		}
+		d.Hide()
+	}
+	form.OnCancel = func() {
+		d.Hide()
+	}
+
+	hint := widget.NewLabel("Examples: Ctrl+Shift+Z, Alt+1, F5, P. Leave empty to unbind.")
+	content := container.NewBorder(hint, resetButton, nil, nil, container.NewVScroll(form))
+	d = dialog.NewCustomWithoutButtons("Shortcuts", content, s.window)
+	d.Resize(fyne.NewSize(420, 620))
+	d.Show()
+}
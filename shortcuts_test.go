@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/test"
+)
+
+func TestParseShortcut(t *testing.T) {
+	tests := []struct {
+		in   string
+		want keyBinding
+		text string
+	}{
+		{"Ctrl+Z", keyBinding{fyne.KeyZ, fyne.KeyModifierControl}, "Ctrl+Z"},
+		{"shift+ctrl+z", keyBinding{fyne.KeyZ, fyne.KeyModifierControl | fyne.KeyModifierShift}, "Ctrl+Shift+Z"},
+		{"Ctrl+-", keyBinding{fyne.KeyMinus, fyne.KeyModifierControl}, "Ctrl+-"},
+		{"Ctrl+Enter", keyBinding{fyne.KeyReturn, fyne.KeyModifierControl}, "Ctrl+Return"},
+		{" esc ", keyBinding{fyne.KeyEscape, 0}, "Escape"},
+		{"f1", keyBinding{fyne.KeyF1, 0}, "F1"},
+		{"3", keyBinding{fyne.Key3, 0}, "3"},
+		{"Ctrl+pagedown", keyBinding{fyne.KeyPageDown, fyne.KeyModifierControl}, "Ctrl+PageDown"},
+		{"Ctrl+Prior", keyBinding{fyne.KeyPageUp, fyne.KeyModifierControl}, "Ctrl+PageUp"},
+	}
+	for _, tt := range tests {
+		got, err := parseShortcut(tt.in)
+		if err != nil || got != tt.want || got.String() != tt.text {
+			t.Errorf("parseShortcut(%q) = %v (%s), %v", tt.in, got, got, err)
+		}
+	}
+
+	for _, bad := range []string{"", "Ctrl+", "Hyper+A", "Ctrl+Foo", "Shift+A"} {
+		if _, err := parseShortcut(bad); err == nil {
+			t.Errorf("parseShortcut(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	test.NewTempApp(t)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	w := test.NewWindow(nil)
+	defer w.Close()
+
+	var undone, cleared int
+	actions := []*shortcutAction{
+		{ID: "undo", Name: "Undo", Default: "Ctrl+Z", Run: func() { undone++ }},
+		{ID: "clear", Name: "Clear", Default: "Ctrl+K", Run: func() { cleared++ }},
+	}
+	keys := newShortcuts(w, actions)
+	// Ctrl+Z はドライバーが標準の Undo として送る
+	w.Canvas().(fyne.Shortcutable).TypedShortcut(&fyne.ShortcutUndo{})
+	if undone != 1 {
+		t.Fatalf("undo ran %d times", undone)
+	}
+
+	err := keys.Rebind(map[string]string{"undo": "Ctrl+K", "clear": "ctrl+k"})
+	if err == nil {
+		t.Fatal("duplicate binding accepted")
+	}
+	if err := keys.Rebind(map[string]string{"undo": "alt+u", "clear": ""}); err != nil {
+		t.Fatal(err)
+	}
+	w.Canvas().(fyne.Shortcutable).TypedShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyU, Modifier: fyne.KeyModifierAlt})
+	w.Canvas().(fyne.Shortcutable).TypedShortcut(&fyne.ShortcutUndo{})
+	if undone != 2 {
+		t.Errorf("after rebinding undo ran %d times", undone)
+	}
+
+	// 変更は保存され、次に起動したときに使われる
+	keys = newShortcuts(w, actions)
+	if keys.Binding("undo") != "Alt+U" || keys.Binding("clear") != "" {
+		t.Errorf("saved bindings: undo %q, clear %q", keys.Binding("undo"), keys.Binding("clear"))
+	}
+}
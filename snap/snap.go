@@ -0,0 +1,192 @@
+// Package snap aligns shapes and moved objects to the grid and to the edges
+// and centres of other objects, and describes the alignment guides to show
+// while dragging.
+package snap
+
+import (
+	"goWhiteBoard/model"
+	"goWhiteBoard/shape"
+	"math"
+)
+
+// Rect is an axis-aligned rectangle in world coordinates
+type Rect struct {
+	Min, Max model.Point
+}
+
+// Bounds returns the rectangle covering lines including their width
+func Bounds(lines []model.Line) (Rect, bool) {
+	min, max, ok := model.Snapshot{Lines: lines}.Bounds()
+	return Rect{Min: min, Max: max}, ok
+}
+
+// Translate returns r moved by d
+func (r Rect) Translate(d model.Point) Rect {
+	return Rect{
+		Min: model.Point{X: r.Min.X + d.X, Y: r.Min.Y + d.Y},
+		Max: model.Point{X: r.Max.X + d.X, Y: r.Max.Y + d.Y},
+	}
+}
+
+// Contains reports whether o lies completely inside r
+func (r Rect) Contains(o Rect) bool {
+	return o.Min.X >= r.Min.X && o.Min.Y >= r.Min.Y && o.Max.X <= r.Max.X && o.Max.Y <= r.Max.Y
+}
+
+// Has reports whether p lies inside r
+func (r Rect) Has(p model.Point) bool {
+	return p.X >= r.Min.X && p.Y >= r.Min.Y && p.X <= r.Max.X && p.Y <= r.Max.Y
+}
+
+// xs and ys return the left edge, centre and right edge (top, middle and
+// bottom) that are aligned
+func (r Rect) xs() [3]float32 { return [3]float32{r.Min.X, (r.Min.X + r.Max.X) / 2, r.Max.X} }
+func (r Rect) ys() [3]float32 { return [3]float32{r.Min.Y, (r.Min.Y + r.Max.Y) / 2, r.Max.Y} }
+
+// Guide is an alignment line shown while dragging. A vertical guide runs
+// along x = Pos from y = From to y = To; a horizontal one along y = Pos.
+type Guide struct {
+	Vertical bool
+	Pos      float32
+	From, To float32
+}
+
+// Result is the correction that aligns a moved rectangle
+type Result struct {
+	Offset model.Point // 加える補正
+	// SnappedX and SnappedY report on which axes an alignment was found
+	SnappedX, SnappedY bool
+	Guides             []Guide
+}
+
+// Objects finds the offset within tolerance that lines up an edge or the
+// centre of moving with an edge or centre of one of others, separately on
+// each axis, and the guides that show every alignment it makes
+func Objects(moving Rect, others []Rect, tolerance float32) Result {
+	var res Result
+	bestX, bestY := tolerance, tolerance
+	for _, o := range others {
+		for _, mx := range moving.xs() {
+			for _, ox := range o.xs() {
+				if d := ox - mx; abs(d) <= bestX {
+					bestX, res.Offset.X, res.SnappedX = abs(d), d, true
+				}
+			}
+		}
+		for _, my := range moving.ys() {
+			for _, oy := range o.ys() {
+				if d := oy - my; abs(d) <= bestY {
+					bestY, res.Offset.Y, res.SnappedY = abs(d), d, true
+				}
+			}
+		}
+	}
+	if !res.SnappedX && !res.SnappedY {
+		return res
+	}
+
+	// 補正後に揃っているものすべてにガイドを引く
+	moved := moving.Translate(res.Offset)
+	for _, o := range others {
+		for _, mx := range moved.xs() {
+			for _, ox := range o.xs() {
+				if res.SnappedX && abs(ox-mx) < 0.01 {
+					res.Guides = addGuide(res.Guides, Guide{Vertical: true, Pos: ox,
+						From: min(moved.Min.Y, o.Min.Y), To: max(moved.Max.Y, o.Max.Y)})
+				}
+			}
+		}
+		for _, my := range moved.ys() {
+			for _, oy := range o.ys() {
+				if res.SnappedY && abs(oy-my) < 0.01 {
+					res.Guides = addGuide(res.Guides, Guide{Pos: oy,
+						From: min(moved.Min.X, o.Min.X), To: max(moved.Max.X, o.Max.X)})
+				}
+			}
+		}
+	}
+	return res
+}
+
+// addGuide adds g, merging it with a guide along the same line
+func addGuide(guides []Guide, g Guide) []Guide {
+	for i, o := range guides {
+		if o.Vertical == g.Vertical && abs(o.Pos-g.Pos) < 0.01 {
+			guides[i].From, guides[i].To = min(o.From, g.From), max(o.To, g.To)
+			return guides
+		}
+	}
+	return append(guides, g)
+}
+
+// Grid returns the offset that moves the top-left corner of r onto the
+// nearest grid point
+func Grid(r Rect, g model.Grid) model.Point {
+	p := g.Snap(r.Min)
+	return model.Point{X: p.X - r.Min.X, Y: p.Y - r.Min.Y}
+}
+
+// Shape snaps the outline of a recognized shape to the grid: the ends of
+// lines and arrows and the corners of triangles go to the nearest grid
+// points, and the other shapes are stretched so their bounds do
+func Shape(r shape.Result, g model.Grid) []model.Point {
+	points := append([]model.Point(nil), r.Points...)
+	if !g.Visible() || len(points) == 0 {
+		return points
+	}
+	switch r.Kind {
+	case shape.Line, shape.Triangle:
+		for i, p := range points {
+			points[i] = g.Snap(p)
+		}
+		return points
+	case shape.Arrow:
+		// 始点 → 先端 → 矢じり → 先端 → 矢じり。矢じりは先端について回転する
+		oldStart, oldTip := points[0], points[1]
+		start, tip := g.Snap(oldStart), g.Snap(oldTip)
+		before := math.Atan2(float64(oldStart.Y-oldTip.Y), float64(oldStart.X-oldTip.X))
+		after := math.Atan2(float64(start.Y-tip.Y), float64(start.X-tip.X))
+		sin, cos := math.Sincos(after - before)
+		for i, p := range points {
+			dx, dy := float64(p.X-oldTip.X), float64(p.Y-oldTip.Y)
+			points[i] = model.Point{X: tip.X + float32(dx*cos-dy*sin), Y: tip.Y + float32(dx*sin+dy*cos)}
+		}
+		points[0], points[1], points[3] = start, tip, tip
+		return points
+	}
+
+	min, max := points[0], points[0]
+	for _, p := range points {
+		min = model.Point{X: float32(math.Min(float64(min.X), float64(p.X))), Y: float32(math.Min(float64(min.Y), float64(p.Y)))}
+		max = model.Point{X: float32(math.Max(float64(max.X), float64(p.X))), Y: float32(math.Max(float64(max.Y), float64(p.Y)))}
+	}
+	newMin, newMax := g.Snap(min), g.Snap(max)
+	// 潰れてしまう場合は格子 1 つ分の大きさにする
+	if newMax.X <= newMin.X {
+		newMax.X = newMin.X + g.Spacing
+	}
+	if newMax.Y <= newMin.Y {
+		newMax.Y = newMin.Y + g.RowHeight()
+	}
+	for i, p := range points {
+		points[i] = model.Point{
+			X: newMin.X + (p.X-min.X)/nonZero(max.X-min.X)*(newMax.X-newMin.X),
+			Y: newMin.Y + (p.Y-min.Y)/nonZero(max.Y-min.Y)*(newMax.Y-newMin.Y),
+		}
+	}
+	return points
+}
+
+func nonZero(f float32) float32 {
+	if f == 0 {
+		return 1
+	}
+	return f
+}
+
+func abs(f float32) float32 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
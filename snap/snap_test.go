@@ -0,0 +1,53 @@
+package snap
+
+import (
+	"goWhiteBoard/model"
+	"goWhiteBoard/shape"
+	"testing"
+)
+
+func rect(x0, y0, x1, y1 float32) Rect {
+	return Rect{Min: model.Point{X: x0, Y: y0}, Max: model.Point{X: x1, Y: y1}}
+}
+
+func TestObjects(t *testing.T) {
+	others := []Rect{rect(0, 0, 100, 50), rect(300, 200, 400, 260)}
+
+	// 左端が 3 ずれている → 揃える。縦は近いものがない
+	r := Objects(rect(3, 120, 63, 170), others, 5)
+	if !r.SnappedX || r.SnappedY || r.Offset != (model.Point{X: -3}) {
+		t.Fatalf("left edges: %+v", r)
+	}
+	if len(r.Guides) != 1 || !r.Guides[0].Vertical || r.Guides[0].Pos != 0 || r.Guides[0].From != 0 || r.Guides[0].To != 170 {
+		t.Errorf("guides: %+v", r.Guides)
+	}
+
+	// 中心同士を揃える
+	r = Objects(rect(320, 102, 380, 142), others, 5)
+	if !r.SnappedX || r.Offset.X != 0 {
+		t.Errorf("centres: %+v", r)
+	}
+
+	if r := Objects(rect(150, 120, 160, 130), others, 5); r.SnappedX || r.SnappedY || len(r.Guides) != 0 {
+		t.Errorf("snapped to nothing: %+v", r)
+	}
+}
+
+func TestShape(t *testing.T) {
+	g := model.Grid{Style: model.GridSquares, Spacing: 10}
+	box := shape.Result{Kind: shape.Rectangle, Points: []model.Point{{X: 3, Y: 2}, {X: 48, Y: 2}, {X: 48, Y: 29}, {X: 3, Y: 29}, {X: 3, Y: 2}}}
+	want := []model.Point{{X: 0, Y: 0}, {X: 50, Y: 0}, {X: 50, Y: 30}, {X: 0, Y: 30}, {X: 0, Y: 0}}
+	got := Shape(box, g)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("rectangle: %v", got)
+		}
+	}
+
+	// 矢印は始点と先端が格子に乗り、矢じりは先端について回る
+	arrow := shape.Result{Kind: shape.Arrow, Points: []model.Point{{X: 1, Y: 1}, {X: 41, Y: 1}, {X: 33, Y: -4}, {X: 41, Y: 1}, {X: 33, Y: 6}}}
+	got = Shape(arrow, g)
+	if got[0] != (model.Point{}) || got[1] != (model.Point{X: 40}) || got[3] != got[1] || got[2] != (model.Point{X: 32, Y: -5}) {
+		t.Errorf("arrow: %v", got)
+	}
+}
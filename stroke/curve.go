@@ -0,0 +1,81 @@
+package stroke
+
+import (
+	"goWhiteBoard/model"
+	"math"
+)
+
+// Bezier is one cubic Bézier segment from P0 to P3
+type Bezier struct {
+	P0, C1, C2, P3 model.Point
+}
+
+// Curve returns the cubic Bézier segments of the Catmull-Rom spline through
+// points (uniform, tension 0.5). The curve passes through every point; the
+// end segments use the end points as their missing neighbours.
+func Curve(points []model.Point) []Bezier {
+	if len(points) < 2 {
+		return nil
+	}
+	segments := make([]Bezier, 0, len(points)-1)
+	for i := 0; i+1 < len(points); i++ {
+		segments = append(segments, Segment(points, i))
+	}
+	return segments
+}
+
+// Segment returns the Bézier segment between points[i] and points[i+1]
+func Segment(points []model.Point, i int) Bezier {
+	p0 := points[max(i-1, 0)]
+	p1 := points[i]
+	p2 := points[i+1]
+	p3 := points[min(i+2, len(points)-1)]
+	return Bezier{
+		P0: p1,
+		C1: model.Point{X: p1.X + (p2.X-p0.X)/6, Y: p1.Y + (p2.Y-p0.Y)/6},
+		C2: model.Point{X: p2.X - (p3.X-p1.X)/6, Y: p2.Y - (p3.Y-p1.Y)/6},
+		P3: p2,
+	}
+}
+
+// At returns the point at t in [0, 1]. Pressure is interpolated linearly
+// between the end points.
+func (b Bezier) At(t float32) model.Point {
+	u := 1 - t
+	a, c, d, e := u*u*u, 3*u*u*t, 3*u*t*t, t*t*t
+	return model.Point{
+		X: a*b.P0.X + c*b.C1.X + d*b.C2.X + e*b.P3.X,
+		Y: a*b.P0.Y + c*b.C1.Y + d*b.C2.Y + e*b.P3.Y,
+		P: u*b.P0.P + t*b.P3.P,
+	}
+}
+
+// Flatten appends points along b to dst, without b.P0, about step apart.
+// The result of flattening consecutive segments is a polyline through the
+// curve.
+func (b Bezier) Flatten(dst []model.Point, step float32) []model.Point {
+	// 制御点の折れ線の長さは曲線の長さの上限になる
+	length := distance(b.P0, b.C1) + distance(b.C1, b.C2) + distance(b.C2, b.P3)
+	n := 1
+	if step > 0 {
+		n = int(math.Ceil(float64(length / step)))
+	}
+	n = max(1, min(n, 64))
+	for i := 1; i <= n; i++ {
+		dst = append(dst, b.At(float32(i)/float32(n)))
+	}
+	return dst
+}
+
+// Sample returns a dense polyline that follows the curve through points
+func Sample(points []model.Point, step float32) []model.Point {
+	if len(points) < 3 {
+		return points
+	}
+	out := make([]model.Point, 1, len(points)*4)
+	out[0] = points[0]
+	for _, b := range Curve(points) {
+		out = b.Flatten(out, step)
+	}
+	return out
+}
@@ -0,0 +1,29 @@
+package stroke
+
+import "image/color"
+
+// Pen is a preset for new strokes
+type Pen struct {
+	Name  string
+	Width float32 // 既定の太さ
+	// Opacity scales the alpha of the chosen colour
+	Opacity uint8
+	// Highlighter strokes are drawn beneath ink with the multiply blend mode
+	Highlighter bool
+	// Key is the default shortcut that picks the pen; empty for none
+	Key string
+}
+
+// Pens lists the presets offered in the settings
+var Pens = []Pen{
+	{Name: "Pen", Width: 2, Opacity: 255, Key: "P"},
+	{Name: "Marker", Width: 6, Opacity: 220, Key: "M"},
+	{Name: "Highlighter", Width: 16, Opacity: 100, Highlighter: true, Key: "H"},
+}
+
+// Color returns c with the pen's opacity applied
+func (p Pen) Color(c color.Color) color.Color {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = uint8(uint32(n.A) * uint32(p.Opacity) / 255)
+	return n
+}
@@ -0,0 +1,201 @@
+// Package stroke turns raw pointer samples into clean strokes: a one-euro
+// filter smooths the input while drawing, Ramer–Douglas–Peucker drops
+// redundant points when the stroke ends, and Catmull-Rom splines render the
+// remaining points as a smooth curve.
+package stroke
+
+import (
+	"goWhiteBoard/model"
+	"math"
+)
+
+// Smoothing presets for the input filter
+type Smoothing int
+
+const (
+	SmoothingOff Smoothing = iota
+	SmoothingLight
+	SmoothingStrong
+)
+
+// Options configures the input pipeline
+type Options struct {
+	Smoothing Smoothing
+	// Tolerance is the largest distance (in screen pixels) a point may be
+	// moved by simplification. 0 keeps every point.
+	Tolerance float32
+	// Curves renders strokes as splines through their points instead of
+	// straight segments.
+	Curves bool
+	// Pressure varies the width of strokes with the drawing speed. Fyne does
+	// not report tablet pressure, so it is simulated; strokes from the API
+	// can carry real pressure.
+	Pressure bool
+}
+
+// DefaultOptions is the pipeline used unless the user changes it
+var DefaultOptions = Options{Smoothing: SmoothingLight, Tolerance: 1, Curves: true}
+
+// Filter returns a new input filter for one stroke, or nil for no smoothing
+func (o Options) Filter() *OneEuro {
+	switch o.Smoothing {
+	case SmoothingLight:
+		return NewOneEuro(3, 0.02)
+	case SmoothingStrong:
+		return NewOneEuro(1, 0.005)
+	}
+	return nil
+}
+
+// OneEuro is the 1€ filter (Casiez et al.): a low-pass filter whose cutoff
+// rises with speed, so slow movements lose their jitter while fast ones do
+// not lag. Times are in seconds, positions in screen pixels.
+type OneEuro struct {
+	MinCutoff float64 // Hz; lower means smoother slow strokes
+	Beta      float64 // how quickly the cutoff rises with speed
+	DCutoff   float64 // Hz; cutoff for the speed estimate
+
+	started bool
+	last    float64
+	x, dx   [2]float64
+}
+
+// NewOneEuro creates a filter with the given parameters
+func NewOneEuro(minCutoff, beta float64) *OneEuro {
+	return &OneEuro{MinCutoff: minCutoff, Beta: beta, DCutoff: 1}
+}
+
+// Filter returns the smoothed position of a sample taken at time t
+func (f *OneEuro) Filter(p model.Point, t float64) model.Point {
+	in := [2]float64{float64(p.X), float64(p.Y)}
+	if !f.started || t <= f.last {
+		if !f.started {
+			f.x, f.dx = in, [2]float64{}
+			f.started = true
+		}
+		f.last = t
+		return model.Point{X: float32(f.x[0]), Y: float32(f.x[1])}
+	}
+
+	dt := t - f.last
+	f.last = t
+	ad := alpha(f.DCutoff, dt)
+	for i := range in {
+		d := (in[i] - f.x[i]) / dt
+		f.dx[i] += ad * (d - f.dx[i])
+		a := alpha(f.MinCutoff+f.Beta*math.Abs(f.dx[i]), dt)
+		f.x[i] += a * (in[i] - f.x[i])
+	}
+	return model.Point{X: float32(f.x[0]), Y: float32(f.x[1])}
+}
+
+func alpha(cutoff, dt float64) float64 {
+	tau := 1 / (2 * math.Pi * cutoff)
+	return 1 / (1 + tau/dt)
+}
+
+// pressureTolerance is how far a point's pressure may differ from the
+// pressure interpolated along the simplified line
+const pressureTolerance = 0.08
+
+// Simplify removes points that lie within tolerance of the polyline through
+// the remaining ones (Ramer–Douglas–Peucker). The end points are kept, and
+// so are points where the pressure changes noticeably.
+func Simplify(points []model.Point, tolerance float32) []model.Point {
+	if len(points) < 3 || tolerance <= 0 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// 再帰の代わりに区間のスタックで処理する（長いストロークでも安全）
+	type span struct{ first, last int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		// 位置と筆圧のずれをそれぞれの許容値で割った大きい方で判定する
+		farthest, worst := -1, float32(1)
+		for i := s.first + 1; i < s.last; i++ {
+			if d := deviation(points[i], points[s.first], points[s.last], tolerance); d > worst {
+				farthest, worst = i, d
+			}
+		}
+		if farthest >= 0 {
+			keep[farthest] = true
+			stack = append(stack, span{s.first, farthest}, span{farthest, s.last})
+		}
+	}
+
+	out := make([]model.Point, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// deviation returns how far p is from the segment a-b relative to the
+// tolerances; above 1 means p has to be kept
+func deviation(p, a, b model.Point, tolerance float32) float32 {
+	d, t := project(p, a, b)
+	dp := float32(math.Abs(float64(p.P - (a.P + t*(b.P-a.P)))))
+	return max(d/tolerance, dp/pressureTolerance)
+}
+
+// segmentDistance returns the distance from p to the segment a-b
+func segmentDistance(p, a, b model.Point) float32 {
+	d, _ := project(p, a, b)
+	return d
+}
+
+// project returns the distance from p to the segment a-b and the position
+// of the closest point along it in [0, 1]
+func project(p, a, b model.Point) (float32, float32) {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	length := dx*dx + dy*dy
+	t := float32(0)
+	if length > 0 {
+		t = ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / length
+		t = float32(math.Max(0, math.Min(1, float64(t))))
+	}
+	return distance(p, model.Point{X: a.X + t*dx, Y: a.Y + t*dy}), t
+}
+
+// SpeedPressure simulates pen pressure from the drawing speed: slow strokes
+// get heavier, fast ones lighter, like a brush pen. Positions are in screen
+// pixels and times in seconds.
+type SpeedPressure struct {
+	started  bool
+	last     model.Point
+	lastTime float64
+	pressure float32
+}
+
+// nominalSpeed is the speed (pixels per second) drawn at the nominal width
+const nominalSpeed = 400
+
+// Next returns the pressure for a sample at p taken at time t
+func (s *SpeedPressure) Next(p model.Point, t float64) float32 {
+	if !s.started {
+		s.started, s.last, s.lastTime, s.pressure = true, p, t, 0.5
+		return s.pressure
+	}
+	dt := t - s.lastTime
+	if dt <= 0 {
+		return s.pressure
+	}
+	speed := float64(distance(s.last, p)) / dt
+	s.last, s.lastTime = p, t
+
+	// 急に太さが変わらないように指数移動平均をとる
+	target := float32(1 / (1 + speed/nominalSpeed))
+	s.pressure += 0.3 * (target - s.pressure)
+	return s.pressure
+}
+
+func distance(a, b model.Point) float32 {
+	return float32(math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y)))
+}
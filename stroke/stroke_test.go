@@ -0,0 +1,129 @@
+package stroke
+
+import (
+	"goWhiteBoard/model"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	// ほぼ直線の途中の点は消え、角は残る
+	points := []model.Point{{X: 0, Y: 0}, {X: 10, Y: 0.4}, {X: 20, Y: -0.3}, {X: 30, Y: 0}, {X: 30, Y: 10}, {X: 30.2, Y: 20}}
+	got := Simplify(points, 1)
+	want := []model.Point{{X: 0, Y: 0}, {X: 30, Y: 0}, {X: 30.2, Y: 20}}
+	if len(got) != len(want) {
+		t.Fatalf("Simplify = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Simplify = %v, want %v", got, want)
+		}
+	}
+
+	if got := Simplify(points, 0); len(got) != len(points) {
+		t.Errorf("tolerance 0 dropped points: %v", got)
+	}
+}
+
+func TestSimplifyStaysWithinTolerance(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points := make([]model.Point, 500)
+	for i := range points {
+		points[i] = model.Point{X: float32(i), Y: float32(20*math.Sin(float64(i)/30) + rng.Float64())}
+	}
+	const tolerance = 1.5
+	simplified := Simplify(points, tolerance)
+	if len(simplified) >= len(points)/4 {
+		t.Errorf("only reduced %d points to %d", len(points), len(simplified))
+	}
+
+	// 元の各点は簡略化した折れ線から tolerance 以内にある
+	for _, p := range points {
+		best := float32(math.Inf(1))
+		for i := 1; i < len(simplified); i++ {
+			best = min(best, segmentDistance(p, simplified[i-1], simplified[i]))
+		}
+		if best > tolerance+1e-3 {
+			t.Fatalf("point %v is %.2f away from the simplified stroke", p, best)
+		}
+	}
+}
+
+func TestSimplifyKeepsPressureChanges(t *testing.T) {
+	// 直線上でも筆圧の変化が折れる点は残る
+	points := []model.Point{{X: 0, P: 0.5}, {X: 10, P: 0.6}, {X: 20, P: 0.7}, {X: 30, P: 0.7}, {X: 40, P: 0.7}}
+	got := Simplify(points, 1)
+	if len(got) != 3 || got[1] != points[2] {
+		t.Errorf("Simplify = %v", got)
+	}
+}
+
+func TestSpeedPressure(t *testing.T) {
+	var slow, fast SpeedPressure
+	var ps, pf float32
+	for i := 0; i < 30; i++ {
+		ps = slow.Next(model.Point{X: float32(i)}, float64(i)/60)
+		pf = fast.Next(model.Point{X: float32(i) * 30}, float64(i)/60)
+	}
+	if ps <= 0.8 || pf >= 0.4 || pf <= 0 {
+		t.Errorf("pressure slow = %.2f, fast = %.2f", ps, pf)
+	}
+}
+
+func TestOneEuroSmoothsJitter(t *testing.T) {
+	f := NewOneEuro(1, 0.005)
+	rng := rand.New(rand.NewSource(2))
+	var spread float32
+	for i := 0; i < 200; i++ {
+		// 静止したペンに ±2px のノイズ
+		p := f.Filter(model.Point{X: 100 + float32(rng.Float64()*4-2), Y: 50}, float64(i)/120)
+		if i > 20 {
+			spread = max(spread, float32(math.Abs(float64(p.X-100))))
+		}
+	}
+	if spread > 1 {
+		t.Errorf("filtered jitter = %.2f px", spread)
+	}
+
+	// 速い動きにはほぼ遅れずについていく
+	f = NewOneEuro(1, 0.005)
+	var p model.Point
+	for i := 0; i < 60; i++ {
+		p = f.Filter(model.Point{X: float32(i) * 20}, float64(i)/120)
+	}
+	if lag := 59*20 - p.X; lag > 60 {
+		t.Errorf("lag while moving fast = %.1f px", lag)
+	}
+}
+
+func TestCurvePassesThroughPoints(t *testing.T) {
+	points := []model.Point{{X: 0, Y: 0}, {X: 10, Y: 10}, {X: 20, Y: 0}, {X: 30, Y: 10}}
+	segments := Curve(points)
+	if len(segments) != 3 {
+		t.Fatalf("%d segments", len(segments))
+	}
+	for i, s := range segments {
+		if s.At(0) != points[i] || s.At(1) != points[i+1] {
+			t.Errorf("segment %d runs from %v to %v", i, s.At(0), s.At(1))
+		}
+	}
+	// 内側の点では接線が連続している
+	for i := 1; i < len(segments); i++ {
+		in := model.Point{X: segments[i-1].P3.X - segments[i-1].C2.X, Y: segments[i-1].P3.Y - segments[i-1].C2.Y}
+		out := model.Point{X: segments[i].C1.X - segments[i].P0.X, Y: segments[i].C1.Y - segments[i].P0.Y}
+		if in != out {
+			t.Errorf("kink at point %d: %v vs %v", i, in, out)
+		}
+	}
+
+	sampled := Sample(points, 1)
+	if sampled[0] != points[0] || sampled[len(sampled)-1] != points[len(points)-1] {
+		t.Errorf("sampled curve does not keep the end points")
+	}
+	for i := 1; i < len(sampled); i++ {
+		if d := distance(sampled[i-1], sampled[i]); d > 1.5 {
+			t.Fatalf("chord %d is %.2f long", i, d)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package util
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Artifact kinds reported by ExtractArtifact
+const (
+	ArtifactHTML    = "html"
+	ArtifactSVG     = "svg"
+	ArtifactMermaid = "mermaid"
+	ArtifactText    = "text"
+)
+
+// CodeBlock is a fenced code block found in the reply.
+type CodeBlock struct {
+	Language string
+	Code     string
+}
+
+// Extraction は応答から取り出した表示用のコンテンツと、その取り出し方の情報
+type Extraction struct {
+	Kind    string      // one of the Artifact* constants
+	Source  string      // how the content was found, e.g. "```html block 2 of 3"
+	Content string      // HTML document ready for SanitizeHTML
+	Raw     string      // the full reply text
+	Blocks  []CodeBlock // every fenced block in the reply
+	// NeedsScripts is set when the content only renders with JavaScript
+	// enabled (Mermaid), i.e. under TrustedPolicy.
+	NeedsScripts bool
+}
+
+// Summary returns a one-line description for the status bar.
+func (e Extraction) Summary() string {
+	return fmt.Sprintf("%s from %s", e.Kind, e.Source)
+}
+
+var (
+	fenceOpen    = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([\\w+#.-]*)")
+	htmlDocument = regexp.MustCompile(`(?is)(<!doctype\s+html.*?</html\s*>|<html[\s>].*?</html\s*>)`)
+	htmlOpen     = regexp.MustCompile(`(?is)(<!doctype\s+html|<html[\s>]).*`)
+	svgDocument  = regexp.MustCompile(`(?is)<svg[\s>].*?</svg\s*>`)
+)
+
+// ResponseText concatenates every text content block of the reply.
+func ResponseText(response ResponseBody) string {
+	var texts []string
+	for _, item := range response.Content {
+		if item.Type == "text" && item.Text != "" {
+			texts = append(texts, item.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ParseCodeBlocks returns the fenced code blocks in text in order. A block
+// left open at the end of the text (a truncated reply) runs to the end.
+func ParseCodeBlocks(text string) []CodeBlock {
+	var blocks []CodeBlock
+	var current *CodeBlock
+	var fence string
+	var body []string
+
+	for _, l := range strings.Split(text, "\n") {
+		if current == nil {
+			if m := fenceOpen.FindStringSubmatch(l); m != nil {
+				current = &CodeBlock{Language: strings.ToLower(m[2])}
+				fence = m[1]
+				body = nil
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			current.Code = strings.Join(body, "\n")
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+		body = append(body, l)
+	}
+	if current != nil {
+		current.Code = strings.Join(body, "\n")
+		blocks = append(blocks, *current)
+	}
+	return blocks
+}
+
+// ExtractArtifact picks the renderable part of a reply. Fenced blocks are
+// preferred in the order html, svg, mermaid; without fences it looks for an
+// HTML or SVG document in the prose, and falls back to showing the text.
+func ExtractArtifact(text string) Extraction {
+	e := Extraction{Raw: text, Blocks: ParseCodeBlocks(text)}
+
+	for _, lang := range []string{ArtifactHTML, ArtifactSVG, ArtifactMermaid} {
+		idx := findBlocks(e.Blocks, lang)
+		if len(idx) == 0 {
+			continue
+		}
+		e.Kind = lang
+		e.Source = describeBlocks(lang, idx, len(e.Blocks))
+		switch lang {
+		case ArtifactHTML:
+			e.Content = joinHTMLBlocks(e.Blocks, idx)
+		case ArtifactSVG:
+			e.Content = wrapSVG(joinBlocks(e.Blocks, idx))
+		case ArtifactMermaid:
+			e.Content = wrapMermaid(joinBlocks(e.Blocks, idx))
+			e.NeedsScripts = true
+		}
+		return e
+	}
+
+	if doc := htmlDocument.FindString(text); doc != "" {
+		e.Kind, e.Source, e.Content = ArtifactHTML, "inline <html> document", doc
+		return e
+	}
+	if doc := htmlOpen.FindString(text); doc != "" {
+		// 途中で切れた応答でも表示できるところまでは表示する
+		e.Kind, e.Source, e.Content = ArtifactHTML, "unterminated <html> document", doc
+		return e
+	}
+	if svgs := svgDocument.FindAllString(text, -1); len(svgs) > 0 {
+		e.Kind, e.Source = ArtifactSVG, fmt.Sprintf("%d inline <svg> element(s)", len(svgs))
+		e.Content = wrapSVG(strings.Join(svgs, "\n"))
+		return e
+	}
+
+	e.Kind, e.Source = ArtifactText, "plain text reply"
+	e.Content = "<pre style=\"white-space: pre-wrap\">" + html.EscapeString(text) + "</pre>"
+	return e
+}
+
+// findBlocks returns the indexes of the blocks written in lang. Blocks
+// without a language count as HTML when they look like markup.
+func findBlocks(blocks []CodeBlock, lang string) []int {
+	var idx []int
+	for i, b := range blocks {
+		l := b.Language
+		if l == "xml" || l == "xhtml" || l == "htm" {
+			l = ArtifactHTML
+		}
+		if l == "" && lang == ArtifactHTML && strings.HasPrefix(strings.TrimSpace(b.Code), "<") {
+			l = ArtifactHTML
+		}
+		if l == lang {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func describeBlocks(lang string, idx []int, total int) string {
+	if len(idx) == 1 {
+		return fmt.Sprintf("```%s block %d of %d", lang, idx[0]+1, total)
+	}
+	return fmt.Sprintf("%d ```%s blocks of %d", len(idx), lang, total)
+}
+
+func joinBlocks(blocks []CodeBlock, idx []int) string {
+	parts := make([]string, 0, len(idx))
+	for _, i := range idx {
+		parts = append(parts, blocks[i].Code)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// joinHTMLBlocks returns the first complete document if there is one, and
+// otherwise concatenates the fragments in order.
+func joinHTMLBlocks(blocks []CodeBlock, idx []int) string {
+	for _, i := range idx {
+		if htmlOpen.MatchString(blocks[i].Code) {
+			return blocks[i].Code
+		}
+	}
+	return joinBlocks(blocks, idx)
+}
+
+func wrapSVG(svg string) string {
+	return "<!DOCTYPE html><html><body>" + svg + "</body></html>"
+}
+
+func wrapMermaid(src string) string {
+	return "<!DOCTYPE html><html><body><pre class=\"mermaid\">" + html.EscapeString(src) + "</pre>" +
+		"<script src=\"https://cdn.jsdelivr.net/npm/mermaid@10/dist/mermaid.min.js\"></script>" +
+		"<script>mermaid.initialize({ startOnLoad: true });</script></body></html>"
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractArtifact(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		kind         string
+		source       string
+		contains     []string
+		absent       []string
+		needsScripts bool
+	}{
+		{
+			name:     "prose before html block",
+			text:     "Here is the diagram:\n\n```html\n<!DOCTYPE html>\n<html><body><p>ok</p></body></html>\n```\n\nLet me know!",
+			kind:     ArtifactHTML,
+			source:   "```html block 1 of 1",
+			contains: []string{"<p>ok</p>"},
+			absent:   []string{"Here is the diagram", "```", "Let me know"},
+		},
+		{
+			name:     "first complete document wins",
+			text:     "```css\nbody{}\n```\n```html\n<div>fragment</div>\n```\n```html\n<html><body>full</body></html>\n```",
+			kind:     ArtifactHTML,
+			source:   "2 ```html blocks of 3",
+			contains: []string{"full"},
+			absent:   []string{"fragment"},
+		},
+		{
+			name:     "fragments are joined",
+			text:     "```html\n<div>a</div>\n```\ntext\n```HTML\n<div>b</div>\n```",
+			kind:     ArtifactHTML,
+			contains: []string{"<div>a</div>\n<div>b</div>"},
+		},
+		{
+			name:     "unlabelled markup block",
+			text:     "```\n<html><body>x</body></html>\n```",
+			kind:     ArtifactHTML,
+			contains: []string{"<body>x</body>"},
+		},
+		{
+			name:     "svg block",
+			text:     "```svg\n<svg width=\"10\"></svg>\n```",
+			kind:     ArtifactSVG,
+			contains: []string{"<body><svg width=\"10\"></svg></body>"},
+		},
+		{
+			name:         "mermaid block",
+			text:         "```mermaid\ngraph TD; A-->B\n```",
+			kind:         ArtifactMermaid,
+			contains:     []string{"A--&gt;B", "mermaid.initialize"},
+			needsScripts: true,
+		},
+		{
+			name:     "truncated fence",
+			text:     "```html\n<html><body><p>cut",
+			kind:     ArtifactHTML,
+			contains: []string{"<p>cut"},
+		},
+		{
+			name:     "tilde fence",
+			text:     "~~~html\n<p>tilde</p>\n~~~",
+			kind:     ArtifactHTML,
+			contains: []string{"<p>tilde</p>"},
+		},
+		{
+			name:     "bare html document in prose",
+			text:     "Sure. <!DOCTYPE html><html><body>bare</body></html> Done.",
+			kind:     ArtifactHTML,
+			source:   "inline <html> document",
+			contains: []string{"bare"},
+			absent:   []string{"Sure.", "Done."},
+		},
+		{
+			name:     "bare svg in prose",
+			text:     "Result: <svg viewBox=\"0 0 1 1\"><rect/></svg>",
+			kind:     ArtifactSVG,
+			contains: []string{"<rect/>"},
+			absent:   []string{"Result:"},
+		},
+		{
+			name:     "plain text",
+			text:     "I could not read the image <sorry>",
+			kind:     ArtifactText,
+			contains: []string{"&lt;sorry&gt;"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := ExtractArtifact(tt.text)
+			if e.Kind != tt.kind {
+				t.Errorf("Kind = %q, want %q", e.Kind, tt.kind)
+			}
+			if tt.source != "" && e.Source != tt.source {
+				t.Errorf("Source = %q, want %q", e.Source, tt.source)
+			}
+			if e.Raw != tt.text {
+				t.Errorf("Raw reply was not kept")
+			}
+			if e.NeedsScripts != tt.needsScripts {
+				t.Errorf("NeedsScripts = %v, want %v", e.NeedsScripts, tt.needsScripts)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(e.Content, s) {
+					t.Errorf("content does not contain %q:\n%s", s, e.Content)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(e.Content, s) {
+					t.Errorf("content contains %q:\n%s", s, e.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseText(t *testing.T) {
+	response := ResponseBody{Content: []ContentItem{
+		{Type: "text", Text: "first"},
+		{Type: "tool_use"},
+		{Type: "text", Text: "second"},
+	}}
+	if got := ResponseText(response); got != "first\nsecond" {
+		t.Errorf("ResponseText = %q", got)
+	}
+}
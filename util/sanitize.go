@@ -0,0 +1,267 @@
+package util
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLPolicy はモデルが返した HTML をどこまで許可するかを表す
+type HTMLPolicy struct {
+	// AllowScripts keeps inline <script> elements and on* event handlers.
+	AllowScripts bool
+	// ScriptHosts lists the hosts external <script src> may load from when
+	// AllowScripts is set (e.g. the CDN serving Mermaid).
+	ScriptHosts []string
+}
+
+// StrictPolicy removes every script, event handler and external resource.
+var StrictPolicy = HTMLPolicy{}
+
+// TrustedPolicy is for outputs that legitimately need JavaScript, such as
+// diagrams rendered client-side by Mermaid.
+var TrustedPolicy = HTMLPolicy{
+	AllowScripts: true,
+	ScriptHosts:  []string{"cdn.jsdelivr.net", "unpkg.com", "cdnjs.cloudflare.com"},
+}
+
+// 常に削除する要素（外部リソースの読み込みやページ遷移を起こすもの）
+var droppedElements = map[atom.Atom]bool{
+	atom.Iframe:   true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Applet:   true,
+	atom.Base:     true,
+	atom.Link:     true,
+}
+
+// URL を値に持つ属性
+var urlAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"background": true,
+	"poster":     true,
+	"srcset":     true,
+	"data":       true,
+	"ping":       true,
+	"codebase":   true,
+	"cite":       true,
+	"longdesc":   true,
+	"manifest":   true,
+}
+
+var (
+	cssImport     = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	cssExpression = regexp.MustCompile(`(?i)expression\s*\(`)
+	cssURL        = regexp.MustCompile(`(?i)url\(\s*(['"]?)(.*?)(['"]?)\s*\)`)
+)
+
+// SanitizeHTML applies the policy to a model generated document and injects a
+// Content-Security-Policy so the webview cannot be used to run or fetch
+// anything the policy did not allow.
+func SanitizeHTML(input string, policy HTMLPolicy) string {
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		// html.Parse はほぼ失敗しないが、念のため文字列として表示する
+		return "<pre>" + html.EscapeString(input) + "</pre>"
+	}
+
+	policy.sanitizeChildren(doc)
+	policy.injectCSP(doc)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "<pre>" + html.EscapeString(input) + "</pre>"
+	}
+	return buf.String()
+}
+
+// ContentSecurityPolicy returns the CSP header value enforced for the policy.
+func (p HTMLPolicy) ContentSecurityPolicy() string {
+	directives := []string{
+		"default-src 'none'",
+		"img-src data:",
+		"style-src 'unsafe-inline'",
+		"font-src data:",
+		"connect-src 'none'",
+		"form-action 'none'",
+		"frame-src 'none'",
+		"base-uri 'none'",
+	}
+	if p.AllowScripts {
+		sources := []string{"'unsafe-inline'", "'unsafe-eval'"}
+		for _, host := range p.ScriptHosts {
+			sources = append(sources, "https://"+host)
+		}
+		directives = append(directives, "script-src "+strings.Join(sources, " "))
+	} else {
+		directives = append(directives, "script-src 'none'")
+	}
+	return strings.Join(directives, "; ")
+}
+
+func (p HTMLPolicy) sanitizeChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.ElementNode:
+			if p.dropElement(c) {
+				n.RemoveChild(c)
+			} else {
+				p.sanitizeAttributes(c)
+				if c.DataAtom == atom.Style {
+					sanitizeStyleText(c)
+				}
+				p.sanitizeChildren(c)
+			}
+		case html.CommentNode:
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+func (p HTMLPolicy) dropElement(n *html.Node) bool {
+	if droppedElements[n.DataAtom] {
+		return true
+	}
+	switch n.DataAtom {
+	case atom.Script:
+		if !p.AllowScripts {
+			return true
+		}
+		if src, ok := attribute(n, "src"); ok {
+			return !p.allowedScriptSource(src)
+		}
+	case atom.Meta:
+		// refresh やページ側の CSP 上書きを防ぐ
+		if _, ok := attribute(n, "http-equiv"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p HTMLPolicy) sanitizeAttributes(n *html.Node) {
+	kept := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		switch {
+		case strings.HasPrefix(key, "on"):
+			if !p.AllowScripts {
+				continue
+			}
+		case key == "style":
+			a.Val = sanitizeCSS(a.Val)
+		case urlAttributes[key]:
+			if n.DataAtom == atom.Script && key == "src" {
+				// dropElement で許可済み
+				break
+			}
+			if !safeURL(n, key, a.Val) {
+				continue
+			}
+		}
+		kept = append(kept, a)
+	}
+	n.Attr = kept
+}
+
+func (p HTMLPolicy) allowedScriptSource(src string) bool {
+	u, err := url.Parse(strings.TrimSpace(src))
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	for _, host := range p.ScriptHosts {
+		if strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL reports whether a URL attribute may stay. Only in-document
+// fragments and inline images survive; everything else would either load a
+// remote resource or navigate the webview away from the result.
+func safeURL(n *html.Node, key, val string) bool {
+	v := strings.TrimSpace(val)
+	if key == "href" && strings.HasPrefix(v, "#") {
+		return true
+	}
+	if (key == "src" || key == "href") && (n.DataAtom == atom.Img || n.DataAtom == atom.Image) {
+		return isInlineImage(v)
+	}
+	return false
+}
+
+func isInlineImage(v string) bool {
+	return strings.HasPrefix(strings.ToLower(v), "data:image/")
+}
+
+func sanitizeStyleText(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			c.Data = sanitizeCSS(c.Data)
+		}
+	}
+}
+
+// sanitizeCSS removes @import rules, IE expressions and url() references to
+// anything other than inline images.
+func sanitizeCSS(css string) string {
+	css = cssImport.ReplaceAllString(css, "")
+	css = cssExpression.ReplaceAllString(css, "(")
+	return cssURL.ReplaceAllStringFunc(css, func(m string) string {
+		target := cssURL.FindStringSubmatch(m)[2]
+		if isInlineImage(strings.TrimSpace(target)) {
+			return m
+		}
+		return "none"
+	})
+}
+
+func (p HTMLPolicy) injectCSP(doc *html.Node) {
+	head := findElement(doc, atom.Head)
+	if head == nil {
+		return
+	}
+	meta := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "meta",
+		DataAtom: atom.Meta,
+		Attr: []html.Attribute{
+			{Key: "http-equiv", Val: "Content-Security-Policy"},
+			{Key: "content", Val: p.ContentSecurityPolicy()},
+		},
+	}
+	head.InsertBefore(meta, head.FirstChild)
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attribute(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
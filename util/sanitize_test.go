@@ -0,0 +1,124 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		policy   HTMLPolicy
+		absent   []string
+		contains []string
+	}{
+		{
+			name:     "inline script",
+			input:    `<p>hi</p><script>alert(1)</script>`,
+			policy:   StrictPolicy,
+			absent:   []string{"<script", "alert(1)"},
+			contains: []string{"<p>hi</p>"},
+		},
+		{
+			name:   "remote script",
+			input:  `<script src="https://evil.example/x.js"></script>`,
+			policy: StrictPolicy,
+			absent: []string{"evil.example"},
+		},
+		{
+			name:     "event handlers",
+			input:    `<img src="data:image/png;base64,AAAA" onerror="alert(1)"><div onclick="steal()">x</div>`,
+			policy:   StrictPolicy,
+			absent:   []string{"onerror", "onclick", "steal"},
+			contains: []string{`src="data:image/png;base64,AAAA"`},
+		},
+		{
+			name:   "javascript url",
+			input:  `<a href="javascript:alert(1)">x</a><a href=" JaVaScRiPt:alert(2)">y</a>`,
+			policy: StrictPolicy,
+			absent: []string{"javascript", "JaVaScRiPt"},
+		},
+		{
+			name:     "external links and images",
+			input:    `<a href="https://evil.example">x</a><img src="http://tracker.example/p.gif"><a href="#node1">ok</a>`,
+			policy:   StrictPolicy,
+			absent:   []string{"evil.example", "tracker.example"},
+			contains: []string{`href="#node1"`},
+		},
+		{
+			name:   "embedding elements",
+			input:  `<iframe src="https://evil.example"></iframe><object data="x.swf"></object><embed src="x"><base href="https://evil.example/">`,
+			policy: StrictPolicy,
+			absent: []string{"<iframe", "<object", "<embed", "<base", "evil.example"},
+		},
+		{
+			name:   "meta refresh and csp override",
+			input:  `<head><meta http-equiv="refresh" content="0;url=https://evil.example"><meta http-equiv="Content-Security-Policy" content="default-src *"></head>`,
+			policy: StrictPolicy,
+			absent: []string{"refresh", "evil.example", "default-src *"},
+		},
+		{
+			name:     "css imports and urls",
+			input:    `<style>@import url("https://evil.example/a.css"); body { background: url(https://evil.example/b.png); }</style><div style="background:url('http://evil.example/c')">x</div>`,
+			policy:   StrictPolicy,
+			absent:   []string{"@import", "evil.example"},
+			contains: []string{"background: none"},
+		},
+		{
+			name:     "svg script and handlers",
+			input:    `<svg onload="alert(1)"><script>alert(2)</script><a xlink:href="javascript:alert(3)"><rect width="10" height="10"/></a></svg>`,
+			policy:   StrictPolicy,
+			absent:   []string{"onload", "alert"},
+			contains: []string{"<rect"},
+		},
+		{
+			name:     "trusted keeps inline script",
+			input:    `<div class="mermaid">graph TD; A-->B</div><script>mermaid.initialize({startOnLoad:true});</script>`,
+			policy:   TrustedPolicy,
+			contains: []string{"<script>mermaid.initialize", html.EscapeString("script-src 'unsafe-inline'")},
+		},
+		{
+			name:     "trusted allows known cdn only",
+			input:    `<script src="https://cdn.jsdelivr.net/npm/mermaid/dist/mermaid.min.js"></script><script src="https://evil.example/x.js"></script><script src="http://cdn.jsdelivr.net/insecure.js"></script>`,
+			policy:   TrustedPolicy,
+			absent:   []string{"evil.example", "insecure.js"},
+			contains: []string{"cdn.jsdelivr.net/npm/mermaid"},
+		},
+		{
+			name:   "trusted still strips frames",
+			input:  `<iframe src="https://evil.example"></iframe>`,
+			policy: TrustedPolicy,
+			absent: []string{"<iframe", "evil.example"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeHTML(tt.input, tt.policy)
+			for _, s := range tt.absent {
+				if strings.Contains(got, s) {
+					t.Errorf("output contains %q:\n%s", s, got)
+				}
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Errorf("output does not contain %q:\n%s", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeHTMLInjectsCSP(t *testing.T) {
+	got := SanitizeHTML("<h1>diagram</h1>", StrictPolicy)
+	want := `<meta http-equiv="Content-Security-Policy" content="` + html.EscapeString(StrictPolicy.ContentSecurityPolicy()) + `"/>`
+	if !strings.Contains(got, want) {
+		t.Fatalf("CSP meta missing:\n%s", got)
+	}
+	if !strings.Contains(StrictPolicy.ContentSecurityPolicy(), "script-src 'none'") {
+		t.Errorf("strict policy must forbid scripts:\n%s", got)
+	}
+}
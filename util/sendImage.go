@@ -11,7 +11,6 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strings"
 )
 
 type MessageContentSource struct {
@@ -53,14 +52,18 @@ type ResponseBody struct {
 	Content []ContentItem `json:"content"` // content 配列
 }
 
-func removeCodeTags(input string) string {
-	// Remove the opening and closing ``````
-	result := strings.ReplaceAll(input, "```html", "")
-	result = strings.ReplaceAll(result, "```", "")
-	return result
+// SendImage は画像をモデルに送信し、応答から表示用のコンテンツを取り出す
+func SendImage(imageData []byte) Extraction {
+	e, err := ConvertImage(imageData)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return e
 }
 
-func SendImage(imageData []byte) string {
+// ConvertImage sends a PNG image to the model configured by API_KEY,
+// END_POINT and MODEL and extracts the artifact from the reply
+func ConvertImage(imageData []byte) (Extraction, error) {
 	// OpenAI APIキーを設定
 	apiKey := os.Getenv("API_KEY")
 	endpoint := os.Getenv("END_POINT")
@@ -103,13 +106,13 @@ func SendImage(imageData []byte) string {
 	// JSONにエンコード
 	requestJSON, err := json.Marshal(requestBody)
 	if err != nil {
-		log.Fatalf("JSONエンコードに失敗しました: %v", err)
+		return Extraction{}, fmt.Errorf("JSONエンコードに失敗しました: %w", err)
 	}
 
 	// endpoint にリクエストを送信
 	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(requestJSON))
 	if err != nil {
-		log.Fatalf("リクエストの作成に失敗しました: %v", err)
+		return Extraction{}, fmt.Errorf("リクエストの作成に失敗しました: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("anthropic-version", "2023-06-01")
@@ -118,27 +121,29 @@ func SendImage(imageData []byte) string {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatalf("リクエストの送信に失敗しました: %v", err)
+		return Extraction{}, fmt.Errorf("リクエストの送信に失敗しました: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// レスポンスボディを解析
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("レスポンスの読み込みに失敗しました: %v", err)
+		return Extraction{}, fmt.Errorf("レスポンスの読み込みに失敗しました: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Extraction{}, fmt.Errorf("APIがエラーを返しました: %s: %s", resp.Status, bytes.TrimSpace(respBody))
 	}
 
 	var response ResponseBody
 	err = json.Unmarshal(respBody, &response)
 	if err != nil {
-		log.Fatalf("JSONデコードに失敗しました: %v", err)
+		return Extraction{}, fmt.Errorf("JSONデコードに失敗しました: %w", err)
 	}
 
 	// 結果を出力
-	if len(response.Content) > 0 {
-		return removeCodeTags(response.Content[0].Text)
-	} else {
+	text := ResponseText(response)
+	if text == "" {
 		fmt.Println("No response content found.")
-		return ""
 	}
+	return ExtractArtifact(text), nil
 }
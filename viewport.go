@@ -0,0 +1,78 @@
+package main
+
+import (
+	"goWhiteBoard/model"
+	"math"
+
+	"fyne.io/fyne/v2"
+)
+
+// Zoom limits for the whiteboard viewport
+const (
+	minZoom float32 = 0.1
+	maxZoom float32 = 10
+)
+
+// viewport maps world coordinates (where strokes live) to screen coordinates
+// (widget-relative positions). screen = (world - origin) * scale
+type viewport struct {
+	origin model.Point // world position shown at the top-left corner of the widget
+	scale  float32     // screen pixels per world unit
+}
+
+func newViewport() viewport {
+	return viewport{scale: 1}
+}
+
+// toWorld converts a widget position to world coordinates
+func (v viewport) toWorld(p fyne.Position) model.Point {
+	return model.Point{X: p.X/v.scale + v.origin.X, Y: p.Y/v.scale + v.origin.Y}
+}
+
+// toScreen converts a world point to a widget position
+func (v viewport) toScreen(p model.Point) fyne.Position {
+	return fyne.NewPos((p.X-v.origin.X)*v.scale, (p.Y-v.origin.Y)*v.scale)
+}
+
+// pan moves the view by a screen-space delta (dragging the board by delta)
+func (v *viewport) pan(delta fyne.Delta) {
+	v.origin.X -= delta.DX / v.scale
+	v.origin.Y -= delta.DY / v.scale
+}
+
+// zoomAt multiplies the scale by factor keeping the world point under the
+// screen position anchor fixed
+func (v *viewport) zoomAt(anchor fyne.Position, factor float32) {
+	before := v.toWorld(anchor)
+	v.scale = clampZoom(v.scale * factor)
+	v.origin.X = before.X - anchor.X/v.scale
+	v.origin.Y = before.Y - anchor.Y/v.scale
+}
+
+// fit centres the world rectangle [min, max] in a widget of the given size
+func (v *viewport) fit(min, max model.Point, size fyne.Size, margin float32) {
+	w := max.X - min.X + 2*margin
+	h := max.Y - min.Y + 2*margin
+	if w <= 0 || h <= 0 || size.Width <= 0 || size.Height <= 0 {
+		return
+	}
+	v.scale = clampZoom(float32(math.Min(float64(size.Width/w), float64(size.Height/h))))
+	v.origin.X = (min.X+max.X)/2 - size.Width/2/v.scale
+	v.origin.Y = (min.Y+max.Y)/2 - size.Height/2/v.scale
+}
+
+// scrollZoomFactor converts a scroll delta to a zoom factor; one wheel notch
+// is roughly 10%
+func scrollZoomFactor(dy float32) float32 {
+	return float32(math.Pow(1.1, float64(dy)/25))
+}
+
+func clampZoom(s float32) float32 {
+	if s < minZoom {
+		return minZoom
+	}
+	if s > maxZoom {
+		return maxZoom
+	}
+	return s
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"goWhiteBoard/model"
+	"math"
+	"testing"
+
+	"fyne.io/fyne/v2"
+)
+
+func near(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 1e-3
+}
+
+func TestViewportZoomKeepsAnchor(t *testing.T) {
+	v := newViewport()
+	v.pan(fyne.NewDelta(-30, 12))
+	anchor := fyne.NewPos(120, 80)
+	before := v.toWorld(anchor)
+
+	v.zoomAt(anchor, 2.5)
+	after := v.toWorld(anchor)
+	if !near(before.X, after.X) || !near(before.Y, after.Y) {
+		t.Fatalf("world point under cursor moved: %v -> %v", before, after)
+	}
+
+	pos := v.toScreen(model.Point{X: 42, Y: -7})
+	back := v.toWorld(pos)
+	if !near(back.X, 42) || !near(back.Y, -7) {
+		t.Errorf("round trip = %v", back)
+	}
+}
+
+func TestViewportFit(t *testing.T) {
+	v := newViewport()
+	v.fit(model.Point{X: 1000, Y: 1000}, model.Point{X: 1400, Y: 1200}, fyne.NewSize(200, 200), 0)
+
+	if !near(v.scale, 0.5) {
+		t.Errorf("scale = %v, want 0.5", v.scale)
+	}
+	center := v.toScreen(model.Point{X: 1200, Y: 1100})
+	if !near(center.X, 100) || !near(center.Y, 100) {
+		t.Errorf("content centre drawn at %v", center)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"goWhiteBoard/model"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Views returns the viewports saved on the page shown
+func (w *whiteboard) Views() []model.Viewport {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]model.Viewport(nil), w.pages[w.pageIndex].views...)
+}
+
+// SaveView saves the area shown under name on the page shown. An empty
+// name is replaced by a numbered one.
+func (w *whiteboard) SaveView(name string) {
+	w.mutex.Lock()
+	p := w.pages[w.pageIndex]
+	if name == "" {
+		name = fmt.Sprintf("View %d", len(p.views)+1)
+	}
+	p.views = append(p.views, model.Viewport{
+		Name: name,
+		Min:  w.view.toWorld(fyne.Position{}),
+		Max:  w.view.toWorld(fyne.NewPos(w.size.Width, w.size.Height)),
+	})
+	w.pagesEditedLocked()
+	w.mutex.Unlock()
+}
+
+// ShowView shows saved viewport i of the page shown, fitted to the board
+func (w *whiteboard) ShowView(i int) {
+	w.mutex.Lock()
+	views := w.pages[w.pageIndex].views
+	if i < 0 || i >= len(views) {
+		w.mutex.Unlock()
+		return
+	}
+	w.view.fit(views[i].Min, views[i].Max, w.size, 0)
+	w.followPage = false
+	w.mutex.Unlock()
+	w.viewportChanged()
+}
+
+// DeleteView removes saved viewport i of the page shown
+func (w *whiteboard) DeleteView(i int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	p := w.pages[w.pageIndex]
+	if i < 0 || i >= len(p.views) {
+		return
+	}
+	p.views = append(p.views[:i:i], p.views[i+1:]...)
+	w.pagesEditedLocked()
+}
+
+// showSaveViewDialog asks for a name and saves the area shown under it
+func showSaveViewDialog(w fyne.Window, board *whiteboard) {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder(fmt.Sprintf("View %d", len(board.Views())+1))
+	dialog.ShowForm("Save View", "Save", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Name", entry),
+	}, func(ok bool) {
+		if ok {
+			board.SaveView(entry.Text)
+		}
+	}, w)
+}
+
+// showViewsDialog lists the views saved on the page shown to show or
+// delete them
+func showViewsDialog(w fyne.Window, board *whiteboard) {
+	rows := container.NewVBox()
+	var d dialog.Dialog
+	var update func()
+	update = func() {
+		rows.RemoveAll()
+		views := board.Views()
+		if len(views) == 0 {
+			rows.Add(widget.NewLabel("No views are saved on this page."))
+		}
+		for i, v := range views {
+			show := widget.NewButton("Show", func() {
+				board.ShowView(i)
+				d.Hide()
+			})
+			remove := widget.NewButton("Delete", func() {
+				board.DeleteView(i)
+				update()
+			})
+			rows.Add(container.NewBorder(nil, nil, nil, container.NewHBox(show, remove), widget.NewLabel(v.Name)))
+		}
+	}
+	update()
+	d = dialog.NewCustom("Saved Views", "Close", rows, w)
+	d.Show()
+}
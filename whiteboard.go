@@ -1,49 +1,121 @@
 package main
 
 import (
-	"fmt"
-	"image"
+	"goWhiteBoard/model"
+	"goWhiteBoard/render"
+	"goWhiteBoard/shape"
+	"goWhiteBoard/snap"
+	"goWhiteBoard/stroke"
 	"image/color"
-	"image/png"
 	"os"
 	"sync"
+	"time"
 
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/widget"
 )
 
-// Point represents a point on the whiteboard
-type Point struct {
-	X, Y float32
-}
-
-// Line represents a line on the whiteboard
-type line struct {
-	points []Point
-	color  color.Color
-	width  float32
-}
-
 // Whiteboard is a custom widget for drawing
 type whiteboard struct {
 	widget.BaseWidget
-	lines       []line
-	currentLine line
-	drawing     bool
-	lineColor   color.Color
-	lineWidth   float32
-	mutex       sync.Mutex // 複数のゴルーチンからのアクセスを保護
+
+	// mutex は以下の入力・表示状態を保護する
+	mutex          sync.Mutex
+	pages          []*boardPage
+	pageIndex      int             // 表示中のページ
+	pagesChanged   bool            // 保存後にページを追加・削除・並べ替え・改名した（レイヤーの変更も含む）
+	edits          uint64          // ページ・レイヤー・保存した表示範囲を変更するたびに増える（自動保存の変更検出用）
+	layersVersion  uint64          // 表示中のページのレイヤーが変わるたびに増える（描画のキャッシュ用）
+	doc            *model.Document // 表示中のページの線（ドキュメント自体は複数のゴルーチンから安全に使える）
+	shownDoc       *model.Document // OnPageShown で最後に知らせたドキュメント
+	history        *model.History  // 表示中のページの取り消し・やり直し
+	currentLine    model.Line
+	strokeID       uint64 // MouseDown ごとに増える（描画中の線の識別用）
+	drawing        bool
+	lineColor      color.Color
+	lineWidth      float32
+	pen            stroke.Pen
+	view           viewport       // ワールド座標と画面座標の変換
+	panning        bool           // ドラッグでビューを移動中
+	panFrom        fyne.Position  // 直前のドラッグ位置
+	panMode        bool           // スペースキー押下中は左ドラッグでもパンする
+	exportAll      bool           // true なら表示範囲ではなく全コンテンツを書き出す
+	cursors        []remoteCursor // 共同編集の参加者のカーソル
+	input          stroke.Options // 入力の平滑化・簡略化の設定
+	filter         *stroke.OneEuro
+	pressure       *stroke.SpeedPressure // 筆圧のシミュレーション（無効なら nil）
+	strokeStart    time.Time
+	shapes         shape.Mode     // 図形認識でスナップするタイミング
+	lastMove       time.Time      // ペンが最後に動いた時刻（長押しの判定用）
+	lastPos        fyne.Position  // lastMove の位置
+	pageSize       model.PageSize // キャンバスの大きさ（ゼロなら無制限）
+	followPage     bool           // true ならウィンドウの大きさが変わるたびにページ全体を表示する
+	size           fyne.Size      // レンダラーの Layout で受け取った表示領域の大きさ
+	grid           model.Grid     // 表示中のページの背景
+	snapGrid       bool           // 図形と移動した線を格子に吸着する
+	snapObjects    bool           // 図形と移動した線をほかの線の端と中心に揃える
+	tool           tool
+	selection      []model.ID           // 選択中の線（表示中のページ）
+	selecting      bool                 // 範囲選択の矩形をドラッグ中
+	bandFrom       model.Point          // 範囲選択の始点
+	band           snap.Rect            // 範囲選択の矩形
+	moving         bool                 // 選択中の線をドラッグで移動中
+	moveID         uint64               // 移動ごとに増える（描画のキャッシュ用）
+	moveFrom       model.Point          // 移動を始めた位置
+	scaling        bool                 // 選択中の線をハンドルで拡大・縮小中
+	place          placement            // 吸着を含めた移動先
+	moveBounds     snap.Rect            // 移動前の選択範囲
+	others         []snap.Rect          // 選択していない線の範囲（吸着用）
+	guides         []snap.Guide         // 揃っている位置を示すガイド
+	connectorStyle model.ConnectorStyle // 新しいコネクターの形
+	connecting     bool                 // コネクターをドラッグで引いている
+	connectFrom    connectEnd           // 引いているコネクターの始点
+	connectTo      connectEnd           // 引いているコネクターの終点
+	connector      model.Line           // 引いているコネクター（経路を計算済み）
+	presenting     bool                 // 発表モード
+	presentStep    int                  // 発表中に表示している手順
+	stopPresenting chan struct{}        // 発表モードを終えると閉じる
+	pointer        pointerMode          // 発表中のポインター
+	laser          []laserPoint         // レーザーポインターの軌跡（古い順）
+	spot           fyne.Position        // スポットライトの中心
+	spotShown      bool                 // ポインターがボードの上にある
+
+	// OnViewportChanged is called after the board was panned or zoomed
+	OnViewportChanged func()
+	// OnCursorMoved is called with the pointer position in world coordinates
+	OnCursorMoved func(model.Point)
+	// OnPagesChanged is called after pages were added, removed, reordered
+	// or renamed, or another page was shown
+	OnPagesChanged func()
+	// OnLayersChanged is called after the layers of the page shown changed
+	// or another page was shown
+	OnLayersChanged func()
+	// OnPageShown is called after another page was shown, so anything bound
+	// to the document of the page shown can follow Document
+	OnPageShown func()
+}
+
+// remoteCursor is the pointer of another participant shown on the board
+type remoteCursor struct {
+	Name  string
+	Color color.Color
+	Pos   model.Point // ワールド座標
 }
 
 // NewWhiteboard creates a new whiteboard widget
 func newWhiteboard() *whiteboard {
 	w := &whiteboard{
-		lines:     []line{},
-		lineColor: color.RGBA{0, 0, 0, 255}, // Default: Black
-		lineWidth: 2.0,                      // Default width
+		lineColor:      color.RGBA{0, 0, 0, 255}, // Default: Black
+		lineWidth:      2.0,                      // Default width
+		input:          stroke.DefaultOptions,
+		pen:            stroke.Pens[0],
+		connectorStyle: model.ConnectorStraight,
 	}
+	w.pages = []*boardPage{w.newPage("Page 1", model.PageSize{})}
+	w.pages[0].id = model.PageID(0)
+	w.showPageLocked(0)
+	w.shownDoc = w.doc
 	w.ExtendBaseWidget(w)
 	return w
 }
@@ -53,37 +125,248 @@ var _ desktop.Mouseable = (*whiteboard)(nil)
 var _ fyne.Widget = (*whiteboard)(nil)
 var _ desktop.Hoverable = (*whiteboard)(nil)
 var _ desktop.Cursorable = (*whiteboard)(nil)
+var _ fyne.Scrollable = (*whiteboard)(nil)
 
 // CreateRenderer implements the fyne.Widget interface
 func (w *whiteboard) CreateRenderer() fyne.WidgetRenderer {
-	return &whiteboardRenderer{whiteboard: w}
+	return newWhiteboardRenderer(w)
+}
+
+// Document returns the document model of the page shown by the board
+func (w *whiteboard) Document() *model.Document {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.doc
 }
 
 // MouseDown implements desktop.Mouseable
 func (w *whiteboard) MouseDown(ev *desktop.MouseEvent) {
+	w.mutex.Lock()
+
+	// 中ボタン、またはスペースキーを押しながらのドラッグでパン
+	if ev.Button == desktop.MouseButtonTertiary || w.panMode {
+		w.panning = true
+		w.panFrom = ev.Position
+		w.mutex.Unlock()
+		return
+	}
+	// 発表中は描かない
+	if w.presenting {
+		w.mutex.Unlock()
+		return
+	}
+	if w.tool == toolSelect {
+		w.selectDownLocked(ev.Position)
+		w.mutex.Unlock()
+		w.Refresh()
+		return
+	}
+	if w.tool == toolConnector {
+		if !w.layerLockedLocked() {
+			w.connectDownLocked(ev.Position)
+		}
+		w.mutex.Unlock()
+		w.Refresh()
+		return
+	}
+	// ロック中・非表示のレイヤーには描けない
+	if w.layerLockedLocked() {
+		w.mutex.Unlock()
+		return
+	}
+
 	w.drawing = true
-	w.currentLine = line{
-		points: []Point{{X: ev.Position.X, Y: ev.Position.Y}},
-		color:  w.lineColor,
-		width:  w.lineWidth,
+	w.strokeID++
+	w.filter = w.input.Filter()
+	w.pressure = nil
+	if w.input.Pressure {
+		w.pressure = &stroke.SpeedPressure{}
 	}
+	w.strokeStart = time.Now()
+	w.lastMove, w.lastPos = w.strokeStart, ev.Position
+	w.currentLine = model.Line{
+		Points:      []model.Point{w.inputPoint(ev.Position)},
+		Color:       w.pen.Color(w.lineColor),
+		Width:       w.lineWidth,
+		Curve:       w.input.Curves,
+		Highlighter: w.pen.Highlighter,
+		Layer:       w.pages[w.pageIndex].layer,
+	}
+	w.mutex.Unlock()
 }
 
 // MouseUp implements desktop.Mouseable
 func (w *whiteboard) MouseUp(ev *desktop.MouseEvent) {
-	if w.drawing {
-		w.drawing = false
-		w.lines = append(w.lines, w.currentLine)
-		w.currentLine = line{}
+	w.mutex.Lock()
+	if w.panning {
+		w.panning = false
+		w.mutex.Unlock()
+		return
+	}
+	if w.connecting {
+		remove, add := w.connectUpLocked()
+		history := w.history
+		w.mutex.Unlock()
+		if len(add) > 0 {
+			// 通知で再描画される
+			history.Update(remove, add)
+		} else {
+			w.Refresh()
+		}
+		return
 	}
+	if w.selecting || w.moving {
+		remove, add := w.selectUpLocked()
+		w.mutex.Unlock()
+		if len(add) > 0 {
+			w.moveSelection(remove, add)
+		} else {
+			w.Refresh()
+		}
+		return
+	}
+	if !w.drawing {
+		w.mutex.Unlock()
+		return
+	}
+	finished := w.currentLine
+	if r, ok := w.snapLocked(finished.Points); ok {
+		finished.Points, finished.Curve = w.alignShapeLocked(r, finished.Width), false
+	} else {
+		// 許容誤差は画面上のピクセルなので、ズームに関係なく見た目が同じになる
+		finished.Points = stroke.Simplify(finished.Points, w.input.Tolerance/w.view.scale)
+	}
+	w.drawing = false
+	w.currentLine = model.Line{}
+	history := w.history
+	w.mutex.Unlock()
+
+	// Add の通知で再描画される
+	history.Add(finished)
 }
 
 // MouseMoved implements desktop.Mouseable
 func (w *whiteboard) MouseMoved(ev *desktop.MouseEvent) {
-	if w.drawing {
-		w.currentLine.points = append(w.currentLine.points, Point{X: ev.Position.X, Y: ev.Position.Y})
+	if w.OnCursorMoved != nil {
+		w.mutex.Lock()
+		pos := w.view.toWorld(ev.Position)
+		w.mutex.Unlock()
+		w.OnCursorMoved(pos)
+	}
+
+	w.mutex.Lock()
+	if w.panning {
+		w.view.pan(fyne.NewDelta(ev.Position.X-w.panFrom.X, ev.Position.Y-w.panFrom.Y))
+		w.panFrom = ev.Position
+		w.followPage = false
+		w.mutex.Unlock()
+		w.viewportChanged()
+		return
+	}
+	if w.presenting {
+		w.pointerMovedLocked(ev.Position)
+		w.mutex.Unlock()
+		w.Refresh()
+		return
+	}
+	if w.selecting || w.moving {
+		w.selectMovedLocked(ev.Position)
+		w.mutex.Unlock()
 		w.Refresh()
+		return
+	}
+	if w.connecting {
+		w.connectMovedLocked(ev.Position)
+		w.mutex.Unlock()
+		w.Refresh()
+		return
 	}
+	if !w.drawing {
+		w.mutex.Unlock()
+		return
+	}
+	w.currentLine.Points = append(w.currentLine.Points, w.inputPoint(ev.Position))
+	// 手ぶれ程度の動きは長押しを妨げない
+	if dx, dy := ev.Position.X-w.lastPos.X, ev.Position.Y-w.lastPos.Y; dx*dx+dy*dy > holdTolerance*holdTolerance {
+		w.lastMove, w.lastPos = time.Now(), ev.Position
+	}
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// holdTolerance is how far (in screen pixels) the pen may drift while it is
+// held still to snap a shape
+const holdTolerance = 3
+
+// snapLocked recognizes a finished stroke when the shape mode asks for it.
+// The caller holds the mutex.
+func (w *whiteboard) snapLocked(points []model.Point) (shape.Result, bool) {
+	switch w.shapes {
+	case shape.Hold:
+		if time.Since(w.lastMove) < shape.HoldDuration {
+			return shape.Result{}, false
+		}
+	case shape.Auto:
+	default:
+		return shape.Result{}, false
+	}
+	r := shape.Recognize(points)
+	return r, r.Kind != shape.None
+}
+
+// alignShapeLocked snaps a recognized shape to the grid and then lines up
+// its bounds with those of the other lines, as enabled. The caller holds
+// the mutex.
+func (w *whiteboard) alignShapeLocked(r shape.Result, width float32) []model.Point {
+	points := r.Points
+	if w.snapGrid {
+		points = snap.Shape(r, w.grid)
+	}
+	if !w.snapObjects {
+		return points
+	}
+	var others []snap.Rect
+	for _, l := range w.doc.Snapshot().Lines {
+		if b, ok := snap.Bounds([]model.Line{l}); ok {
+			others = append(others, b)
+		}
+	}
+	b, ok := snap.Bounds([]model.Line{{Points: points, Width: width}})
+	if !ok {
+		return points
+	}
+	d := snap.Objects(b, others, snapTolerance/w.view.scale).Offset
+	return translateLine(model.Line{Points: points}, d).Points
+}
+
+// inputPoint runs a pointer position through the smoothing filter and the
+// pressure simulation and returns it in world coordinates, stamped with the
+// time since the stroke started for replays. The caller holds the mutex.
+func (w *whiteboard) inputPoint(pos fyne.Position) model.Point {
+	elapsed := time.Since(w.strokeStart)
+	t := elapsed.Seconds()
+	if w.filter != nil {
+		p := w.filter.Filter(model.Point{X: pos.X, Y: pos.Y}, t)
+		pos = fyne.NewPos(p.X, p.Y)
+	}
+	p := w.view.toWorld(pos)
+	if w.pressure != nil {
+		p.P = w.pressure.Next(model.Point{X: pos.X, Y: pos.Y}, t)
+	}
+	p.T = int32(elapsed.Milliseconds())
+	return p
+}
+
+// Scrolled implements fyne.Scrollable: the wheel zooms around the cursor
+func (w *whiteboard) Scrolled(ev *fyne.ScrollEvent) {
+	if ev.Scrolled.DY == 0 {
+		return
+	}
+	w.mutex.Lock()
+	w.view.zoomAt(ev.Position, scrollZoomFactor(ev.Scrolled.DY))
+	w.followPage = false
+	w.mutex.Unlock()
+	w.viewportChanged()
 }
 
 // MouseIn implements desktop.Hoverable
@@ -92,199 +375,392 @@ func (w *whiteboard) MouseIn(*desktop.MouseEvent) {
 
 // MouseOut implements desktop.Hoverable
 func (w *whiteboard) MouseOut() {
+	w.mutex.Lock()
+	shown := w.spotShown
+	w.spotShown = false
+	w.mutex.Unlock()
+	if shown {
+		w.Refresh()
+	}
 }
 
 // Cursor implements desktop.Cursorable
 func (w *whiteboard) Cursor() desktop.Cursor {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.panMode || w.panning {
+		return desktop.PointerCursor
+	}
+	// 発表中はレーザーかスポットライトだけで位置を示す
+	if w.presenting {
+		return desktop.HiddenCursor
+	}
+	if w.tool == toolSelect {
+		return desktop.DefaultCursor
+	}
 	return desktop.CrosshairCursor
 }
 
-// SetLineColor sets the color for new lines
-func (w *whiteboard) SetLineColor(c color.Color) {
-	w.lineColor = c
+// SetPanMode turns left-button panning on or off (held space key)
+func (w *whiteboard) SetPanMode(on bool) {
+	w.mutex.Lock()
+	w.panMode = on
+	w.mutex.Unlock()
 }
 
-// SetLineWidth sets the width for new lines
-func (w *whiteboard) SetLineWidth(width float32) {
-	w.lineWidth = width
+// Zoom returns the current zoom factor (1 = 100%)
+func (w *whiteboard) Zoom() float32 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.view.scale
 }
 
-// SaveAsPNG saves the whiteboard as a PNG image
-func (w *whiteboard) SaveAsPNG(filename string, width, height int) error {
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	// Fill with white background
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			img.Set(x, y, color.White)
-		}
-	}
+// ResetZoom returns to 100% keeping the centre of the view in place
+func (w *whiteboard) ResetZoom() {
+	size := w.Size()
+	w.mutex.Lock()
+	w.view.zoomAt(fyne.NewPos(size.Width/2, size.Height/2), 1/w.view.scale)
+	w.followPage = false
+	w.mutex.Unlock()
+	w.viewportChanged()
+}
 
-	// Draw all lines
-	tempLines := make([]line, len(w.lines))
-	copy(tempLines, w.lines)
-	current := w.currentLine
-	drawing := w.drawing
+// ZoomBy zooms by factor around the centre of the view
+func (w *whiteboard) ZoomBy(factor float32) {
+	size := w.Size()
+	w.mutex.Lock()
+	w.view.zoomAt(fyne.NewPos(size.Width/2, size.Height/2), factor)
+	w.followPage = false
+	w.mutex.Unlock()
+	w.viewportChanged()
+}
 
-	for _, l := range tempLines {
-		drawLine(img, l)
+// ZoomToFit scales and scrolls the view so the page, or every stroke on a
+// board without a page size, is visible
+func (w *whiteboard) ZoomToFit() {
+	size := w.Size()
+	min, max, ok := w.contentBounds()
+	w.mutex.Lock()
+	if w.pageSize.Fixed() {
+		w.fitPageLocked(size)
+	} else if !ok {
+		w.view = newViewport()
+	} else {
+		w.view.fit(min, max, size, 20)
 	}
+	w.mutex.Unlock()
+	w.viewportChanged()
+}
 
-	// Draw current line if drawing
-	if drawing {
-		drawLine(img, current)
+// SetPageSize sets the size of the canvas and shows the whole page. The zero
+// PageSize makes the page unbounded.
+func (w *whiteboard) SetPageSize(p model.PageSize) {
+	size := w.Size()
+	w.mutex.Lock()
+	w.pageSize = p
+	if p.Fixed() {
+		w.fitPageLocked(size)
+	} else {
+		w.followPage = false
 	}
+	w.mutex.Unlock()
+	w.viewportChanged()
+}
 
-	// Save to file
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// PageSize returns the size of the canvas
+func (w *whiteboard) PageSize() model.PageSize {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.pageSize
+}
 
-	return png.Encode(f, img)
+// fitPageLocked shows the whole page and keeps it in view when the widget
+// is resized, until the user pans or zooms. The caller holds the mutex.
+func (w *whiteboard) fitPageLocked(size fyne.Size) {
+	w.view.fit(model.Point{}, model.Point{X: w.pageSize.Width, Y: w.pageSize.Height}, size, 20)
+	w.followPage = true
 }
 
-// drawLine draws a line on the image
-func drawLine(img *image.RGBA, l line) {
-	if len(l.points) < 2 {
-		return
+// resized is called by the renderer's Layout with the new size of the
+// widget. It reports whether the view changed.
+func (w *whiteboard) resized(size fyne.Size) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.size = size
+	if w.presenting {
+		// 全画面にしたときなど、表示している手順を合わせ直す
+		before := w.view
+		w.fitStepLocked(size)
+		return w.view != before
 	}
-
-	// Convert color.Color to RGBA
-	r, g, b, a := l.color.RGBA()
-	lineColor := color.RGBA{
-		R: uint8(r >> 8),
-		G: uint8(g >> 8),
-		B: uint8(b >> 8),
-		A: uint8(a >> 8),
+	if !w.followPage || !w.pageSize.Fixed() {
+		return false
 	}
+	before := w.view
+	w.fitPageLocked(size)
+	return w.view != before
+}
 
-	// Draw line segments
-	for i := 1; i < len(l.points); i++ {
-		p1 := l.points[i-1]
-		p2 := l.points[i]
-		drawLineSegment(img, int(p1.X), int(p1.Y), int(p2.X), int(p2.Y), lineColor, int(l.width))
+func (w *whiteboard) viewportChanged() {
+	w.Refresh()
+	if w.OnViewportChanged != nil {
+		w.OnViewportChanged()
 	}
 }
 
-// drawLineSegment draws a line segment using Bresenham's algorithm with thickness
-func drawLineSegment(img *image.RGBA, x0, y0, x1, y1 int, col color.RGBA, thickness int) {
-	dx := abs(x1 - x0)
-	dy := abs(y1 - y0)
-	sx, sy := 1, 1
-	if x0 >= x1 {
-		sx = -1
+// exportState is what an exporter needs: the finished lines, the stroke in
+// progress (if any) and the view, captured together
+type exportState struct {
+	doc           *model.Document
+	snapshot      model.Snapshot
+	current       model.Line
+	strokeID      uint64
+	drawing       bool
+	view          viewport
+	cursors       []remoteCursor
+	pageSize      model.PageSize
+	size          fyne.Size // 表示領域の大きさ
+	all           bool      // 表示範囲ではなく全コンテンツを書き出す
+	grid          model.Grid
+	layers        []model.Layer
+	layersVersion uint64
+
+	// 選択ツールの状態（表示中のページのみ）
+	selection []model.ID
+	moving    bool
+	moveID    uint64
+	place     placement
+	selecting bool
+	band      snap.Rect
+	guides    []snap.Guide
+
+	// 引いているコネクター（表示中のページのみ）
+	connecting bool
+	connector  model.Line
+
+	// 発表中のポインター（表示中のページのみ、書き出さない）
+	presenting bool
+	pointer    pointerMode
+	laser      []laserPoint
+	spot       fyne.Position
+	spotShown  bool
+}
+
+func (w *whiteboard) exportState() exportState {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.pageStateLocked(w.pageIndex)
+}
+
+// pageStateLocked returns the export state of page i. Only the page shown
+// has a stroke in progress and cursors. The caller holds the mutex.
+func (w *whiteboard) pageStateLocked(i int) exportState {
+	w.storePageLocked()
+	p := w.pages[i]
+	state := exportState{
+		doc:      p.doc,
+		snapshot: p.doc.Snapshot(),
+		view:     p.view,
+		pageSize: p.pageSize,
+		size:     w.size,
+		all:      w.exportAll,
+		grid:     p.grid,
+		layers:   append([]model.Layer(nil), p.layers...),
 	}
-	if y0 >= y1 {
-		sy = -1
+	if i == w.pageIndex {
+		state.layersVersion = w.layersVersion
+		state.current, state.strokeID, state.drawing, state.cursors = w.currentLine, w.strokeID, w.drawing, w.cursors
+		state.selection, state.moving, state.moveID, state.place = w.selection, w.moving, w.moveID, w.place
+		state.selecting, state.band, state.guides = w.selecting, w.band, w.guides
+		state.connecting, state.connector = w.connecting, w.connector
+		state.presenting, state.pointer, state.spot, state.spotShown = w.presenting, w.pointer, w.spot, w.spotShown
+		state.laser = append([]laserPoint(nil), w.laser...)
 	}
-	err := dx - dy
+	return state
+}
 
-	bounds := img.Bounds()
-	radius := thickness / 2
-	if radius < 1 {
-		radius = 1
+// lines returns every line to export, including the one being drawn
+func (s exportState) lines() []model.Line {
+	lines := s.snapshot.Lines
+	if s.drawing {
+		lines = append(lines[:len(lines):len(lines)], s.current)
 	}
+	return lines
+}
 
-	for {
-		for y := -radius; y <= radius; y++ {
-			for x := -radius; x <= radius; x++ {
-				if x*x+y*y <= radius*radius {
-					px, py := x0+x, y0+y
-					if px >= bounds.Min.X && px < bounds.Max.X && py >= bounds.Min.Y && py < bounds.Max.Y {
-						img.Set(px, py, col)
-					}
-				}
-			}
-		}
+// exportGroups returns the visible layers to export into view, with the
+// grid beneath them when it is exported
+func (s exportState) exportGroups(view render.View) []render.Group {
+	return render.LayersWithGrid(s.lines(), s.layers, s.grid, view)
+}
 
-		if x0 == x1 && y0 == y1 {
-			break
-		}
-		e2 := 2 * err
-		if e2 > -dy {
-			err -= dy
-			x0 += sx
-		}
-		if e2 < dx {
-			err += dx
-			y0 += sy
-		}
+// exportView returns the area Export writes: the page at 100% zoom, or on a
+// page without a size, the whole content or the visible area
+func (s exportState) exportView() render.View {
+	switch {
+	case s.pageSize.Fixed():
+		return render.View{Scale: 1, Width: int(s.pageSize.Width), Height: int(s.pageSize.Height)}
+	case s.all:
+		return render.ContentView(s.lines(), 20, 1)
 	}
+	return render.View{Origin: s.view.origin, Scale: s.view.scale, Width: int(s.size.Width), Height: int(s.size.Height)}
 }
 
-// abs returns the absolute value of x
-func abs(x int) int {
-	if x < 0 {
-		return -x
-	}
-	return x
+// contentBounds returns the world rectangle covering all strokes including
+// their width
+func (w *whiteboard) contentBounds() (min, max model.Point, ok bool) {
+	state := w.exportState()
+	return model.Snapshot{Lines: state.lines()}.Bounds()
+}
+
+// SetRemoteCursors replaces the cursors of the other participants
+func (w *whiteboard) SetRemoteCursors(cursors []remoteCursor) {
+	w.mutex.Lock()
+	w.cursors = cursors
+	w.mutex.Unlock()
+	w.Refresh()
+}
+
+// Clear removes every stroke from the page shown
+func (w *whiteboard) Clear() {
+	w.currentHistory().Clear()
 }
 
-// whiteboardRenderer implements the fyne.WidgetRenderer interface
-type whiteboardRenderer struct {
-	whiteboard *whiteboard
-	objects    []fyne.CanvasObject // 描画するオブジェクトをキャッシュ
+// Undo reverts the latest stroke or clear made on the page shown. Strokes
+// of other participants are left alone.
+func (w *whiteboard) Undo() bool {
+	return w.currentHistory().Undo()
 }
 
-// MinSize implements fyne.WidgetRenderer
-func (r *whiteboardRenderer) MinSize() fyne.Size {
-	return fyne.NewSize(300, 200)
+// Redo repeats the latest undone edit on the page shown
+func (w *whiteboard) Redo() bool {
+	return w.currentHistory().Redo()
 }
 
-// Layout implements fyne.WidgetRenderer
-func (r *whiteboardRenderer) Layout(size fyne.Size) {
-	// サイズが変わった場合にオブジェクトの再配置などを行う場合はここに記述
+func (w *whiteboard) currentHistory() *model.History {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.history
 }
 
-// Refresh implements fyne.WidgetRenderer
-func (r *whiteboardRenderer) Refresh() {
-	fmt.Println("whiteboardRenderer.Refresh() called") // ログを追加
-	r.updateObjects()                                  // Refresh 時に描画オブジェクトを更新
-	canvas.Refresh(r.whiteboard)
-	fmt.Println("whiteboardRenderer.Refresh() finished")
+// SetLineColor sets the color for new lines
+func (w *whiteboard) SetLineColor(c color.Color) {
+	w.mutex.Lock()
+	w.lineColor = c
+	w.mutex.Unlock()
 }
 
-// Objects implements fyne.WidgetRenderer
-func (r *whiteboardRenderer) Objects() []fyne.CanvasObject {
-	return r.objects
+// LineColor returns the color for new lines
+func (w *whiteboard) LineColor() color.Color {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.lineColor
 }
 
-// Destroy implements fyne.WidgetRenderer
-func (r *whiteboardRenderer) Destroy() {
-	// 特にクリーンアップ処理はなし
+// SetInputOptions configures smoothing and simplification for new strokes
+func (w *whiteboard) SetInputOptions(o stroke.Options) {
+	w.mutex.Lock()
+	w.input = o
+	w.mutex.Unlock()
 }
 
-// updateObjects は whiteboard の線のデータを canvas.Line オブジェクトに変換してキャッシュする
-func (r *whiteboardRenderer) updateObjects() {
-	fmt.Println("updateObjects()")
-	r.whiteboard.mutex.Lock()
-	defer r.whiteboard.mutex.Unlock()
+// SetPen selects the pen preset for new lines. The colour and width are
+// set separately; the pen adds its opacity and blend mode.
+func (w *whiteboard) SetPen(p stroke.Pen) {
+	w.mutex.Lock()
+	w.pen = p
+	w.mutex.Unlock()
+}
 
-	r.objects = make([]fyne.CanvasObject, 0, len(r.whiteboard.lines)+1) // 描画オブジェクトのスライスを初期化
+// Pen returns the pen preset used for new lines
+func (w *whiteboard) Pen() stroke.Pen {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.pen
+}
 
-	// 描画済みの線を canvas.Line オブジェクトに変換
-	for _, l := range r.whiteboard.lines {
-		if len(l.points) >= 2 {
-			for i := 0; i < len(l.points)-1; i++ {
-				line := canvas.NewLine(l.color)
-				line.StrokeWidth = l.width
-				line.Position1 = fyne.NewPos(l.points[i].X, l.points[i].Y)
-				line.Position2 = fyne.NewPos(l.points[i+1].X, l.points[i+1].Y)
-				r.objects = append(r.objects, line)
-			}
-		}
-	}
+// SetShapeMode sets when finished strokes are snapped to recognized shapes
+func (w *whiteboard) SetShapeMode(m shape.Mode) {
+	w.mutex.Lock()
+	w.shapes = m
+	w.mutex.Unlock()
+}
 
-	// 現在描画中の線も追加
-	if r.whiteboard.drawing && len(r.whiteboard.currentLine.points) >= 2 {
-		for i := 0; i < len(r.whiteboard.currentLine.points)-1; i++ {
-			currentLine := canvas.NewLine(r.whiteboard.currentLine.color)
-			currentLine.StrokeWidth = r.whiteboard.currentLine.width
-			currentLine.Position1 = fyne.NewPos(r.whiteboard.currentLine.points[i].X, r.whiteboard.currentLine.points[i].Y)
-			currentLine.Position2 = fyne.NewPos(r.whiteboard.currentLine.points[i+1].X, r.whiteboard.currentLine.points[i+1].Y)
-			r.objects = append(r.objects, currentLine)
-		}
+// ShapeMode returns when strokes are snapped to shapes
+func (w *whiteboard) ShapeMode() shape.Mode {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.shapes
+}
+
+// InputOptions returns the current input pipeline settings
+func (w *whiteboard) InputOptions() stroke.Options {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.input
+}
+
+// SetLineWidth sets the width for new lines
+func (w *whiteboard) SetLineWidth(width float32) {
+	w.mutex.Lock()
+	w.lineWidth = width
+	w.mutex.Unlock()
+}
+
+// LineWidth returns the width for new lines
+func (w *whiteboard) LineWidth() float32 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.lineWidth
+}
+
+// SetExportAll chooses between exporting the visible area (false) and the
+// bounds of all content (true)
+func (w *whiteboard) SetExportAll(all bool) {
+	w.mutex.Lock()
+	w.exportAll = all
+	w.mutex.Unlock()
+}
+
+// ExportAll reports whether Export writes the whole content
+func (w *whiteboard) ExportAll() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.exportAll
+}
+
+// Export writes the page shown at 100% zoom, or on a page without a size,
+// the area selected with SetExportAll
+func (w *whiteboard) Export(filename string) error {
+	state := w.exportState()
+	view := state.exportView()
+	return savePNG(filename, state.exportGroups(view), view)
+}
+
+// SaveAsPNG saves the visible area of the whiteboard as a PNG image
+func (w *whiteboard) SaveAsPNG(filename string, width, height int) error {
+	state := w.exportState()
+	view := render.View{Origin: state.view.origin, Scale: state.view.scale, Width: width, Height: height}
+	return savePNG(filename, state.exportGroups(view), view)
+}
+
+// SaveContentAsPNG saves every stroke at 100% zoom, cropped to the content
+// bounds plus margin
+func (w *whiteboard) SaveContentAsPNG(filename string, margin int) error {
+	state := w.exportState()
+	view := render.ContentView(state.lines(), margin, 1)
+	return savePNG(filename, state.exportGroups(view), view)
+}
+
+// savePNG renders the layers into a PNG file
+func savePNG(filename string, groups []render.Group, view render.View) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := render.WriteGroups(f, "png", groups, view); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
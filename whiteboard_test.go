@@ -0,0 +1,1039 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"goWhiteBoard/model"
+	"goWhiteBoard/recovery"
+	"goWhiteBoard/shape"
+	"goWhiteBoard/snap"
+	"goWhiteBoard/stroke"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/test"
+)
+
+// TestWhiteboardConcurrentUse drives input, export, clearing and rendering
+// from separate goroutines. Run with -race.
+func TestWhiteboardConcurrentUse(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(200, 150))
+	r := test.WidgetRenderer(w).(*whiteboardRenderer)
+	dir := t.TempDir()
+
+	mouse := func(x, y float32) *desktop.MouseEvent {
+		return &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(x, y)}}
+	}
+
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				f(i)
+			}
+		}()
+	}
+
+	run(func(i int) {
+		w.MouseDown(mouse(float32(i), 10))
+		for j := 0; j < 5; j++ {
+			w.MouseMoved(mouse(float32(i+j), float32(10+j)))
+		}
+		w.MouseUp(mouse(float32(i), 15))
+	})
+	run(func(i int) {
+		if i%10 == 0 {
+			_ = w.SaveContentAsPNG(filepath.Join(dir, "content.png"), 5)
+		}
+		w.contentBounds()
+	})
+	run(func(i int) {
+		if i%25 == 24 {
+			w.Clear()
+		}
+		w.Scrolled(&fyne.ScrollEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(50, 50)}, Scrolled: fyne.NewDelta(0, 1)})
+	})
+	run(func(i int) {
+		r.updateObjects()
+		_ = r.Objects()
+		w.SetLineWidth(float32(i%5 + 1))
+	})
+	wg.Wait()
+
+	if err := w.SaveAsPNG(filepath.Join(dir, "view.png"), 200, 150); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShapeSnapping(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.SetInputOptions(stroke.Options{})
+	mouse := func(x, y float32) *desktop.MouseEvent {
+		return &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(x, y)}}
+	}
+	drawBox := func() model.Line {
+		w.MouseDown(mouse(10, 10))
+		for _, p := range [][2]float32{{60, 11}, {110, 9}, {111, 35}, {109, 60}, {60, 61}, {10, 60}, {11, 35}, {10, 12}} {
+			w.MouseMoved(mouse(p[0], p[1]))
+		}
+		w.MouseUp(mouse(10, 12))
+		lines := w.doc.Snapshot().Lines
+		return lines[len(lines)-1]
+	}
+
+	if l := drawBox(); len(l.Points) == 5 && l.Points[0] == (model.Point{X: 10, Y: 10}) {
+		t.Errorf("stroke snapped with shapes off: %v", l.Points)
+	}
+
+	w.SetShapeMode(shape.Auto)
+	want := []model.Point{{X: 10, Y: 9}, {X: 111, Y: 9}, {X: 111, Y: 61}, {X: 10, Y: 61}, {X: 10, Y: 9}}
+	if l := drawBox(); fmt.Sprint(l.Points) != fmt.Sprint(want) || l.Curve {
+		t.Errorf("snapped to %v (curve %v), want %v", l.Points, l.Curve, want)
+	}
+
+	// Hold モードでは止めずに離すとそのまま残る
+	w.SetShapeMode(shape.Hold)
+	if l := drawBox(); len(l.Points) == 5 && l.Points[1] == want[1] {
+		t.Errorf("snapped without holding: %v", l.Points)
+	}
+}
+
+func TestUndoAndModified(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	if w.Modified() {
+		t.Error("new board is modified")
+	}
+	mouse := &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(10, 10)}}
+	w.MouseDown(mouse)
+	mouse.Position = fyne.NewPos(50, 30)
+	w.MouseMoved(mouse)
+	w.MouseUp(mouse)
+	if w.doc.Len() != 1 || !w.Modified() {
+		t.Fatalf("after drawing: %d lines, modified %v", w.doc.Len(), w.Modified())
+	}
+
+	if err := w.SaveBoard(filepath.Join(t.TempDir(), "board"+model.BoardExt)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Modified() {
+		t.Error("modified after saving")
+	}
+	if !w.Undo() || w.doc.Len() != 0 || !w.Modified() {
+		t.Errorf("after undo: %d lines, modified %v", w.doc.Len(), w.Modified())
+	}
+	if !w.Redo() || w.doc.Len() != 1 {
+		t.Errorf("after redo: %d lines", w.doc.Len())
+	}
+}
+
+func TestPageFollowsResize(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	r := test.WidgetRenderer(w).(*whiteboardRenderer)
+
+	w.SetPageSize(model.PageSize{Width: 800, Height: 600})
+	if z := w.Zoom(); z >= 0.5 || z < 0.4 {
+		t.Errorf("zoom %.3f does not fit the page", z)
+	}
+	// ウィンドウを広げるとページ全体が見えたまま拡大される
+	w.Resize(fyne.NewSize(840, 640))
+	r.Layout(fyne.NewSize(840, 640))
+	if z := w.Zoom(); z != 1 {
+		t.Errorf("zoom after resize = %.3f, want 1", z)
+	}
+
+	// ユーザーが拡大した後は大きさが変わっても動かさない
+	w.ZoomBy(2)
+	r.Layout(fyne.NewSize(400, 300))
+	if z := w.Zoom(); z != 2 {
+		t.Errorf("zoom after resize = %.3f, want 2", z)
+	}
+
+	// 書き出しはページの大きさになる
+	filename := filepath.Join(t.TempDir(), "page.png")
+	if err := w.Export(filename); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := png.DecodeConfig(f)
+	if err != nil || config.Width != 800 || config.Height != 600 {
+		t.Errorf("exported %dx%d, %v", config.Width, config.Height, err)
+	}
+}
+
+func TestPages(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	line := model.Line{Points: []model.Point{{X: 0, Y: 0}, {X: 40, Y: 20}}, Color: color.Black, Width: 2}
+	w.doc.Add(line)
+	if id := w.CurrentPageID(); id != model.PageID(0) {
+		t.Errorf("ID of the first page = %q", id)
+	}
+
+	// 追加したページは空で、元のページの線はそのまま残る
+	w.AddPage()
+	if w.CurrentPage() != 1 || w.doc.Len() != 0 {
+		t.Fatalf("after adding: page %d with %d lines", w.CurrentPage(), w.doc.Len())
+	}
+	w.SetCurrentPage(0)
+	if w.doc.Len() != 1 {
+		t.Errorf("first page has %d lines", w.doc.Len())
+	}
+	w.DuplicatePage()
+	if got := w.Pages(); len(got) != 3 || got[1] != "Page 1 copy" || w.doc.Len() != 1 {
+		t.Fatalf("after duplicating: %q with %d lines", got, w.doc.Len())
+	}
+	// 複製は元のページと別の履歴を持つ
+	w.doc.Add(line)
+	w.SetCurrentPage(0)
+	if w.Undo() || w.doc.Len() != 1 {
+		t.Errorf("undo reached the copied page: %d lines", w.doc.Len())
+	}
+
+	w.RenamePage(2, "  Notes ")
+	w.MovePage(2, 0)
+	if got := w.Pages(); got[0] != "Notes" || got[1] != "Page 1" || w.CurrentPage() != 1 {
+		t.Errorf("after moving: %q, current %d", got, w.CurrentPage())
+	}
+	// ページの ID は動かしても変わらず、どれも違う
+	ids := []string{w.pages[0].id, w.pages[1].id, w.pages[2].id}
+	if ids[1] != model.PageID(0) || ids[0] == ids[2] || ids[0] == ids[1] {
+		t.Errorf("page IDs after moving: %q", ids)
+	}
+
+	filename := filepath.Join(t.TempDir(), "deck"+model.BoardExt)
+	if err := w.SaveBoard(filename); err != nil {
+		t.Fatal(err)
+	}
+	if w.Modified() {
+		t.Error("modified after saving")
+	}
+	if err := w.DeletePage(0); err != nil || !w.Modified() {
+		t.Fatalf("delete: %v, modified %v", err, w.Modified())
+	}
+
+	if err := w.LoadBoard(filename); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Pages(); len(got) != 3 || got[0] != "Notes" || w.CurrentPage() != 0 || w.Modified() {
+		t.Errorf("after loading: %q, current %d, modified %v", got, w.CurrentPage(), w.Modified())
+	}
+	if w.CurrentPageID() != ids[0] || w.pages[1].id != ids[1] || w.pages[2].id != ids[2] {
+		t.Errorf("page IDs after loading: %q, %q, %q", w.pages[0].id, w.pages[1].id, w.pages[2].id)
+	}
+	data, err := w.PagesPNG([]int{1, 2}, nil)
+	if err != nil || len(data) == 0 {
+		t.Errorf("PagesPNG: %d bytes, %v", len(data), err)
+	}
+
+	for len(w.Pages()) > 1 {
+		w.DeletePage(0)
+	}
+	if err := w.DeletePage(0); err != errLastPage {
+		t.Errorf("deleting the last page: %v", err)
+	}
+}
+
+func TestSelectAndMove(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	r := test.WidgetRenderer(w).(*whiteboardRenderer)
+	line := func(x0, y0, x1, y1 float32) model.Line {
+		return model.Line{Points: []model.Point{{X: x0, Y: y0}, {X: x1, Y: y1}}, Color: color.Black, Width: 2}
+	}
+	w.doc.Add(line(10, 10, 60, 10))
+	w.doc.Add(line(100, 50, 150, 50))
+	w.SetTool(toolSelect)
+	w.SetSnapping(false, true)
+
+	// 2 本目をつかんで動かすと、1 本目の右端に左端が吸着する
+	mouse := &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(120, 50)}}
+	w.MouseDown(mouse)
+	mouse.Position = fyne.NewPos(83, 80)
+	w.MouseMoved(mouse)
+	if state := w.exportState(); !state.moving || len(state.guides) == 0 {
+		t.Fatalf("moving %v, guides %v", state.moving, state.guides)
+	}
+	r.Refresh()
+	w.MouseUp(mouse)
+
+	lines := w.doc.Snapshot().Lines
+	if len(lines) != 2 || lines[1].Points[0] != (model.Point{X: 62, Y: 80}) {
+		t.Fatalf("after moving: %v", lines)
+	}
+	if sel := w.Selection(); len(sel) != 1 || sel[0] != lines[1].ID {
+		t.Errorf("selection after moving: %v", sel)
+	}
+	if !w.Undo() || w.doc.Snapshot().Lines[1].Points[0] != (model.Point{X: 100, Y: 50}) {
+		t.Errorf("undo did not move the line back: %v", w.doc.Snapshot().Lines)
+	}
+
+	// 空いているところからの矩形で両方を選んで消す
+	mouse.Position = fyne.NewPos(300, 250)
+	w.MouseDown(mouse)
+	mouse.Position = fyne.NewPos(0, 0)
+	w.MouseMoved(mouse)
+	w.MouseUp(mouse)
+	if sel := w.Selection(); len(sel) != 2 {
+		t.Fatalf("rectangle selected %d lines", len(sel))
+	}
+	w.DeleteSelection()
+	if w.doc.Len() != 0 {
+		t.Errorf("%d lines left after deleting the selection", w.doc.Len())
+	}
+}
+
+func TestImages(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	r := test.WidgetRenderer(w).(*whiteboardRenderer)
+	w.SetPageSize(model.PageSize{Width: 400, Height: 300})
+	svg := func(width, height int) *model.Image {
+		img, err := model.NewImage([]byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d"><rect width="%d" height="%d" fill="#00f"/></svg>`, width, height, width, height)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	// 背景はページに合わせて縦横比を保ったまま広げる
+	if err := w.AddImage(svg(100, 50), true); err != nil {
+		t.Fatal(err)
+	}
+	w.SetTool(toolSelect)
+	if err := w.AddImageAt(svg(40, 20), fyne.NewPos(100, 100), false); err != nil {
+		t.Fatal(err)
+	}
+	lines := w.doc.Snapshot().Lines
+	if len(lines) != 2 || lines[0].Points[0] != (model.Point{X: 0, Y: 50}) || lines[0].Points[1] != (model.Point{X: 400, Y: 250}) {
+		t.Fatalf("background placed at %v", lines[0].Points)
+	}
+	// 元の大きさでドロップした位置を中心に置く
+	c := w.view.toWorld(fyne.NewPos(100, 100))
+	if lines[1].Points[0] != (model.Point{X: c.X - 20, Y: c.Y - 10}) || lines[1].Points[1] != (model.Point{X: c.X + 20, Y: c.Y + 10}) {
+		t.Fatalf("image placed at %v", lines[1].Points)
+	}
+	w.ResetZoom()
+	if sel := w.Selection(); len(sel) != 1 || sel[0] != lines[1].ID {
+		t.Errorf("new image not selected: %v", sel)
+	}
+	r.Refresh()
+
+	// 背景はクリックでも範囲でも選べない
+	mouse := &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(300, 200)}}
+	w.MouseDown(mouse)
+	w.MouseUp(mouse)
+	mouse.Position = fyne.NewPos(0, 0)
+	w.MouseDown(mouse)
+	mouse.Position = fyne.NewPos(400, 300)
+	w.MouseMoved(mouse)
+	w.MouseUp(mouse)
+	if sel := w.Selection(); len(sel) != 1 || sel[0] != lines[1].ID {
+		t.Fatalf("selection = %v", sel)
+	}
+
+	// 右下のハンドルを引くと左上を固定して 2 倍になる
+	from, to := lines[1].Points[0], lines[1].Points[1]
+	corner := w.view.toScreen(to)
+	mouse.Position = fyne.NewPos(corner.X+selectionPadding, corner.Y+selectionPadding)
+	w.MouseDown(mouse)
+	mouse.Position = fyne.NewPos(corner.X+selectionPadding+40, corner.Y+selectionPadding+10)
+	w.MouseMoved(mouse)
+	r.Refresh()
+	w.MouseUp(mouse)
+	lines = w.doc.Snapshot().Lines
+	near := func(a, b model.Point) bool {
+		return math.Abs(float64(a.X-b.X)) < 1e-3 && math.Abs(float64(a.Y-b.Y)) < 1e-3
+	}
+	if got := lines[1].Points; !near(got[0], from) || !near(got[1], model.Point{X: to.X + 40, Y: to.Y + 20}) {
+		t.Errorf("scaled image at %v", got)
+	}
+	if !w.Undo() || w.doc.Snapshot().Lines[1].Points[1] != to {
+		t.Errorf("undo did not restore the size: %v", w.doc.Snapshot().Lines[1].Points)
+	}
+}
+
+func TestClipboard(t *testing.T) {
+	test.NewTempApp(t)
+	win := test.NewWindow(nil)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	w.doc.Add(model.Line{Points: []model.Point{{X: 10, Y: 10}, {X: 60, Y: 10}}, Color: color.Black, Width: 2})
+	clip := newBoardClipboard(win, w)
+	if clip.Copy() {
+		t.Error("copied without a selection")
+	}
+
+	// コピーして 2 回貼り付けると、そのたびにずれた位置に選択された状態で入る
+	w.SetTool(toolSelect)
+	w.mutex.Lock()
+	w.selection = []model.ID{w.doc.Snapshot().Lines[0].ID}
+	w.mutex.Unlock()
+	clip.Copy()
+	clip.Paste()
+	clip.Paste()
+	lines := w.doc.Snapshot().Lines
+	if len(lines) != 3 || lines[1].Points[0] != (model.Point{X: 30, Y: 30}) || lines[2].Points[0] != (model.Point{X: 50, Y: 50}) {
+		t.Fatalf("pasted lines: %v", lines)
+	}
+	if sel := w.Selection(); len(sel) != 1 || sel[0] != lines[2].ID {
+		t.Errorf("selection after pasting: %v", sel)
+	}
+	if !w.Undo() || w.doc.Len() != 2 {
+		t.Errorf("one undo should remove one paste, %d lines left", w.doc.Len())
+	}
+
+	// 切り取ると消えて、クリップボードから戻せる
+	w.mutex.Lock()
+	w.selection = []model.ID{lines[1].ID}
+	w.mutex.Unlock()
+	clip.Cut()
+	if w.doc.Len() != 1 {
+		t.Errorf("%d lines after cutting", w.doc.Len())
+	}
+	if _, ok := model.ReadClip(win.Clipboard().Content()); !ok {
+		t.Errorf("clipboard after cutting: %q", win.Clipboard().Content())
+	}
+
+	// 普通の文字は文字のオブジェクトになる
+	win.Clipboard().SetContent("Agenda\n1. Budget")
+	clip.Paste()
+	lines = w.doc.Snapshot().Lines
+	if last := lines[len(lines)-1]; last.Text != "Agenda\n1. Budget" || !last.Boxed() {
+		t.Fatalf("pasted text: %+v", last)
+	}
+
+	// 画像としてのコピーは PNG の data: URI になり、そのまま貼り付けられる
+	clip.CopyImage()
+	if content := win.Clipboard().Content(); !strings.HasPrefix(content, "data:image/png;base64,") {
+		t.Fatalf("copied image: %.40q", content)
+	}
+	n := w.doc.Len()
+	clip.Paste()
+	if lines = w.doc.Snapshot().Lines; len(lines) != n+1 || lines[n].Image == nil || lines[n].Image.Format != model.ImagePNG {
+		t.Errorf("pasted image: %+v", lines[len(lines)-1])
+	}
+}
+
+func TestLayers(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	r := test.WidgetRenderer(w).(*whiteboardRenderer)
+	w.SetTool(toolSelect)
+	drag := func(x0, y0, x1, y1 float32) {
+		mouse := &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(x0, y0)}}
+		w.MouseDown(mouse)
+		mouse.Position = fyne.NewPos(x1, y1)
+		w.MouseMoved(mouse)
+		w.MouseUp(mouse)
+	}
+
+	// 新しいレイヤーは現在のレイヤーの上に入り、新しい線はそこに描かれる
+	w.AddLayer()
+	if layers := w.Layers(); len(layers) != 2 || layers[1].Name != "Layer 2" || w.CurrentLayer() != 1 {
+		t.Fatalf("after adding: %+v, current %d", layers, w.CurrentLayer())
+	}
+	if err := w.AddText("Top"); err != nil {
+		t.Fatal(err)
+	}
+	w.SetCurrentLayer(0)
+	if err := w.AddText("Bottom"); err != nil {
+		t.Fatal(err)
+	}
+	top := w.Layers()[1].ID
+	lines := w.doc.Snapshot().Lines
+	if lines[0].Layer != top || lines[1].Layer != "" {
+		t.Fatalf("layers of the lines: %q, %q", lines[0].Layer, lines[1].Layer)
+	}
+	r.Refresh()
+
+	// 非表示・ロック中のレイヤーの線は選べず、描き込めない
+	w.SetLayerHidden(1, true)
+	drag(0, 0, 400, 300)
+	if sel := w.Selection(); len(sel) != 1 || sel[0] != lines[1].ID {
+		t.Errorf("selection with the top layer hidden: %v", sel)
+	}
+	w.SetLayerLocked(0, true)
+	drag(0, 0, 400, 300)
+	if sel := w.Selection(); len(sel) != 0 {
+		t.Errorf("selection with every layer hidden or locked: %v", sel)
+	}
+	if err := w.AddText("Locked"); err != errLayerLocked {
+		t.Errorf("adding to a locked layer: %v", err)
+	}
+	r.Refresh()
+
+	// 選択を別のレイヤーに移すのは 1 回で取り消せる
+	w.SetLayerHidden(1, false)
+	w.SetLayerLocked(0, false)
+	w.SetLayerOpacity(1, 0.5)
+	drag(0, 0, 400, 300)
+	w.MoveSelectionByLayers(1)
+	lines = w.doc.Snapshot().Lines
+	if len(lines) != 2 || lines[0].Layer != top || lines[1].Layer != top || w.CurrentLayer() != 1 || len(w.Selection()) != 2 {
+		t.Fatalf("after moving to the layer above: %+v, current %d", lines, w.CurrentLayer())
+	}
+	r.Refresh()
+	if !w.Undo() || w.doc.Snapshot().Lines[1].Layer != "" {
+		t.Errorf("undo did not move the line back")
+	}
+
+	filename := filepath.Join(t.TempDir(), "layers"+model.BoardExt)
+	if err := w.SaveBoard(filename); err != nil {
+		t.Fatal(err)
+	}
+	w.RenameLayer(1, "Notes")
+	if !w.Modified() {
+		t.Error("renaming a layer did not modify the board")
+	}
+	if err := w.LoadBoard(filename); err != nil {
+		t.Fatal(err)
+	}
+	if layers := w.Layers(); len(layers) != 2 || layers[1].Name != "Layer 2" || layers[1].Alpha() != 0.5 || w.Modified() {
+		t.Errorf("after loading: %+v, modified %v", layers, w.Modified())
+	}
+
+	// 送信ではレイヤーを名前で選べる
+	all, err := w.PagesPNG(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one, err := w.PagesPNG(nil, []string{"Layer 2"})
+	if err != nil || bytes.Equal(all, one) {
+		t.Errorf("PagesPNG with one layer: %d bytes, %v", len(one), err)
+	}
+
+	// レイヤーを消すとその線も消え、取り消すとレイヤーごと線が戻る
+	w.SetCurrentLayer(1)
+	if err := w.DeleteLayer(1); err != nil || w.doc.Len() != 1 || len(w.Layers()) != 1 || w.CurrentLayer() != 0 {
+		t.Errorf("delete: %v, %d lines, %d layers", err, w.doc.Len(), len(w.Layers()))
+	}
+	if !w.Undo() {
+		t.Fatal("nothing to undo after deleting a layer")
+	}
+	layers := w.Layers()
+	if len(layers) != 2 || layers[1].ID != top || layers[1].Name != "Layer 2" || w.CurrentLayer() != 1 {
+		t.Fatalf("after undoing the delete: %+v, current %d", layers, w.CurrentLayer())
+	}
+	// 線は元の重なり順に戻る
+	if lines := w.doc.Snapshot().Lines; len(lines) != 2 || lines[0].Text != "Top" || model.LayerIndex(layers, lines[0].Layer) != 1 {
+		t.Errorf("lines after undoing the delete: %+v", lines)
+	}
+	if !w.Redo() || w.doc.Len() != 1 || len(w.Layers()) != 1 {
+		t.Errorf("redo: %d lines, %d layers", w.doc.Len(), len(w.Layers()))
+	}
+	if err := w.DeleteLayer(0); err != errLastLayer {
+		t.Errorf("deleting the last layer: %v", err)
+	}
+}
+
+func TestLayerIDsDifferBetweenParticipants(t *testing.T) {
+	test.NewTempApp(t)
+	a, b := newWhiteboard(), newWhiteboard()
+
+	// 同じ順にレイヤーを足しても、参加者ごとに別の ID になる
+	a.AddLayer()
+	b.AddLayer()
+	if ia, ib := a.Layers()[1].ID, b.Layers()[1].ID; ia == ib || ia == "" {
+		t.Errorf("layer IDs %q and %q", ia, ib)
+	}
+}
+
+func TestLayersAreCopiedOnWrite(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.AddLayer()
+	w.AddLayer()
+
+	// 以前に渡したレイヤーの配列は、追加・移動・変更で書き換わらない
+	held := w.pages[w.pageIndex].layers
+	want := slices.Clone(held)
+	w.SetCurrentLayer(0)
+	w.AddLayer()
+	w.MoveLayer(0, 2)
+	w.RenameLayer(1, "Renamed")
+	if !reflect.DeepEqual(held, want) {
+		t.Errorf("held layers changed: %+v, want %+v", held, want)
+	}
+}
+
+func TestConnectors(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	r := test.WidgetRenderer(w).(*whiteboardRenderer)
+	box := func(x, y float32) model.Line {
+		return model.Line{Points: []model.Point{{X: x, Y: y}, {X: x + 60, Y: y}, {X: x + 60, Y: y + 40}, {X: x, Y: y + 40}, {X: x, Y: y}}, Color: color.Black, Width: 2}
+	}
+	w.doc.Add(box(20, 20))
+	w.doc.Add(box(220, 120))
+	drag := func(x0, y0, x1, y1 float32) {
+		mouse := &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(x0, y0)}}
+		w.MouseDown(mouse)
+		mouse.Position = fyne.NewPos(x1, y1)
+		w.MouseMoved(mouse)
+		r.Refresh()
+		w.MouseUp(mouse)
+	}
+
+	// 図形から図形へ引くと、両方に名前が付いて辺の中点につながる
+	w.SetTool(toolConnector)
+	w.SetConnectorStyle(model.ConnectorElbow)
+	drag(50, 40, 250, 140)
+	lines := w.doc.Snapshot().Lines
+	if len(lines) != 3 || lines[0].Node == "" || lines[1].Node == "" || lines[2].Connector == nil {
+		t.Fatalf("after connecting: %+v", lines)
+	}
+	arrow := lines[2]
+	if c := arrow.Connector; c.From.Node != lines[0].Node || c.To.Node != lines[1].Node || c.Style != model.ConnectorElbow {
+		t.Errorf("connector = %+v", c)
+	}
+	if p := arrow.Points[0]; p != (model.Point{X: 81, Y: 40}) {
+		t.Errorf("connector starts at %v", p)
+	}
+	if !w.Undo() || w.doc.Len() != 2 || w.doc.Snapshot().Lines[0].Node != "" {
+		t.Fatalf("one undo should remove the connector and the names")
+	}
+	w.Redo()
+
+	// 図形を動かすとコネクターが付いてくる。取り消しは 1 回
+	w.SetTool(toolSelect)
+	drag(250, 121, 250, 221)
+	lines = w.doc.Snapshot().Lines
+	var moved model.Line
+	for _, l := range lines {
+		if l.Connector != nil {
+			moved = l
+		}
+	}
+	if end := moved.Points[len(moved.Points)-1]; end.Y < 200 {
+		t.Errorf("connector did not follow the shape: ends at %v", end)
+	}
+	if sel := w.Selection(); len(sel) != 1 || sel[0] == moved.ID {
+		t.Errorf("selection after moving: %v", sel)
+	}
+	if !w.Undo() {
+		t.Fatal("nothing to undo")
+	}
+	for _, l := range w.doc.Snapshot().Lines {
+		if l.Connector != nil && !reflect.DeepEqual(l.Points, arrow.Points) {
+			t.Errorf("undo did not move the connector back: %v", l.Points)
+		}
+	}
+
+	// 選んだコネクターの形とラベルを変える
+	w.mutex.Lock()
+	for _, l := range w.doc.Snapshot().Lines {
+		if l.Connector != nil {
+			w.selection = []model.ID{l.ID}
+		}
+	}
+	w.mutex.Unlock()
+	w.SetConnectorStyle(model.ConnectorCurved)
+	w.SetConnectorLabel("calls")
+	if label, ok := w.SelectedConnectorLabel(); !ok || label != "calls" {
+		t.Errorf("label = %q, %v", label, ok)
+	}
+	lines = w.doc.Snapshot().Lines
+	if l := lines[len(lines)-1]; l.Connector.Style != model.ConnectorCurved || !l.Curve {
+		t.Errorf("restyled connector = %+v", l)
+	}
+	r.Refresh()
+
+	// 貼り付けた図形は元の図形とは別の名前になり、コネクターもそれに従う
+	if err := w.PasteLines(w.doc.Snapshot().Lines, 1); err != nil {
+		t.Fatal(err)
+	}
+	lines = w.doc.Snapshot().Lines
+	if len(lines) != 6 || lines[3].Node == lines[0].Node || lines[5].Connector.From.Node != lines[3].Node {
+		t.Errorf("pasted nodes: %q, %+v", lines[3].Node, lines[5].Connector)
+	}
+}
+
+func TestArrange(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	r := test.WidgetRenderer(w).(*whiteboardRenderer)
+	colors := map[color.Color]string{}
+	for i, c := range []color.RGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}} {
+		x, y := []float32{20, 100, 260}[i], float32(20+40*i)
+		w.doc.Add(model.Line{Points: []model.Point{{X: x, Y: y}, {X: x + 60, Y: y}, {X: x + 60, Y: y + 40}, {X: x, Y: y + 40}, {X: x, Y: y}}, Color: c, Width: 2})
+		colors[c] = string(rune('A' + i))
+	}
+	order := func() string {
+		var s string
+		for _, l := range w.doc.Snapshot().Lines {
+			s += colors[l.Color]
+		}
+		return s
+	}
+	ids := func() map[model.ID]bool {
+		set := map[model.ID]bool{}
+		for _, l := range w.doc.Snapshot().Lines {
+			set[l.ID] = true
+		}
+		return set
+	}
+	bounds := func() []float32 {
+		var xs []float32
+		for _, l := range w.doc.Snapshot().Lines {
+			b, _ := snap.Bounds([]model.Line{l})
+			xs = append(xs, b.Min.X)
+		}
+		return xs
+	}
+	drag := func(x0, y0, x1, y1 float32) {
+		mouse := &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(x0, y0)}}
+		w.MouseDown(mouse)
+		mouse.Position = fyne.NewPos(x1, y1)
+		w.MouseMoved(mouse)
+		r.Refresh()
+		w.MouseUp(mouse)
+	}
+	w.SetTool(toolSelect)
+
+	// 重なり順は 1 回で取り消せる。線は入れ替えずに並べ替えるので ID は変わらない
+	drag(20, 40, 20, 40)
+	before := ids()
+	for _, c := range []struct {
+		order zOrder
+		want  string
+	}{{toFront, "BCA"}, {backward, "BAC"}, {toBack, "ABC"}, {forward, "BAC"}} {
+		w.Arrange(c.order)
+		if !reflect.DeepEqual(ids(), before) {
+			t.Errorf("arranging %d replaced lines", c.order)
+		}
+		if got := order(); got != c.want {
+			t.Errorf("after arranging %d: %s, want %s", c.order, got, c.want)
+		}
+		if len(w.Selection()) != 1 {
+			t.Errorf("selection lost after arranging %d", c.order)
+		}
+	}
+	if !w.Undo() || order() != "ABC" {
+		t.Errorf("undo gave %s", order())
+	}
+	r.Refresh()
+
+	// グループのどれかを選ぶと全体が選ばれる
+	drag(10, 10, 190, 110)
+	w.GroupSelection()
+	drag(390, 290, 390, 290)
+	drag(20, 40, 20, 40)
+	if sel := w.SelectedLines(); len(sel) != 2 || sel[0].Group == "" || sel[0].Group != sel[1].Group {
+		t.Fatalf("clicking a grouped line selected %+v", sel)
+	}
+
+	// ロックした線は動かせず、消せない
+	w.LockSelection(true)
+	locked := bounds()
+	drag(20, 40, 60, 80)
+	w.DeleteSelection()
+	if w.doc.Len() != 3 || !reflect.DeepEqual(bounds(), locked) {
+		t.Errorf("locked lines changed: %v, want %v", bounds(), locked)
+	}
+	w.LockSelection(false)
+	w.UngroupSelection()
+	if l := w.SelectedLines(); len(l) != 2 || l[0].Group != "" || l[0].Locked {
+		t.Errorf("after unlocking and ungrouping: %+v", l)
+	}
+
+	// 揃えと等間隔の配置も 1 回で取り消せる。動かない線はそのまま残る
+	drag(10, 10, 390, 290)
+	first := w.doc.Snapshot().Lines[0].ID
+	w.Align(alignLeft)
+	if xs := bounds(); xs[0] != 19 || xs[1] != 19 || xs[2] != 19 {
+		t.Errorf("aligned left: %v", xs)
+	}
+	if !ids()[first] || order() != "ABC" {
+		t.Errorf("aligning replaced the line that stayed or changed the order: %s", order())
+	}
+	w.Undo()
+	if xs := bounds(); xs[1] != 99 {
+		t.Errorf("undo align: %v", xs)
+	}
+	// 取り消すと線の ID が変わるので選び直す
+	drag(10, 10, 390, 290)
+	w.Distribute(false)
+	if xs := bounds(); xs[0] != 19 || xs[1] != 139 || xs[2] != 259 {
+		t.Errorf("distributed: %v", xs)
+	}
+	r.Refresh()
+}
+
+func TestReplay(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(200, 100))
+	mouse := &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(10, 10)}}
+	w.MouseDown(mouse)
+	for i := 1; i <= 3; i++ {
+		time.Sleep(5 * time.Millisecond)
+		mouse.Position = fyne.NewPos(10+float32(40*i), 10+float32(20*i*i))
+		w.MouseMoved(mouse)
+	}
+	w.MouseUp(mouse)
+	w.Clear()
+
+	// 点と操作に時刻が付き、再生では線を描いてから消す
+	ops := w.doc.Ops()
+	if len(ops) != 2 || ops[0].Time == 0 || ops[0].Line.Points[len(ops[0].Line.Points)-1].T < 15 {
+		t.Fatalf("ops are not timed: %+v", ops)
+	}
+	r, o := w.Replay()
+	if o.View.Width != 200 || r.Duration() < 15*time.Millisecond {
+		t.Errorf("view %+v, duration %v", o.View, r.Duration())
+	}
+	if lines := r.Lines(r.Duration() - time.Nanosecond); len(lines) != 1 {
+		t.Errorf("before clearing: %d lines", len(lines))
+	}
+	if lines := r.Lines(r.Duration()); len(lines) != 0 {
+		t.Errorf("after clearing: %d lines", len(lines))
+	}
+}
+
+func TestPresentation(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	drag := func(x0, y0, x1, y1 float32) {
+		mouse := &desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(x0, y0)}}
+		w.MouseDown(mouse)
+		mouse.Position = fyne.NewPos(x1, y1)
+		w.MouseMoved(mouse)
+		w.MouseUp(mouse)
+	}
+	drag(10, 10, 100, 100)
+	w.AddPage()
+	drag(10, 10, 100, 100)
+	w.SaveView("")
+	w.ZoomBy(2)
+	w.SaveView("Detail")
+	if views := w.Views(); len(views) != 2 || views[0].Name != "View 1" || views[1].Max.X-views[1].Min.X != 200 {
+		t.Fatalf("views = %+v", views)
+	}
+	w.SetCurrentPage(0)
+
+	// 手順は 1 ページ目全体と 2 ページ目の保存した表示範囲
+	w.StartPresenting()
+	if step, steps := w.PresentStep(); step != 0 || steps != 3 || !w.Presenting() {
+		t.Fatalf("step %d of %d", step, steps)
+	}
+	w.PresentNext()
+	w.PresentNext()
+	w.PresentNext()
+	if step, _ := w.PresentStep(); step != 2 || w.CurrentPage() != 1 || w.Zoom() != 2 {
+		t.Errorf("last step: step %d, page %d, zoom %v", step, w.CurrentPage(), w.Zoom())
+	}
+	w.PresentPrevious()
+	if w.Zoom() != 1 {
+		t.Errorf("first view of page 2 at zoom %v", w.Zoom())
+	}
+
+	// レーザーとスポットライトは表示されるが、線にも書き出しにもならない
+	lines := w.doc.Len()
+	drag(50, 50, 150, 120)
+	state := w.exportState()
+	if w.doc.Len() != lines || len(state.lines()) != lines || len(state.laser) != 1 {
+		t.Errorf("%d lines, %d exported, laser %v", w.doc.Len(), len(state.lines()), state.laser)
+	}
+	w.MouseMoved(&desktop.MouseEvent{PointEvent: fyne.PointEvent{Position: fyne.NewPos(160, 130)}})
+	// 軌跡を薄くするゴルーチンも r を描き直すので、別のレンダラーで確かめる
+	pointer := &whiteboardRenderer{size: w.Size()}
+	pointer.updatePointer(w.exportState())
+	if len(pointer.pointer) != 2 {
+		t.Errorf("laser drawn as %d objects", len(pointer.pointer))
+	}
+	time.Sleep(laserFade + 100*time.Millisecond)
+	if state := w.exportState(); len(state.laser) != 0 {
+		t.Errorf("laser did not fade: %v", state.laser)
+	}
+	w.SetPointer(pointerSpotlight)
+	pointer.updatePointer(w.exportState())
+	if len(pointer.pointer) != 1 {
+		t.Errorf("spotlight drawn as %d objects", len(pointer.pointer))
+	}
+	img := spotlightImage(400, 300, fyne.NewPos(200, 150), fyne.NewSize(400, 300)).(*image.NRGBA)
+	if img.NRGBAAt(200, 150).A != 0 || img.NRGBAAt(0, 0) != spotlightShade {
+		t.Errorf("spotlight %v, shade %v", img.NRGBAAt(200, 150), img.NRGBAAt(0, 0))
+	}
+
+	w.StopPresenting()
+	drag(10, 10, 100, 100)
+	if w.Presenting() || w.doc.Len() != lines+1 {
+		t.Errorf("drawing after presenting: %d lines", w.doc.Len())
+	}
+}
+
+func TestAutosave(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.Resize(fyne.NewSize(400, 300))
+	session, err := recovery.Start(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &autosaver{board: w, session: session, stop: make(chan struct{}), done: make(chan struct{}), key: w.autosaveKey()}
+
+	// 変更が止まってから保存する
+	start := time.Now()
+	if a.due(w.autosaveKey(), start) {
+		t.Fatal("saving an unchanged board")
+	}
+	w.doc.Add(model.Line{Points: []model.Point{{X: 10, Y: 10}, {X: 300, Y: 100}}, Color: color.Black, Width: 4})
+	if a.due(w.autosaveKey(), start) || a.due(w.autosaveKey(), start.Add(autosaveDelay/2)) {
+		t.Error("saving while the board is changing")
+	}
+	if !a.due(w.autosaveKey(), start.Add(autosaveDelay)) {
+		t.Error("not saving after the changes settled")
+	}
+
+	// 変更が続いても一定の間隔で保存する
+	a.dirty = time.Time{}
+	saved := false
+	for i := 0; i <= 40 && !saved; i++ {
+		w.RenamePage(0, fmt.Sprintf("Page %d", i))
+		saved = a.due(w.autosaveKey(), start.Add(time.Duration(i)*time.Second))
+		if saved && time.Duration(i)*time.Second < autosaveInterval {
+			t.Errorf("saved after %d s of changes", i)
+		}
+	}
+	if !saved {
+		t.Error("not saving while the board keeps changing")
+	}
+
+	w.AddPage()
+	a.tick(time.Now().Add(autosaveDelay))
+	a.tick(time.Now().Add(2 * autosaveDelay))
+	if !a.dirty.IsZero() {
+		t.Fatal("board not saved")
+	}
+	pages, err := model.LoadPages(filepath.Join(session.Dir(), "board"+model.BoardExt))
+	if err != nil || len(pages) != 2 || len(pages[0].Lines) != 1 {
+		t.Fatalf("autosaved %v, %v", pages, err)
+	}
+	f, err := os.Open(filepath.Join(session.Dir(), "thumbnail.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnail, err := png.Decode(f)
+	f.Close()
+	if b := thumbnail.Bounds(); err != nil || b.Dx() > thumbnailWidth || b.Dy() > thumbnailHeight || b.Dx() < b.Dy() {
+		t.Errorf("thumbnail %v, %v", b, err)
+	}
+
+	// 復元したボードは保存していないので変更ありのまま
+	restored := newWhiteboard()
+	restored.RestorePages(pages)
+	if restored.Pages()[0] != pages[0].Name || len(restored.Pages()) != 2 || restored.doc.Len() != 1 || !restored.Modified() {
+		t.Errorf("restored %v with %d lines, modified %v", restored.Pages(), restored.doc.Len(), restored.Modified())
+	}
+
+	go a.run()
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(session.Dir()); !os.IsNotExist(err) {
+		t.Errorf("session kept after a clean exit: %v", err)
+	}
+}
+
+func TestPageShown(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	var docs []*model.Document
+	w.OnPageShown = func() { docs = append(docs, w.Document()) }
+
+	first := w.Document()
+	w.AddPage()
+	w.RenamePage(1, "Notes")
+	w.SetCurrentPage(1)
+	w.SetCurrentPage(0)
+	w.DeletePage(0)
+	if len(docs) != 3 || docs[1] != first || docs[0] != docs[2] || docs[0] == first {
+		t.Errorf("pages shown %v", docs)
+	}
+
+	u, _ := url.Parse("ws://localhost:8080/ws")
+	r := collabRoom{server: u, room: "design"}
+	if got := r.pageURL(model.PageID(0)); got != "ws://localhost:8080/ws?room=design" {
+		t.Errorf("first page room %s", got)
+	}
+	if got := r.pageURL(model.PageID(2)); got != "ws://localhost:8080/ws?room=design%2Fpage-3" {
+		t.Errorf("third page room %s", got)
+	}
+	if got := r.pageURL(w.CurrentPageID()); got != "ws://localhost:8080/ws?room=design%2F"+w.CurrentPageID() {
+		t.Errorf("added page room %s", got)
+	}
+}
+
+func TestEditLines(t *testing.T) {
+	test.NewTempApp(t)
+	w := newWhiteboard()
+	w.AddLayer()
+	top := w.Layers()[1].ID
+	line := model.Line{Points: []model.Point{{X: 10, Y: 10}, {X: 20, Y: 20}}}
+
+	// API からの線は現在のレイヤーに入り、取り消せる
+	ids, err := w.EditLines(false, nil, []model.Line{line})
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("adding: %v, %v", ids, err)
+	}
+	if lines := w.doc.Snapshot().Lines; len(lines) != 1 || lines[0].Layer != top {
+		t.Fatalf("after adding: %+v", lines)
+	}
+	if !w.Undo() || w.doc.Len() != 0 {
+		t.Fatal("undo did not remove the line")
+	}
+	w.Redo()
+
+	// ロック中・非表示のレイヤーには書き込めず、その線も消せない
+	w.SetLayerLocked(1, true)
+	if _, err := w.EditLines(false, nil, []model.Line{line}); err != errLayerLocked {
+		t.Errorf("adding to a locked layer: %v", err)
+	}
+	w.SetLayerLocked(1, false)
+	w.SetLayerHidden(1, true)
+	w.SetCurrentLayer(0)
+	id := w.doc.Snapshot().Lines[0].ID
+	if _, err := w.EditLines(false, []model.ID{id}, nil); err != errLineLocked || w.doc.Len() != 1 {
+		t.Errorf("deleting a line on a hidden layer: %v", err)
+	}
+
+	// 新しいボードは 1 回で取り消せる
+	if _, err := w.EditLines(true, nil, []model.Line{line, line}); err != nil || w.doc.Len() != 2 {
+		t.Fatalf("clearing: %v, %d lines", err, w.doc.Len())
+	}
+	if !w.Undo() || w.doc.Len() != 1 || w.doc.Snapshot().Lines[0].Layer != top {
+		t.Errorf("undo did not restore the board: %+v", w.doc.Snapshot().Lines)
+	}
+}